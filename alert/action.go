@@ -0,0 +1,164 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/logterm/encode"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind of action.
+type Kind int
+
+const (
+	// Bell rings the bell of the terminal
+	Bell Kind = iota + 1
+	// Banner shows the alert where the user looks
+	Banner
+	// Command runs, with the entry as JSON on its stdin
+	Command
+	// Webhook is posted the alert and its entry as JSON
+	Webhook
+)
+
+// Action that an alert runs when it fires.
+type Action struct {
+	Kind Kind
+	// Command and its arguments
+	Command []string
+	// URL of the webhook
+	URL string
+}
+
+// ParseAction reads an action written like `bell`, `banner`,
+// `command notify-send logterm` or `webhook https://example.com/hook`.
+// The arguments of commands are split on spaces, without quotes.
+func ParseAction(spec string) (Action, error) {
+	words := strings.Fields(spec)
+	if len(words) == 0 {
+		return Action{}, fmt.Errorf("empty action, want bell, banner, command or webhook")
+	}
+	switch words[0] {
+	case "bell", "banner":
+		if len(words) > 1 {
+			return Action{}, fmt.Errorf("%s takes no arguments, got %q", words[0], strings.Join(words[1:], " "))
+		}
+		if words[0] == "bell" {
+			return Action{Kind: Bell}, nil
+		}
+		return Action{Kind: Banner}, nil
+	case "command":
+		if len(words) == 1 {
+			return Action{}, fmt.Errorf("command needs the program to run")
+		}
+		return Action{Kind: Command, Command: words[1:]}, nil
+	case "webhook":
+		if len(words) != 2 {
+			return Action{}, fmt.Errorf("webhook needs a URL")
+		}
+		u, err := url.Parse(words[1])
+		if err != nil {
+			return Action{}, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return Action{}, fmt.Errorf("webhook needs an http or https URL, got %q", words[1])
+		}
+		return Action{Kind: Webhook, URL: words[1]}, nil
+	}
+	return Action{}, fmt.Errorf("unknown action %q, want bell, banner, command or webhook", words[0])
+}
+
+func (a Action) String() string {
+	switch a.Kind {
+	case Bell:
+		return "bell"
+	case Banner:
+		return "banner"
+	case Command:
+		return "command " + strings.Join(a.Command, " ")
+	case Webhook:
+		return "webhook " + a.URL
+	}
+	return fmt.Sprintf("Kind(%d)", int(a.Kind))
+}
+
+// do a command or webhook for an alert.
+func (a Action) do(al *Alert, opts Options) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	switch a.Kind {
+	case Command:
+		return runCommand(ctx, a.Command, al)
+	case Webhook:
+		return postWebhook(ctx, opts.Client, a.URL, al)
+	}
+	return nil
+}
+
+// runCommand with the entry as JSON on stdin, and the alert in the
+// environment.
+func runCommand(ctx context.Context, args []string, al *Alert) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(append(encode.AppendJSON(nil, al.Entry), '\n'))
+	cmd.Env = append(os.Environ(),
+		"LOGTERM_ALERT="+al.Rule,
+		"LOGTERM_ALERT_KEY="+al.Key,
+		"LOGTERM_ALERT_COUNT="+strconv.Itoa(al.Count),
+		"LOGTERM_ALERT_HELD="+strconv.Itoa(al.Held),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(bytes.TrimSpace(out)) > 0 {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
+
+// payload posted to webhooks
+type payload struct {
+	Alert  string          `json:"alert"`
+	Key    string          `json:"key,omitempty"`
+	Count  int             `json:"count"`
+	Within string          `json:"within"`
+	At     time.Time       `json:"at"`
+	Held   int             `json:"held,omitempty"`
+	Line   string          `json:"line"`
+	Entry  json.RawMessage `json:"entry"`
+}
+
+func postWebhook(ctx context.Context, client *http.Client, u string, al *Alert) error {
+	body, err := json.Marshal(payload{
+		Alert:  al.Rule,
+		Key:    al.Key,
+		Count:  al.Count,
+		Within: al.Within.String(),
+		At:     al.At,
+		Held:   al.Held,
+		Line:   string(al.Line),
+		Entry:  encode.AppendJSON(nil, al.Entry),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+// Package alert runs actions when entries match rules: when a query
+// matches N times within a span of time, it rings the bell, shows a
+// banner, runs a command or posts to a webhook. A cooldown keeps a rule
+// from firing over and over, and its dedup fields keep the same alert
+// from repeating while letting different ones through.
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWithin  = time.Minute
+	DefaultTimeout = 10 * time.Second
+	DefaultQueue   = 100
+)
+
+// values of the dedup fields that a rule remembers at most
+const maxKeys = 10000
+
+// Rule that fires an alert when its query matches Count entries within a
+// span of time.
+type Rule struct {
+	Name string
+	// Query that entries must match, it can't aggregate
+	Query *query.Query
+	// Count of matches that fire the alert, 1 by default
+	Count int
+	// Within is the span of time the matches must fall in, DefaultWithin
+	// by default
+	Within time.Duration
+	// Cooldown after the alert fires, during which it doesn't fire
+	// again. It's Within by default
+	Cooldown time.Duration
+	// Dedup fields: the entries that have other values for them are
+	// counted, and cooled down, apart
+	Dedup []string
+	// Actions run when the alert fires
+	Actions []Action
+}
+
+func (r *Rule) check() error {
+	switch {
+	case r.Query == nil:
+		return errors.New("it needs a query")
+	case r.Query.Aggregates():
+		return errors.New("its query can't aggregate")
+	case r.Count < 0 || r.Within < 0 || r.Cooldown < 0:
+		return errors.New("its count and spans of time can't be negative")
+	case len(r.Actions) == 0:
+		return errors.New("it needs actions")
+	}
+	return nil
+}
+
+// Alert fired by a rule.
+type Alert struct {
+	Rule string
+	// Key is the values of the dedup fields, like `msg=disk full`
+	Key string
+	// Count of matches within the span of time that fired it
+	Count  int
+	Within time.Duration
+	At     time.Time
+	// Held back by the cooldown since the rule last fired
+	Held int
+	// Line and Entry that the rule matched last
+	Line  []byte
+	Entry *parser.Entry
+}
+
+func (a *Alert) String() string {
+	s := fmt.Sprintf("alert %s: %d match", a.Rule, a.Count)
+	if a.Count != 1 {
+		s += "es"
+	}
+	s += " within " + a.Within.String()
+	if a.Key != "" {
+		s += " for " + a.Key
+	}
+	if a.Held > 0 {
+		s += fmt.Sprintf(" (%d more held back)", a.Held)
+	}
+	return s
+}
+
+// Options of an Engine. The zero value of a field takes its default.
+type Options struct {
+	// Bell is where the bell is rung, os.Stderr by default
+	Bell io.Writer
+	// Banner shows alerts. By default they're written to Bell, in
+	// reverse video
+	Banner func(a *Alert)
+	// Client that posts to webhooks, one with the timeout by default
+	Client *http.Client
+	// Timeout of commands and webhooks
+	Timeout time.Duration
+	// Errors of commands and webhooks are given to it, they're logged
+	// by default
+	Errors func(err error)
+	// Now is the time that matches happen at, time.Now by default
+	Now func() time.Time
+	// Queue of the commands and webhooks waiting to run. Those of
+	// alerts that fire while it's full are dropped
+	Queue int
+}
+
+// Engine checks entries against rules, and runs the actions of those that
+// fire. Commands and webhooks run in the background, one at a time, so
+// that a flood of alerts can't start a flood of processes. It's safe for
+// concurrent use.
+type Engine struct {
+	opts Options
+	jobs chan job
+	done chan struct{}
+
+	mu     sync.Mutex
+	rules  []*tracker
+	closed bool
+}
+
+// tracker counts the matches of a rule, for each key of its dedup
+// fields.
+type tracker struct {
+	rule *Rule
+	keys map[string]*window
+}
+
+type window struct {
+	// matches within the span of time of the rule, since it last fired
+	matches []time.Time
+	fired   time.Time
+	held    int
+	// last match, for keys that aren't seen anymore to be forgotten
+	seen time.Time
+}
+
+type job struct {
+	alert  *Alert
+	action Action
+}
+
+// NewEngine of the rules, that starts running their commands and
+// webhooks until it's closed.
+func NewEngine(rules []*Rule, opts Options) (*Engine, error) {
+	if opts.Bell == nil {
+		opts.Bell = os.Stderr
+	}
+	if opts.Banner == nil {
+		bell := opts.Bell
+		opts.Banner = func(a *Alert) { fmt.Fprintf(bell, "\x1b[7m %s \x1b[0m\n", a) }
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: opts.Timeout}
+	}
+	if opts.Errors == nil {
+		opts.Errors = func(err error) { log.Printf("alert: %v", err) }
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Queue <= 0 {
+		opts.Queue = DefaultQueue
+	}
+	e := &Engine{
+		opts: opts,
+		jobs: make(chan job, opts.Queue),
+		done: make(chan struct{}),
+	}
+	for _, r := range rules {
+		if err := r.check(); err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %v", r.Name, err)
+		}
+		r := *r
+		if r.Count == 0 {
+			r.Count = 1
+		}
+		if r.Within == 0 {
+			r.Within = DefaultWithin
+		}
+		if r.Cooldown == 0 {
+			r.Cooldown = r.Within
+		}
+		e.rules = append(e.rules, &tracker{rule: &r, keys: make(map[string]*window)})
+	}
+	go e.work()
+	return e, nil
+}
+
+// Add an entry and its line, and fire the rules that it makes fire. It
+// returns the alerts fired. The line and entry can be reused once it
+// returns, alerts keep copies of them.
+func (e *Engine) Add(line []byte, ent *parser.Entry) []*Alert {
+	now := e.opts.Now()
+	var (
+		fired []*Alert
+		rules []*Rule
+	)
+	e.mu.Lock()
+	for _, t := range e.rules {
+		matched, ok := t.rule.Query.Match(ent)
+		if !ok {
+			continue
+		}
+		if a := t.add(now, matched); a != nil {
+			a.Line = append([]byte(nil), line...)
+			fired = append(fired, a)
+			rules = append(rules, t.rule)
+		}
+	}
+	e.mu.Unlock()
+
+	for i, a := range fired {
+		for _, act := range rules[i].Actions {
+			e.run(a, act)
+		}
+	}
+	return fired
+}
+
+// add a match of the entry at now, and return the alert if it fires.
+func (t *tracker) add(now time.Time, ent *parser.Entry) *Alert {
+	r := t.rule
+	key := dedupKey(ent, r.Dedup)
+	w, ok := t.keys[key]
+	if !ok {
+		if len(t.keys) >= maxKeys {
+			t.forget(now)
+		}
+		w = &window{}
+		t.keys[key] = w
+	}
+	w.seen = now
+	since := now.Add(-r.Within)
+	i := 0
+	for i < len(w.matches) && w.matches[i].Before(since) {
+		i++
+	}
+	w.matches = append(w.matches[i:], now)
+	if len(w.matches) < r.Count {
+		return nil
+	}
+	w.matches = w.matches[:0]
+	if !w.fired.IsZero() && now.Sub(w.fired) < r.Cooldown {
+		w.held++
+		return nil
+	}
+	a := &Alert{
+		Rule:   r.Name,
+		Key:    key,
+		Count:  r.Count,
+		Within: r.Within,
+		At:     now,
+		Held:   w.held,
+		Entry:  detach(ent),
+	}
+	w.fired, w.held = now, 0
+	return a
+}
+
+// detach a copy of the entry from the buffer of the caller, that its raw
+// fields point into, for actions to read it after Add returns.
+func detach(e *parser.Entry) *parser.Entry {
+	c := e.Clone()
+	for _, name := range c.FieldNames() {
+		if raw, ok := e.Field(name); ok {
+			if raw, ok := raw.(parser.RawField); ok {
+				c.Set(name, append(parser.RawField(nil), raw...))
+			}
+		}
+	}
+	return c
+}
+
+// forget the keys that weren't seen for longer than the rule remembers
+// them, or all of them if there are still too many.
+func (t *tracker) forget(now time.Time) {
+	span := t.rule.Within
+	if t.rule.Cooldown > span {
+		span = t.rule.Cooldown
+	}
+	for key, w := range t.keys {
+		if now.Sub(w.seen) > span {
+			delete(t.keys, key)
+		}
+	}
+	if len(t.keys) >= maxKeys {
+		t.keys = make(map[string]*window)
+	}
+}
+
+// dedupKey of an entry is the values of the fields, like `msg=disk full`.
+func dedupKey(e *parser.Entry, fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	parts := make([]string, len(fields))
+	for i, name := range fields {
+		f, _ := e.Field(name)
+		parts[i] = fmt.Sprintf("%s=%v", name, f)
+	}
+	return strings.Join(parts, " ")
+}
+
+// run an action of an alert: the bell and the banner right away, commands
+// and webhooks in the background.
+func (e *Engine) run(a *Alert, act Action) {
+	switch act.Kind {
+	case Bell:
+		fmt.Fprint(e.opts.Bell, "\a")
+	case Banner:
+		e.opts.Banner(a)
+	default:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.closed {
+			return
+		}
+		select {
+		case e.jobs <- job{alert: a, action: act}:
+		default:
+			e.opts.Errors(fmt.Errorf("%s of %s dropped, too many are waiting", act, a.Rule))
+		}
+	}
+}
+
+func (e *Engine) work() {
+	defer close(e.done)
+	for j := range e.jobs {
+		if err := j.action.do(j.alert, e.opts); err != nil {
+			e.opts.Errors(fmt.Errorf("%s of %s: %v", j.action, j.alert.Rule, err))
+		}
+	}
+}
+
+// Close the engine, once the commands and webhooks waiting have run.
+// Those of the alerts fired after don't run.
+func (e *Engine) Close() {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.jobs)
+	}
+	e.mu.Unlock()
+	<-e.done
+}
@@ -0,0 +1,225 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clock that tests move by hand
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func mustParse(t *testing.T, q string) *query.Query {
+	t.Helper()
+	parsed, err := query.Parse(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func add(e *Engine, line string) []*Alert {
+	return e.Add([]byte(line), parser.ParseLine([]byte(line)))
+}
+
+func TestEngine(t *testing.T) {
+	c := &clock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	var bell, banners bytes.Buffer
+	e, err := NewEngine([]*Rule{{
+		Name:     "slow",
+		Query:    mustParse(t, "took>1s"),
+		Count:    3,
+		Within:   10 * time.Second,
+		Cooldown: time.Minute,
+		Actions:  []Action{{Kind: Bell}, {Kind: Banner}},
+	}, {
+		Name:    "fatal",
+		Query:   mustParse(t, "level=fatal"),
+		Dedup:   []string{"msg"},
+		Actions: []Action{{Kind: Banner}},
+	}}, Options{
+		Bell:   &bell,
+		Banner: func(a *Alert) { banners.WriteString(a.String() + "\n") },
+		Now:    c.Now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	slow := `{"took":"2s"}`
+	step := func(d time.Duration) { c.now = c.now.Add(d) }
+
+	// matches that fall out of the span don't count
+	add(e, slow)
+	step(11 * time.Second)
+	add(e, slow)
+	add(e, `{"took":"10ms"}`)
+	if got := add(e, slow); len(got) != 0 {
+		t.Fatalf("want no alert with 2 matches in the span, got %v", got)
+	}
+	got := add(e, slow)
+	if len(got) != 1 || got[0].Rule != "slow" || got[0].Count != 3 {
+		t.Fatalf("want slow to fire on the third match, got %v", got)
+	}
+	if bell.String() != "\a" {
+		t.Errorf("want the bell rung once, got %q", bell.String())
+	}
+
+	// the cooldown holds it back, then it tells how many were
+	for i := 0; i < 6; i++ {
+		step(time.Second)
+		if got := add(e, slow); len(got) != 0 {
+			t.Fatalf("want no alert during the cooldown, got %v", got)
+		}
+	}
+	step(time.Minute)
+	for i := 0; i < 2; i++ {
+		add(e, slow)
+	}
+	if got := add(e, slow); len(got) != 1 || got[0].Held != 2 {
+		t.Fatalf("want slow to fire after the cooldown, with 2 held back, got %v", got)
+	}
+
+	// alerts of other values of the dedup fields aren't held back
+	disk := `{"level":"fatal","msg":"disk full"}`
+	if got := add(e, disk); len(got) != 1 || got[0].Key != "msg=disk full" {
+		t.Fatalf("want fatal to fire for the disk, got %v", got)
+	}
+	if got := add(e, disk); len(got) != 0 {
+		t.Fatalf("want the same alert deduped, got %v", got)
+	}
+	if got := add(e, `{"level":"fatal","msg":"oom"}`); len(got) != 1 {
+		t.Fatalf("want fatal to fire for another message, got %v", got)
+	}
+	want := "alert fatal: 1 match within 1m0s for msg=oom\n"
+	if !strings.HasSuffix(banners.String(), want) {
+		t.Errorf("want the last banner %q, got %q", want, banners.String())
+	}
+}
+
+func TestCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "entry.json")
+	e, err := NewEngine([]*Rule{{
+		Name:    "fatal",
+		Query:   mustParse(t, "level=fatal"),
+		Actions: []Action{{Kind: Command, Command: []string{"sh", "-c", `cat > ` + out + `; echo "$LOGTERM_ALERT" >> ` + out}}},
+	}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(e, `level=fatal msg=boom`)
+	e.Close()
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"level\":\"fatal\",\"msg\":\"boom\"}\nfatal\n"; string(data) != want {
+		t.Errorf("want the entry on stdin and the rule in the environment %q, got %q", want, data)
+	}
+}
+
+func TestReusedLine(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "entry.json")
+	e, err := NewEngine([]*Rule{{
+		Name:    "fatal",
+		Query:   mustParse(t, "fatal"),
+		Actions: []Action{{Kind: Command, Command: []string{"sh", "-c", `cat > ` + out}}},
+	}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// like the buffer of a scanner, the line is overwritten by the next
+	buf := []byte("fatal: disk full")
+	e.Add(buf, parser.ParseLine(buf))
+	copy(buf, "XXXXXXXXXXXXXXXX")
+	e.Close()
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"raw\":\"fatal: disk full\"}\n"; string(data) != want {
+		t.Errorf("want the entry as it was added %q, got %q", want, data)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	got := make(chan payload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nope" {
+			http.NotFound(w, r)
+			return
+		}
+		var p payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		got <- p
+	}))
+	defer srv.Close()
+
+	var errs []error
+	e, err := NewEngine([]*Rule{{
+		Name:    "fatal",
+		Query:   mustParse(t, "level=fatal"),
+		Actions: []Action{{Kind: Webhook, URL: srv.URL}, {Kind: Webhook, URL: srv.URL + "/nope"}},
+	}}, Options{Errors: func(err error) { errs = append(errs, err) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(e, `{"level":"fatal","msg":"boom"}`)
+	e.Close()
+	p := <-got
+	if p.Alert != "fatal" || p.Count != 1 || string(p.Entry) != `{"level":"fatal","msg":"boom"}` {
+		t.Errorf("want the alert and its entry posted, got %+v", p)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "404") {
+		t.Errorf("want the error of the webhook that failed, got %v", errs)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, tt := range []struct {
+		spec, want string
+	}{
+		{"bell", "bell"},
+		{"banner", "banner"},
+		{"command notify-send logterm", "command notify-send logterm"},
+		{"webhook https://example.com/hook", "webhook https://example.com/hook"},
+		{"bell loud", "bell takes no arguments"},
+		{"command", "command needs"},
+		{"webhook example.com", "webhook needs an http"},
+		{"email", "unknown action"},
+	} {
+		a, err := ParseAction(tt.spec)
+		got := a.String()
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%q: want %q, got %q", tt.spec, tt.want, got)
+		}
+	}
+}
+
+func TestRuleErrors(t *testing.T) {
+	for _, r := range []*Rule{
+		{Name: "none", Actions: []Action{{Kind: Bell}}},
+		{Name: "agg", Query: mustParse(t, "| count by level"), Actions: []Action{{Kind: Bell}}},
+		{Name: "idle", Query: mustParse(t, "level=error")},
+	} {
+		if _, err := NewEngine([]*Rule{r}, Options{}); err == nil {
+			t.Errorf("%s: want an error", r.Name)
+		}
+	}
+}
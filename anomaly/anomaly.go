@@ -0,0 +1,272 @@
+// Package anomaly learns what a log looks like during a session, and
+// flags what departs from it: messages of a template that was never seen,
+// and windows of time where the share of errors jumps.
+package anomaly
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/templates"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWindow       = time.Minute
+	DefaultLearnWindows = 5
+	DefaultThreshold    = 3
+	DefaultMinDeviation = 0.05
+	DefaultMinEntries   = 10
+	DefaultMaxAnomalies = 1000
+)
+
+// fields that hold the level of an entry, and the levels that are errors
+var (
+	levelFields = []string{"level", "lvl", "severity", "loglevel"}
+	errorLevels = map[string]bool{
+		"error": true, "err": true, "fatal": true, "panic": true,
+		"critical": true, "crit": true, "alert": true, "emerg": true,
+	}
+)
+
+// Options of a Detector. The zero value of a field takes its default.
+type Options struct {
+	// Window is the span of time over which error rates are measured
+	Window time.Duration
+	// LearnWindows are how many windows make the baseline before
+	// anything is flagged
+	LearnWindows int
+	// Threshold is how many standard deviations away from the baseline
+	// an error rate must be to be flagged
+	Threshold float64
+	// MinDeviation is the least difference with the baseline error rate
+	// that is flagged, for baselines that barely vary
+	MinDeviation float64
+	// MinEntries a window needs for its error rate to mean something
+	MinEntries int
+	// MaxAnomalies that are remembered
+	MaxAnomalies int
+}
+
+// Kind of anomaly.
+type Kind int
+
+const (
+	NewTemplate Kind = iota + 1
+	ErrorRate
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NewTemplate:
+		return "new template"
+	case ErrorRate:
+		return "error rate"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Anomaly is something that departs from the baseline.
+type Anomaly struct {
+	Kind Kind
+	// From and To are the lines of the history that it covers
+	From, To uint64
+	// Start and End are when it happened
+	Start, End time.Time
+	// Template of a new message
+	Template string
+	// Rate of errors in the window, and in the baseline
+	Rate, Baseline float64
+}
+
+func (a Anomaly) String() string {
+	if a.Kind == ErrorRate {
+		return fmt.Sprintf("%.0f%% errors, usually %.0f%%", a.Rate*100, a.Baseline*100)
+	}
+	return a.Template
+}
+
+// Detector flags the anomalies of the entries it's given. It's safe for
+// concurrent use.
+type Detector struct {
+	opts  Options
+	miner *templates.Miner
+	now   func() time.Time
+
+	mu sync.Mutex
+	// completed windows, and the running mean and variance of their
+	// error rates, by Welford's method
+	windows  int
+	baseline int
+	mean, m2 float64
+	win      *window
+
+	anomalies []Anomaly
+	lines     map[uint64]Kind
+}
+
+type window struct {
+	start    time.Time
+	from, to uint64
+	total    int
+	errors   int
+}
+
+func NewDetector(opts Options) *Detector {
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.LearnWindows <= 0 {
+		opts.LearnWindows = DefaultLearnWindows
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultThreshold
+	}
+	if opts.MinDeviation <= 0 {
+		opts.MinDeviation = DefaultMinDeviation
+	}
+	if opts.MinEntries <= 0 {
+		opts.MinEntries = DefaultMinEntries
+	}
+	if opts.MaxAnomalies <= 0 {
+		opts.MaxAnomalies = DefaultMaxAnomalies
+	}
+	return &Detector{
+		opts:  opts,
+		miner: templates.NewMiner(templates.Options{}),
+		now:   time.Now,
+		lines: make(map[uint64]Kind),
+	}
+}
+
+// Add the entry on line n of the history, and return the anomalies that it
+// reveals.
+func (d *Detector) Add(n uint64, line []byte, e *parser.Entry) []Anomaly {
+	at, ok := e.Time()
+	if !ok {
+		at = d.now()
+	}
+	tmpl := d.miner.Add(templates.Message(line, e), at)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var found []Anomaly
+	if d.win != nil && !at.Before(d.win.start.Add(d.opts.Window)) {
+		if a, ok := d.closeWindow(); ok {
+			found = append(found, a)
+		}
+	}
+	if d.win == nil {
+		d.win = &window{start: at.Truncate(d.opts.Window), from: n}
+	}
+	d.win.to = n
+	d.win.total++
+	if isError(e) {
+		d.win.errors++
+	}
+
+	if tmpl.Count == 1 && d.windows >= d.opts.LearnWindows {
+		found = append(found, Anomaly{
+			Kind:     NewTemplate,
+			From:     n,
+			To:       n,
+			Start:    at,
+			End:      at,
+			Template: tmpl.String(),
+		})
+	}
+	for _, a := range found {
+		d.remember(a)
+	}
+	return found
+}
+
+// closeWindow ends the current window, and compares its error rate with
+// the baseline. Anomalous windows stay out of the baseline.
+func (d *Detector) closeWindow() (Anomaly, bool) {
+	w := d.win
+	d.win = nil
+	d.windows++
+	if w.total < d.opts.MinEntries {
+		return Anomaly{}, false
+	}
+	rate := float64(w.errors) / float64(w.total)
+	if d.baseline >= d.opts.LearnWindows {
+		std := math.Sqrt(d.m2 / float64(d.baseline))
+		dev := math.Abs(rate - d.mean)
+		if dev >= d.opts.MinDeviation && dev > d.opts.Threshold*std {
+			return Anomaly{
+				Kind:     ErrorRate,
+				From:     w.from,
+				To:       w.to,
+				Start:    w.start,
+				End:      w.start.Add(d.opts.Window),
+				Rate:     rate,
+				Baseline: d.mean,
+			}, true
+		}
+	}
+	d.baseline++
+	delta := rate - d.mean
+	d.mean += delta / float64(d.baseline)
+	d.m2 += delta * (rate - d.mean)
+	return Anomaly{}, false
+}
+
+func (d *Detector) remember(a Anomaly) {
+	if len(d.anomalies) >= d.opts.MaxAnomalies {
+		old := d.anomalies[0]
+		d.anomalies = d.anomalies[1:]
+		if old.Kind == NewTemplate {
+			delete(d.lines, old.From)
+		}
+	}
+	d.anomalies = append(d.anomalies, a)
+	if a.Kind == NewTemplate {
+		d.lines[a.From] = a.Kind
+	}
+}
+
+// Anomalies returns those found so far, the latest last.
+func (d *Detector) Anomalies() []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Anomaly(nil), d.anomalies...)
+}
+
+// Flagged tells if line n of the history is part of an anomaly. Lines of a
+// new template win over the window they're in.
+func (d *Detector) Flagged(n uint64) (Kind, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if k, ok := d.lines[n]; ok {
+		return k, true
+	}
+	for i := len(d.anomalies) - 1; i >= 0; i-- {
+		if a := d.anomalies[i]; a.Kind == ErrorRate && a.From <= n && n <= a.To {
+			return a.Kind, true
+		}
+	}
+	return 0, false
+}
+
+// Baseline is the usual share of errors in a window, and how many windows
+// it was learned from.
+func (d *Detector) Baseline() (rate float64, windows int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mean, d.baseline
+}
+
+func isError(e *parser.Entry) bool {
+	for _, name := range levelFields {
+		if f, ok := e.Field(name); ok {
+			if s, ok := f.(parser.StringField); ok {
+				return errorLevels[strings.ToLower(string(s))]
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package anomaly
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2014, 10, 27, 18, 0, 0, 0, time.UTC)
+
+// feeder adds minutes of entries to a detector.
+type feeder struct {
+	d *Detector
+	n uint64
+}
+
+// window adds `per` entries to minute i, `errs` of them errors, then the
+// extra messages. It returns what was found.
+func (f *feeder) window(t *testing.T, i, per, errs int, extra ...string) []Anomaly {
+	var found []Anomaly
+	add := func(line string) {
+		e := parser.ParseLine([]byte(line))
+		found = append(found, f.d.Add(f.n, []byte(line), e)...)
+		f.n++
+	}
+	for j := 0; j < per; j++ {
+		at := start.Add(time.Duration(i)*time.Minute + time.Duration(j)*time.Second)
+		level := "info"
+		if j < errs {
+			level = "error"
+		}
+		add(fmt.Sprintf(`time=%s level=%s msg="handled request %d"`, at.Format(time.RFC3339), level, j))
+	}
+	for _, msg := range extra {
+		at := start.Add(time.Duration(i)*time.Minute + 59*time.Second)
+		add(fmt.Sprintf(`time=%s level=info msg=%q`, at.Format(time.RFC3339), msg))
+	}
+	return found
+}
+
+func TestNewTemplates(t *testing.T) {
+	f := &feeder{d: NewDetector(Options{LearnWindows: 2})}
+	// learning: nothing is new yet
+	if found := f.window(t, 0, 20, 1, "cache warmed in 12ms"); len(found) != 0 {
+		t.Fatalf("want nothing while learning, got %v", found)
+	}
+	f.window(t, 1, 20, 1)
+	f.window(t, 2, 20, 1, "cache warmed in 30ms")
+	found := f.window(t, 3, 20, 1, "disk full on /dev/sda1")
+	if len(found) != 1 || found[0].Kind != NewTemplate || found[0].Template != "disk full on /dev/sda1" {
+		t.Fatalf("want the new template, got %v", found)
+	}
+	if k, ok := f.d.Flagged(found[0].From); !ok || k != NewTemplate {
+		t.Errorf("want line %d flagged, got %v, %v", found[0].From, k, ok)
+	}
+	if _, ok := f.d.Flagged(found[0].From - 1); ok {
+		t.Errorf("want line %d not flagged", found[0].From-1)
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	f := &feeder{d: NewDetector(Options{LearnWindows: 3})}
+	for i := 0; i < 6; i++ {
+		// between 1 and 2 errors out of 40
+		if found := f.window(t, i, 40, 1+i%2); len(found) != 0 {
+			t.Fatalf("window %d: want nothing, got %v", i, found)
+		}
+	}
+	// the window is only judged once it's over
+	f.window(t, 6, 40, 20)
+	found := f.window(t, 7, 40, 1)
+	if len(found) != 1 || found[0].Kind != ErrorRate {
+		t.Fatalf("want the error rate of window 6, got %v", found)
+	}
+	a := found[0]
+	if !a.Start.Equal(start.Add(6*time.Minute)) || a.Rate != 0.5 || a.Baseline < 0.02 || a.Baseline > 0.05 {
+		t.Errorf("want 50%% errors at %v over a baseline of ~3%%, got %+v", start.Add(6*time.Minute), a)
+	}
+	if k, ok := f.d.Flagged(a.From + 5); !ok || k != ErrorRate {
+		t.Errorf("want the lines of the window flagged, got %v, %v", k, ok)
+	}
+	// the spike stays out of the baseline
+	if rate, windows := f.d.Baseline(); rate > 0.05 || windows != 6 {
+		t.Errorf("want a baseline of ~3%% over 6 windows, got %v over %d", rate, windows)
+	}
+}
+
+func TestSmallWindowsAreNotJudged(t *testing.T) {
+	f := &feeder{d: NewDetector(Options{LearnWindows: 1, MinEntries: 10})}
+	f.window(t, 0, 20, 0)
+	f.window(t, 1, 20, 0)
+	f.window(t, 2, 3, 3)
+	if found := f.window(t, 3, 20, 0); len(found) != 0 {
+		t.Errorf("want too few entries to judge, got %v", found)
+	}
+}
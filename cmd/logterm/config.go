@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/aybabtme/logterm/config"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/state"
+	"log"
+	"path/filepath"
+)
+
+// loadConfig loads the config at path, or the default one.
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	parser.AddTimeLayouts(cfg.TimeLayouts...)
+	return cfg, nil
+}
+
+// addSavedQueries adds the queries saved in the pager to those of the
+// config, that win over them. The state isn't made when it's not there,
+// and when it can't be read, the saved queries are left out.
+func addSavedQueries(cfg *config.Config) {
+	dir, err := state.Dir()
+	if err != nil {
+		return
+	}
+	saved, err := state.OpenSaved(filepath.Join(dir, "saved-queries"))
+	if err != nil {
+		log.Printf("can't read the saved queries: %v", err)
+		return
+	}
+	for name, q := range saved.All() {
+		if _, ok := cfg.Queries[name]; !ok {
+			cfg.Queries[name] = q
+		}
+	}
+}
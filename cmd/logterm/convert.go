@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"github.com/aybabtme/logterm/encode"
+	"github.com/aybabtme/logterm/extract"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/redact"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// runConvert is the `convert` subcommand: it writes the entries of files,
+// or of stdin, in another format, like `logterm convert --to csv`.
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := fs.String("to", "json", "format to write, one of "+strings.Join(encode.Formats, ", "))
+	columns := fs.String("columns", "", "fields to write in csv and tsv, like `time,level,msg`, those of the first entry by default")
+	queryFlag := fs.String("q", "", "only convert the entries that match this query, with the fields that its stages set")
+	rulesFlag := fs.String("rules", "", "file of grok patterns and rules that extract fields from lines")
+	redactFlag := fs.String("redact", "", "hide secrets from entries, `on` for the defaults, or options like `action=hash`")
+	configFlag := fs.String("config", "", "config file, whose aliases, rules and saved queries are used")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	var cols []string
+	if *columns != "" {
+		cols = strings.Split(*columns, ",")
+	}
+	enc, err := encode.New(*to, os.Stdout, cols)
+	if err != nil {
+		log.Fatalf("invalid -to: %v", err)
+	}
+	var q *query.Query
+	if *queryFlag != "" {
+		addSavedQueries(cfg)
+		if q, err = cfg.ParseQuery(*queryFlag); err != nil {
+			log.Fatalf("invalid -q: %v", err)
+		}
+		if q.Aggregates() {
+			log.Fatal("invalid -q: only entries can be converted, not the table of an aggregation")
+		}
+	}
+	var rules extract.Rules
+	if *rulesFlag != "" {
+		if rules, err = extract.LoadFile(*rulesFlag, cfg.Patterns); err != nil {
+			log.Fatalf("invalid -rules: %v", err)
+		}
+	}
+
+	var r *redact.Redactor
+	if *redactFlag != "" {
+		if r, err = newRedactor(*redactFlag); err != nil {
+			log.Fatalf("invalid -redact: %v", err)
+		}
+	}
+
+	convert := func(src io.Reader) {
+		if r != nil {
+			src = redact.NewReader(src, r)
+		}
+		scan := bufio.NewScanner(src)
+		scan.Buffer(nil, 1<<20)
+		for scan.Scan() {
+			e := rules.Apply(cfg.Parse(scan.Bytes()))
+			if q != nil {
+				var ok bool
+				if e, ok = q.Match(e); !ok {
+					continue
+				}
+			}
+			if err := enc.Encode(e); err != nil {
+				log.Fatalf("can't write entries: %v", err)
+			}
+		}
+		if err := scan.Err(); err != nil {
+			log.Fatalf("error with input source: %v", err)
+		}
+	}
+	if fs.NArg() == 0 {
+		convert(os.Stdin)
+	}
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatalf("can't open file %q, %v", name, err)
+		}
+		convert(f)
+		f.Close()
+	}
+	if err := enc.Flush(); err != nil {
+		log.Fatalf("can't write entries: %v", err)
+	}
+}
+
+// newRedactor with the options of a -redact flag.
+func newRedactor(spec string) (*redact.Redactor, error) {
+	opts, err := redact.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return redact.New(opts)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"github.com/aybabtme/logterm/correlate"
+	"github.com/aybabtme/logterm/extract"
+	"github.com/aybabtme/logterm/parser"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// runCorrelate is the `correlate` subcommand: it groups the entries of
+// files, or of stdin, that share a request or trace identifier, and
+// prints each group in time order.
+func runCorrelate(args []string) {
+	fs := flag.NewFlagSet("correlate", flag.ExitOnError)
+	by := fs.String("by", strings.Join(correlate.DefaultFields, ","), "fields that identify a request, the first that an entry has is used")
+	id := fs.String("id", "", "only show the group of this identifier")
+	min := fs.Int("min", 1, "only show groups of at least this many entries")
+	rulesFlag := fs.String("rules", "", "file of grok patterns and rules that extract fields from lines")
+	configFlag := fs.String("config", "", "config file, whose aliases and rules are used")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+	var rules extract.Rules
+	if *rulesFlag != "" {
+		if rules, err = extract.LoadFile(*rulesFlag, cfg.Patterns); err != nil {
+			log.Fatalf("invalid -rules: %v", err)
+		}
+	}
+	parse := func(line []byte) *parser.Entry { return rules.Apply(cfg.Parse(line)) }
+
+	c := correlate.NewCorrelator(strings.Split(*by, ","), 0)
+	// entries of many files are grouped together, and tell which file
+	// they come from
+	var n uint64
+	add := func(source string, src io.Reader) {
+		scan := bufio.NewScanner(src)
+		scan.Buffer(nil, 1<<20)
+		for scan.Scan() {
+			line := scan.Bytes()
+			c.AddFrom(source, n, line, parse(line))
+			n++
+		}
+		if err := scan.Err(); err != nil {
+			log.Fatalf("error with input source: %v", err)
+		}
+	}
+	if fs.NArg() == 0 {
+		add("", os.Stdin)
+	}
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatalf("can't open file %q, %v", name, err)
+		}
+		add(name, f)
+		f.Close()
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	first := true
+	for _, g := range c.Groups() {
+		if len(g.Entries) < *min || (*id != "" && g.Value != *id) {
+			continue
+		}
+		if !first {
+			w.WriteByte('\n')
+		}
+		first = false
+		if _, err := g.Table(fs.NArg() > 1).WriteTo(w); err != nil {
+			log.Fatalf("can't write groups: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("can't write groups: %v", err)
+	}
+}
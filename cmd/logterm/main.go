@@ -6,6 +6,16 @@ import (
 	"flag"
 	"fmt"
 	"github.com/aybabtme/iocontrol"
+	"github.com/aybabtme/logterm/alert"
+	"github.com/aybabtme/logterm/columns"
+	"github.com/aybabtme/logterm/config"
+	"github.com/aybabtme/logterm/extract"
+	"github.com/aybabtme/logterm/format"
+	"github.com/aybabtme/logterm/metrics"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/redact"
+	"github.com/aybabtme/logterm/timefmt"
 	"github.com/aybabtme/tailf"
 	"github.com/dustin/go-humanize"
 	"io"
@@ -36,15 +46,90 @@ var debugComplete = func(line string, pos int, key rune) (string, int, bool) {
 
 func main() {
 	log.SetFlags(0)
+	if len(os.Args) > 1 && os.Args[1] == "templates" {
+		runTemplates(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "correlate" {
+		runCorrelate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		runConvert(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+		return
+	}
 	tui := flag.Bool("tui", false, "run as an interactive terminal interface")
 	follow := flag.String("f", "", "file to follow")
 	tail := flag.Bool("tail", false, "when following a file, don't first read the whole file's content (similar to `tail -f`)")
+	sinceFlag := flag.String("since", "", "only show entries at or after this time, like `2014-10-27T18:38`")
+	untilFlag := flag.String("until", "", "only show entries at or before this time")
+	queryFlag := flag.String("q", "", "only show entries that match this query, or the table of its aggregation, like `level=error | count by service`")
+	rulesFlag := flag.String("rules", "", "file of grok patterns and rules that extract fields from lines, for -q")
+	columnsFlag := flag.String("columns", "", "show entries as aligned columns of these fields, like `time,level,service,msg,latency`")
+	formatFlag := flag.String("format", "", "render entries with this text/template, or one of the builtin templates: "+strings.Join(format.Names(), ", "))
+	timeFlag := flag.String("time", "", "show times in `utc`, `local`, an IANA zone like America/Montreal, or relative: `ago` or `delta` since the entry before")
+	redactFlag := flag.String("redact", "", "hide secrets from entries, `on` for the defaults, or options like `action=hash detect=jwt,card fields=password,token`")
+	configFlag := flag.String("config", "", "config file, by default ~/.config/logterm/config.toml or config.yaml if there's one")
+	profileFlag := flag.String("p", "", "profile of the config, whose sources are read and whose options are the defaults of the flags")
+	alertsFlag := flag.Bool("alerts", true, "run the alerts of the config on the entries, telling them on stderr")
+	metricsFlag := flag.String("metrics", "", "serve the metrics of the config on /metrics at this address, like `:9100`, until interrupted")
 	flag.Parse()
 
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+	var profile *config.Profile
+	if *profileFlag != "" {
+		if profile, err = cfg.Profile(*profileFlag); err != nil {
+			log.Fatalf("invalid -p: %v", err)
+		}
+		profile.SetFlags(flag.CommandLine)
+	}
+
+	var q *query.Query
+	if *queryFlag != "" {
+		addSavedQueries(cfg)
+		if q, err = cfg.ParseQuery(*queryFlag); err != nil {
+			log.Fatalf("invalid -q: %v", err)
+		}
+	}
+	var rules extract.Rules
+	if *rulesFlag != "" {
+		if rules, err = extract.LoadFile(*rulesFlag, cfg.Patterns); err != nil {
+			log.Fatalf("invalid -rules: %v", err)
+		}
+	}
+	parse := func(line []byte) *parser.Entry { return rules.Apply(cfg.Parse(line)) }
+
+	since, err := parseTimeFlag(*sinceFlag)
+	if err != nil {
+		log.Fatalf("invalid -since: %v", err)
+	}
+	until, err := parseTimeFlag(*untilFlag)
+	if err != nil {
+		log.Fatalf("invalid -until: %v", err)
+	}
+
 	var src io.Reader
-	var err error
-	if *follow != "" {
-		fsrc, err := tailf.Follow(*follow, !*tail)
+	// whether src may never end
+	followed := true
+	if profile != nil && *follow == "" && flag.NArg() == 0 {
+		if src, err = profileSource(profile, *tail); err != nil {
+			log.Fatalf("can't read the sources of profile %q: %v", profile.Name, err)
+		}
+		followed = profile.Follow || len(profile.Command) > 0
+	} else if *follow != "" {
+		var fsrc io.ReadCloser
+		if !since.IsZero() && !*tail {
+			fsrc, err = followSince(*follow, since)
+		} else {
+			fsrc, err = tailf.Follow(*follow, !*tail)
+		}
 		if err != nil {
 			log.Fatalf("can't follow file %q, %v", *follow, err)
 		}
@@ -56,8 +141,64 @@ func main() {
 			log.Printf("can't read output of command %q, %v", strings.Join(flag.Args(), " "), err)
 			log.Fatal("no file to follow, need a command or a file to follow when in interactive mode")
 		}
+	} else if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("can't open file %q, %v", flag.Arg(0), err)
+		}
+		defer f.Close()
+		src = f
+		followed = false
 	} else {
 		src = os.Stdin
+		// unless it's redirected from a file
+		if fi, err := os.Stdin.Stat(); err == nil && fi.Mode().IsRegular() {
+			followed = false
+		}
+	}
+
+	if !since.IsZero() || !until.IsZero() {
+		src, err = timeRange(src, since, until)
+		if err != nil {
+			log.Fatalf("can't seek to %v: %v", since, err)
+		}
+	}
+	if *redactFlag != "" {
+		r, err := newRedactor(*redactFlag)
+		if err != nil {
+			log.Fatalf("invalid -redact: %v", err)
+		}
+		src = redact.NewReader(src, r)
+	}
+	var watchers []func(line []byte, e *parser.Entry)
+	if *alertsFlag && len(cfg.Alerts) > 0 {
+		alerts, err := alert.NewEngine(cfg.Alerts, alert.Options{})
+		if err != nil {
+			log.Fatalf("invalid alerts: %v", err)
+		}
+		// the commands and webhooks of the last alerts run before exiting
+		defer alerts.Close()
+		watchers = append(watchers, func(line []byte, e *parser.Entry) { alerts.Add(line, e) })
+	}
+	if *metricsFlag != "" {
+		if len(cfg.Metrics) == 0 {
+			log.Fatal("-metrics needs metrics in the config")
+		}
+		reg, err := metrics.NewRegistry(cfg.Metrics)
+		if err != nil {
+			log.Fatalf("invalid metrics: %v", err)
+		}
+		if err := serveMetrics(*metricsFlag, reg); err != nil {
+			log.Fatalf("can't serve metrics: %v", err)
+		}
+		watchers = append(watchers, func(_ []byte, e *parser.Entry) { reg.Add(e) })
+	}
+	if len(watchers) > 0 {
+		src = watching(src, parse, func(line []byte, e *parser.Entry) {
+			for _, watch := range watchers {
+				watch(line, e)
+			}
+		})
 	}
 
 	var out io.Writer
@@ -82,10 +223,46 @@ func main() {
 		out = os.Stdout
 	}
 
-	_, err = io.Copy(out, src)
+	var how output
+	if *columnsFlag != "" && *formatFlag != "" {
+		log.Fatal("can't use both -columns and -format")
+	}
+	if *columnsFlag != "" {
+		how.columns = columns.Parse(*columnsFlag)
+	}
+	if *formatFlag != "" {
+		_, colors := termWidth(out)
+		if how.format, err = format.New(*formatFlag, colors); err != nil {
+			log.Fatalf("invalid -format: %v", err)
+		}
+		cfg.SetTemplate(how.format)
+	}
+	how.times = cfg.Times
+	if *timeFlag != "" {
+		if how.times, err = timefmt.Parse(*timeFlag); err != nil {
+			log.Fatalf("invalid -time: %v", err)
+		}
+	}
+	if followed {
+		how.refresh = aggregateRefresh
+	}
+	if q == nil && (how.columns != nil || how.format != nil || how.times.Mode != timefmt.Parsed) {
+		// a query that matches everything
+		q, _ = query.Parse("")
+	}
+	if q != nil {
+		err = copyQuery(out, src, q, parse, how)
+	} else {
+		_, err = io.Copy(out, src)
+	}
 	if err != nil {
 		log.Fatalf("error with input source: %v", err)
 	}
+	if *metricsFlag != "" {
+		// scrapes go on once the input is read
+		log.Print("read all the input, still serving metrics")
+		select {}
+	}
 }
 
 func followCommand(args []string) (io.Reader, error) {
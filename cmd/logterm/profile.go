@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aybabtme/logterm/config"
+	"github.com/aybabtme/tailf"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// profileSource reads the sources of a profile one after the other, or
+// follows the one it has, or reads the output of its command.
+func profileSource(p *config.Profile, tail bool) (io.Reader, error) {
+	if len(p.Command) > 0 {
+		return followCommand(p.Command)
+	}
+	var paths []string
+	for _, source := range p.Sources {
+		matches, err := filepath.Glob(source)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no file is like %q", source)
+		}
+		paths = append(paths, matches...)
+	}
+	switch {
+	case len(paths) == 0:
+		return nil, errors.New("the profile has no sources or command")
+	case p.Follow && len(paths) > 1:
+		return nil, fmt.Errorf("can only follow one file, the sources are %s", strings.Join(paths, ", "))
+	case p.Follow:
+		return tailf.Follow(paths[0], !tail)
+	}
+	files := make([]*os.File, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return &filesReader{files: files}, nil
+}
+
+// filesReader reads files one after the other, closing each at its end.
+// A file whose last line has no newline gets one, so that it isn't
+// joined to the first line of the next.
+type filesReader struct {
+	files []*os.File
+	// last byte read from the current file
+	last byte
+}
+
+func (r *filesReader) Read(b []byte) (int, error) {
+	for len(r.files) > 0 {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		f := r.files[0]
+		n, err := f.Read(b)
+		if n > 0 {
+			r.last = b[n-1]
+			return n, nil
+		}
+		if err != io.EOF {
+			return 0, err
+		}
+		f.Close()
+		r.files = r.files[1:]
+		if r.last != 0 && r.last != '\n' {
+			r.last = 0
+			b[0] = '\n'
+			return 1, nil
+		}
+		r.last = 0
+	}
+	return 0, io.EOF
+}
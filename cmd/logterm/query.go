@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"code.google.com/p/go.crypto/ssh/terminal"
+	"github.com/aybabtme/logterm/columns"
+	"github.com/aybabtme/logterm/format"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/timefmt"
+	"github.com/aybabtme/logterm/ui"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// size of the charts, terminals can make them wider
+const (
+	chartWidth  = 80
+	chartHeight = 20
+)
+
+// how often the table or chart of a followed source is written
+const aggregateRefresh = 2 * time.Second
+
+// output is how the entries that match are written: as their lines by
+// default.
+type output struct {
+	// columns of fields to show, or
+	columns []string
+	// a template to render entries with
+	format *format.Template
+	// how times are shown. Unless they're shown as they were parsed,
+	// lines are prefixed with their time
+	times timefmt.Display
+	// when the source is followed, how often the table or chart of a
+	// query that aggregates is written, instead of only at its end
+	refresh time.Duration
+}
+
+// copyQuery copies the lines of src that match q, once parsed with parse,
+// or writes their entries as out asks. When q aggregates, its table or
+// chart is written instead, at the end of src.
+func copyQuery(dst io.Writer, src io.Reader, q *query.Query, parse func([]byte) *parser.Entry, out output) error {
+	if q.Aggregates() {
+		return copyAggregate(dst, src, q, parse, out.refresh)
+	}
+	w := bufio.NewWriter(dst)
+	scan := bufio.NewScanner(src)
+	scan.Buffer(nil, 1<<20)
+	var buf []byte
+	var cols *ui.ColumnWriter
+	if out.columns != nil {
+		// columns are only cut to fit a terminal
+		width, _ := termWidth(dst)
+		if width <= 0 {
+			width = math.MaxInt32
+		}
+		cols = ui.NewColumnWriter(w, out.columns, width)
+	}
+	if out.format != nil {
+		out.format.SetTimes(out.times)
+	}
+	// time of the entry before, for times shown relative to it
+	var prev time.Time
+	var n uint64
+	for ; scan.Scan(); n++ {
+		line := scan.Bytes()
+		e, ok := q.Match(parse(line))
+		if !ok {
+			continue
+		}
+		at, timed := e.Time()
+		switch {
+		case cols != nil:
+			row := columns.NewRow(n, line, e, out.columns)
+			if out.times.Mode != timefmt.Parsed {
+				row.FormatTimes(out.times, prev)
+			}
+			if err := cols.Write(row.Cells); err != nil {
+				return err
+			}
+		case out.format != nil:
+			if err := out.format.Execute(w, line, e); err != nil {
+				return err
+			}
+		default:
+			buf = buf[:0]
+			if timed && out.times.Mode != timefmt.Parsed {
+				buf = append(buf, out.times.Format(at, prev, timefmt.Layout)...)
+				buf = append(buf, ' ')
+			}
+			// the scanner owns line, computed fields go with a copy of it
+			buf = q.AppendComputed(buf, line, e)
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			if err := w.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		if timed {
+			prev = at
+		}
+		// the source can be followed, don't hold lines back
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// copyAggregate adds the entries of src to q, and writes its table or
+// chart at the end of src. When refresh isn't zero, it's also written
+// that often while entries keep coming, since a followed source may
+// never end.
+func copyAggregate(dst io.Writer, src io.Reader, q *query.Query, parse func([]byte) *parser.Entry, refresh time.Duration) error {
+	var (
+		mu sync.Mutex
+		// entries added since the table was last written
+		added   bool
+		written bool
+	)
+	write := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if written {
+			// apart from the one before
+			if _, err := io.WriteString(dst, "\n"); err != nil {
+				return err
+			}
+		}
+		added, written = false, true
+		return writeAggregate(dst, q)
+	}
+
+	// the refreshes stop before the table is written at the end
+	done, stopped := make(chan struct{}), make(chan struct{})
+	var refreshErr error
+	if refresh > 0 {
+		tick := time.NewTicker(refresh)
+		defer tick.Stop()
+		go func() {
+			defer close(stopped)
+			for {
+				select {
+				case <-done:
+					return
+				case <-tick.C:
+				}
+				mu.Lock()
+				changed := added
+				mu.Unlock()
+				if !changed {
+					continue
+				}
+				if err := write(); err != nil {
+					mu.Lock()
+					refreshErr = err
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	} else {
+		close(stopped)
+	}
+	stop := func() {
+		close(done)
+		<-stopped
+	}
+
+	scan := bufio.NewScanner(src)
+	scan.Buffer(nil, 1<<20)
+	for scan.Scan() {
+		q.Add(parse(scan.Bytes()))
+		mu.Lock()
+		added = true
+		err := refreshErr
+		mu.Unlock()
+		if err != nil {
+			stop()
+			return err
+		}
+	}
+	stop()
+	if err := scan.Err(); err != nil {
+		return err
+	}
+	return write()
+}
+
+// writeAggregate writes the chart of q, if it has one, or its table.
+func writeAggregate(dst io.Writer, q *query.Query) error {
+	w := bufio.NewWriter(dst)
+	if chart := q.Chart(); chart != nil {
+		width, height := chartWidth, chartHeight
+		termCols, colors := termWidth(dst)
+		if termCols > 0 {
+			width = termCols
+		}
+		if err := ui.WriteChart(w, chart, width, height, colors); err != nil {
+			return err
+		}
+	} else if _, err := q.Table().WriteTo(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// termWidth is the width of dst, if it's a terminal.
+func termWidth(dst io.Writer) (int, bool) {
+	f, ok := dst.(*os.File)
+	if !ok || !terminal.IsTerminal(int(f.Fd())) {
+		return 0, false
+	}
+	width, _, err := terminal.GetSize(int(f.Fd()))
+	if err != nil {
+		return 0, true
+	}
+	return width, true
+}
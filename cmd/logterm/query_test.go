@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is written by the refreshes, and read by the test.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCopyQueryRefresh(t *testing.T) {
+	q, err := query.Parse("| count by level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, wr := io.Pipe()
+	var dst lockedBuffer
+	errc := make(chan error, 1)
+	go func() {
+		errc <- copyQuery(&dst, rd, q, parser.ParseLine, output{refresh: 10 * time.Millisecond})
+	}()
+
+	// the source doesn't end, but its table is written
+	io.WriteString(wr, "level=info\nlevel=info\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(dst.String(), "info   2") {
+		if time.Now().After(deadline) {
+			t.Fatalf("want the table while following, got %q", dst.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	io.WriteString(wr, "level=error\n")
+	wr.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.String(); !strings.HasSuffix(got, "\nlevel  count\ninfo   2\nerror  1\n") {
+		t.Errorf("want the last table at the end, got %q", got)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"github.com/aybabtme/logterm/config"
+	"github.com/aybabtme/logterm/extract"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/redact"
+	"github.com/aybabtme/logterm/web"
+	"github.com/aybabtme/tailf"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runServe is the `serve` subcommand: it keeps the lines of a file, a
+// command or stdin in a history, and serves them to browsers, which each
+// follow the entries that match their own query, like
+// `logterm serve -f app.log`.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to serve on, `:8080` lets others on the network look too")
+	follow := fs.String("f", "", "file to follow")
+	tail := fs.Bool("tail", false, "when following a file, don't first read the whole file's content (similar to `tail -f`)")
+	rulesFlag := fs.String("rules", "", "file of grok patterns and rules that extract fields from lines")
+	redactFlag := fs.String("redact", "", "hide secrets from entries, `on` for the defaults, or options like `action=hash`")
+	configFlag := fs.String("config", "", "config file, whose aliases, rules and saved queries are used")
+	profileFlag := fs.String("p", "", "profile of the config, whose sources are read")
+	histDir := fs.String("history", "", "directory where to keep the history, default to a temporary one")
+	histSize := fs.Int64("history-size", 1<<30, "bytes of history to keep")
+	histAge := fs.Duration("history-age", 24*time.Hour, "how long to keep history for")
+	backlog := fs.Int("backlog", web.DefaultBacklog, "entries that match sent to browsers before those that come next")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+	var profile *config.Profile
+	if *profileFlag != "" {
+		if profile, err = cfg.Profile(*profileFlag); err != nil {
+			log.Fatalf("invalid -p: %v", err)
+		}
+		profile.SetFlags(fs)
+	}
+	var rules extract.Rules
+	if *rulesFlag != "" {
+		if rules, err = extract.LoadFile(*rulesFlag, cfg.Patterns); err != nil {
+			log.Fatalf("invalid -rules: %v", err)
+		}
+	}
+
+	var src io.Reader
+	switch {
+	case profile != nil && *follow == "" && fs.NArg() == 0:
+		if src, err = profileSource(profile, *tail); err != nil {
+			log.Fatalf("can't read the sources of profile %q: %v", profile.Name, err)
+		}
+	case *follow != "":
+		fsrc, err := tailf.Follow(*follow, !*tail)
+		if err != nil {
+			log.Fatalf("can't follow file %q, %v", *follow, err)
+		}
+		defer fsrc.Close()
+		src = fsrc
+	case fs.NArg() == 1:
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Fatalf("can't open file %q, %v", fs.Arg(0), err)
+		}
+		defer f.Close()
+		src = f
+	case fs.NArg() > 1:
+		if src, err = followCommand(fs.Args()); err != nil {
+			log.Fatalf("can't read output of command %q, %v", strings.Join(fs.Args(), " "), err)
+		}
+	default:
+		src = os.Stdin
+	}
+	if *redactFlag != "" {
+		r, err := newRedactor(*redactFlag)
+		if err != nil {
+			log.Fatalf("invalid -redact: %v", err)
+		}
+		src = redact.NewReader(src, r)
+	}
+
+	dir := *histDir
+	if dir == "" {
+		dir, err = ioutil.TempDir("", "logterm-history")
+		if err != nil {
+			log.Fatalf("can't create history directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+	}
+	hist, err := history.Open(dir, history.Options{
+		MaxSize: *histSize,
+		MaxAge:  *histAge,
+	})
+	if err != nil {
+		log.Fatalf("can't open history in %q: %v", dir, err)
+	}
+	defer hist.Close()
+
+	// browsers list the saved queries, and can use them
+	addSavedQueries(cfg)
+	srv := web.New(hist, web.Options{
+		Parse:   func(line []byte) *parser.Entry { return rules.Apply(cfg.Parse(line)) },
+		Query:   cfg.ParseQuery,
+		Queries: cfg.Queries,
+		Backlog: *backlog,
+	})
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("can't serve on %q: %v", *addr, err)
+	}
+	go func() {
+		log.Fatalf("can't serve: %v", http.Serve(l, srv))
+	}()
+	log.Printf("serving on http://%s/", l.Addr())
+
+	if _, err := srv.ReadFrom(src); err != nil {
+		log.Fatalf("error with input source: %v", err)
+	}
+	// browsers can still look at what was read
+	log.Print("read all the input, still serving")
+	select {}
+}
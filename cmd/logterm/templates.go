@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/templates"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// runTemplates is the `templates` subcommand: it prints the templates of
+// the messages of a file, or of stdin.
+func runTemplates(args []string) {
+	fs := flag.NewFlagSet("templates", flag.ExitOnError)
+	top := fs.Int("n", 20, "how many templates to show, 0 for all")
+	newest := fs.Bool("new", false, "show the templates that appeared last first, instead of the most common")
+	samples := fs.Bool("samples", false, "show sample messages of each template")
+	similarity := fs.Float64("similarity", templates.DefaultSimilarity, "share of words that a message has in common with its template")
+	fs.Parse(args)
+
+	src := io.Reader(os.Stdin)
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Fatalf("can't open file %q, %v", fs.Arg(0), err)
+		}
+		defer f.Close()
+		src = f
+	}
+
+	miner := templates.NewMiner(templates.Options{Similarity: *similarity})
+	scan := bufio.NewScanner(src)
+	scan.Buffer(nil, 1<<20)
+	for scan.Scan() {
+		line := scan.Bytes()
+		miner.AddEntry(line, parser.ParseLine(line))
+	}
+	if err := scan.Err(); err != nil {
+		log.Fatalf("error with input source: %v", err)
+	}
+
+	all := miner.Templates()
+	if *newest {
+		templates.Newest(all)
+	}
+	if *top > 0 && len(all) > *top {
+		all = all[:*top]
+	}
+	if _, err := templateTable(all, *samples).WriteTo(os.Stdout); err != nil {
+		log.Fatalf("can't write templates: %v", err)
+	}
+}
+
+func templateTable(all []*templates.Template, samples bool) *query.Table {
+	t := &query.Table{Columns: []string{"count", "first", "last", "template"}}
+	for _, tmpl := range all {
+		t.Rows = append(t.Rows, []string{
+			strconv.Itoa(tmpl.Count),
+			tmpl.First.Format(time.RFC3339),
+			tmpl.Last.Format(time.RFC3339),
+			tmpl.String(),
+		})
+		if !samples {
+			continue
+		}
+		for _, s := range tmpl.Samples {
+			t.Rows = append(t.Rows, []string{"", "", "", "  " + s})
+		}
+	}
+	return t
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/aybabtme/logterm/parser"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// lines longer than this are cut to it
+const maxLine = 1 << 20
+
+// errPastRange stops copying at the first entry after the range.
+var errPastRange = errors.New("past the end of the range")
+
+// timeRange limits src to the entries in [since, until]. A zero time
+// doesn't limit that side. When src is a regular file, the start of the
+// range is found by binary search instead of reading up to it.
+func timeRange(src io.Reader, since, until time.Time) (io.Reader, error) {
+	if f, ok := src.(*os.File); ok && !since.IsZero() {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			off, err := parser.SeekTime(f, fi.Size(), since)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := f.Seek(off, io.SeekStart); err != nil {
+				return nil, err
+			}
+			// already there
+			since = time.Time{}
+		}
+	}
+
+	rd, wr := io.Pipe()
+	go func() {
+		wr.CloseWithError(copyTimeRange(wr, src, since, until))
+	}()
+	return rd, nil
+}
+
+// followSince follows the file from the first entry at or after since,
+// found by binary search, without reading the lines before it.
+func followSince(path string, since time.Time) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		off, err := parser.SeekTime(f, fi.Size(), since)
+		if err == nil {
+			_, err = f.Seek(off, io.SeekStart)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &fileFollower{f: f, path: path, poll: followPoll, done: make(chan struct{})}, nil
+}
+
+// how often a followed file is checked for more lines, once its end is
+// reached
+const followPoll = 250 * time.Millisecond
+
+// fileFollower reads a file from where it's at, and then what's appended
+// to it. A file that's truncated is read again from its start, and one
+// that's replaced, like when logs are rotated, is opened again.
+type fileFollower struct {
+	f    *os.File
+	path string
+	poll time.Duration
+	// bytes read from the files
+	read int64
+
+	// held to change the file, or close it
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+func (ff *fileFollower) Read(b []byte) (int, error) {
+	for {
+		ff.mu.Lock()
+		if ff.closed {
+			ff.mu.Unlock()
+			return 0, io.EOF
+		}
+		n, err := ff.f.Read(b)
+		ff.read += int64(n)
+		if n == 0 && err == io.EOF {
+			err = ff.reopen()
+		}
+		ff.mu.Unlock()
+		if n > 0 || err != nil {
+			return n, err
+		}
+		select {
+		case <-ff.done:
+			return 0, io.EOF
+		case <-time.After(ff.poll):
+		}
+	}
+}
+
+// reopen the file if it's not the one at its path anymore, or rewind it
+// if it was truncated. The lock must be held.
+func (ff *fileFollower) reopen() error {
+	cur, err := ff.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(ff.path); err == nil && !os.SameFile(fi, cur) {
+		f, err := os.Open(ff.path)
+		if err != nil {
+			return err
+		}
+		ff.f.Close()
+		ff.f = f
+		return nil
+	}
+	pos, err := ff.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if cur.Size() < pos {
+		_, err = ff.f.Seek(0, io.SeekStart)
+	}
+	return err
+}
+
+// Close stops following, the reads waiting for more lines end.
+func (ff *fileFollower) Close() error {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if ff.closed {
+		return nil
+	}
+	ff.closed = true
+	close(ff.done)
+	return ff.f.Close()
+}
+
+// copyTimeRange copies the lines of src that are in the range. Lines
+// without a timestamp go with the entry before them.
+func copyTimeRange(dst io.Writer, src io.Reader, since, until time.Time) error {
+	w := bufio.NewWriter(dst)
+	started := since.IsZero()
+	err := readLines(src, func(line []byte) error {
+		if t, ok := parser.ParseLine(line).Time(); ok {
+			if !until.IsZero() && t.After(until) {
+				return errPastRange
+			}
+			started = started || !t.Before(since)
+		}
+		if !started {
+			return nil
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+		// the source can be followed, don't hold lines back
+		return w.Flush()
+	})
+	if err == errPastRange {
+		return nil
+	}
+	return err
+}
+
+// readLines gives fn the lines of src without their newline, until fn
+// fails or src ends. Lines longer than maxLine are cut to it, instead of
+// failing the whole stream.
+func readLines(src io.Reader, fn func(line []byte) error) error {
+	rd := bufio.NewReaderSize(src, maxLine)
+	for {
+		line, err := rd.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// keep the start of the line, drop the rest
+			line = append([]byte(nil), line...)
+			for err == bufio.ErrBufferFull {
+				_, err = rd.ReadSlice('\n')
+			}
+			if err == nil {
+				line = append(line, '\n')
+			}
+		}
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if ferr := fn(line); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return parser.ParseTime(value)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timerange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	start := time.Date(2014, 10, 27, 0, 0, 0, 0, time.UTC)
+	w := bufio.NewWriter(f)
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(w, "time=%s n=%d\n", start.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := f.Stat()
+
+	rc, err := followSince(path, start.Add(99990*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	lines := make(chan string)
+	go func() {
+		scan := bufio.NewScanner(rc)
+		for scan.Scan() {
+			lines <- scan.Text()
+		}
+		close(lines)
+	}()
+	next := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(5 * time.Second):
+			t.Fatal("no line came")
+		}
+		return ""
+	}
+	if line, want := next(), "time=2014-10-28T03:46:30Z n=99990"; line != want {
+		t.Fatalf("want %q first, got %q", want, line)
+	}
+	for i := 0; i < 9; i++ {
+		next()
+	}
+	// the lines before weren't read
+	if read := rc.(*fileFollower).read; read > fi.Size()/100 {
+		t.Errorf("read %d bytes of %d to get to the last 10 lines", read, fi.Size())
+	}
+
+	fmt.Fprintf(f, "msg=appended\n")
+	if line := next(); line != "msg=appended" {
+		t.Errorf("want the appended line, got %q", line)
+	}
+}
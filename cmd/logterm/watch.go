@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"github.com/aybabtme/logterm/metrics"
+	"github.com/aybabtme/logterm/parser"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// watching reads the lines of src as they are, and gives them and their
+// entries to watch on the way, for alerts and metrics.
+func watching(src io.Reader, parse func([]byte) *parser.Entry, watch func(line []byte, e *parser.Entry)) io.Reader {
+	rd, wr := io.Pipe()
+	go func() {
+		scan := bufio.NewScanner(src)
+		scan.Buffer(nil, 1<<20)
+		var line []byte
+		for scan.Scan() {
+			watch(scan.Bytes(), parse(scan.Bytes()))
+			line = append(append(line[:0], scan.Bytes()...), '\n')
+			if _, err := wr.Write(line); err != nil {
+				return
+			}
+		}
+		wr.CloseWithError(scan.Err())
+	}()
+	return rd
+}
+
+// serveMetrics of the registry on /metrics at addr, in the background.
+func serveMetrics(addr string, reg *metrics.Registry) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg)
+	go func() {
+		log.Fatalf("can't serve metrics: %v", http.Serve(l, mux))
+	}()
+	log.Printf("serving metrics on http://%s/metrics", l.Addr())
+	return nil
+}
@@ -0,0 +1,164 @@
+// Package columns lays the fields of entries out in rows, to show them
+// as aligned columns like:
+//
+//	time                          level service msg          latency
+//	2014-10-27T18:38:45.123-04:00 error api     db down      1.5s
+package columns
+
+import (
+	"github.com/aybabtme/logterm/encode"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/timefmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Parse a list of columns, separated by commas or spaces.
+func Parse(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+// Text of a field, the way it's shown in a column.
+func Text(f parser.Field) string {
+	switch f := f.(type) {
+	case nil, parser.NilField:
+		return ""
+	case parser.TimeField:
+		return f.Format(timefmt.Layout)
+	}
+	return encode.Text(f)
+}
+
+// Row of an entry, with a value for each column.
+type Row struct {
+	// N is the number of the line of the entry
+	N      uint64
+	Fields []parser.Field
+	Cells  []string
+}
+
+// NewRow is the row of the entry on line n. An entry that has none of the
+// columns, like an unstructured line, shows its line in the last one.
+func NewRow(n uint64, line []byte, e *parser.Entry, names []string) Row {
+	r := Row{N: n, Fields: make([]parser.Field, len(names)), Cells: make([]string, len(names))}
+	found := false
+	for i, name := range names {
+		f, _ := e.Field(name)
+		if missing(f) {
+			continue
+		}
+		found = true
+		r.Fields[i] = f
+		r.Cells[i] = Text(f)
+	}
+	if !found && len(names) > 0 {
+		r.Cells[len(names)-1] = string(line)
+	}
+	return r
+}
+
+// FormatTimes shows the times of the row with the display. Prev is the
+// time of the row before, for displays relative to it.
+func (r Row) FormatTimes(d timefmt.Display, prev time.Time) {
+	for i, f := range r.Fields {
+		if t, ok := f.(parser.TimeField); ok {
+			r.Cells[i] = d.Format(t.Time, prev, timefmt.Layout)
+		}
+	}
+}
+
+// Sort the rows by their values in column col, in order of their type:
+// numbers, durations and times by value, the rest as text. Rows that
+// don't have the column go last, and those that are equal keep their
+// order.
+func Sort(rows []Row, col int, desc bool) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i].Fields[col], rows[j].Fields[col]
+		if missing(a) || missing(b) {
+			return !missing(a) && missing(b)
+		}
+		if desc {
+			return Less(b, a)
+		}
+		return Less(a, b)
+	})
+}
+
+func missing(f parser.Field) bool {
+	_, isNil := f.(parser.NilField)
+	return f == nil || isNil
+}
+
+// Less tells if a comes before b. Fields of different types are ordered
+// by their type, then fields of the same type by their value.
+func Less(a, b parser.Field) bool {
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	switch a := a.(type) {
+	case parser.NumberField:
+		return a < b.(parser.NumberField)
+	case parser.DurationField:
+		return a.Duration < b.(parser.DurationField).Duration
+	case parser.TimeField:
+		return a.Before(b.(parser.TimeField).Time)
+	case parser.BooleanField:
+		return !bool(a) && bool(b.(parser.BooleanField))
+	}
+	return Text(a) < Text(b)
+}
+
+func rank(f parser.Field) int {
+	switch f.(type) {
+	case parser.NumberField:
+		return 0
+	case parser.DurationField:
+		return 1
+	case parser.TimeField:
+		return 2
+	case parser.BooleanField:
+		return 3
+	}
+	return 4
+}
+
+// Index of the column name, or -1.
+func Index(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Toggle adds the column at the end if it isn't there, or removes it.
+func Toggle(names []string, name string) []string {
+	if i := Index(names, name); i >= 0 {
+		return append(names[:i:i], names[i+1:]...)
+	}
+	return append(names[:len(names):len(names)], name)
+}
+
+// Move the column by delta places, to the left when it's negative. It
+// stops at the ends.
+func Move(names []string, name string, delta int) []string {
+	i := Index(names, name)
+	if i < 0 {
+		return names
+	}
+	j := i + delta
+	if j < 0 {
+		j = 0
+	}
+	if j >= len(names) {
+		j = len(names) - 1
+	}
+	moved := append([]string(nil), names...)
+	copy(moved[i:], moved[i+1:])
+	copy(moved[j+1:], moved[j:len(moved)-1])
+	moved[j] = name
+	return moved
+}
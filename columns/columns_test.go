@@ -0,0 +1,90 @@
+package columns
+
+import (
+	"github.com/aybabtme/logterm/parser"
+	"reflect"
+	"testing"
+)
+
+var lines = []string{
+	`time=2014-10-27T18:38:45Z level=error latency=1.5s status=500 msg="db down"`,
+	`time=2014-10-27T18:38:46Z level=info latency=250ms status=200 msg=ok`,
+	`time=2014-10-27T18:38:47Z level=info status=404 msg="not found"`,
+	`time=2014-10-27T18:38:48Z level=warn latency=1m status=200 msg=slow`,
+	`a line without fields`,
+}
+
+func rows(names []string) []Row {
+	var all []Row
+	for i, line := range lines {
+		all = append(all, NewRow(uint64(i), []byte(line), parser.ParseLine([]byte(line)), names))
+	}
+	return all
+}
+
+func TestNewRow(t *testing.T) {
+	all := rows([]string{"time", "level", "latency", "msg"})
+	want := [][]string{
+		{"2014-10-27T18:38:45.000Z", "error", "1.5s", "db down"},
+		{"2014-10-27T18:38:46.000Z", "info", "250ms", "ok"},
+		{"2014-10-27T18:38:47.000Z", "info", "", "not found"},
+		{"2014-10-27T18:38:48.000Z", "warn", "1m0s", "slow"},
+		{"", "", "", "a line without fields"},
+	}
+	for i, r := range all {
+		if !reflect.DeepEqual(want[i], r.Cells) {
+			t.Errorf("line %d: want %q, got %q", i, want[i], r.Cells)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	for _, tt := range []struct {
+		col  int
+		desc bool
+		want []uint64
+	}{
+		// durations by value, not as text
+		{0, false, []uint64{1, 0, 3, 2, 4}},
+		{0, true, []uint64{3, 0, 1, 2, 4}},
+		// numbers, equal ones keep their order
+		{1, false, []uint64{1, 3, 2, 0, 4}},
+		{1, true, []uint64{0, 2, 1, 3, 4}},
+		{2, false, []uint64{0, 1, 2, 3, 4}},
+	} {
+		all := rows([]string{"latency", "status", "level"})
+		Sort(all, tt.col, tt.desc)
+		var got []uint64
+		for _, r := range all {
+			got = append(got, r.N)
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("column %d, desc=%v: want %v, got %v", tt.col, tt.desc, tt.want, got)
+		}
+	}
+}
+
+func TestEdits(t *testing.T) {
+	names := Parse("time, level msg")
+	if want := []string{"time", "level", "msg"}; !reflect.DeepEqual(want, names) {
+		t.Fatalf("want %q, got %q", want, names)
+	}
+	for _, tt := range []struct {
+		got, want []string
+	}{
+		{Toggle(names, "latency"), []string{"time", "level", "msg", "latency"}},
+		{Toggle(names, "level"), []string{"time", "msg"}},
+		{Move(names, "msg", -1), []string{"time", "msg", "level"}},
+		{Move(names, "time", 1), []string{"level", "time", "msg"}},
+		{Move(names, "time", -1), []string{"time", "level", "msg"}},
+		{Move(names, "time", 5), []string{"level", "msg", "time"}},
+		{Move(names, "nope", 1), []string{"time", "level", "msg"}},
+	} {
+		if !reflect.DeepEqual(tt.want, tt.got) {
+			t.Errorf("want %q, got %q", tt.want, tt.got)
+		}
+	}
+	if want := []string{"time", "level", "msg"}; !reflect.DeepEqual(want, names) {
+		t.Errorf("edits changed the columns: %q", names)
+	}
+}
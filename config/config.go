@@ -0,0 +1,752 @@
+// Package config reads the configuration file of logterm, at
+// ~/.config/logterm/config.toml, or config.yaml:
+//
+//	[theme]
+//	header = "black on white"
+//	[theme.levels]
+//	error = "bold red"
+//
+//	[keys]
+//	correlate = "ctrl-t"
+//
+//	[aliases]
+//	msg = ["message", "log"]
+//
+//	[time]
+//	display = "local"
+//	layouts = ["02 Jan 06 15:04:05.000"]
+//
+//	[extract]
+//	rules = ['from=msg took %{DURATION:took}']
+//	[extract.patterns]
+//	DURATION = '[0-9.]+(?:ms|s)'
+//
+//	[queries]
+//	errors = "level=error"
+//
+//	[profiles.payments]
+//	sources = ["/var/log/payments.log"]
+//	query = "service=payments"
+//
+//	[alerts.fatal]
+//	query = "level=fatal"
+//	count = 1
+//	within = "1m"
+//	cooldown = "5m"
+//	dedup = ["msg"]
+//	actions = ["bell", "banner", "webhook https://example.com/hook"]
+//
+//	[metrics.request_seconds]
+//	type = "histogram"
+//	query = "service=api"
+//	field = "took"
+//	labels = ["method", "status=http.status"]
+//	buckets = [0.1, 0.5, 1]
+//
+// Everything is checked as it's read, and errors tell the line they're on.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aybabtme/logterm/alert"
+	"github.com/aybabtme/logterm/columns"
+	"github.com/aybabtme/logterm/extract"
+	"github.com/aybabtme/logterm/format"
+	"github.com/aybabtme/logterm/metrics"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/redact"
+	"github.com/aybabtme/logterm/timefmt"
+	"github.com/aybabtme/logterm/ui"
+	"github.com/nsf/termbox-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultKeys are the actions that keys are bound to, and the keys they
+// are bound to unless the config binds others.
+var DefaultKeys = map[string]string{
+	"correlate":         "ctrl-t",
+	"repeats":           "ctrl-o",
+	"fields":            "ctrl-f",
+	"next-field":        "ctrl-n",
+	"previous-field":    "ctrl-p",
+	"toggle-column":     "ctrl-x",
+	"column-left":       "ctrl-b",
+	"column-right":      "ctrl-l",
+	"sort":              "ctrl-s",
+	"times":             "ctrl-w",
+	"bookmark":          "ctrl-k",
+	"bookmarks":         "ctrl-v",
+	"next-bookmark":     "ctrl-u",
+	"previous-bookmark": "ctrl-y",
+}
+
+// keys that the edit box and the canvas keep for themselves
+var reservedKeys = map[termbox.Key]string{
+	termbox.KeyCtrlA: "moving to the start of the line",
+	termbox.KeyCtrlE: "moving to the end of the line",
+	termbox.KeyCtrlR: "searching the queries typed before",
+	termbox.KeyCtrlG: "cancelling a search",
+	termbox.KeyCtrlC: "quitting",
+	termbox.KeyCtrlD: "quitting",
+}
+
+// Config of logterm. The zero value isn't usable, New makes the default
+// one.
+type Config struct {
+	// Path the config was read from, if any
+	Path string
+	// Theme of the boxes of the UI
+	Theme ui.Theme
+	// LevelColors of templates, by level
+	LevelColors map[string]string
+	// Keys bound to the actions of DefaultKeys
+	Keys map[string]termbox.Key
+	// Aliases of fields: the fields of the other names are renamed to
+	// the field's
+	Aliases map[string][]string
+	// Times shows times in a zone or relative to others
+	Times timefmt.Display
+	// TimeLayouts that times are parsed in, on top of those known
+	TimeLayouts []string
+	// Patterns of grok, the default ones and those of the config
+	Patterns extract.Patterns
+	// Rules that extract fields from lines
+	Rules extract.Rules
+	// Queries saved by name, that `@name` stands for
+	Queries map[string]string
+	// Profiles by name
+	Profiles map[string]*Profile
+	// Alerts, in the order they're written
+	Alerts []*alert.Rule
+	// Metrics derived from entries, in the order they're written
+	Metrics []*metrics.Metric
+}
+
+// Profile bundles sources and the options to read them with.
+type Profile struct {
+	Name string
+	// Sources are the files read
+	Sources []string
+	// Command whose output is read, in place of sources
+	Command []string
+	// Follow the sources as they grow
+	Follow bool
+	// Query that entries must match, or that aggregates them
+	Query string
+	// Columns, Format, Time and Redact are like the flags of the same
+	// name
+	Columns []string
+	Format  string
+	Time    string
+	Redact  string
+}
+
+// New config, with the defaults.
+func New() *Config {
+	c := &Config{
+		Theme:       ui.DefaultTheme,
+		LevelColors: make(map[string]string),
+		Keys:        make(map[string]termbox.Key),
+		Aliases:     make(map[string][]string),
+		Patterns:    make(extract.Patterns),
+		Queries:     make(map[string]string),
+		Profiles:    make(map[string]*Profile),
+	}
+	for name, key := range DefaultKeys {
+		c.Keys[name], _ = ui.ParseKey(key)
+	}
+	for name, pattern := range extract.Default {
+		c.Patterns[name] = pattern
+	}
+	return c
+}
+
+// DefaultPath is the path of the config file, the first of config.toml,
+// config.yaml and config.yml in $XDG_CONFIG_HOME/logterm, or
+// ~/.config/logterm, that's there. It's empty if there's none.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	for _, name := range []string{"config.toml", "config.yaml", "config.yml"} {
+		path := filepath.Join(dir, "logterm", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Load the config at path, or at the default path if it's empty. There
+// being no config at the default path isn't an error, the defaults are
+// used.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		if path = DefaultPath(); path == "" {
+			return New(), nil
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Read(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Read a config from data, in YAML if the name ends in .yaml or .yml, in
+// TOML otherwise.
+func Read(name string, data []byte) (*Config, error) {
+	var (
+		root *table
+		err  error
+	)
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		root, err = readYAML(string(data))
+	default:
+		root, err = readTOML(string(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := New()
+	c.Path = name
+	if err := c.decode(root); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// sections of the file, and how they're decoded
+func (c *Config) sections() map[string]func(*table) error {
+	return map[string]func(*table) error{
+		"theme":    c.decodeTheme,
+		"keys":     c.decodeKeys,
+		"aliases":  c.decodeAliases,
+		"time":     c.decodeTime,
+		"extract":  c.decodeExtract,
+		"queries":  c.decodeQueries,
+		"profiles": c.decodeProfiles,
+		"alerts":   c.decodeAlerts,
+		"metrics":  c.decodeMetrics,
+	}
+}
+
+func (c *Config) decode(root *table) error {
+	sections := c.sections()
+	// profiles, alerts and metrics use saved queries, whatever the order
+	// they're in
+	order := []string{"theme", "keys", "aliases", "time", "extract", "queries", "profiles", "alerts", "metrics"}
+	for _, key := range root.keys {
+		if _, ok := sections[key]; !ok {
+			return errorf(root.values[key].line, "unknown section %q, want one of %s", key, strings.Join(order, ", "))
+		}
+	}
+	for _, name := range order {
+		v, ok := root.values[name]
+		if !ok {
+			continue
+		}
+		t, err := v.tbl()
+		if err != nil {
+			return err
+		}
+		if err := sections[name](t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) decodeTheme(t *table) error {
+	for _, key := range t.keys {
+		v := t.values[key]
+		if key == "levels" {
+			levels, err := v.tbl()
+			if err != nil {
+				return err
+			}
+			for _, level := range levels.keys {
+				lv := levels.values[level]
+				color, err := lv.str()
+				if err != nil {
+					return err
+				}
+				probe, _ := format.New("", false)
+				if err := probe.SetLevelColor(level, color); err != nil {
+					return errorf(lv.line, "%v", err)
+				}
+				c.LevelColors[level] = color
+			}
+			continue
+		}
+		spec, err := v.str()
+		if err != nil {
+			return err
+		}
+		if err := c.Theme.Set(key, spec); err != nil {
+			return errorf(v.line, "%v", err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) decodeKeys(t *table) error {
+	bound := make(map[termbox.Key]string)
+	for name, key := range c.Keys {
+		if _, ok := t.values[name]; !ok {
+			bound[key] = name
+		}
+	}
+	for _, name := range t.keys {
+		v := t.values[name]
+		if _, ok := DefaultKeys[name]; !ok {
+			return errorf(v.line, "unknown action %q, want one of %s", name, strings.Join(actions(), ", "))
+		}
+		spec, err := v.str()
+		if err != nil {
+			return err
+		}
+		if spec == "" || spec == "none" {
+			delete(c.Keys, name)
+			continue
+		}
+		key, err := ui.ParseKey(spec)
+		if err != nil {
+			return errorf(v.line, "%v", err)
+		}
+		if why, ok := reservedKeys[key]; ok {
+			return errorf(v.line, "%s is kept for %s", spec, why)
+		}
+		if other, ok := bound[key]; ok {
+			return errorf(v.line, "%s is already bound to %s", spec, other)
+		}
+		bound[key] = name
+		c.Keys[name] = key
+	}
+	return nil
+}
+
+// actions that keys can be bound to, in order.
+func actions() []string {
+	var names []string
+	for name := range DefaultKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Config) decodeAliases(t *table) error {
+	aliased := make(map[string]string)
+	for _, field := range t.keys {
+		v := t.values[field]
+		names, err := v.strs()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if other, ok := aliased[name]; ok {
+				return errorf(v.line, "%q is already an alias of %q", name, other)
+			}
+			if _, ok := t.values[name]; ok || name == field {
+				return errorf(v.line, "%q can't be an alias, it has aliases", name)
+			}
+			aliased[name] = field
+		}
+		c.Aliases[field] = names
+	}
+	return nil
+}
+
+func (c *Config) decodeTime(t *table) error {
+	for _, key := range t.keys {
+		v := t.values[key]
+		switch key {
+		case "display":
+			spec, err := v.str()
+			if err != nil {
+				return err
+			}
+			if c.Times, err = timefmt.Parse(spec); err != nil {
+				return errorf(v.line, "%v", err)
+			}
+		case "layouts":
+			layouts, err := v.strs()
+			if err != nil {
+				return err
+			}
+			for _, layout := range layouts {
+				if !strings.ContainsAny(layout, "0123456789") {
+					return errorf(v.line, "layout %q has none of the numbers of the reference time, like 2006-01-02T15:04:05Z07:00", layout)
+				}
+			}
+			c.TimeLayouts = layouts
+		default:
+			return errorf(v.line, "unknown key %q in [time], want display or layouts", key)
+		}
+	}
+	return nil
+}
+
+func (c *Config) decodeExtract(t *table) error {
+	// patterns first, rules use them
+	if v, ok := t.values["patterns"]; ok {
+		patterns, err := v.tbl()
+		if err != nil {
+			return err
+		}
+		for _, name := range patterns.keys {
+			pv := patterns.values[name]
+			pattern, err := pv.str()
+			if err != nil {
+				return err
+			}
+			if err := c.Patterns.Define(name, pattern); err != nil {
+				return errorf(pv.line, "%v", err)
+			}
+		}
+	}
+	for _, key := range t.keys {
+		v := t.values[key]
+		switch key {
+		case "patterns":
+		case "rules":
+			texts, err := v.strs()
+			if err != nil {
+				return err
+			}
+			for i, text := range texts {
+				line := v.line
+				if v.kind == listKind {
+					line = v.list[i].line
+				}
+				rule, err := extract.ParseRule(text, c.Patterns)
+				if err != nil {
+					return errorf(line, "%v", err)
+				}
+				c.Rules = append(c.Rules, rule)
+			}
+		default:
+			return errorf(v.line, "unknown key %q in [extract], want patterns or rules", key)
+		}
+	}
+	return nil
+}
+
+func (c *Config) decodeQueries(t *table) error {
+	for _, name := range t.keys {
+		v := t.values[name]
+		text, err := v.str()
+		if err != nil {
+			return err
+		}
+		if _, err := query.Parse(text); err != nil {
+			return errorf(v.line, "invalid query %q: %v", name, err)
+		}
+		c.Queries[name] = text
+	}
+	return nil
+}
+
+func (c *Config) decodeProfiles(t *table) error {
+	for _, name := range t.keys {
+		pt, err := t.values[name].tbl()
+		if err != nil {
+			return err
+		}
+		p := &Profile{Name: name}
+		for _, key := range pt.keys {
+			if err := c.decodeProfile(p, key, pt.values[key]); err != nil {
+				return err
+			}
+		}
+		if len(p.Sources) > 0 && len(p.Command) > 0 {
+			return errorf(pt.line, "profile %q has both sources and a command, it can only read one of them", name)
+		}
+		c.Profiles[name] = p
+	}
+	return nil
+}
+
+func (c *Config) decodeProfile(p *Profile, key string, v *value) error {
+	var err error
+	switch key {
+	case "sources":
+		p.Sources, err = v.strs()
+	case "command":
+		p.Command, err = v.strs()
+	case "follow":
+		p.Follow, err = v.boolean()
+	case "columns":
+		var cols []string
+		if cols, err = v.strs(); err == nil {
+			p.Columns = columns.Parse(strings.Join(cols, ","))
+		}
+	case "query":
+		if p.Query, err = v.str(); err == nil {
+			_, err = c.ParseQuery(p.Query)
+		}
+	case "format":
+		if p.Format, err = v.str(); err == nil {
+			_, err = format.New(p.Format, false)
+		}
+	case "time":
+		if p.Time, err = v.str(); err == nil {
+			_, err = timefmt.Parse(p.Time)
+		}
+	case "redact":
+		if p.Redact, err = v.str(); err == nil {
+			_, err = redact.Parse(p.Redact)
+		}
+	default:
+		return errorf(v.line, "unknown key %q in profile %q, want sources, command, follow, query, columns, format, time or redact", key, p.Name)
+	}
+	if _, ok := err.(*lineError); err != nil && !ok {
+		return errorf(v.line, "%v", err)
+	}
+	return err
+}
+
+func (c *Config) decodeAlerts(t *table) error {
+	for _, name := range t.keys {
+		at, err := t.values[name].tbl()
+		if err != nil {
+			return err
+		}
+		r := &alert.Rule{Name: name}
+		for _, key := range at.keys {
+			if err := c.decodeAlert(r, key, at.values[key]); err != nil {
+				return err
+			}
+		}
+		switch {
+		case r.Query == nil:
+			return errorf(at.line, "alert %q needs a query", name)
+		case len(r.Actions) == 0:
+			return errorf(at.line, "alert %q needs actions", name)
+		}
+		c.Alerts = append(c.Alerts, r)
+	}
+	return nil
+}
+
+func (c *Config) decodeAlert(r *alert.Rule, key string, v *value) error {
+	var err error
+	switch key {
+	case "query":
+		var text string
+		if text, err = v.str(); err == nil {
+			if r.Query, err = c.ParseQuery(text); err == nil && r.Query.Aggregates() {
+				err = fmt.Errorf("the query of alert %q can't aggregate", r.Name)
+			}
+		}
+	case "count":
+		if r.Count, err = v.integer(); err == nil && r.Count < 1 {
+			err = fmt.Errorf("want a count of 1 or more, got %d", r.Count)
+		}
+	case "within":
+		r.Within, err = v.duration()
+	case "cooldown":
+		r.Cooldown, err = v.duration()
+	case "dedup":
+		r.Dedup, err = v.strs()
+	case "actions":
+		var specs []string
+		if specs, err = v.strs(); err == nil {
+			for _, spec := range specs {
+				a, aerr := alert.ParseAction(spec)
+				if aerr != nil {
+					err = aerr
+					break
+				}
+				r.Actions = append(r.Actions, a)
+			}
+		}
+	default:
+		return errorf(v.line, "unknown key %q in alert %q, want query, count, within, cooldown, dedup or actions", key, r.Name)
+	}
+	if _, ok := err.(*lineError); err != nil && !ok {
+		return errorf(v.line, "%v", err)
+	}
+	return err
+}
+
+func (c *Config) decodeMetrics(t *table) error {
+	for _, name := range t.keys {
+		mt, err := t.values[name].tbl()
+		if err != nil {
+			return err
+		}
+		m := &metrics.Metric{Name: name}
+		for _, key := range mt.keys {
+			if err := c.decodeMetric(m, key, mt.values[key]); err != nil {
+				return err
+			}
+		}
+		if m.Kind == 0 {
+			return errorf(mt.line, "metric %q needs a type, counter, histogram or gauge", name)
+		}
+		// checks the metric as a whole
+		if _, err := metrics.NewRegistry([]*metrics.Metric{m}); err != nil {
+			return errorf(mt.line, "%v", err)
+		}
+		c.Metrics = append(c.Metrics, m)
+	}
+	return nil
+}
+
+func (c *Config) decodeMetric(m *metrics.Metric, key string, v *value) error {
+	var err error
+	switch key {
+	case "type":
+		var kind string
+		if kind, err = v.str(); err == nil {
+			var ok bool
+			if m.Kind, ok = metrics.Kinds[kind]; !ok {
+				err = fmt.Errorf("unknown type %q, want counter, histogram or gauge", kind)
+			}
+		}
+	case "help":
+		m.Help, err = v.str()
+	case "query":
+		var text string
+		if text, err = v.str(); err == nil {
+			m.Query, err = c.ParseQuery(text)
+		}
+	case "field":
+		m.Field, err = v.str()
+	case "labels":
+		var specs []string
+		if specs, err = v.strs(); err == nil {
+			for _, spec := range specs {
+				l, lerr := metrics.ParseLabel(spec)
+				if lerr != nil {
+					err = lerr
+					break
+				}
+				m.Labels = append(m.Labels, l)
+			}
+		}
+	case "buckets":
+		m.Buckets, err = v.numbers()
+	case "max_series":
+		m.MaxSeries, err = v.integer()
+	default:
+		return errorf(v.line, "unknown key %q in metric %q, want type, help, query, field, labels, buckets or max_series", key, m.Name)
+	}
+	if _, ok := err.(*lineError); err != nil && !ok {
+		return errorf(v.line, "%v", err)
+	}
+	return err
+}
+
+// SetFlags sets the flags that weren't given, among q, columns, format,
+// time and redact, to the options of the profile.
+func (p *Profile) SetFlags(fs *flag.FlagSet) {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for name, value := range map[string]string{
+		"q":       p.Query,
+		"columns": strings.Join(p.Columns, ","),
+		"format":  p.Format,
+		"time":    p.Time,
+		"redact":  p.Redact,
+	} {
+		if value != "" && !given[name] && fs.Lookup(name) != nil {
+			// checked when the config was read
+			_ = fs.Set(name, value)
+		}
+	}
+}
+
+// Profile of that name.
+func (c *Config) Profile(name string) (*Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		var names []string
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown profile %q, the config has none", name)
+		}
+		return nil, fmt.Errorf("unknown profile %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// Query text with the saved query it names, if it's like `@errors`.
+func (c *Config) Query(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "@") {
+		return text, nil
+	}
+	saved, ok := c.Queries[text[1:]]
+	if !ok {
+		return "", fmt.Errorf("no query is saved as %q", text[1:])
+	}
+	return saved, nil
+}
+
+// ParseQuery parses a query, or the saved query it names.
+func (c *Config) ParseQuery(text string) (*query.Query, error) {
+	text, err := c.Query(text)
+	if err != nil {
+		return nil, err
+	}
+	return query.Parse(text)
+}
+
+// Parse a line with the aliases and rules of the config.
+func (c *Config) Parse(line []byte) *parser.Entry {
+	return c.Apply(parser.ParseLine(line))
+}
+
+// Apply the aliases and rules of the config to an entry. Fields are
+// renamed first, so that rules can match them by their alias.
+func (c *Config) Apply(e *parser.Entry) *parser.Entry {
+	copied := false
+	for field, names := range c.Aliases {
+		if _, ok := e.Field(field); ok {
+			continue
+		}
+		for _, name := range names {
+			f, ok := e.Field(name)
+			if !ok {
+				continue
+			}
+			if !copied {
+				e, copied = e.Clone(), true
+			}
+			e.Delete(name)
+			e.Set(field, f)
+			break
+		}
+	}
+	return c.Rules.Apply(e)
+}
+
+// SetTemplate makes a template show levels in the colors of the config.
+func (c *Config) SetTemplate(t *format.Template) {
+	for level, color := range c.LevelColors {
+		// checked when the config was read
+		_ = t.SetLevelColor(level, color)
+	}
+}
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"flag"
+	"github.com/aybabtme/logterm/metrics"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/timefmt"
+	"github.com/nsf/termbox-go"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const tomlConfig = `# logterm
+[theme]
+header = "yellow on blue"
+[theme.levels]
+error = "bold magenta"
+
+[keys]
+correlate = "f2"
+sort = "none"
+
+[aliases]
+msg = ["message", "log"]
+
+[time]
+display = "utc"
+layouts = [
+	"02 Jan 06 15:04:05.000", # like syslog, with a year
+]
+
+[extract]
+rules = ['from=msg took %{DURATION:took}']
+[extract.patterns]
+DURATION = '[0-9.]+(?:ms|s)'
+
+[queries]
+errors = "level=error"
+"slow calls" = 'took>1s | count by service'
+
+[profiles.payments]
+sources = ["/var/log/payments.log", "/var/log/payments.1.log"]
+follow = true
+query = "@errors"
+columns = "time,level,msg"
+time = "local"
+redact = "action=hash"
+
+[alerts.slow]
+query = "took>1s"
+count = 5
+within = "30s"
+cooldown = "5m"
+dedup = ["service"]
+actions = ["banner", "command notify-send logterm"]
+
+[metrics.request_seconds]
+type = "histogram"
+query = "@errors"
+field = "took"
+labels = ["service", "status=http.status"]
+buckets = [0.1, 1]
+`
+
+const yamlConfig = `# logterm
+theme:
+  header: yellow on blue
+  levels:
+    error: bold magenta
+
+keys:
+  correlate: f2
+  sort: none
+
+aliases:
+  msg: [message, log]
+
+time:
+  display: utc
+  layouts:
+  - "02 Jan 06 15:04:05.000" # like syslog, with a year
+
+extract:
+  rules:
+    - 'from=msg took %{DURATION:took}'
+  patterns:
+    DURATION: '[0-9.]+(?:ms|s)'
+
+queries:
+  errors: level=error
+  "slow calls": took>1s | count by service
+
+profiles:
+  payments:
+    sources:
+      - /var/log/payments.log
+      - /var/log/payments.1.log
+    follow: true
+    query: "@errors"
+    columns: [time, level, msg]
+    time: local
+    redact: action=hash
+
+alerts:
+  slow:
+    query: took>1s
+    count: 5
+    within: 30s
+    cooldown: 5m
+    dedup: [service]
+    actions:
+      - banner
+      - command notify-send logterm
+
+metrics:
+  request_seconds:
+    type: histogram
+    query: "@errors"
+    field: took
+    labels: [service, status=http.status]
+    buckets: [0.1, 1]
+`
+
+func TestRead(t *testing.T) {
+	for _, name := range []string{"config.toml", "config.yaml"} {
+		data := tomlConfig
+		if strings.HasSuffix(name, ".yaml") {
+			data = yamlConfig
+		}
+		c, err := Read(name, []byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if c.Theme.Header.Fg != termbox.ColorYellow || c.Theme.Header.Bg != termbox.ColorBlue {
+			t.Errorf("%s: want a yellow on blue header, got %+v", name, c.Theme.Header)
+		}
+		if c.LevelColors["error"] != "bold magenta" {
+			t.Errorf("%s: want magenta errors, got %v", name, c.LevelColors)
+		}
+		if c.Keys["correlate"] != termbox.KeyF2 || c.Keys["repeats"] != termbox.KeyCtrlO {
+			t.Errorf("%s: want correlate on f2 and repeats on ctrl-o, got %v", name, c.Keys)
+		}
+		if _, ok := c.Keys["sort"]; ok {
+			t.Errorf("%s: want sort unbound", name)
+		}
+		if want := []string{"message", "log"}; !reflect.DeepEqual(c.Aliases["msg"], want) {
+			t.Errorf("%s: want aliases %v, got %v", name, want, c.Aliases["msg"])
+		}
+		if c.Times.Mode != timefmt.Zone || len(c.TimeLayouts) != 1 {
+			t.Errorf("%s: want utc times and a layout, got %v and %v", name, c.Times, c.TimeLayouts)
+		}
+		if len(c.Rules) != 1 || c.Queries["slow calls"] != "took>1s | count by service" {
+			t.Errorf("%s: want a rule and the saved queries, got %v and %v", name, c.Rules, c.Queries)
+		}
+		want := &Profile{
+			Name:    "payments",
+			Sources: []string{"/var/log/payments.log", "/var/log/payments.1.log"},
+			Follow:  true,
+			Query:   "@errors",
+			Columns: []string{"time", "level", "msg"},
+			Time:    "local",
+			Redact:  "action=hash",
+		}
+		p, err := c.Profile("payments")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("%s: want profile %+v, got %+v", name, want, p)
+		}
+		if _, err := c.Profile("billing"); err == nil || !strings.Contains(err.Error(), "payments") {
+			t.Errorf("%s: want an error that lists the profiles, got %v", name, err)
+		}
+		if q, err := c.Query(p.Query); err != nil || q != "level=error" {
+			t.Errorf("%s: want the saved query, got %q, %v", name, q, err)
+		}
+		if len(c.Alerts) != 1 {
+			t.Fatalf("%s: want an alert, got %v", name, c.Alerts)
+		}
+		a := c.Alerts[0]
+		if a.Name != "slow" || a.Query.String() != "took>1s" || a.Count != 5 || a.Within != 30*time.Second || a.Cooldown != 5*time.Minute {
+			t.Errorf("%s: want the alert slow, got %+v", name, a)
+		}
+		if len(a.Dedup) != 1 || len(a.Actions) != 2 || a.Actions[1].String() != "command notify-send logterm" {
+			t.Errorf("%s: want the dedup fields and actions of the alert, got %v and %v", name, a.Dedup, a.Actions)
+		}
+		if len(c.Metrics) != 1 {
+			t.Fatalf("%s: want a metric, got %v", name, c.Metrics)
+		}
+		m := c.Metrics[0]
+		if m.Kind != metrics.Histogram || m.Query.String() != "level=error" || m.Field != "took" || !reflect.DeepEqual(m.Buckets, []float64{0.1, 1}) {
+			t.Errorf("%s: want the histogram request_seconds, got %+v", name, m)
+		}
+		if want := []metrics.Label{{Name: "service", Field: "service"}, {Name: "status", Field: "http.status"}}; !reflect.DeepEqual(m.Labels, want) {
+			t.Errorf("%s: want labels %v, got %v", name, want, m.Labels)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	c, err := Read("config.toml", []byte(tomlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := c.Parse([]byte(`{"level":"info","message":"call took 1.5s"}`))
+	if f, _ := e.Field("msg"); f != parser.StringField("call took 1.5s") {
+		t.Errorf("want message renamed msg, got %v", f)
+	}
+	if _, ok := e.Field("message"); ok {
+		t.Error("want no message field")
+	}
+	if f, _ := e.Field("took"); f == nil {
+		t.Error("want the took field extracted from msg")
+	}
+	if _, err := c.Query("@nope"); err == nil {
+		t.Error("want an error for a query that isn't saved")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data string
+		want string
+	}{
+		{"config.toml", "[theme]\nheader = \"plaid\"", "line 2: unknown color \"plaid\""},
+		{"config.toml", "[theme]\nfooter = \"red\"", "line 2: unknown part of the theme \"footer\""},
+		{"config.toml", "\n\n[colors]\n", "line 3: unknown section \"colors\""},
+		{"config.toml", "[keys]\ncorrelate = \"ctrl-o\"", "line 2: ctrl-o is already bound to repeats"},
+		{"config.toml", "[keys]\nsort = \"ctrl-c\"", "line 2: ctrl-c is kept for quitting"},
+		{"config.toml", "[keys]\nfly = \"ctrl-g\"", "line 2: unknown action \"fly\""},
+		{"config.toml", "[queries]\nbad = \"| count by\"", "line 2: invalid query \"bad\""},
+		{"config.toml", "[queries]\na = \"x\"\na = \"y\"", "line 3: \"a\" is already set on line 2"},
+		{"config.toml", "[time]\n\ndisplay = \"Mars/Olympus\"", "line 3: want utc"},
+		{"config.toml", "[extract]\nrules = [\n\t\"%{NOPE:x}\",\n]", "line 3:"},
+		{"config.toml", "[profiles.p]\nquery = \"@nope\"", "line 2: no query is saved as \"nope\""},
+		{"config.toml", "[profiles.p]\nfollow = \"yes\"", "line 2: want true or false, got a string"},
+		{"config.toml", "[profiles.p]\nsources = [\"a\"]\ncommand = [\"b\"]", "line 1: profile \"p\" has both"},
+		{"config.toml", "[time]\ndisplay = utc", "line 2: want a value, got \"utc\", strings need quotes"},
+		{"config.toml", "[time]\ndisplay = \"utc", "line 2: the string isn't closed"},
+		{"config.toml", "[time]\ndisplay = \"utc\" x", "line 2: want a new line, got \"x\""},
+		{"config.toml", "[time]\n[time]", "line 2: table [time] is already defined on line 1"},
+		{"config.toml", "[alerts.a]\nquery = \"| count by level\"", "line 2: the query of alert \"a\" can't aggregate"},
+		{"config.toml", "[alerts.a]\nquery = \"x\"\ncount = 1.5", "line 3: want a whole number"},
+		{"config.toml", "[alerts.a]\nquery = \"x\"\nwithin = \"soon\"", "line 3: want a span of time"},
+		{"config.toml", "[alerts.a]\nquery = \"x\"\nactions = [\"email\"]", "line 3: unknown action \"email\""},
+		{"config.toml", "[alerts.a]\nquery = \"x\"", "line 1: alert \"a\" needs actions"},
+		{"config.toml", "[metrics.m]\nfield = \"took\"", "line 1: metric \"m\" needs a type"},
+		{"config.toml", "[metrics.m]\ntype = \"summary\"", "line 2: unknown type \"summary\""},
+		{"config.toml", "[metrics.m]\ntype = \"gauge\"", "line 1: invalid metric \"m\": a gauge needs a field"},
+		{"config.toml", "[metrics.m]\ntype = \"histogram\"\nfield = \"took\"\nbuckets = [1, \"2\"]", "line 4: want a number, got a string"},
+		{"config.toml", "[metrics.m]\nlabels = [\"__name__=x\"]", "line 2: label names starting with __"},
+		{"config.yaml", "theme:\n  header: plaid", "line 2: unknown color \"plaid\""},
+		{"config.yaml", "time:\n  display: utc\n   layouts: []", "line 3: the indentation doesn't match"},
+		{"config.yaml", "profiles:\n  p:\n    follow: maybe", "line 3: want true or false, got a string"},
+		{"config.yaml", "keys: [a, b]", "line 1: want a table, got a list"},
+		{"config.yaml", "queries:\n  q: |\n    level=error", "line 2: block scalars aren't supported"},
+		{"config.yaml", "aliases:\n\tmsg: [message]", "line 2: indent with spaces, not tabs"},
+	} {
+		_, err := Read(tt.name, []byte(tt.data))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s %q: want an error like %q, got %v", tt.name, tt.data, tt.want, err)
+		}
+	}
+}
+
+func TestLoadDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if path := DefaultPath(); path != "" {
+		t.Fatalf("want no default path, got %q", path)
+	}
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Keys["correlate"] != termbox.KeyCtrlT || c.Theme != New().Theme {
+		t.Errorf("want the defaults, got %+v", c)
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	p := &Profile{Query: "level=error", Columns: []string{"level", "msg"}, Time: "utc"}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	q := fs.String("q", "", "")
+	cols := fs.String("columns", "", "")
+	times := fs.String("time", "", "")
+	if err := fs.Parse([]string{"-time", "local"}); err != nil {
+		t.Fatal(err)
+	}
+	p.SetFlags(fs)
+	if *q != "level=error" || *cols != "level,msg" || *times != "local" {
+		t.Errorf("want the options of the profile where flags aren't given, got %q, %q, %q", *q, *cols, *times)
+	}
+}
@@ -0,0 +1,294 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// readTOML reads the part of TOML that configs need: tables, dotted keys,
+// strings, numbers, booleans and arrays of them. Inline tables, arrays of
+// tables, dates and multi-line strings are errors.
+func readTOML(src string) (*table, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := newTable(1)
+	cur := root
+	headers := make(map[string]int)
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+		line := p.line
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.src[p.pos:], "[[") {
+				return nil, errorf(line, "arrays of tables aren't supported")
+			}
+			p.pos++
+			p.space()
+			keys, err := p.keys()
+			if err != nil {
+				return nil, err
+			}
+			p.space()
+			if p.eof() || p.peek() != ']' {
+				return nil, errorf(line, "want ] to close the table header, got %s", p.next())
+			}
+			p.pos++
+			name := strings.Join(keys, ".")
+			if prev, ok := headers[name]; ok {
+				return nil, errorf(line, "table [%s] is already defined on line %d", name, prev)
+			}
+			headers[name] = line
+			cur = root
+			for _, k := range keys {
+				if cur, err = cur.subtable(k, line); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			keys, err := p.keys()
+			if err != nil {
+				return nil, err
+			}
+			p.space()
+			if p.eof() || p.peek() != '=' {
+				return nil, errorf(line, "want = after %q, got %s", strings.Join(keys, "."), p.next())
+			}
+			p.pos++
+			p.space()
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			t := cur
+			for _, k := range keys[:len(keys)-1] {
+				if t, err = t.subtable(k, line); err != nil {
+					return nil, err
+				}
+			}
+			if err := t.set(keys[len(keys)-1], v); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+// next is what comes next, to tell in errors.
+func (p *tomlParser) next() string {
+	if p.eof() {
+		return "the end of the file"
+	}
+	rest := p.src[p.pos:]
+	if i := strings.IndexAny(rest, "\r\n"); i >= 0 {
+		rest = rest[:i]
+	}
+	if rest == "" {
+		return "the end of the line"
+	}
+	return strconv.Quote(rest)
+}
+
+// space skips spaces and tabs.
+func (p *tomlParser) space() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips spaces, new lines and comments.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endLine skips what's left of a line, that can only be a comment.
+func (p *tomlParser) endLine() error {
+	p.space()
+	if !p.eof() && p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if !p.eof() && p.peek() == '\r' {
+		p.pos++
+	}
+	if !p.eof() && p.peek() != '\n' {
+		return errorf(p.line, "want a new line, got %s", p.next())
+	}
+	return nil
+}
+
+func isBare(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// keys of a dotted key, like `a."b c".d`.
+func (p *tomlParser) keys() ([]string, error) {
+	var keys []string
+	for {
+		var key string
+		switch {
+		case p.eof():
+			return nil, errorf(p.line, "want a key, got the end of the file")
+		case p.peek() == '"' || p.peek() == '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		default:
+			start := p.pos
+			for !p.eof() && isBare(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, errorf(p.line, "want a key, got %s", p.next())
+			}
+			key = p.src[start:p.pos]
+		}
+		keys = append(keys, key)
+		p.space()
+		if p.eof() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+		p.space()
+	}
+}
+
+func (p *tomlParser) value() (*value, error) {
+	line := p.line
+	if p.eof() {
+		return nil, errorf(line, "want a value, got the end of the file")
+	}
+	switch p.peek() {
+	case '"', '\'':
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return &value{line: line, kind: stringKind, text: s}, nil
+	case '[':
+		p.pos++
+		v := &value{line: line, kind: listKind}
+		for {
+			p.skipBlank()
+			if p.eof() {
+				return nil, errorf(line, "the list isn't closed with ]")
+			}
+			if p.peek() == ']' {
+				p.pos++
+				return v, nil
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			v.list = append(v.list, item)
+			p.skipBlank()
+			if !p.eof() && p.peek() == ',' {
+				p.pos++
+			} else if p.eof() || p.peek() != ']' {
+				return nil, errorf(p.line, "want , or ] in the list, got %s", p.next())
+			}
+		}
+	case '{':
+		return nil, errorf(line, "inline tables aren't supported, use a [table]")
+	}
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]#", rune(p.peek())) {
+		p.pos++
+	}
+	text := p.src[start:p.pos]
+	v := scalar(line, strings.Replace(text, "_", "", -1), false)
+	if v.kind == stringKind {
+		return nil, errorf(line, "want a value, got %q, strings need quotes", text)
+	}
+	return v, nil
+}
+
+// str reads a string in double quotes, with escapes, or in single quotes,
+// without.
+func (p *tomlParser) str() (string, error) {
+	line := p.line
+	quote := p.peek()
+	if strings.HasPrefix(p.src[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", errorf(line, "multi-line strings aren't supported")
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", errorf(line, "the string isn't closed with %c", quote)
+		}
+		c := p.peek()
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if p.eof() {
+				return "", errorf(line, "the string isn't closed with %c", quote)
+			}
+			e := p.peek()
+			p.pos++
+			switch e {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.src) {
+					return "", errorf(line, "invalid escape \\%c", e)
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+				if err != nil {
+					return "", errorf(line, "invalid escape \\%c%s", e, p.src[p.pos:p.pos+n])
+				}
+				b.WriteRune(rune(r))
+				p.pos += n
+			default:
+				return "", errorf(line, "invalid escape \\%c, regular expressions read better in 'single quotes'", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
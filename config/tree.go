@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// kind of a value in a file.
+type kind int
+
+const (
+	stringKind kind = iota
+	numberKind
+	boolKind
+	listKind
+	tableKind
+)
+
+func (k kind) String() string {
+	switch k {
+	case numberKind:
+		return "a number"
+	case boolKind:
+		return "a boolean"
+	case listKind:
+		return "a list"
+	case tableKind:
+		return "a table"
+	}
+	return "a string"
+}
+
+// value read from a file, with the line it's on so that errors can tell
+// where it is.
+type value struct {
+	line int
+	kind kind
+	// text of strings, and of numbers and booleans as written
+	text string
+	// plain scalars of YAML, that can be read as strings whatever they
+	// look like
+	plain bool
+	b     bool
+	list  []*value
+	table *table
+}
+
+// table of values by key, in the order they're written.
+type table struct {
+	line   int
+	keys   []string
+	values map[string]*value
+}
+
+func newTable(line int) *table {
+	return &table{line: line, values: make(map[string]*value)}
+}
+
+// set key to v, unless it's already set.
+func (t *table) set(key string, v *value) error {
+	if prev, ok := t.values[key]; ok {
+		return &lineError{v.line, fmt.Sprintf("%q is already set on line %d", key, prev.line)}
+	}
+	t.keys = append(t.keys, key)
+	t.values[key] = v
+	return nil
+}
+
+// subtable of key, made if it's not there.
+func (t *table) subtable(key string, line int) (*table, error) {
+	if v, ok := t.values[key]; ok {
+		if v.kind != tableKind {
+			return nil, &lineError{line, fmt.Sprintf("%q is already set to %s on line %d", key, v.kind, v.line)}
+		}
+		return v.table, nil
+	}
+	sub := newTable(line)
+	t.keys = append(t.keys, key)
+	t.values[key] = &value{line: line, kind: tableKind, table: sub}
+	return sub, nil
+}
+
+// lineError is an error on a line of a file.
+type lineError struct {
+	line int
+	msg  string
+}
+
+func (e *lineError) Error() string { return "line " + strconv.Itoa(e.line) + ": " + e.msg }
+
+func errorf(line int, format string, args ...interface{}) error {
+	return &lineError{line, fmt.Sprintf(format, args...)}
+}
+
+// str is v as a string.
+func (v *value) str() (string, error) {
+	if v.kind == stringKind || v.plain && v.kind != listKind && v.kind != tableKind {
+		return v.text, nil
+	}
+	return "", errorf(v.line, "want a string, got %s", v.kind)
+}
+
+// strs is v as a list of strings. A string alone is a list of it.
+func (v *value) strs() ([]string, error) {
+	if v.kind != listKind {
+		s, err := v.str()
+		if err != nil {
+			return nil, errorf(v.line, "want a list of strings, got %s", v.kind)
+		}
+		return []string{s}, nil
+	}
+	out := make([]string, 0, len(v.list))
+	for _, item := range v.list {
+		s, err := item.str()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (v *value) boolean() (bool, error) {
+	if v.kind != boolKind {
+		return false, errorf(v.line, "want true or false, got %s", v.kind)
+	}
+	return v.b, nil
+}
+
+func (v *value) integer() (int, error) {
+	if v.kind != numberKind {
+		return 0, errorf(v.line, "want a number, got %s", v.kind)
+	}
+	n, err := strconv.Atoi(v.text)
+	if err != nil {
+		return 0, errorf(v.line, "want a whole number, got %s", v.text)
+	}
+	return n, nil
+}
+
+// numbers is v as a list of numbers. A number alone is a list of it.
+func (v *value) numbers() ([]float64, error) {
+	items := v.list
+	if v.kind != listKind {
+		items = []*value{v}
+	}
+	out := make([]float64, 0, len(items))
+	for _, item := range items {
+		if item.kind != numberKind {
+			return nil, errorf(item.line, "want a number, got %s", item.kind)
+		}
+		n, err := strconv.ParseFloat(item.text, 64)
+		if err != nil {
+			return nil, errorf(item.line, "want a number, got %s", item.text)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// duration is v as a string like `1m30s`.
+func (v *value) duration() (time.Duration, error) {
+	s, err := v.str()
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errorf(v.line, "want a span of time like 30s or 5m, got %q", s)
+	}
+	return d, nil
+}
+
+func (v *value) tbl() (*table, error) {
+	if v.kind != tableKind {
+		return nil, errorf(v.line, "want a table, got %s", v.kind)
+	}
+	return v.table, nil
+}
+
+// scalar is the value of the text of a plain scalar, or a number or
+// boolean of TOML.
+func scalar(line int, text string, plain bool) *value {
+	v := &value{line: line, kind: stringKind, text: text, plain: plain}
+	switch text {
+	case "true":
+		v.kind, v.b = boolKind, true
+	case "false":
+		v.kind = boolKind
+	default:
+		if _, err := strconv.ParseFloat(text, 64); err == nil {
+			v.kind = numberKind
+		}
+	}
+	return v
+}
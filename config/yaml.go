@@ -0,0 +1,250 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// readYAML reads the part of YAML that configs need: mappings nested by
+// indentation, lists of scalars in blocks or in brackets, and scalars
+// plain or in quotes. Anchors, tags, block scalars and flow mappings are
+// errors.
+func readYAML(src string) (*table, error) {
+	lines, err := yamlLines(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return newTable(1), nil
+	}
+	p := &yamlParser{lines: lines}
+	if isListItem(lines[0].text) {
+		return nil, errorf(lines[0].n, "want key: value, got a list item")
+	}
+	v, err := p.mapping(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(lines) {
+		return nil, errorf(lines[p.i].n, "the indentation doesn't match the lines before")
+	}
+	return v.table, nil
+}
+
+type yamlLine struct {
+	n      int
+	indent int
+	text   string
+}
+
+// yamlLines are the lines of src that aren't blank, without comments.
+func yamlLines(src string) ([]yamlLine, error) {
+	var lines []yamlLine
+	for i, text := range strings.Split(src, "\n") {
+		n := i + 1
+		text = strings.TrimRight(stripComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		if trimmed[0] == '\t' {
+			return nil, errorf(n, "indent with spaces, not tabs")
+		}
+		lines = append(lines, yamlLine{n: n, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	return lines, nil
+}
+
+// stripComment removes a comment that starts with # after a space, and
+// that isn't in quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isListItem(s string) bool { return s == "-" || strings.HasPrefix(s, "- ") }
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func (p *yamlParser) mapping(indent int) (*value, error) {
+	t := newTable(p.lines[p.i].n)
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, errorf(l.n, "the indentation doesn't match the lines before")
+		}
+		if isListItem(l.text) {
+			return nil, errorf(l.n, "want key: value, got a list item")
+		}
+		key, rest, err := splitKey(l.n, l.text)
+		if err != nil {
+			return nil, err
+		}
+		p.i++
+		var v *value
+		switch {
+		case rest != "":
+			v, err = inline(l.n, rest)
+		case p.i < len(p.lines) && p.lines[p.i].indent > indent:
+			if isListItem(p.lines[p.i].text) {
+				v, err = p.list(p.lines[p.i].indent)
+			} else {
+				v, err = p.mapping(p.lines[p.i].indent)
+			}
+		case p.i < len(p.lines) && p.lines[p.i].indent == indent && isListItem(p.lines[p.i].text):
+			// lists can be as indented as their key
+			v, err = p.list(indent)
+		default:
+			v = &value{line: l.n, kind: stringKind, plain: true}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := t.set(key, v); err != nil {
+			return nil, err
+		}
+	}
+	return &value{line: t.line, kind: tableKind, table: t}, nil
+}
+
+func (p *yamlParser) list(indent int) (*value, error) {
+	v := &value{line: p.lines[p.i].n, kind: listKind}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent || !isListItem(l.text) {
+			break
+		}
+		if l.indent > indent {
+			return nil, errorf(l.n, "the indentation doesn't match the lines before")
+		}
+		item := strings.TrimSpace(l.text[1:])
+		if item == "" {
+			return nil, errorf(l.n, "lists of lists or mappings aren't supported")
+		}
+		if _, _, err := splitKey(l.n, item); err == nil && item[0] != '"' && item[0] != '\'' {
+			return nil, errorf(l.n, "lists of mappings aren't supported")
+		}
+		iv, err := inline(l.n, item)
+		if err != nil {
+			return nil, err
+		}
+		v.list = append(v.list, iv)
+		p.i++
+	}
+	return v, nil
+}
+
+// splitKey splits `key: value` into the key and the value.
+func splitKey(line int, s string) (string, string, error) {
+	if s[0] == '"' || s[0] == '\'' {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", "", errorf(line, "the key isn't closed with %c", s[0])
+		}
+		key, rest := s[1:end+1], s[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", errorf(line, "want : after the key %q", key)
+		}
+		return key, strings.TrimSpace(rest[1:]), nil
+	}
+	if strings.HasSuffix(s, ":") {
+		return strings.TrimSpace(s[:len(s)-1]), "", nil
+	}
+	i := strings.Index(s, ": ")
+	if i < 0 {
+		return "", "", errorf(line, "want key: value, got %q", s)
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+2:]), nil
+}
+
+// inline reads a value that's on the line of its key or list item.
+func inline(line int, s string) (*value, error) {
+	switch s[0] {
+	case '"':
+		text, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, errorf(line, "invalid string %s", s)
+		}
+		return &value{line: line, kind: stringKind, text: text}, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, errorf(line, "the string isn't closed with '")
+		}
+		return &value{line: line, kind: stringKind, text: strings.Replace(s[1:len(s)-1], "''", "'", -1)}, nil
+	case '[':
+		if s[len(s)-1] != ']' {
+			return nil, errorf(line, "the list isn't closed with ]")
+		}
+		v := &value{line: line, kind: listKind}
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			if item[0] == '[' || item[0] == '{' {
+				return nil, errorf(line, "lists of lists or mappings aren't supported")
+			}
+			iv, err := inline(line, item)
+			if err != nil {
+				return nil, err
+			}
+			v.list = append(v.list, iv)
+		}
+		return v, nil
+	case '{':
+		return nil, errorf(line, "mappings in braces aren't supported, indent them")
+	case '|', '>':
+		return nil, errorf(line, "block scalars aren't supported, quote the string")
+	case '&', '*', '!':
+		return nil, errorf(line, "anchors, aliases and tags aren't supported")
+	}
+	if s == "~" || s == "null" {
+		return &value{line: line, kind: stringKind, plain: true}, nil
+	}
+	return scalar(line, s, true), nil
+}
+
+// splitFlow splits the items of a list in brackets on the commas that
+// aren't in quotes.
+func splitFlow(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
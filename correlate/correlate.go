@@ -0,0 +1,237 @@
+// Package correlate groups the entries that share the value of a field
+// like `request_id` or `trace_id`, to follow a request through the logs
+// of the services it went through.
+package correlate
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultMaxGroups = 10000
+
+// DefaultFields commonly hold the identifier of a request, in order of
+// preference.
+var DefaultFields = []string{
+	"request_id", "trace_id", "req_id", "requestId", "traceId",
+	"correlation_id", "x-request-id", "span_id",
+}
+
+// Key is the first of the fields that the entry has a value for, and that
+// value.
+func Key(e *parser.Entry, fields []string) (field, value string, ok bool) {
+	for _, name := range fields {
+		f, ok := e.Field(name)
+		if !ok {
+			continue
+		}
+		if v, ok := keyValue(f); ok {
+			return name, v, true
+		}
+	}
+	return "", "", false
+}
+
+func keyValue(f parser.Field) (string, bool) {
+	switch f := f.(type) {
+	case parser.StringField:
+		return string(f), f != ""
+	case parser.RawField:
+		return string(f), len(f) > 0
+	case parser.NumberField:
+		return strconv.FormatFloat(float64(f), 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// Entry of a group.
+type Entry struct {
+	// N is the number of the line, in the order it was read
+	N    uint64
+	Line []byte
+	// Source the entry was read from, like the name of its file
+	Source string
+	// Time of the entry, zero if it has none
+	Time time.Time
+	// time the entry is ordered by, that of the entry read before it
+	// when it has none
+	at time.Time
+}
+
+// Group of the entries that share a value.
+type Group struct {
+	Field, Value string
+	// Entries ordered by time. Those without a time are put after the
+	// one that was read before them.
+	Entries []Entry
+	// time of the entry read last
+	last time.Time
+	// times of the first and last entries that have one
+	start, end time.Time
+}
+
+// Add the entry on line n to the group.
+func (g *Group) Add(n uint64, line []byte, e *parser.Entry) { g.AddFrom("", n, line, e) }
+
+// AddFrom adds the entry on line n, read from source, to the group.
+func (g *Group) AddFrom(source string, n uint64, line []byte, e *parser.Entry) {
+	entry := Entry{N: n, Source: source, Line: append([]byte(nil), line...)}
+	if at, ok := e.Time(); ok {
+		entry.Time = at
+		g.last = at
+		if g.start.IsZero() || at.Before(g.start) {
+			g.start = at
+		}
+		if g.end.IsZero() || at.After(g.end) {
+			g.end = at
+		}
+	}
+	entry.at = g.last
+	// entries mostly come in order, look from the end
+	i := len(g.Entries)
+	for i > 0 && g.Entries[i-1].at.After(entry.at) {
+		i--
+	}
+	g.Entries = append(g.Entries, Entry{})
+	copy(g.Entries[i+1:], g.Entries[i:])
+	g.Entries[i] = entry
+}
+
+// Start is the time of the first entry that has one.
+func (g *Group) Start() time.Time { return g.start }
+
+// Span is the time between the first and the last entries that have one.
+func (g *Group) Span() time.Duration { return g.end.Sub(g.start) }
+
+// Offset is how long after the start of the group the entry happened.
+// Entries without a time get the offset of the one before them, or 0.
+func (g *Group) Offset(e Entry) time.Duration {
+	if e.at.IsZero() {
+		return 0
+	}
+	return e.at.Sub(g.start)
+}
+
+func (g *Group) String() string {
+	if len(g.Entries) == 1 {
+		return fmt.Sprintf("%s=%s, 1 entry", g.Field, g.Value)
+	}
+	return fmt.Sprintf("%s=%s, %d entries over %v", g.Field, g.Value, len(g.Entries), g.Span())
+}
+
+// Table lists the entries with their offset from the start of the group,
+// and their source if sources is true, under a header that tells its span.
+func (g *Group) Table(sources bool) *query.Table {
+	t := &query.Table{Columns: []string{"offset", g.String()}}
+	if sources {
+		t.Columns = []string{"offset", "source", g.String()}
+	}
+	for _, e := range g.Entries {
+		row := []string{formatOffset(g.Offset(e)), string(e.Line)}
+		if sources {
+			row = []string{formatOffset(g.Offset(e)), e.Source, string(e.Line)}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+func formatOffset(d time.Duration) string {
+	if d < 0 {
+		return d.String()
+	}
+	return fmt.Sprintf("+%v", d)
+}
+
+func (g *Group) clone() *Group {
+	c := *g
+	c.Entries = append([]Entry(nil), g.Entries...)
+	return &c
+}
+
+// Correlator groups the entries it's given by the value of the first of
+// its fields that they have. It's safe for concurrent use.
+type Correlator struct {
+	fields []string
+	max    int
+
+	mu     sync.Mutex
+	groups map[string]*Group
+	// values of the groups, the oldest first, to forget them in order
+	order []string
+}
+
+// NewCorrelator groups by the first of fields that entries have, keeping
+// up to max groups. A nil fields means DefaultFields, a max of 0 means
+// DefaultMaxGroups.
+func NewCorrelator(fields []string, max int) *Correlator {
+	if fields == nil {
+		fields = DefaultFields
+	}
+	if max <= 0 {
+		max = DefaultMaxGroups
+	}
+	return &Correlator{fields: fields, max: max, groups: make(map[string]*Group)}
+}
+
+// Add the entry on line n to its group, if it has one.
+func (c *Correlator) Add(n uint64, line []byte, e *parser.Entry) bool {
+	return c.AddFrom("", n, line, e)
+}
+
+// AddFrom adds the entry on line n, read from source, to its group, if it
+// has one.
+func (c *Correlator) AddFrom(source string, n uint64, line []byte, e *parser.Entry) bool {
+	field, value, ok := Key(e, c.fields)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := field + "=" + value
+	g, ok := c.groups[key]
+	if !ok {
+		if len(c.order) >= c.max {
+			delete(c.groups, c.order[0])
+			c.order = c.order[1:]
+		}
+		g = &Group{Field: field, Value: value}
+		c.groups[key] = g
+		c.order = append(c.order, key)
+	}
+	g.AddFrom(source, n, line, e)
+	return true
+}
+
+// Group that has the value for field.
+func (c *Correlator) Group(field, value string) (*Group, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[field+"="+value]
+	if !ok {
+		return nil, false
+	}
+	return g.clone(), true
+}
+
+// Groups ordered by the time of their first entry.
+func (c *Correlator) Groups() []*Group {
+	c.mu.Lock()
+	all := make([]*Group, 0, len(c.groups))
+	for _, g := range c.groups {
+		all = append(all, g.clone())
+	}
+	c.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		if a, b := all[i].Start(), all[j].Start(); !a.Equal(b) {
+			return a.Before(b)
+		}
+		return all[i].Entries[0].N < all[j].Entries[0].N
+	})
+	return all
+}
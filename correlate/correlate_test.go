@@ -0,0 +1,124 @@
+package correlate
+
+import (
+	"github.com/aybabtme/logterm/parser"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// lines of two services, merged out of order
+var merged = []string{
+	`time=2014-10-27T18:38:45.000Z service=api request_id=r1 msg="got request"`,
+	`time=2014-10-27T18:38:45.500Z service=api request_id=r2 msg="got request"`,
+	`time=2014-10-27T18:38:47.000Z service=api request_id=r1 msg=responded`,
+	`time=2014-10-27T18:38:45.200Z service=db trace_id=r1 msg=query`,
+	`time=2014-10-27T18:38:46.900Z service=db request_id=r1 msg="query timed out"`,
+	`service=db request_id=r1 msg="no time"`,
+	`time=2014-10-27T18:38:48.000Z msg="no request"`,
+}
+
+func correlateAll(c *Correlator) {
+	for i, line := range merged {
+		c.Add(uint64(i), []byte(line), parser.ParseLine([]byte(line)))
+	}
+}
+
+func TestGroups(t *testing.T) {
+	c := NewCorrelator(nil, 0)
+	correlateAll(c)
+
+	type summary struct {
+		key     string
+		lines   []uint64
+		offsets []time.Duration
+		span    time.Duration
+	}
+	var got []summary
+	for _, g := range c.Groups() {
+		s := summary{key: g.Field + "=" + g.Value, span: g.Span()}
+		for _, e := range g.Entries {
+			s.lines = append(s.lines, e.N)
+			s.offsets = append(s.offsets, g.Offset(e))
+		}
+		got = append(got, s)
+	}
+	ms := time.Millisecond
+	want := []summary{
+		{"request_id=r1", []uint64{0, 4, 5, 2}, []time.Duration{0, 1900 * ms, 1900 * ms, 2000 * ms}, 2 * time.Second},
+		{"trace_id=r1", []uint64{3}, []time.Duration{0}, 0},
+		{"request_id=r2", []uint64{1}, []time.Duration{0}, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestGroupByFields(t *testing.T) {
+	c := NewCorrelator([]string{"trace_id", "request_id"}, 0)
+	correlateAll(c)
+	g, ok := c.Group("trace_id", "r1")
+	if !ok {
+		t.Fatal("no group for trace_id=r1")
+	}
+	if want := "trace_id=r1, 1 entry"; g.String() != want {
+		t.Errorf("want %q, got %q", want, g.String())
+	}
+	if _, ok := c.Group("request_id", "nope"); ok {
+		t.Error("want no group for an unknown value")
+	}
+}
+
+func TestMaxGroups(t *testing.T) {
+	c := NewCorrelator(nil, 1)
+	correlateAll(c)
+	all := c.Groups()
+	if len(all) != 1 || all[0].Value != "r1" || all[0].Field != "request_id" {
+		t.Fatalf("want only the last group, got %v", all)
+	}
+	// it was forgotten when r2 came, so it only has the entries after
+	if n := len(all[0].Entries); n != 2 {
+		t.Errorf("want 2 entries, got %d", n)
+	}
+}
+
+func TestUntimedFirst(t *testing.T) {
+	g := &Group{Field: "request_id", Value: "r1"}
+	for i, line := range []string{
+		`request_id=r1 msg="no time"`,
+		`time=2014-10-27T18:38:45.000Z request_id=r1 msg=start`,
+		`time=2014-10-27T18:38:45.250Z request_id=r1 msg=end`,
+	} {
+		g.Add(uint64(i), []byte(line), parser.ParseLine([]byte(line)))
+	}
+	if g.Span() != 250*time.Millisecond {
+		t.Errorf("want a span of 250ms, got %v", g.Span())
+	}
+	var offsets []time.Duration
+	for _, e := range g.Entries {
+		offsets = append(offsets, g.Offset(e))
+	}
+	if want := []time.Duration{0, 0, 250 * time.Millisecond}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("want offsets %v, got %v", want, offsets)
+	}
+}
+
+func TestTable(t *testing.T) {
+	c := NewCorrelator(nil, 0)
+	for i, line := range merged[:4] {
+		source := "api.log"
+		if i == 3 {
+			source = "db.log"
+		}
+		c.AddFrom(source, uint64(i), []byte(line), parser.ParseLine([]byte(line)))
+	}
+	g, _ := c.Group("request_id", "r1")
+	got := g.Table(true)
+	want := [][]string{
+		{"+0s", "api.log", merged[0]},
+		{"+2s", "api.log", merged[2]},
+	}
+	if !reflect.DeepEqual(got.Columns, []string{"offset", "source", "request_id=r1, 2 entries over 2s"}) || !reflect.DeepEqual(got.Rows, want) {
+		t.Errorf("want\n%v\ngot\n%v %v", want, got.Columns, got.Rows)
+	}
+}
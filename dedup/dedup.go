@@ -0,0 +1,298 @@
+// Package dedup collapses the entries that repeat one another into runs,
+// so that a service spamming the same error shows as one line with a
+// count.
+package dedup
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultMaxRuns = 100000
+
+// DefaultVolatile are the fields that differ between entries that are
+// otherwise the same: their time and identifiers.
+var DefaultVolatile = append(append([]string(nil), parser.TimeFields...),
+	"id", "request_id", "trace_id", "span_id", "req_id", "correlation_id",
+)
+
+// By is what entries are compared by.
+type By int
+
+const (
+	// Raw compares the lines as they are
+	Raw By = iota
+	// Fields compares the fields of the entries, minus the volatile
+	// ones
+	Fields
+)
+
+func (b By) String() string {
+	if b == Fields {
+		return "fields"
+	}
+	return "raw"
+}
+
+// Options of a Collapser. The zero value of a field takes its default.
+type Options struct {
+	By By
+	// Window is how long after the last entry of a run a repeat can
+	// still join it, with other entries in between. Without a window,
+	// only consecutive repeats join.
+	Window time.Duration
+	// Volatile fields are left out when comparing by fields
+	Volatile []string
+	// MaxRuns that are remembered, the oldest are forgotten first
+	MaxRuns int
+}
+
+// Run of entries that repeat the first one.
+type Run struct {
+	// Lines of the entries, the first is the one that's shown
+	Lines       []uint64
+	First, Last time.Time
+
+	key string
+}
+
+// Count of entries in the run.
+func (r *Run) Count() int { return len(r.Lines) }
+
+func (r *Run) String() string {
+	s := fmt.Sprintf("×%d", len(r.Lines))
+	if !r.First.IsZero() {
+		s += fmt.Sprintf(" %s–%s", r.First.Format("15:04:05"), r.Last.Format("15:04:05"))
+	}
+	return s
+}
+
+// Collapser groups the lines it's given, in order, in runs. It's safe for
+// concurrent use.
+type Collapser struct {
+	opts     Options
+	volatile map[string]bool
+
+	mu sync.Mutex
+	// next line expected, those from there weren't seen yet
+	next uint64
+	// first lines of the runs, that are shown, in order
+	shown []uint64
+	runs  map[uint64]*Run
+	// lines hidden behind the first of their run
+	hidden map[uint64]*Run
+	// runs that repeats can join, by key
+	open map[string]*Run
+	last *Run
+}
+
+func NewCollapser(opts Options) *Collapser {
+	if opts.Volatile == nil {
+		opts.Volatile = DefaultVolatile
+	}
+	if opts.MaxRuns <= 0 {
+		opts.MaxRuns = DefaultMaxRuns
+	}
+	c := &Collapser{
+		opts:     opts,
+		volatile: make(map[string]bool),
+		runs:     make(map[uint64]*Run),
+		hidden:   make(map[uint64]*Run),
+		open:     make(map[string]*Run),
+	}
+	for _, name := range opts.Volatile {
+		c.volatile[name] = true
+	}
+	return c
+}
+
+// Next is the number of the line that the collapser expects next.
+func (c *Collapser) Next() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.next
+}
+
+// key that repeats of the entry share.
+func (c *Collapser) key(line []byte, e *parser.Entry) string {
+	if c.opts.By == Raw {
+		return string(line)
+	}
+	var b strings.Builder
+	for _, name := range e.FieldNames() {
+		if c.volatile[name] {
+			continue
+		}
+		f, _ := e.Field(name)
+		fmt.Fprintf(&b, "%s=%v\x00", name, f)
+	}
+	return b.String()
+}
+
+// Add line n, that happened at `at`. Lines must be added in order.
+func (c *Collapser) Add(n uint64, line []byte, e *parser.Entry, at time.Time) {
+	key := c.key(line, e)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next = n + 1
+
+	r := c.last
+	if c.opts.Window > 0 {
+		r = c.open[key]
+	}
+	if r != nil && r.key == key && (c.opts.Window == 0 || at.Sub(r.Last) <= c.opts.Window) {
+		r.Lines = append(r.Lines, n)
+		if at.After(r.Last) {
+			r.Last = at
+		}
+		c.hidden[n] = r
+		c.last = r
+		return
+	}
+
+	r = &Run{Lines: []uint64{n}, First: at, Last: at, key: key}
+	c.runs[n] = r
+	c.shown = append(c.shown, n)
+	c.last = r
+	if c.opts.Window > 0 {
+		c.open[key] = r
+	}
+	if len(c.shown) > c.opts.MaxRuns {
+		c.forget(c.shown[0])
+		c.shown = c.shown[1:]
+	}
+}
+
+func (c *Collapser) forget(first uint64) {
+	r := c.runs[first]
+	delete(c.runs, first)
+	for _, n := range r.Lines[1:] {
+		delete(c.hidden, n)
+	}
+	if c.open[r.key] == r {
+		delete(c.open, r.key)
+	}
+	if c.last == r {
+		c.last = nil
+	}
+}
+
+// Evict forgets the lines before first, like when they're evicted from
+// where the lines are kept, so that runs that go on for long don't grow
+// without bounds. The first line that's left of a run is shown in place
+// of those.
+func (c *Collapser) Evict(first uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := sort.Search(len(c.shown), func(i int) bool { return c.shown[i] >= first })
+	var moved []uint64
+	for _, n := range c.shown[:i] {
+		r := c.runs[n]
+		// lines are in order
+		j := sort.Search(len(r.Lines), func(j int) bool { return r.Lines[j] >= first })
+		if j == len(r.Lines) {
+			c.forget(n)
+			continue
+		}
+		delete(c.runs, n)
+		for _, n := range r.Lines[1:j] {
+			delete(c.hidden, n)
+		}
+		r.Lines = append([]uint64(nil), r.Lines[j:]...)
+		delete(c.hidden, r.Lines[0])
+		c.runs[r.Lines[0]] = r
+		moved = append(moved, r.Lines[0])
+	}
+	if i == 0 {
+		return
+	}
+	sort.Slice(moved, func(i, j int) bool { return moved[i] < moved[j] })
+	c.shown = merge(moved, c.shown[i:])
+}
+
+// merge two sorted lists.
+func merge(a, b []uint64) []uint64 {
+	out := make([]uint64, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] < b[0] {
+			out, a = append(out, a[0]), a[1:]
+		} else {
+			out, b = append(out, b[0]), b[1:]
+		}
+	}
+	return append(append(out, a...), b...)
+}
+
+// Fold tells if line n is hidden behind the first of its run, and if
+// not, what to show after it: how many repeats it has, and when.
+func (c *Collapser) Fold(n uint64) (suffix string, hidden bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.hidden[n]; ok {
+		return "", true
+	}
+	if r, ok := c.runs[n]; ok && len(r.Lines) > 1 {
+		return r.String(), false
+	}
+	return "", false
+}
+
+// Run that line n is part of.
+func (c *Collapser) Run(n uint64) (*Run, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.hidden[n]
+	if !ok {
+		if r, ok = c.runs[n]; !ok {
+			return nil, false
+		}
+	}
+	cp := *r
+	cp.Lines = append([]uint64(nil), r.Lines...)
+	return &cp, true
+}
+
+// Back is the shown line that's k shown lines before line n. Lines that
+// weren't added yet are all shown.
+func (c *Collapser) Back(n uint64, k int) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > c.next {
+		unseen := n - c.next
+		if uint64(k) <= unseen {
+			return n - uint64(k)
+		}
+		k -= int(unseen)
+		n = c.next
+	}
+	i := sort.Search(len(c.shown), func(i int) bool { return c.shown[i] >= n })
+	i -= k
+	switch {
+	case len(c.shown) == 0:
+		return n
+	case i < 0:
+		return c.shown[0]
+	case i >= len(c.shown):
+		return n
+	}
+	return c.shown[i]
+}
+
+// Forward is the shown line that's k shown lines after line n, or after
+// the first shown line after n when n is hidden.
+func (c *Collapser) Forward(n uint64, k int) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n >= c.next {
+		return n + uint64(k)
+	}
+	i := sort.Search(len(c.shown), func(i int) bool { return c.shown[i] >= n }) + k
+	if i < len(c.shown) {
+		return c.shown[i]
+	}
+	return c.next + uint64(i-len(c.shown))
+}
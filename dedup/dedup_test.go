@@ -0,0 +1,148 @@
+package dedup
+
+import (
+	"github.com/aybabtme/logterm/parser"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var spam = []string{
+	`time=2014-10-27T18:38:45Z level=error request_id=1 msg="db down"`,
+	`time=2014-10-27T18:38:46Z level=error request_id=2 msg="db down"`,
+	`time=2014-10-27T18:38:47Z level=error request_id=3 msg="db down"`,
+	`time=2014-10-27T18:38:48Z level=info msg="health check"`,
+	`time=2014-10-27T18:38:49Z level=error request_id=4 msg="db down"`,
+	`time=2014-10-27T18:38:49Z level=error request_id=4 msg="db down"`,
+	`time=2014-10-27T18:40:00Z level=error request_id=5 msg="db down"`,
+}
+
+func collapse(opts Options) *Collapser {
+	c := NewCollapser(opts)
+	for i, line := range spam {
+		e := parser.ParseLine([]byte(line))
+		at, _ := e.Time()
+		c.Add(uint64(i), []byte(line), e, at)
+	}
+	return c
+}
+
+// shown lists the lines that aren't hidden, with their suffix.
+func shown(c *Collapser) map[uint64]string {
+	got := make(map[uint64]string)
+	for n := range spam {
+		if suffix, hidden := c.Fold(uint64(n)); !hidden {
+			got[uint64(n)] = suffix
+		}
+	}
+	return got
+}
+
+func TestCollapse(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want map[uint64]string
+	}{
+		{
+			name: "raw lines",
+			opts: Options{},
+			want: map[uint64]string{0: "", 1: "", 2: "", 3: "", 4: "×2 18:38:49–18:38:49", 6: ""},
+		},
+		{
+			name: "fields",
+			opts: Options{By: Fields},
+			want: map[uint64]string{0: "×3 18:38:45–18:38:47", 3: "", 4: "×3 18:38:49–18:40:00"},
+		},
+		{
+			name: "fields in a window",
+			opts: Options{By: Fields, Window: 10 * time.Second},
+			want: map[uint64]string{0: "×5 18:38:45–18:38:49", 3: "", 6: ""},
+		},
+	}
+	for _, tt := range tests {
+		if got := shown(collapse(tt.opts)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	c := collapse(Options{By: Fields, Window: 10 * time.Second})
+	r, ok := c.Run(5)
+	if !ok {
+		t.Fatal("line 5 has no run")
+	}
+	if want := []uint64{0, 1, 2, 4, 5}; !reflect.DeepEqual(r.Lines, want) || r.Count() != 5 {
+		t.Errorf("want lines %v, got %v", want, r.Lines)
+	}
+	// changing the copy doesn't change the run
+	r.Lines[0] = 42
+	if r, _ := c.Run(0); r.Lines[0] != 0 {
+		t.Error("Run returned the run itself")
+	}
+}
+
+func TestBackAndForward(t *testing.T) {
+	// shown: 0, 3, 6, then 7 and on weren't seen
+	c := collapse(Options{By: Fields, Window: 10 * time.Second})
+	tests := []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{"back from unseen", c.Back(9, 2), 7},
+		{"back into seen", c.Back(9, 3), 6},
+		{"back over hidden", c.Back(6, 1), 3},
+		{"back from hidden", c.Back(5, 1), 3},
+		{"back past the start", c.Back(6, 10), 0},
+		{"forward", c.Forward(0, 1), 3},
+		{"forward from hidden", c.Forward(1, 0), 3},
+		{"forward into unseen", c.Forward(3, 3), 8},
+		{"forward from unseen", c.Forward(8, 2), 10},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.want, tt.got)
+		}
+	}
+}
+
+func TestMaxRuns(t *testing.T) {
+	c := collapse(Options{By: Fields, MaxRuns: 2})
+	// the first run was forgotten, its lines show as they are
+	if _, hidden := c.Fold(1); hidden {
+		t.Error("line 1 is still hidden")
+	}
+	if _, ok := c.Run(0); ok {
+		t.Error("the first run is still remembered")
+	}
+	if suffix, _ := c.Fold(4); suffix == "" {
+		t.Error("the last run was forgotten")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	c := collapse(Options{By: Fields, Window: 10 * time.Second})
+	c.Evict(2)
+	// the first line left of the run shows in place of those evicted,
+	// which aren't folded anymore
+	want := map[uint64]string{0: "", 1: "", 2: "×3 18:38:45–18:38:49", 3: "", 6: ""}
+	if got := shown(c); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if r, ok := c.Run(5); !ok || !reflect.DeepEqual(r.Lines, []uint64{2, 4, 5}) {
+		t.Errorf("want the lines left of the run, got %+v", r)
+	}
+	if got := c.Forward(2, 1); got != 3 {
+		t.Errorf("want line 3 after line 2, got %d", got)
+	}
+
+	c.Evict(6)
+	if _, ok := c.Run(4); ok {
+		t.Error("an evicted run is still remembered")
+	}
+	if len(c.hidden) != 0 || len(c.runs) != 1 {
+		t.Errorf("want only the run of line 6, got %d runs and %d hidden lines", len(c.runs), len(c.hidden))
+	}
+}
@@ -0,0 +1,247 @@
+// Package encode writes entries back out, as JSON, logfmt, CSV or TSV.
+//
+// Typed fields are written so that the parser types them the same way
+// when it reads them back: times in RFC3339Nano and durations as Go
+// duration strings. Strings that read as another type, like "200", come
+// back as that type.
+package encode
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Formats that New knows of.
+var Formats = []string{"json", "logfmt", "csv", "tsv"}
+
+// Encoder writes entries.
+type Encoder interface {
+	Encode(e *parser.Entry) error
+	// Flush what's buffered to the underlying writer
+	Flush() error
+}
+
+// New encoder of format to w. Columns are the fields written by the CSV
+// and TSV encoders, those of the first entry when there are none.
+func New(format string, w io.Writer, columns []string) (Encoder, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return &lineEncoder{w: bufio.NewWriter(w), appendEntry: AppendJSON}, nil
+	case "logfmt":
+		return &lineEncoder{w: bufio.NewWriter(w), appendEntry: AppendLogfmt}, nil
+	case "csv":
+		return NewCSV(w, ',', columns), nil
+	case "tsv":
+		return NewCSV(w, '\t', columns), nil
+	}
+	return nil, fmt.Errorf("unknown format %q, want one of %s", format, strings.Join(Formats, ", "))
+}
+
+// Text of a field, the way it's written in logfmt and CSV.
+func Text(f parser.Field) string {
+	switch f := f.(type) {
+	case nil, parser.NilField:
+		return "null"
+	case parser.StringField:
+		return string(f)
+	case parser.RawField:
+		return string(f)
+	case parser.NumberField:
+		return formatNumber(float64(f))
+	case parser.DurationField:
+		return f.Duration.String()
+	case parser.TimeField:
+		return f.Format(time.RFC3339Nano)
+	case parser.BooleanField:
+		return strconv.FormatBool(bool(f))
+	}
+	return fmt.Sprint(f)
+}
+
+// formatNumber writes integers without exponents, when they're not huge.
+func formatNumber(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e21 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type lineEncoder struct {
+	w           *bufio.Writer
+	buf         []byte
+	appendEntry func(dst []byte, e *parser.Entry) []byte
+}
+
+func (l *lineEncoder) Encode(e *parser.Entry) error {
+	l.buf = append(l.appendEntry(l.buf[:0], e), '\n')
+	_, err := l.w.Write(l.buf)
+	return err
+}
+
+func (l *lineEncoder) Flush() error { return l.w.Flush() }
+
+// AppendJSON appends the entry as a JSON object, with its fields sorted
+// by name. Numbers that JSON can't hold are null.
+func AppendJSON(dst []byte, e *parser.Entry) []byte {
+	names := append([]string(nil), e.FieldNames()...)
+	sort.Strings(names)
+	dst = append(dst, '{')
+	for i, name := range names {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, name)
+		dst = append(dst, ':')
+		f, _ := e.Field(name)
+		dst = appendJSONValue(dst, f)
+	}
+	return append(dst, '}')
+}
+
+func appendJSONValue(dst []byte, f parser.Field) []byte {
+	switch f := f.(type) {
+	case nil, parser.NilField:
+		return append(dst, "null"...)
+	case parser.NumberField:
+		if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+			return append(dst, "null"...)
+		}
+		return append(dst, formatNumber(float64(f))...)
+	case parser.BooleanField:
+		return strconv.AppendBool(dst, bool(f))
+	}
+	return appendJSONString(dst, Text(f))
+}
+
+func appendJSONString(dst []byte, s string) []byte {
+	b, err := json.Marshal(s)
+	if err != nil {
+		// strings always marshal
+		panic(err)
+	}
+	return append(dst, b...)
+}
+
+// AppendLogfmt appends the entry as `key=value` pairs, in the order of
+// its fields. Values are quoted when they'd be read otherwise, and keys
+// lose the runes that would end them.
+//
+// Lines are only read as logfmt when their first key is made of letters,
+// so the first field with such a name goes first. Nested fields like
+// `http.status` or `tags[0]` are then read back as they were.
+func AppendLogfmt(dst []byte, e *parser.Entry) []byte {
+	names := e.FieldNames()
+	for i, name := range names {
+		if i > 0 && isLetters(name) {
+			names = append(append([]string{name}, names[:i]...), names[i+1:]...)
+			break
+		}
+	}
+	for i, name := range names {
+		if i > 0 {
+			dst = append(dst, ' ')
+		}
+		dst = append(dst, logfmtKey(name)...)
+		dst = append(dst, '=')
+		f, _ := e.Field(name)
+		if _, ok := f.(parser.NilField); ok || f == nil {
+			continue
+		}
+		v := Text(f)
+		if needsQuotes(v) {
+			dst = strconv.AppendQuote(dst, v)
+		} else {
+			dst = append(dst, v...)
+		}
+	}
+	return dst
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func logfmtKey(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// needsQuotes tells if a value can't be written as it is: values end at
+// the space before the next `key=`, and quoted ones are unquoted.
+func needsQuotes(v string) bool {
+	if v == "" || v[0] == '"' {
+		return true
+	}
+	for _, r := range v {
+		if unicode.IsSpace(r) || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r) || r == utf8.RuneError {
+			return true
+		}
+	}
+	return false
+}
+
+// CSV writes the fields of entries in columns, under a header.
+type CSV struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+	row     []string
+}
+
+// NewCSV writes entries to w with comma between the values. Without
+// columns, those of the first entry are used.
+func NewCSV(w io.Writer, comma rune, columns []string) *CSV {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &CSV{w: cw, columns: columns}
+}
+
+func (c *CSV) Encode(e *parser.Entry) error {
+	if !c.header {
+		if c.columns == nil {
+			c.columns = append([]string(nil), e.FieldNames()...)
+		}
+		if err := c.w.Write(c.columns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	c.row = c.row[:0]
+	for _, name := range c.columns {
+		f, ok := e.Field(name)
+		if _, isNil := f.(parser.NilField); !ok || isNil {
+			c.row = append(c.row, "")
+			continue
+		}
+		c.row = append(c.row, Text(f))
+	}
+	return c.w.Write(c.row)
+}
+
+func (c *CSV) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
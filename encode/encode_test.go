@@ -0,0 +1,162 @@
+package encode
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/aybabtme/logterm/parser"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var lines = []string{
+	`time=2014-10-27T18:38:45.123456789-04:00 level=error took=1.5s status=500 ok=false msg="db down, retrying" path=/api/v1 gone=`,
+	`{"time":"2014-10-27T18:38:46Z","level":"info","took":"250ms","status":200,"ok":true,"msg":"a \"quoted\" word","req":{"id":"x=y"}}`,
+	`level=debug msg="" n=1e30 frac=0.25`,
+	`{"http":{"status":503,"took":"2s"},"tags":["a","b c"],"x-id":"r1","level":"warn"}`,
+}
+
+// sameFields tells how a and b differ, if they do. Times are compared as
+// instants.
+func sameFields(t *testing.T, a, b *parser.Entry) {
+	an, bn := append([]string(nil), a.FieldNames()...), append([]string(nil), b.FieldNames()...)
+	if len(an) != len(bn) {
+		t.Errorf("want fields %v, got %v", an, bn)
+	}
+	for _, name := range an {
+		want, _ := a.Field(name)
+		got, ok := b.Field(name)
+		if !ok {
+			t.Errorf("%s: missing", name)
+			continue
+		}
+		if wt, ok := want.(parser.TimeField); ok {
+			if gt, ok := got.(parser.TimeField); !ok || !gt.Equal(wt.Time) {
+				t.Errorf("%s: want %v, got %#v", name, wt, got)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %#v, got %#v", name, want, got)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "logfmt"} {
+		for _, line := range lines {
+			want := parser.ParseLine([]byte(line))
+			var buf bytes.Buffer
+			enc, err := New(format, &buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Encode(want); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			out := strings.TrimSuffix(buf.String(), "\n")
+			t.Logf("%s: %s", format, out)
+			sameFields(t, want, parser.ParseLine([]byte(out)))
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	at := time.Date(2014, 10, 27, 18, 38, 45, 5000, time.UTC)
+	for _, tt := range []struct {
+		f    parser.Field
+		want string
+	}{
+		{parser.NumberField(200), "200"},
+		{parser.NumberField(0.5), "0.5"},
+		{parser.NumberField(1e30), "1e+30"},
+		{parser.DurationField{Duration: 1500 * time.Millisecond}, "1.5s"},
+		{parser.TimeField{Time: at}, "2014-10-27T18:38:45.000005Z"},
+		{parser.BooleanField(true), "true"},
+		{parser.NilField{}, "null"},
+		{parser.RawField("plain line"), "plain line"},
+	} {
+		if got := Text(tt.f); got != tt.want {
+			t.Errorf("%#v: want %q, got %q", tt.f, tt.want, got)
+		}
+	}
+}
+
+func TestJSONSortsKeys(t *testing.T) {
+	e := parser.ParseLine([]byte(`b=1 a="x y" c=`))
+	want := `{"a":"x y","b":1,"c":null}`
+	if got := string(AppendJSON(nil, e)); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestLogfmtKeys(t *testing.T) {
+	e := parser.ParseLine([]byte(`{"a b":"1","c=d":"x"}`))
+	got := string(AppendLogfmt(nil, e))
+	for _, want := range []string{"a_b=1", "c_d=x"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in %q", want, got)
+		}
+	}
+}
+
+func TestLogfmtLeadsWithLetters(t *testing.T) {
+	e := parser.ParseLine([]byte(`{"http":{"status":503},"msg":"hi","x-id":1}`))
+	if got := string(AppendLogfmt(nil, e)); !strings.HasPrefix(got, "msg=hi ") {
+		t.Errorf("want msg first, got %q", got)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	for _, tt := range []struct {
+		format  string
+		comma   rune
+		columns []string
+		want    [][]string
+	}{
+		{"csv", ',', nil, [][]string{
+			{"time", "level", "took", "status", "ok", "msg", "path", "gone"},
+			{"2014-10-27T18:38:45.123456789-04:00", "error", "1.5s", "500", "false", "db down, retrying", "/api/v1", ""},
+			{"2014-10-27T18:38:46Z", "info", "250ms", "200", "true", `a "quoted" word`, "", ""},
+		}},
+		{"tsv", '\t', []string{"level", "req.id", "status"}, [][]string{
+			{"level", "req.id", "status"},
+			{"error", "", "500"},
+			{"info", "x=y", "200"},
+		}},
+	} {
+		var buf bytes.Buffer
+		enc, err := New(tt.format, &buf, tt.columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines[:2] {
+			if err := enc.Encode(parser.ParseLine([]byte(line))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		r := csv.NewReader(&buf)
+		r.Comma = tt.comma
+		r.FieldsPerRecord = -1
+		got, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("%s: want %q, got %q", tt.format, tt.want, got)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := New("xml", nil, nil); err == nil {
+		t.Error("want an error")
+	}
+}
@@ -0,0 +1,184 @@
+// Package extract pulls typed fields out of the text of entries, with
+// regular expressions' named groups or grok patterns like:
+//
+//	%{IP:client} %{WORD:method} %{URIPATHPARAM:path} took %{DURATION:took}
+//
+// The values are typed like those of key=value pairs are.
+package extract
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// fields whose text is matched when a rule doesn't name one
+var defaultFields = []string{parser.DefaultRaw, "msg", "message"}
+
+// Rule extracts fields out of the text of a field.
+type Rule struct {
+	// From is the field matched, or the raw line or message if empty
+	From     string
+	Pattern  string
+	re       *regexp.Regexp
+	captures map[string]capture
+}
+
+// NewRule compiles a pattern, with grok references to those of lib.
+func NewRule(from, pattern string, lib Patterns) (*Rule, error) {
+	re, captures, err := lib.compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(captures) == 0 {
+		return nil, fmt.Errorf("pattern %q captures no field", pattern)
+	}
+	return &Rule{From: from, Pattern: pattern, re: re, captures: captures}, nil
+}
+
+// ParseRule reads a rule as it's written in the query prompt and in rules
+// files: `[from=<field>] <pattern>`, the pattern being quoted or not. In
+// quotes, only `\"` is an escape, so that regular expressions read the
+// same.
+func ParseRule(text string, lib Patterns) (*Rule, error) {
+	text = strings.TrimSpace(text)
+	from := ""
+	if strings.HasPrefix(text, "from=") {
+		end := strings.IndexAny(text, " \t")
+		if end == -1 {
+			return nil, fmt.Errorf("rule %q has no pattern", text)
+		}
+		from, text = text[len("from="):end], strings.TrimSpace(text[end:])
+	}
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		text = strings.Replace(text[1:len(text)-1], `\"`, `"`, -1)
+	}
+	if text == "" {
+		return nil, fmt.Errorf("rule has no pattern")
+	}
+	return NewRule(from, text, lib)
+}
+
+// text of the field that the rule matches.
+func (r *Rule) text(e *parser.Entry) (string, bool) {
+	if r.From != "" {
+		return fieldText(e, r.From)
+	}
+	for _, name := range defaultFields {
+		if s, ok := fieldText(e, name); ok {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+func fieldText(e *parser.Entry, name string) (string, bool) {
+	switch f, _ := e.Field(name); f := f.(type) {
+	case parser.RawField:
+		return string(f), true
+	case parser.StringField:
+		return string(f), true
+	}
+	return "", false
+}
+
+// Apply the rule to the entry. If it matches, it returns a copy of the
+// entry with the captured fields set, replacing those it had. Groups that
+// capture nothing set nothing.
+func (r *Rule) Apply(e *parser.Entry) (*parser.Entry, bool) {
+	text, ok := r.text(e)
+	if !ok {
+		return e, false
+	}
+	m := r.re.FindStringSubmatchIndex(text)
+	if m == nil {
+		return e, false
+	}
+	out := e.Clone()
+	for i, group := range r.re.SubexpNames() {
+		c, ok := r.captures[group]
+		if !ok || m[2*i] == m[2*i+1] {
+			continue
+		}
+		val := text[m[2*i]:m[2*i+1]]
+		if c.asString {
+			out.Set(c.field, parser.StringField(val))
+		} else {
+			out.Set(c.field, parser.InferField([]byte(val)))
+		}
+	}
+	return out, true
+}
+
+// Rules are applied in order, each to the entry that the ones before
+// returned.
+type Rules []*Rule
+
+// Apply the rules that match.
+func (rs Rules) Apply(e *parser.Entry) *parser.Entry {
+	for _, r := range rs {
+		e, _ = r.Apply(e)
+	}
+	return e
+}
+
+// Parse is parser.ParseLine followed by the rules.
+func (rs Rules) Parse(line []byte) *parser.Entry {
+	return rs.Apply(parser.ParseLine(line))
+}
+
+// Load reads a rules file. Each line defines a grok pattern in lib, like
+// grok pattern files do, or a rule:
+//
+//	# comments and blank lines are skipped
+//	DURATION [0-9.]+(?:ms|s)
+//	extract from=msg took %{DURATION:took}
+//
+// Errors tell the line they're on.
+func Load(r io.Reader, lib Patterns) (Rules, error) {
+	var rules Rules
+	scan := bufio.NewScanner(r)
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, rest := line, ""
+		if i := strings.IndexAny(line, " \t"); i != -1 {
+			name, rest = line[:i], strings.TrimSpace(line[i:])
+		}
+		if name == "extract" {
+			rule, err := ParseRule(rest, lib)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			rules = append(rules, rule)
+			continue
+		}
+		if rest == "" {
+			return nil, fmt.Errorf("line %d: pattern %s has no definition", n, name)
+		}
+		if err := lib.Define(name, rest); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	return rules, scan.Err()
+}
+
+// LoadFile reads the rules file at path.
+func LoadFile(path string, lib Patterns) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := Load(f, lib)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,162 @@
+package extract
+
+import (
+	"github.com/aybabtme/logterm/parser"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRuleApply(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		line string
+		want map[string]parser.Field
+	}{
+		{
+			name: "grok on raw line",
+			rule: `%{IP:client} %{WORD:method} %{URIPATHPARAM:path} took %{DURATION:took}`,
+			line: `10.0.0.1 GET /users?id=3 took 120ms`,
+			want: map[string]parser.Field{
+				"client": parser.StringField("10.0.0.1"),
+				"method": parser.StringField("GET"),
+				"path":   parser.StringField("/users?id=3"),
+				"took":   parser.DurationField{Duration: 120 * time.Millisecond},
+			},
+		},
+		{
+			name: "regexp named groups",
+			rule: `status=(?P<code>\d+) (?P<slow>true|false)`,
+			line: `got status=503 true`,
+			want: map[string]parser.Field{
+				"code": parser.NumberField(503),
+				"slow": parser.BooleanField(true),
+			},
+		},
+		{
+			name: "kept as a string",
+			rule: `order %{INT:order_id:string}`,
+			line: `shipped order 00042`,
+			want: map[string]parser.Field{
+				"order_id": parser.StringField("00042"),
+			},
+		},
+		{
+			name: "from a field, with dots in names",
+			rule: `from=msg user %{USERNAME:user.name}`,
+			line: `level=info msg="user alice logged in"`,
+			want: map[string]parser.Field{
+				"level":     parser.StringField("info"),
+				"msg":       parser.StringField("user alice logged in"),
+				"user.name": parser.StringField("alice"),
+			},
+		},
+		{
+			name: "common log format",
+			rule: `%{COMMONAPACHELOG}`,
+			line: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			want: map[string]parser.Field{
+				"clientip":    parser.StringField("127.0.0.1"),
+				"ident":       parser.StringField("-"),
+				"auth":        parser.StringField("frank"),
+				"timestamp":   parser.TimeField{Time: time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))},
+				"verb":        parser.StringField("GET"),
+				"request":     parser.StringField("/apache_pb.gif"),
+				"httpversion": parser.NumberField(1),
+				"response":    parser.NumberField(200),
+				"bytes":       parser.NumberField(2326),
+			},
+		},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.rule, Default)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		e, ok := rule.Apply(parser.ParseLine([]byte(tt.line)))
+		if !ok {
+			t.Errorf("%s: didn't match", tt.name)
+			continue
+		}
+		for name, want := range tt.want {
+			got, _ := e.Field(name)
+			if wt, ok := want.(parser.TimeField); ok {
+				if gt, ok := got.(parser.TimeField); !ok || !gt.Equal(wt.Time) {
+					t.Errorf("%s: %s: want %v, got %#v", tt.name, name, want, got)
+				}
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s: want %#v, got %#v", tt.name, name, want, got)
+			}
+		}
+	}
+}
+
+func TestRuleApplyLeavesEntryAlone(t *testing.T) {
+	rule, err := ParseRule(`took (?P<took>\S+)`, Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := parser.ParseLine([]byte(`request took 3s`))
+	before := e.FieldNames()
+	if _, ok := rule.Apply(e); !ok {
+		t.Fatal("didn't match")
+	}
+	if got := e.FieldNames(); !reflect.DeepEqual(got, before) {
+		t.Errorf("entry changed, had %v, has %v", before, got)
+	}
+	if got, ok := rule.Apply(parser.ParseLine([]byte(`nothing here`))); ok || got == nil {
+		t.Errorf("want the entry back unmatched, got %v, %v", got, ok)
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, text := range []string{
+		``,
+		`from=msg`,
+		`%{NOPE:x}`,
+		`no captures`,
+		`(?P<x>unclosed`,
+	} {
+		if _, err := ParseRule(text, Default); err == nil {
+			t.Errorf("%q: want an error", text)
+		}
+	}
+	lib := Patterns{"A": `%{B}`, "B": `%{A}`}
+	if _, err := ParseRule(`%{A:a}`, lib); err == nil {
+		t.Error("want an error for cyclic patterns")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	lib := Patterns{"WORD": Default["WORD"]}
+	rules, err := Load(strings.NewReader(`
+# custom patterns come first
+QUEUE q-\d+
+
+extract from=msg job %{WORD:job} on %{QUEUE:queue}
+extract "attempt (?P<attempt>\d+)"
+`), lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := rules.Parse([]byte(`level=warn msg="job resize on q-7, attempt 3"`))
+	for name, want := range map[string]parser.Field{
+		"job":     parser.StringField("resize"),
+		"queue":   parser.StringField("q-7"),
+		"attempt": parser.NumberField(3),
+	} {
+		if got, _ := e.Field(name); got != want {
+			t.Errorf("%s: want %#v, got %#v", name, want, got)
+		}
+	}
+
+	_, err = Load(strings.NewReader("A x\n\nextract %{NOPE:y}\n"), Patterns{})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("want an error on line 3, got %v", err)
+	}
+}
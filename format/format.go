@@ -0,0 +1,371 @@
+// Package format renders entries with text/template, like:
+//
+//	{{date "15:04:05" .Time}} {{level .Level}} {{.Message}}{{range .Fields}} {{.Key}}={{.Value}}{{end}}
+//
+// The dot is an Entry, and templates have helpers to show fields, colors,
+// levels and the like. Builtin templates are found by name.
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/logterm/columns"
+	"github.com/aybabtme/logterm/encode"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/timefmt"
+	"github.com/dustin/go-humanize"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Builtin templates, by name.
+var Builtin = map[string]string{
+	"short": `{{if not .Structured}}{{.Line}}{{else}}` +
+		`{{date "15:04:05" .Time}} {{level .Level}} {{.Message}}{{end}}`,
+	"long": `{{if not .Structured}}{{.Line}}{{else}}` +
+		`{{date "2006-01-02T15:04:05.000Z07:00" .Time}} {{level .Level}} {{.Message}}` +
+		`{{range .Fields}} {{.Key}}={{.Value}}{{end}}{{end}}`,
+	"humanlog": `{{if not .Structured}}{{.Line}}{{else}}` +
+		`{{date "Jan _2 15:04:05" .Time}} |{{level .Level}}| {{pad 40 .Message}}` +
+		`{{range .Fields}} {{color "green" .Key}}={{color "white" .Value}}{{end}}{{end}}`,
+	"json": `{{json}}`,
+}
+
+// Names of the builtin templates.
+func Names() []string {
+	var names []string
+	for name := range Builtin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	levelFields   = []string{"level", "lvl", "severity", "loglevel"}
+	messageFields = []string{"msg", "message"}
+)
+
+// Entry is what templates are run against.
+type Entry struct {
+	// Line as it was read
+	Line string
+	// Structured tells if the line has fields, or only its raw text
+	Structured bool
+	// Time of the entry, zero if it has none
+	Time    time.Time
+	Level   string
+	Message string
+	// Fields other than those of the time, level and message, in order
+	Fields []Pair
+}
+
+// Pair of a field, with the text of its value.
+type Pair struct {
+	Key, Value string
+}
+
+func newEntry(line []byte, e *parser.Entry) *Entry {
+	d := &Entry{Line: string(line)}
+	skip := make(map[string]bool)
+	if at, ok := e.Time(); ok {
+		d.Time = at
+		first(e, parser.TimeFields, skip)
+	}
+	d.Level = first(e, levelFields, skip)
+	d.Message = first(e, messageFields, skip)
+	for _, name := range e.FieldNames() {
+		if name == parser.DefaultRaw {
+			continue
+		}
+		d.Structured = true
+		if skip[name] {
+			continue
+		}
+		f, _ := e.Field(name)
+		d.Fields = append(d.Fields, Pair{Key: name, Value: columns.Text(f)})
+	}
+	if d.Message == "" && !d.Structured {
+		d.Message = d.Line
+	}
+	return d
+}
+
+// first is the text of the first of fields that e has, that's then
+// skipped.
+func first(e *parser.Entry, fields []string, skip map[string]bool) string {
+	for _, name := range fields {
+		if f, ok := e.Field(name); ok {
+			skip[name] = true
+			return columns.Text(f)
+		}
+	}
+	return ""
+}
+
+// Template renders entries. It's safe for concurrent use.
+type Template struct {
+	tmpl   *template.Template
+	colors bool
+	// colors of levels, by their short name, in place of the usual ones
+	levelColors map[string]string
+
+	mu    sync.Mutex
+	times timefmt.Display
+	// the entry being rendered, for the helpers that read it, and the
+	// time of the one before
+	cur  *parser.Entry
+	prev time.Time
+	buf  bytes.Buffer
+}
+
+// New template of text, or the builtin template of that name. With
+// colors, the color and level helpers write escape sequences.
+func New(text string, colors bool) (*Template, error) {
+	if builtin, ok := Builtin[text]; ok {
+		text = builtin
+	}
+	t := &Template{colors: colors}
+	tmpl, err := template.New("format").Funcs(t.funcs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+// SetTimes makes the date helper show times with the display: in its
+// zone, or relative to now or to the entry before.
+func (t *Template) SetTimes(d timefmt.Display) {
+	t.mu.Lock()
+	t.times = d
+	t.mu.Unlock()
+}
+
+// SetLevelColor shows a level, by any of its names like `error` or
+// `err`, in a color in place of its usual one.
+func (t *Template) SetLevelColor(level, color string) error {
+	if err := checkColor(color); err != nil {
+		return err
+	}
+	short, ok := levelShort(level)
+	if !ok {
+		return fmt.Errorf("unknown level %q", level)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.levelColors == nil {
+		t.levelColors = make(map[string]string)
+	}
+	t.levelColors[short] = color
+	return nil
+}
+
+// Execute writes the entry parsed from line, followed by a newline.
+func (t *Template) Execute(w io.Writer, line []byte, e *parser.Entry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cur = e
+	t.buf.Reset()
+	err := t.tmpl.Execute(&t.buf, newEntry(line, e))
+	if at, ok := e.Time(); ok {
+		t.prev = at
+	}
+	if err != nil {
+		return err
+	}
+	t.buf.WriteByte('\n')
+	_, err = w.Write(t.buf.Bytes())
+	return err
+}
+
+func (t *Template) funcs() template.FuncMap {
+	return template.FuncMap{
+		// field "name" is the text of a field of the entry
+		"field": func(name string) string {
+			f, _ := t.cur.Field(name)
+			return columns.Text(f)
+		},
+		"color": t.color,
+		"level": t.level,
+		// date formats a time with the layout, unless times are shown
+		// relative to others
+		"date": func(layout string, at time.Time) string {
+			if at.IsZero() {
+				return ""
+			}
+			return t.times.Format(at, t.prev, layout)
+		},
+		"humanizeTime": func(v interface{}) (string, error) {
+			at, err := toTime(v)
+			if err != nil || at.IsZero() {
+				return "", err
+			}
+			return humanize.Time(at), nil
+		},
+		"humanizeDuration": func(v interface{}) (string, error) {
+			if v == "" {
+				// a missing field
+				return "", nil
+			}
+			d, err := toDuration(v)
+			if err != nil {
+				return "", err
+			}
+			return timefmt.Duration(d), nil
+		},
+		"truncate": truncate,
+		"pad":      pad,
+		// json is the entry as JSON, or json x is x as JSON
+		"json": func(v ...interface{}) (string, error) {
+			if len(v) == 0 {
+				return string(encode.AppendJSON(nil, t.cur)), nil
+			}
+			b, err := json.Marshal(v[0])
+			return string(b), err
+		},
+	}
+}
+
+var colors = map[string]string{
+	"black":   "30",
+	"red":     "31",
+	"green":   "32",
+	"yellow":  "33",
+	"blue":    "34",
+	"magenta": "35",
+	"cyan":    "36",
+	"white":   "37",
+	"gray":    "90",
+	"bold":    "1",
+	"faint":   "2",
+}
+
+// checkColor tells if name is a color that templates know, like "red" or
+// "bold red".
+func checkColor(name string) error {
+	for _, n := range strings.Fields(name) {
+		if _, ok := colors[n]; !ok {
+			return fmt.Errorf("unknown color %q", n)
+		}
+	}
+	return nil
+}
+
+// color writes s in a color, like "red" or "bold red".
+func (t *Template) color(name, s string) (string, error) {
+	var codes []string
+	for _, n := range strings.Fields(name) {
+		code, ok := colors[n]
+		if !ok {
+			return "", fmt.Errorf("unknown color %q", n)
+		}
+		codes = append(codes, code)
+	}
+	if !t.colors || len(codes) == 0 || s == "" {
+		return s, nil
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m" + s + "\x1b[0m", nil
+}
+
+var levels = []struct {
+	names []string
+	short string
+	color string
+}{
+	{[]string{"trace"}, "TRAC", "gray"},
+	{[]string{"debug", "dbug", "debu"}, "DEBU", "gray"},
+	{[]string{"info", "information", "notice"}, "INFO", "cyan"},
+	{[]string{"warn", "warning"}, "WARN", "yellow"},
+	{[]string{"error", "err", "erro"}, "ERRO", "red"},
+	{[]string{"fatal", "critical", "crit", "alert", "emerg", "panic"}, "FATA", "bold red"},
+}
+
+// levelShort is the 4 letters name of a level.
+func levelShort(s string) (string, bool) {
+	lower := strings.ToLower(s)
+	for _, l := range levels {
+		for _, name := range l.names {
+			if lower == name {
+				return l.short, true
+			}
+		}
+	}
+	return "", false
+}
+
+// level shortens a level to 4 letters, in the color of its severity.
+func (t *Template) level(s string) string {
+	short, ok := levelShort(s)
+	if !ok {
+		return pad(4, truncate(4, strings.ToUpper(s)))
+	}
+	color, ok := t.levelColors[short]
+	if !ok {
+		for _, l := range levels {
+			if l.short == short {
+				color = l.color
+			}
+		}
+	}
+	c, _ := t.color(color, short)
+	return c
+}
+
+// truncate s to n runes, marking the cut with an ellipsis.
+func truncate(n int, s string) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+// pad s with spaces to n runes, on the left when n is negative.
+func pad(n int, s string) string {
+	left := n < 0
+	if left {
+		n = -n
+	}
+	missing := n - utf8.RuneCountInString(s)
+	if missing <= 0 {
+		return s
+	}
+	if left {
+		return strings.Repeat(" ", missing) + s
+	}
+	return s + strings.Repeat(" ", missing)
+}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case parser.TimeField:
+		return v.Time, nil
+	case string:
+		if v == "" {
+			return time.Time{}, nil
+		}
+		return parser.ParseTime(v)
+	}
+	return time.Time{}, fmt.Errorf("not a time: %v", v)
+}
+
+func toDuration(v interface{}) (time.Duration, error) {
+	switch v := v.(type) {
+	case time.Duration:
+		return v, nil
+	case parser.DurationField:
+		return v.Duration, nil
+	case string:
+		return time.ParseDuration(v)
+	}
+	return 0, fmt.Errorf("not a duration: %v", v)
+}
@@ -0,0 +1,142 @@
+package format
+
+import (
+	"bytes"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/timefmt"
+	"testing"
+)
+
+var lines = []string{
+	`time=2014-10-27T18:38:45Z level=error msg="db down" service=api latency=1.5s`,
+	`{"time":"2014-10-27T18:38:46Z","lvl":"info","message":"ok"}`,
+	`a plain line`,
+}
+
+func render(t *testing.T, text string, colors bool) []string {
+	tmpl, err := New(text, colors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, line := range lines {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, []byte(line), parser.ParseLine([]byte(line))); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		out = append(out, buf.String())
+	}
+	return out
+}
+
+func TestBuiltin(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want []string
+	}{
+		{"short", []string{
+			"18:38:45 ERRO db down\n",
+			"18:38:46 INFO ok\n",
+			"a plain line\n",
+		}},
+		{"long", []string{
+			"2014-10-27T18:38:45.000Z ERRO db down service=api latency=1.5s\n",
+			"2014-10-27T18:38:46.000Z INFO ok\n",
+			"a plain line\n",
+		}},
+		{"humanlog", []string{
+			"Oct 27 18:38:45 |ERRO| db down                                  service=api latency=1.5s\n",
+			"Oct 27 18:38:46 |INFO| ok                                      \n",
+			"a plain line\n",
+		}},
+		{"json", []string{
+			`{"latency":"1.5s","level":"error","msg":"db down","service":"api","time":"2014-10-27T18:38:45Z"}` + "\n",
+			`{"lvl":"info","message":"ok","time":"2014-10-27T18:38:46Z"}` + "\n",
+			`{"raw":"a plain line"}` + "\n",
+		}},
+	} {
+		got := render(t, tt.name, false)
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s, line %d: want %q, got %q", tt.name, i, tt.want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	for _, tt := range []struct {
+		text   string
+		colors bool
+		want   string
+	}{
+		{`{{field "service"}}|{{field "nope"}}`, false, "api|"},
+		{`{{color "bold red" .Level}}`, true, "\x1b[1;31merror\x1b[0m"},
+		{`{{color "bold red" .Level}}`, false, "error"},
+		{`{{level .Level}}`, true, "\x1b[31mERRO\x1b[0m"},
+		{`[{{pad 8 .Message}}][{{pad -8 .Message}}]`, false, "[db down ][ db down]"},
+		{`{{truncate 4 .Message}}`, false, "db …"},
+		{`{{humanizeDuration (field "latency")}}`, false, "1.5s"},
+		{`{{json .Message}}`, false, `"db down"`},
+	} {
+		got := render(t, tt.text, tt.colors)[0]
+		if want := tt.want + "\n"; got != want {
+			t.Errorf("%s: want %q, got %q", tt.text, want, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(`{{nope}}`, false); err == nil {
+		t.Error("want an error for an unknown function")
+	}
+	tmpl, err := New(`{{color "plaid" .Message}}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, []byte("msg=x"), parser.ParseLine([]byte("msg=x"))); err == nil {
+		t.Error("want an error for an unknown color")
+	}
+}
+
+func TestTimes(t *testing.T) {
+	tmpl, err := New("short", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SetTimes(timefmt.Display{Mode: timefmt.Delta})
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if err := tmpl.Execute(&buf, []byte(line), parser.ParseLine([]byte(line))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "+0s ERRO db down\n+1s INFO ok\na plain line\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestLevelColor(t *testing.T) {
+	tmpl, err := New(`{{level .Level}}`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.SetLevelColor("err", "magenta"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	line := []byte(lines[0])
+	if err := tmpl.Execute(&buf, line, parser.ParseLine(line)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x1b[35mERRO\x1b[0m\n"; buf.String() != want {
+		t.Errorf("want %q, got %q", want, buf.String())
+	}
+	if err := tmpl.SetLevelColor("error", "plaid"); err == nil {
+		t.Error("want an error for an unknown color")
+	}
+	if err := tmpl.SetLevelColor("loud", "red"); err == nil {
+		t.Error("want an error for an unknown level")
+	}
+}
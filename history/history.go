@@ -0,0 +1,312 @@
+// Package history keeps the lines of a session on disk, in segments that
+// are dropped as they get too old or too big, so that hours of logs can
+// be scrolled back to without holding them in memory.
+package history
+
+import (
+	"errors"
+	"github.com/aybabtme/logterm/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrEvicted is returned for lines that retention dropped.
+	ErrEvicted = errors.New("history: line was dropped by retention")
+	// ErrNotWritten is returned for lines past the last one.
+	ErrNotWritten = errors.New("history: line was not written yet")
+)
+
+// Options of a Store. The zero value of a field is replaced by its
+// default.
+type Options struct {
+	// SegmentSize is how many bytes a segment can have before a new
+	// one is started.
+	SegmentSize int64
+	// MaxSize is how many bytes to retain, 0 to not limit by size.
+	MaxSize int64
+	// MaxAge is how long to retain lines, 0 to not limit by age.
+	MaxAge time.Duration
+	// IndexEvery is how many lines are between two entries of the sparse
+	// index of a segment.
+	IndexEvery int
+}
+
+const (
+	DefaultSegmentSize = 16 << 20
+	DefaultIndexEvery  = 256
+)
+
+// Store appends lines to segments on disk, and reads them back by line
+// number. It's safe for concurrent use.
+type Store struct {
+	mu   sync.Mutex
+	dir  string
+	opts Options
+	now  func() time.Time
+
+	segs []*segment // oldest first, the last one is active
+	size int64
+}
+
+// Open the store in dir, creating it if needed. Lines written to dir by
+// a previous store are kept.
+func Open(dir string, opts Options) (*Store, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.IndexEvery <= 0 {
+		opts.IndexEvery = DefaultIndexEvery
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, opts: opts, now: time.Now}
+
+	firsts, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, first := range firsts {
+		seg, err := openSegment(dir, first, i < len(firsts)-1)
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+		s.segs = append(s.segs, seg)
+		s.size += seg.size
+	}
+
+	if len(s.segs) == 0 {
+		seg, err := createSegment(dir, 0)
+		if err != nil {
+			return nil, err
+		}
+		s.segs = append(s.segs, seg)
+	}
+	if err := s.retain(); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var firsts []uint64
+	for _, fi := range fis {
+		name := fi.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		firsts = append(firsts, first)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+	return firsts, nil
+}
+
+// Dir where the segments are.
+func (s *Store) Dir() string { return s.dir }
+
+// Append a line and the metadata of its entry. If the entry is nil, the
+// line is parsed on its own. It returns the number of the line.
+func (s *Store) Append(line []byte, e *parser.Entry) (uint64, error) {
+	if e == nil {
+		e = parser.ParseLine(line)
+	}
+	meta := Meta{Added: s.now()}
+	if t, ok := e.Time(); ok {
+		meta.Time = t
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := s.active()
+	if active.size >= s.opts.SegmentSize {
+		var err error
+		if active, err = s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n := active.first + active.count
+	before := active.size
+	if err := active.append(line, e, meta, s.opts.IndexEvery); err != nil {
+		return 0, err
+	}
+	s.size += active.size - before
+	return n, nil
+}
+
+func (s *Store) active() *segment { return s.segs[len(s.segs)-1] }
+
+// rotate seals the active segment, starts a new one and applies
+// retention to the sealed ones.
+func (s *Store) rotate() (*segment, error) {
+	old := s.active()
+	if err := old.seal(); err != nil {
+		return nil, err
+	}
+	seg, err := createSegment(s.dir, old.first+old.count)
+	if err != nil {
+		return nil, err
+	}
+	s.segs = append(s.segs, seg)
+	return seg, s.retain()
+}
+
+// retain drops the oldest sealed segments while they are over the size
+// or age limits. It's only checked when a segment is sealed, so the
+// active segment can go past the limits until it's full.
+func (s *Store) retain() error {
+	cutoff := s.now().Add(-s.opts.MaxAge)
+	for len(s.segs) > 1 {
+		oldest := s.segs[0]
+		tooBig := s.opts.MaxSize > 0 && s.size > s.opts.MaxSize
+		tooOld := s.opts.MaxAge > 0 && oldest.added.Before(cutoff)
+		if !tooBig && !tooOld {
+			return nil
+		}
+		s.size -= oldest.size
+		s.segs = s.segs[1:]
+		if err := oldest.remove(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// First is the number of the oldest line that is retained.
+func (s *Store) First() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.segs[0].first
+}
+
+// Next is the number that the next appended line will get.
+func (s *Store) Next() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := s.active()
+	return active.first + active.count
+}
+
+// Size is how many bytes the retained segments take.
+func (s *Store) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Line returns the line numbered n and the metadata of its entry.
+func (s *Store) Line(n uint64) ([]byte, Meta, error) {
+	var (
+		line  []byte
+		meta  Meta
+		found bool
+	)
+	err := s.Walk(n, func(_ uint64, l []byte, m Meta) bool {
+		line, meta, found = l, m, true
+		return false
+	})
+	if err == nil && !found {
+		err = ErrNotWritten
+	}
+	return line, meta, err
+}
+
+// Walk the lines starting at the one numbered `from`, until fn returns
+// false or there are no more lines. fn can't use the store.
+func (s *Store) Walk(from uint64, fn func(n uint64, line []byte, meta Meta) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if from < s.segs[0].first {
+		return ErrEvicted
+	}
+	i := sort.Search(len(s.segs), func(i int) bool {
+		seg := s.segs[i]
+		return from < seg.first+seg.count
+	})
+	for ; i < len(s.segs); i++ {
+		more, err := s.segs[i].walk(from, fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close the store. The segments stay on disk.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, seg := range s.segs {
+		if cerr := seg.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Remove the store and all of its segments.
+func (s *Store) Remove() error {
+	err := s.Close()
+	if rerr := os.RemoveAll(filepath.Clean(s.dir)); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// SeekTime finds the first retained line whose entry is at or after t,
+// assuming that entries are sorted by time. It does a binary search over
+// the line numbers, so it reads O(log n) lines. Lines whose entry has no
+// timestamp go with the entry before them. If all lines are before t, it
+// returns Next().
+func (s *Store) SeekTime(t time.Time) (uint64, error) {
+	lo, hi := s.First(), s.Next()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		n, lineTime, found, err := s.nextTimedLine(mid, hi)
+		if err != nil {
+			return 0, err
+		}
+		if found && lineTime.Before(t) {
+			lo = n + 1
+		} else {
+			hi = mid
+		}
+	}
+	n, _, found, err := s.nextTimedLine(lo, s.Next())
+	if err != nil || !found {
+		return s.Next(), err
+	}
+	return n, nil
+}
+
+// nextTimedLine finds the first line in [from, to) that has a timestamp.
+func (s *Store) nextTimedLine(from, to uint64) (n uint64, t time.Time, found bool, err error) {
+	err = s.Walk(from, func(line uint64, _ []byte, meta Meta) bool {
+		if line >= to {
+			return false
+		}
+		if !meta.Time.IsZero() {
+			n, t, found = line, meta.Time, true
+			return false
+		}
+		return true
+	})
+	return n, t, found, err
+}
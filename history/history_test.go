@@ -0,0 +1,202 @@
+package history
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func tempStore(t *testing.T, opts Options) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "history_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { _ = s.Remove() }
+}
+
+func appendLines(t *testing.T, s *Store, from, to int) {
+	for i := from; i < to; i++ {
+		line := []byte(fmt.Sprintf("line=%d", i))
+		n, err := s.Append(line, parser.ParseLine(line))
+		if err != nil {
+			t.Fatalf("appending line %d: %v", i, err)
+		}
+		if n != uint64(i) {
+			t.Fatalf("want line number %d, got %d", i, n)
+		}
+	}
+}
+
+func checkLine(t *testing.T, s *Store, n uint64) {
+	line, _, err := s.Line(n)
+	if err != nil {
+		t.Fatalf("reading line %d: %v", n, err)
+	}
+	if want := fmt.Sprintf("line=%d", n); string(line) != want {
+		t.Fatalf("line %d: want %q, got %q", n, want, line)
+	}
+}
+
+func TestRandomAccessAcrossSegments(t *testing.T) {
+	s, done := tempStore(t, Options{SegmentSize: 256, IndexEvery: 3})
+	defer done()
+
+	appendLines(t, s, 0, 1000)
+	if len(s.segs) < 10 {
+		t.Fatalf("want many segments, got %d", len(s.segs))
+	}
+
+	for _, n := range []uint64{0, 1, 2, 3, 500, 998, 999, 17, 333} {
+		checkLine(t, s, n)
+	}
+	if _, _, err := s.Line(1000); err != ErrNotWritten {
+		t.Fatalf("want %v, got %v", ErrNotWritten, err)
+	}
+
+	var got []uint64
+	err := s.Walk(995, func(n uint64, line []byte, _ Meta) bool {
+		got = append(got, n)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[995 996 997 998 999]" {
+		t.Fatalf("walked the wrong lines: %v", got)
+	}
+}
+
+func TestKeepsEntryTime(t *testing.T) {
+	s, done := tempStore(t, Options{})
+	defer done()
+
+	line := []byte(`{"time":"2014-10-27T18:38:45Z","msg":"hello"}`)
+	n, err := s.Append(line, parser.ParseLine(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, meta, err := s.Line(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC); !meta.Time.Equal(want) {
+		t.Fatalf("want time %v, got %v", want, meta.Time)
+	}
+	if meta.Added.IsZero() {
+		t.Fatal("want an added time")
+	}
+}
+
+func TestReopen(t *testing.T) {
+	s, done := tempStore(t, Options{SegmentSize: 256, IndexEvery: 4})
+	defer done()
+
+	appendLines(t, s, 0, 100)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of a write
+	active := segmentName(s.dir, s.segs[len(s.segs)-1].first, segmentExt)
+	f, err := os.OpenFile(active, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 42, 1, 2})
+	_ = f.Close()
+
+	s, err = Open(s.dir, Options{SegmentSize: 256, IndexEvery: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Next() != 100 {
+		t.Fatalf("want 100 lines after reopening, got %d", s.Next())
+	}
+	appendLines(t, s, 100, 200)
+	for n := uint64(0); n < 200; n++ {
+		checkLine(t, s, n)
+	}
+	_ = s.Close()
+}
+
+func TestRetainBySize(t *testing.T) {
+	s, done := tempStore(t, Options{SegmentSize: 256, MaxSize: 1024})
+	defer done()
+
+	appendLines(t, s, 0, 1000)
+	if size := s.Size(); size > int64(1024+256+headerSize+len("line=999")) {
+		t.Fatalf("retained too many bytes: %d", size)
+	}
+	first := s.First()
+	if first == 0 {
+		t.Fatal("should have dropped the oldest lines")
+	}
+	if _, _, err := s.Line(first - 1); err != ErrEvicted {
+		t.Fatalf("want %v, got %v", ErrEvicted, err)
+	}
+	for n := first; n < 1000; n++ {
+		checkLine(t, s, n)
+	}
+}
+
+func TestRetainByAge(t *testing.T) {
+	s, done := tempStore(t, Options{SegmentSize: 256, MaxAge: time.Hour})
+	defer done()
+
+	now := time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	appendLines(t, s, 0, 100)
+
+	now = now.Add(2 * time.Hour)
+	appendLines(t, s, 100, 200)
+
+	first := s.First()
+	if first == 0 || first > 100 {
+		t.Fatalf("should have dropped the lines of 2h ago, first is %d", first)
+	}
+	checkLine(t, s, 150)
+}
+
+func TestSeekTime(t *testing.T) {
+	s, done := tempStore(t, Options{SegmentSize: 1024, IndexEvery: 8})
+	defer done()
+
+	start := time.Date(2014, 10, 27, 18, 0, 0, 0, time.UTC)
+	for i := 0; i < 1000; i++ {
+		line := []byte(fmt.Sprintf("time=%s n=%d", start.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i))
+		if i%10 == 9 {
+			line = []byte("no time here")
+		}
+		if _, err := s.Append(line, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		at   time.Time
+		want uint64
+	}{
+		{at: start.Add(-time.Hour), want: 0},
+		{at: start.Add(500 * time.Second), want: 500},
+		{at: start.Add(500*time.Second + 1), want: 501},
+		// line 19 has no time, it goes with line 18
+		{at: start.Add(19 * time.Second), want: 20},
+		{at: start.Add(time.Hour), want: 1000},
+	}
+	for _, tt := range tests {
+		got, err := s.SeekTime(tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("seeking %v: want line %d, got %d", tt.at, tt.want, got)
+		}
+	}
+}
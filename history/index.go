@@ -0,0 +1,408 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/aybabtme/logterm/parser"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	termsExt = ".tok"
+	// the terms file of a segment keeps one term out of this many in
+	// memory, the others are found by scanning from the closest one
+	sparseTermsEvery = 64
+	// the separator between a field name and a value token in a term.
+	// full-text terms have no field name.
+	termSep = "\x00"
+)
+
+// tokenize splits text in lowercase words made of letters and digits.
+// Dashes, underscores and dots inside a word don't split it, so that
+// identifiers like `req-42` or `10.0.0.1` stay whole.
+func tokenize(text string, fn func(tok string)) {
+	start := -1
+	emit := func(end int) {
+		if tok := strings.Trim(text[start:end], joiners); tok != "" {
+			fn(strings.ToLower(tok))
+		}
+		start = -1
+	}
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(joiners, r)
+		switch {
+		case isWord && start == -1:
+			start = i
+		case !isWord && start != -1:
+			emit(i)
+		}
+	}
+	if start != -1 {
+		emit(len(text))
+	}
+}
+
+const joiners = "-_."
+
+func fieldTerm(name, tok string) string { return name + termSep + tok }
+
+func textTerm(tok string) string { return termSep + tok }
+
+// entryTerms calls fn with every term of an entry: its field names with
+// each token of their value, and the full-text tokens of its message and
+// raw content.
+func entryTerms(e *parser.Entry, fn func(term string)) {
+	for _, name := range e.FieldNames() {
+		f, _ := e.Field(name)
+		text, ok := fieldText(f)
+		if !ok {
+			continue
+		}
+		tokenize(text, func(tok string) { fn(fieldTerm(name, tok)) })
+		if name == "msg" || name == "message" || name == parser.DefaultRaw {
+			tokenize(text, func(tok string) { fn(textTerm(tok)) })
+		}
+	}
+}
+
+// fieldText is the text of the field values that are worth indexing.
+// Timestamps are left out, nobody looks for them by token.
+func fieldText(f parser.Field) (string, bool) {
+	switch f := f.(type) {
+	case parser.StringField:
+		return string(f), true
+	case parser.RawField:
+		return string(f), true
+	case parser.NumberField:
+		return strconv.FormatFloat(float64(f), 'g', -1, 64), true
+	case parser.BooleanField:
+		return strconv.FormatBool(bool(f)), true
+	case parser.DurationField:
+		return f.Duration.String(), true
+	}
+	return "", false
+}
+
+// memTerms are the terms of the active segment, in memory until it's
+// sealed.
+type memTerms map[string][]uint64
+
+func (m memTerms) add(n uint64, e *parser.Entry) {
+	entryTerms(e, func(term string) {
+		lines := m[term]
+		// a term can appear many times in an entry
+		if len(lines) == 0 || lines[len(lines)-1] != n {
+			m[term] = append(lines, n)
+		}
+	})
+}
+
+func (m memTerms) lookup(term string) ([]uint64, error) { return m[term], nil }
+
+// write the terms in order, each followed by the deltas of the lines
+// that have it, then a footer of every `sparseTermsEvery` terms and
+// where they are in the file. The file ends with the offset of the
+// footer.
+func (m memTerms) write(w io.Writer) error {
+	terms := make([]string, 0, len(m))
+	for term := range m {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	bw := bufio.NewWriter(w)
+	var (
+		off    int64
+		footer bytes.Buffer
+		buf    [binary.MaxVarintLen64]byte
+	)
+	put := func(w io.Writer, v uint64) int64 {
+		n := binary.PutUvarint(buf[:], v)
+		_, _ = w.Write(buf[:n])
+		return int64(n)
+	}
+	for i, term := range terms {
+		if i%sparseTermsEvery == 0 {
+			put(&footer, uint64(len(term)))
+			footer.WriteString(term)
+			put(&footer, uint64(off))
+		}
+		off += put(bw, uint64(len(term)))
+		n, _ := bw.WriteString(term)
+		off += int64(n)
+		lines := m[term]
+		off += put(bw, uint64(len(lines)))
+		var prev uint64
+		for _, line := range lines {
+			off += put(bw, line-prev)
+			prev = line
+		}
+	}
+	if _, err := bw.Write(footer.Bytes()); err != nil {
+		return err
+	}
+	var end [8]byte
+	binary.BigEndian.PutUint64(end[:], uint64(off))
+	if _, err := bw.Write(end[:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+type sparseTerm struct {
+	term string
+	off  int64
+}
+
+// diskTerms are the terms of a sealed segment. Only a sparse sample of
+// them is kept in memory.
+type diskTerms struct {
+	f      *os.File
+	end    int64 // where the footer starts
+	sparse []sparseTerm
+}
+
+var errBadTerms = errors.New("history: corrupted terms file")
+
+func openDiskTerms(name string) (*diskTerms, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := loadDiskTerms(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func loadDiskTerms(f *os.File) (*diskTerms, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < 8 {
+		return nil, errBadTerms
+	}
+	var end [8]byte
+	if _, err := f.ReadAt(end[:], fi.Size()-8); err != nil {
+		return nil, err
+	}
+	footerOff := int64(binary.BigEndian.Uint64(end[:]))
+	if footerOff > fi.Size()-8 {
+		return nil, errBadTerms
+	}
+	d := &diskTerms{f: f, end: footerOff}
+	rd := bufio.NewReader(io.NewSectionReader(f, footerOff, fi.Size()-8-footerOff))
+	for {
+		term, err := readTerm(rd)
+		if err == io.EOF {
+			return d, nil
+		} else if err != nil {
+			return nil, err
+		}
+		off, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, errBadTerms
+		}
+		d.sparse = append(d.sparse, sparseTerm{term: term, off: int64(off)})
+	}
+}
+
+func readTerm(rd *bufio.Reader) (string, error) {
+	l, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return "", err
+	}
+	term := make([]byte, l)
+	if _, err := io.ReadFull(rd, term); err != nil {
+		return "", errBadTerms
+	}
+	return string(term), nil
+}
+
+func (d *diskTerms) lookup(term string) ([]uint64, error) {
+	i := sort.Search(len(d.sparse), func(i int) bool { return d.sparse[i].term > term })
+	if i == 0 {
+		return nil, nil
+	}
+	off := d.sparse[i-1].off
+	rd := bufio.NewReader(io.NewSectionReader(d.f, off, d.end-off))
+	for {
+		got, err := readTerm(rd)
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		count, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, errBadTerms
+		}
+		if got > term {
+			return nil, nil
+		}
+		var (
+			lines []uint64
+			line  uint64
+		)
+		for j := uint64(0); j < count; j++ {
+			delta, err := binary.ReadUvarint(rd)
+			if err != nil {
+				return nil, errBadTerms
+			}
+			if got == term {
+				line += delta
+				lines = append(lines, line)
+			}
+		}
+		if got == term {
+			return lines, nil
+		}
+	}
+}
+
+func (d *diskTerms) close() error { return d.f.Close() }
+
+type terms interface {
+	lookup(term string) ([]uint64, error)
+}
+
+// intersect lists of ascending line numbers.
+func intersect(a, b []uint64) []uint64 {
+	var out []uint64
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// Match is what Search looks for in the entries: fields that have a value,
+// or words in their message. Both are compared token by token, case
+// insensitively.
+type Match struct {
+	Field string // empty to match the words of the message
+	Value string
+}
+
+// ParseMatches reads a search like `request_id=abc some words` in a list
+// of matches.
+func ParseMatches(search string) []Match {
+	var matches []Match
+	for _, word := range strings.Fields(search) {
+		if i := strings.IndexByte(word, '='); i > 0 {
+			matches = append(matches, Match{Field: word[:i], Value: word[i+1:]})
+		} else {
+			matches = append(matches, Match{Value: word})
+		}
+	}
+	return matches
+}
+
+func (m Match) terms() []string {
+	var terms []string
+	tokenize(m.Value, func(tok string) {
+		if m.Field == "" {
+			terms = append(terms, textTerm(tok))
+		} else {
+			terms = append(terms, fieldTerm(m.Field, tok))
+		}
+	})
+	return terms
+}
+
+// Search the retained lines for those whose entries have all of the
+// matches. The line numbers are in ascending order.
+func (s *Store) Search(matches ...Match) ([]uint64, error) {
+	var all []string
+	for _, m := range matches {
+		all = append(all, m.terms()...)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+
+	// the sealed segments are read from disk without holding the lock,
+	// only the terms of the active one, that lines are appended to, are
+	// looked up with it
+	s.mu.Lock()
+	sealed := make([]segmentTerms, 0, len(s.segs)-1)
+	for _, seg := range s.segs[:len(s.segs)-1] {
+		sealed = append(sealed, segmentTerms{first: seg.first, terms: seg.terms})
+	}
+	active, err := lookupAll(s.active().terms, all)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []uint64
+	for _, seg := range sealed {
+		lines, err := lookupAll(seg.terms, all)
+		if err != nil {
+			if seg.first < s.First() {
+				// evicted while it was read, its lines are gone
+				continue
+			}
+			return nil, err
+		}
+		found = append(found, lines...)
+	}
+	return append(found, active...), nil
+}
+
+// segmentTerms are the terms of a segment, as they were when Search
+// started.
+type segmentTerms struct {
+	first uint64
+	terms terms
+}
+
+// lookupAll the lines that have every term.
+func lookupAll(t terms, all []string) ([]uint64, error) {
+	var lines []uint64
+	for i, term := range all {
+		got, err := t.lookup(term)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			// the terms of the active segment keep growing
+			lines = append([]uint64(nil), got...)
+		} else {
+			lines = intersect(lines, got)
+		}
+		if len(lines) == 0 {
+			break
+		}
+	}
+	return lines, nil
+}
+
+// indexSegment builds the terms of a segment from its lines, for
+// segments whose terms were lost or never written.
+func indexSegment(seg *segment) (memTerms, error) {
+	mem := make(memTerms)
+	_, err := seg.walk(seg.first, func(n uint64, line []byte, _ Meta) bool {
+		mem.add(n, parser.ParseLine(line))
+		return true
+	})
+	return mem, err
+}
@@ -0,0 +1,141 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func appendRequests(t testing.TB, s *Store, from, to int) {
+	for i := from; i < to; i++ {
+		var line string
+		switch i % 3 {
+		case 0:
+			line = fmt.Sprintf(`{"level":"info","request_id":"req-%d","msg":"served request","status":200}`, i/3)
+		case 1:
+			line = fmt.Sprintf(`level=error request_id=req-%d msg="Connection refused" status=502`, i/3)
+		case 2:
+			line = fmt.Sprintf(`[worker] finished job %d`, i)
+		}
+		if _, err := s.Append([]byte(line), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s, done := tempStore(t, Options{SegmentSize: 1024})
+	defer done()
+	appendRequests(t, s, 0, 300)
+
+	var tests = []struct {
+		search string
+		want   []uint64
+	}{
+		{search: "request_id=req-42", want: []uint64{126, 127}},
+		{search: "request_id=req-42 level=error", want: []uint64{127}},
+		{search: "status=502 request_id=REQ-7", want: []uint64{22}},
+		{search: "connection refused request_id=req-1", want: []uint64{4}},
+		{search: "job 299", want: []uint64{299}},
+		{search: "request_id=req-1000"},
+		{search: "nothing"},
+	}
+
+	check := func() {
+		for _, tt := range tests {
+			got, err := s.Search(ParseMatches(tt.search)...)
+			if err != nil {
+				t.Fatalf("search %q: %v", tt.search, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("search %q: want %v, got %v", tt.search, tt.want, got)
+			}
+		}
+	}
+	check()
+
+	// sealed segments have their terms on disk, the active one has its
+	// terms rebuilt
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	s, err = Open(s.dir, Options{SegmentSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seg := range s.segs[:len(s.segs)-1] {
+		if _, ok := seg.terms.(*diskTerms); !ok {
+			t.Fatalf("segment %d should have its terms on disk", seg.first)
+		}
+	}
+	check()
+
+	// lost terms are rebuilt
+	if err := os.Remove(segmentName(s.dir, s.segs[0].first, termsExt)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(s.dir, Options{SegmentSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func BenchmarkSearch(b *testing.B) {
+	s, done := tempStore(&testing.T{}, Options{SegmentSize: 4 << 20})
+	defer done()
+	appendRequests(b, s, 0, 300000)
+	matches := ParseMatches("request_id=req-4242")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lines, err := s.Search(matches...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(lines) != 2 {
+			b.Fatalf("want 2 lines, got %v", lines)
+		}
+	}
+}
+
+func TestSearchWhileEvicting(t *testing.T) {
+	s, done := tempStore(t, Options{SegmentSize: 1024, MaxSize: 4096})
+	defer done()
+	appendRequests(t, s, 0, 300)
+
+	errc := make(chan error, 1)
+	stop := make(chan struct{})
+	go func() {
+		defer close(errc)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			got, err := s.Search(ParseMatches("level=error")...)
+			if err != nil {
+				errc <- err
+				return
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i] <= got[i-1] {
+					errc <- fmt.Errorf("lines out of order: %v", got)
+					return
+				}
+			}
+		}
+	}()
+	// segments are sealed and evicted while they're searched
+	appendRequests(t, s, 300, 3000)
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,365 @@
+package history
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	segmentExt = ".seg"
+	indexExt   = ".idx"
+
+	// a record is a header followed by the line:
+	//    len uint32 | time int64 | added int64 | line
+	headerSize = 4 + 8 + 8
+	// an index entry is the number of a line and its offset in the
+	// segment:
+	//    line uint64 | offset int64
+	indexEntrySize = 8 + 8
+)
+
+// Meta is what's kept about the parsed entry of a line.
+type Meta struct {
+	// Time is the timestamp of the entry, or zero if it had none.
+	Time time.Time
+	// Added is when the line was appended to the history.
+	Added time.Time
+}
+
+type indexEntry struct {
+	line uint64
+	off  int64
+}
+
+// segment is a file of records for the lines [first, first+count), and
+// a sparse index of where some of those lines start in the file.
+type segment struct {
+	first uint64
+	count uint64
+	size  int64
+	added time.Time // when its last line was added
+
+	f     *os.File
+	w     *bufio.Writer // only for the active segment
+	idxF  *os.File
+	index []indexEntry
+	terms terms // memTerms for the active segment, diskTerms once sealed
+}
+
+func segmentName(dir string, first uint64, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", first, ext))
+}
+
+func createSegment(dir string, first uint64) (*segment, error) {
+	f, err := os.OpenFile(segmentName(dir, first, segmentExt), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	idxF, err := os.OpenFile(segmentName(dir, first, indexExt), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &segment{
+		first: first,
+		f:     f,
+		w:     bufio.NewWriter(f),
+		idxF:  idxF,
+		terms: make(memTerms),
+	}, nil
+}
+
+// openSegment loads a segment that was written before. Records that were
+// only partly written when the process died are truncated. The terms of
+// a sealed segment are loaded from disk, or rebuilt if they're missing.
+func openSegment(dir string, first uint64, sealed bool) (*segment, error) {
+	f, err := os.OpenFile(segmentName(dir, first, segmentExt), os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	idxF, err := os.OpenFile(segmentName(dir, first, indexExt), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	seg := &segment{first: first, f: f, idxF: idxF}
+	if err := seg.load(); err != nil {
+		_ = seg.close()
+		return nil, fmt.Errorf("loading segment %d: %v", first, err)
+	}
+	if err := seg.loadTerms(dir, sealed); err != nil {
+		_ = seg.close()
+		return nil, fmt.Errorf("loading terms of segment %d: %v", first, err)
+	}
+	return seg, nil
+}
+
+func (s *segment) loadTerms(dir string, sealed bool) error {
+	name := segmentName(dir, s.first, termsExt)
+	if sealed {
+		if terms, err := openDiskTerms(name); err == nil {
+			s.terms = terms
+			return nil
+		}
+	}
+	mem, err := indexSegment(s)
+	if err != nil {
+		return err
+	}
+	s.terms = mem
+	if !sealed {
+		s.w = bufio.NewWriter(s.f)
+		return nil
+	}
+	return s.writeTerms(dir, mem)
+}
+
+func (s *segment) writeTerms(dir string, mem memTerms) error {
+	name := segmentName(dir, s.first, termsExt)
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := mem.write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	terms, err := openDiskTerms(name)
+	if err != nil {
+		return err
+	}
+	s.terms = terms
+	return nil
+}
+
+func (s *segment) load() error {
+	idx, err := readIndex(s.idxF)
+	if err != nil {
+		return err
+	}
+	fi, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// the index may have entries for records that never made it to
+	// the segment
+	for len(idx) > 0 && idx[len(idx)-1].off >= fi.Size() {
+		idx = idx[:len(idx)-1]
+	}
+	s.index = idx
+
+	line, off := s.first, int64(0)
+	if len(idx) > 0 {
+		line, off = idx[len(idx)-1].line, idx[len(idx)-1].off
+	}
+	rd := bufio.NewReader(io.NewSectionReader(s.f, off, fi.Size()-off))
+	for {
+		_, meta, n, err := readRecord(rd)
+		if err != nil {
+			break
+		}
+		s.added = meta.Added
+		off += n
+		line++
+	}
+	s.count = line - s.first
+	s.size = off
+	if off != fi.Size() {
+		if err := s.f.Truncate(off); err != nil {
+			return err
+		}
+	}
+	if err := s.idxF.Truncate(int64(len(idx)) * indexEntrySize); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = s.idxF.Seek(0, io.SeekEnd)
+	return err
+}
+
+func readIndex(r io.Reader) ([]indexEntry, error) {
+	var idx []indexEntry
+	rd := bufio.NewReader(r)
+	buf := make([]byte, indexEntrySize)
+	for {
+		if _, err := io.ReadFull(rd, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return idx, nil
+		} else if err != nil {
+			return nil, err
+		}
+		idx = append(idx, indexEntry{
+			line: binary.BigEndian.Uint64(buf[0:]),
+			off:  int64(binary.BigEndian.Uint64(buf[8:])),
+		})
+	}
+}
+
+// append a line to the segment, noting where it starts in the index if
+// it's the first of `indexEvery` lines.
+func (s *segment) append(line []byte, e *parser.Entry, meta Meta, indexEvery int) error {
+	n := s.first + s.count
+	if s.count%uint64(indexEvery) == 0 {
+		var buf [indexEntrySize]byte
+		binary.BigEndian.PutUint64(buf[0:], n)
+		binary.BigEndian.PutUint64(buf[8:], uint64(s.size))
+		if _, err := s.idxF.Write(buf[:]); err != nil {
+			return err
+		}
+		s.index = append(s.index, indexEntry{line: n, off: s.size})
+	}
+
+	var hdr [headerSize]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(len(line)))
+	binary.BigEndian.PutUint64(hdr[4:], uint64(unixNano(meta.Time)))
+	binary.BigEndian.PutUint64(hdr[12:], uint64(unixNano(meta.Added)))
+	if _, err := s.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(line); err != nil {
+		return err
+	}
+	s.terms.(memTerms).add(n, e)
+	s.count++
+	s.size += int64(headerSize + len(line))
+	s.added = meta.Added
+	return nil
+}
+
+// walk the lines of the segment starting at `from`, until fn returns
+// false.
+func (s *segment) walk(from uint64, fn func(n uint64, line []byte, meta Meta) bool) (bool, error) {
+	if s.w != nil {
+		if err := s.w.Flush(); err != nil {
+			return false, err
+		}
+	}
+	line, off := s.seek(from)
+	rd := bufio.NewReader(io.NewSectionReader(s.f, off, s.size-off))
+	for ; line < s.first+s.count; line++ {
+		data, meta, _, err := readRecord(rd)
+		if err != nil {
+			return false, err
+		}
+		if line < from {
+			continue
+		}
+		if !fn(line, data, meta) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// seek finds the closest indexed line at or before `line`.
+func (s *segment) seek(line uint64) (uint64, int64) {
+	lo, hi := 0, len(s.index)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if s.index[mid].line <= line {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return s.first, 0
+	}
+	e := s.index[lo-1]
+	return e.line, e.off
+}
+
+func readRecord(rd *bufio.Reader) (line []byte, meta Meta, n int64, err error) {
+	var hdr [headerSize]byte
+	if _, err = io.ReadFull(rd, hdr[:]); err != nil {
+		return
+	}
+	line = make([]byte, binary.BigEndian.Uint32(hdr[0:]))
+	if _, err = io.ReadFull(rd, line); err != nil {
+		return
+	}
+	meta.Time = fromUnixNano(int64(binary.BigEndian.Uint64(hdr[4:])))
+	meta.Added = fromUnixNano(int64(binary.BigEndian.Uint64(hdr[12:])))
+	return line, meta, int64(headerSize + len(line)), nil
+}
+
+// seal flushes the segment and writes its terms, it won't be appended
+// to anymore.
+func (s *segment) seal() error {
+	if s.w == nil {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	s.w = nil
+	return s.writeTerms(filepath.Dir(s.f.Name()), s.terms.(memTerms))
+}
+
+// close the files of the segment. The terms of the active segment are
+// rebuilt when it's opened again.
+func (s *segment) close() error {
+	var err error
+	if s.w != nil {
+		err = s.w.Flush()
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := s.idxF.Close(); err == nil {
+		err = cerr
+	}
+	if d, ok := s.terms.(*diskTerms); ok {
+		if cerr := d.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *segment) remove() error {
+	dir := filepath.Dir(s.f.Name())
+	err := s.close()
+	if rerr := os.Remove(segmentName(dir, s.first, segmentExt)); err == nil {
+		err = rerr
+	}
+	if rerr := os.Remove(segmentName(dir, s.first, indexExt)); err == nil {
+		err = rerr
+	}
+	if rerr := os.Remove(segmentName(dir, s.first, termsExt)); err == nil && !os.IsNotExist(rerr) {
+		err = rerr
+	}
+	return err
+}
+
+var (
+	minUnixNano = time.Unix(0, -1<<63+1)
+	maxUnixNano = time.Unix(0, 1<<63-1)
+)
+
+// unixNano is 0 for times that don't fit in int64 nanoseconds, which
+// reads back as a zero time.
+func unixNano(t time.Time) int64 {
+	if t.Before(minUnixNano) || t.After(maxUnixNano) {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
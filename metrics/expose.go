@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// WriteTo writes the metrics in the text exposition format of
+// Prometheus, their series sorted by the values of their labels. The
+// entries dropped for metrics that had too many series are counted in
+// logterm_metrics_dropped_total.
+//
+// The metrics are copied before they're written, so that a slow writer
+// doesn't hold back the entries being added.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	families := r.snapshot()
+	var dropped []*family
+	for _, f := range families {
+		f.write(cw)
+		if f.dropped > 0 {
+			dropped = append(dropped, f)
+		}
+	}
+	if len(dropped) > 0 {
+		fmt.Fprintf(cw, "# HELP logterm_metrics_dropped_total Entries left out of metrics that had too many series.\n")
+		fmt.Fprintf(cw, "# TYPE logterm_metrics_dropped_total counter\n")
+		for _, f := range dropped {
+			fmt.Fprintf(cw, "logterm_metrics_dropped_total{metric=\"%s\"} %d\n", f.Name, f.dropped)
+		}
+	}
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+// snapshot of the families and their series, as they are now.
+func (r *Registry) snapshot() []*family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	families := make([]*family, len(r.families))
+	for i, f := range r.families {
+		c := &family{Metric: f.Metric, series: make(map[string]*series, len(f.series)), dropped: f.dropped}
+		for key, s := range f.series {
+			s := *s
+			s.buckets = append([]uint64(nil), s.buckets...)
+			c.series[key] = &s
+		}
+		families[i] = c
+	}
+	return families
+}
+
+// ServeHTTP serves the metrics, for Prometheus to scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	if req.Method == http.MethodHead {
+		return
+	}
+	r.WriteTo(w)
+}
+
+func (f *family) write(w io.Writer) {
+	if f.Help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.Name, helpEscaper.Replace(f.Help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.Name, f.Kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		labels := f.labels(s.values)
+		switch f.Kind {
+		case Counter:
+			fmt.Fprintf(w, "%s%s %d\n", f.Name, braces(labels), s.count)
+		case Gauge:
+			fmt.Fprintf(w, "%s%s %s\n", f.Name, braces(labels), formatFloat(s.sum))
+		case Histogram:
+			var cumulative uint64
+			for i, bound := range f.Buckets {
+				cumulative += s.buckets[i]
+				le := append(labels[:len(labels):len(labels)], `le="`+formatFloat(bound)+`"`)
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.Name, braces(le), cumulative)
+			}
+			le := append(labels[:len(labels):len(labels)], `le="+Inf"`)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.Name, braces(le), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.Name, braces(labels), formatFloat(s.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.Name, braces(labels), s.count)
+		}
+	}
+}
+
+// labels of a series, like `status="200"`. Those without a value are
+// left out, as Prometheus does.
+func (f *family) labels(values []string) []string {
+	var labels []string
+	for i, l := range f.Labels {
+		if values[i] != "" {
+			labels = append(labels, l.Name+`="`+labelEscaper.Replace(values[i])+`"`)
+		}
+	}
+	return labels
+}
+
+func braces(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written, and keeps the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
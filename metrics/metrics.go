@@ -0,0 +1,256 @@
+// Package metrics derives Prometheus metrics from entries: counters of
+// the entries that match a query, histograms of a duration field, and
+// gauges of the last value of a field, labelled by the values of other
+// fields. A Registry serves them in the text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/encode"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxSeries is how many series of labels a metric has at most.
+const DefaultMaxSeries = 1000
+
+// DefaultBuckets of histograms, in seconds, the same as the client
+// libraries of Prometheus.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	validName  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	validLabel = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	invalid    = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// Kind of metric.
+type Kind int
+
+const (
+	// Counter of the entries that match
+	Counter Kind = iota + 1
+	// Histogram of the durations of a field, in seconds
+	Histogram
+	// Gauge of the last value of a field
+	Gauge
+)
+
+// Kinds by the names of their type in Prometheus.
+var Kinds = map[string]Kind{"counter": Counter, "histogram": Histogram, "gauge": Gauge}
+
+func (k Kind) String() string {
+	for name, kind := range Kinds {
+		if kind == k {
+			return name
+		}
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Label of a metric, whose values are those of a field of the entries.
+type Label struct {
+	Name  string
+	Field string
+}
+
+// ParseLabel reads a label written like `status=http.status`, or like
+// `method` for a label named after its field. Characters that can't be in
+// names of labels are replaced by underscores.
+func ParseLabel(spec string) (Label, error) {
+	name, field := spec, spec
+	if i := strings.IndexByte(spec, '='); i >= 0 {
+		name, field = spec[:i], spec[i+1:]
+	} else {
+		name = invalid.ReplaceAllString(name, "_")
+	}
+	switch {
+	case field == "":
+		return Label{}, fmt.Errorf("label %q needs a field", spec)
+	case !validLabel.MatchString(name):
+		return Label{}, fmt.Errorf("invalid label name %q", name)
+	case strings.HasPrefix(name, "__"):
+		return Label{}, fmt.Errorf("label names starting with __ are kept for Prometheus, got %q", name)
+	}
+	return Label{Name: name, Field: field}, nil
+}
+
+// Metric derived from entries.
+type Metric struct {
+	Name string
+	Help string
+	Kind Kind
+	// Query that entries must match to count, all do without one. It
+	// can't aggregate
+	Query *query.Query
+	// Field observed by histograms, whose durations are in seconds, and
+	// by gauges, whose numbers and durations are their values
+	Field string
+	// Labels of the series
+	Labels []Label
+	// Buckets of histograms, DefaultBuckets by default
+	Buckets []float64
+	// MaxSeries of labels, the entries of others are dropped.
+	// DefaultMaxSeries by default
+	MaxSeries int
+}
+
+func (m *Metric) check() error {
+	switch {
+	case !validName.MatchString(m.Name):
+		return fmt.Errorf("invalid name %q", m.Name)
+	case m.Kind < Counter || m.Kind > Gauge:
+		return fmt.Errorf("unknown kind %v", m.Kind)
+	case m.Query != nil && m.Query.Aggregates():
+		return fmt.Errorf("its query can't aggregate")
+	case m.Kind != Counter && m.Field == "":
+		return fmt.Errorf("a %s needs a field", m.Kind)
+	case !sort.Float64sAreSorted(m.Buckets):
+		return fmt.Errorf("buckets must go up")
+	}
+	seen := make(map[string]bool)
+	for _, l := range m.Labels {
+		if seen[l.Name] || m.Kind == Histogram && l.Name == "le" {
+			return fmt.Errorf("label %q is there twice, or kept for buckets", l.Name)
+		}
+		seen[l.Name] = true
+	}
+	return nil
+}
+
+// series of a metric, for some values of its labels.
+type series struct {
+	values []string
+	// count of entries, or of observations
+	count uint64
+	// sum of the observations, or last value of a gauge
+	sum float64
+	// counts of the observations of each bucket, not cumulative
+	buckets []uint64
+}
+
+// family is a metric and its series, by their values joined.
+type family struct {
+	*Metric
+	series  map[string]*series
+	dropped uint64
+}
+
+// Registry of metrics, that entries are added to. It's safe for
+// concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry of the metrics.
+func NewRegistry(metrics []*Metric) (*Registry, error) {
+	r := &Registry{}
+	names := make(map[string]bool)
+	for _, m := range metrics {
+		if err := m.check(); err != nil {
+			return nil, fmt.Errorf("invalid metric %q: %v", m.Name, err)
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("metric %q is there twice", m.Name)
+		}
+		names[m.Name] = true
+		m := *m
+		if m.Kind == Histogram && m.Buckets == nil {
+			m.Buckets = DefaultBuckets
+		}
+		if m.MaxSeries <= 0 {
+			m.MaxSeries = DefaultMaxSeries
+		}
+		r.families = append(r.families, &family{Metric: &m, series: make(map[string]*series)})
+	}
+	return r, nil
+}
+
+// Add an entry to the metrics it counts for.
+func (r *Registry) Add(e *parser.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		f.add(e)
+	}
+}
+
+func (f *family) add(e *parser.Entry) {
+	if f.Query != nil {
+		var ok bool
+		if e, ok = f.Query.Match(e); !ok {
+			return
+		}
+	}
+	var v float64
+	if f.Kind != Counter {
+		var ok bool
+		if v, ok = value(e, f.Field, f.Kind == Histogram); !ok {
+			return
+		}
+	}
+	s := f.get(e)
+	if s == nil {
+		f.dropped++
+		return
+	}
+	switch f.Kind {
+	case Counter:
+		s.count++
+	case Histogram:
+		s.count++
+		s.sum += v
+		if i := sort.SearchFloat64s(f.Buckets, v); i < len(f.Buckets) {
+			s.buckets[i]++
+		}
+	case Gauge:
+		s.sum = v
+	}
+}
+
+// get the series of the values of the labels of the entry, made if
+// there's room for it.
+func (f *family) get(e *parser.Entry) *series {
+	values := make([]string, len(f.Labels))
+	for i, l := range f.Labels {
+		if v, ok := e.Field(l.Field); ok {
+			values[i] = encode.Text(v)
+		}
+	}
+	key := strings.Join(values, "\x00")
+	s, ok := f.series[key]
+	if !ok {
+		if len(f.series) >= f.MaxSeries {
+			return nil
+		}
+		s = &series{values: values}
+		if f.Kind == Histogram {
+			s.buckets = make([]uint64, len(f.Buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// value of a field as a number: durations in seconds, and for gauges,
+// numbers and times in seconds since the epoch.
+func value(e *parser.Entry, name string, durations bool) (float64, bool) {
+	f, ok := e.Field(name)
+	if !ok {
+		return 0, false
+	}
+	switch f := f.(type) {
+	case parser.DurationField:
+		return f.Seconds(), true
+	case parser.NumberField:
+		return float64(f), !durations
+	case parser.TimeField:
+		return float64(f.UnixNano()) / 1e9, !durations
+	}
+	return 0, false
+}
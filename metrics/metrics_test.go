@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustQuery(t *testing.T, text string) *query.Query {
+	t.Helper()
+	q, err := query.Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func mustLabels(t *testing.T, specs ...string) []Label {
+	t.Helper()
+	var labels []Label
+	for _, spec := range specs {
+		l, err := ParseLabel(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels = append(labels, l)
+	}
+	return labels
+}
+
+const lines = `{"service":"api","http.status":200,"took":"20ms","depth":3}
+{"service":"api","http.status":500,"took":"1.5s","depth":7}
+{"service":"api","http.status":200,"took":"300ms"}
+{"service":"db","http.status":200,"took":"fast","msg":"say \"hi\""}
+`
+
+const exposed = `# HELP requests_total Requests by status.
+# TYPE requests_total counter
+requests_total{service="api",http_status="200"} 2
+requests_total{service="api",http_status="500"} 1
+requests_total{service="db",http_status="200"} 1
+# TYPE request_seconds histogram
+request_seconds_bucket{le="0.1"} 1
+request_seconds_bucket{le="1"} 2
+request_seconds_bucket{le="+Inf"} 3
+request_seconds_sum 1.82
+request_seconds_count 3
+# TYPE queue_depth gauge
+queue_depth{service="api"} 7
+# TYPE messages_total counter
+messages_total 3
+# HELP logterm_metrics_dropped_total Entries left out of metrics that had too many series.
+# TYPE logterm_metrics_dropped_total counter
+logterm_metrics_dropped_total{metric="messages_total"} 1
+`
+
+func newRegistry(t *testing.T) *Registry {
+	r, err := NewRegistry([]*Metric{{
+		Name:   "requests_total",
+		Help:   "Requests by status.",
+		Kind:   Counter,
+		Labels: mustLabels(t, "service", "http.status"),
+	}, {
+		Name:    "request_seconds",
+		Kind:    Histogram,
+		Query:   mustQuery(t, "service=api"),
+		Field:   "took",
+		Buckets: []float64{0.1, 1},
+	}, {
+		Name:   "queue_depth",
+		Kind:   Gauge,
+		Field:  "depth",
+		Labels: mustLabels(t, "service"),
+	}, {
+		Name:      "messages_total",
+		Kind:      Counter,
+		Labels:    mustLabels(t, "msg"),
+		MaxSeries: 1,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(lines), "\n") {
+		e := parser.ParseLine([]byte(line))
+		if len(e.FieldNames()) == 0 {
+			t.Fatalf("can't parse %q", line)
+		}
+		r.Add(e)
+	}
+	return r
+}
+
+func TestWriteTo(t *testing.T) {
+	r := newRegistry(t)
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != exposed {
+		t.Errorf("want\n%s\ngot\n%s", exposed, got)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("want %d bytes written, got %d", buf.Len(), n)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	srv := httptest.NewServer(newRegistry(t))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("want content type %q, got %q", ContentType, ct)
+	}
+	if string(body) != exposed {
+		t.Errorf("want the metrics scraped, got\n%s", body)
+	}
+	resp, err = http.Post(srv.URL+"/metrics", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("want posts refused, got %s", resp.Status)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		m    *Metric
+		want string
+	}{
+		{&Metric{Name: "bad-name", Kind: Counter}, "invalid name"},
+		{&Metric{Name: "h", Kind: Histogram}, "a histogram needs a field"},
+		{&Metric{Name: "c", Kind: Counter, Query: mustQuery(t, "| count")}, "its query can't aggregate"},
+		{&Metric{Name: "h", Kind: Histogram, Field: "took", Buckets: []float64{1, 0.5}}, "buckets must go up"},
+		{&Metric{Name: "h", Kind: Histogram, Field: "took", Labels: []Label{{Name: "le", Field: "x"}}}, "label \"le\""},
+	} {
+		if _, err := NewRegistry([]*Metric{tt.m}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: want an error like %q, got %v", tt.m, tt.want, err)
+		}
+	}
+	for _, spec := range []string{"=x", "1st=x", "__name__=x", "s="} {
+		if _, err := ParseLabel(spec); err == nil {
+			t.Errorf("%q: want an error", spec)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	r, err := NewRegistry([]*Metric{{
+		Name:   "messages_total",
+		Help:   "Messages, like C:\\logs\nor others.",
+		Kind:   Counter,
+		Labels: mustLabels(t, "msg"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Add(parser.ParseLine([]byte(`{"msg":"say \"hi\"\nbye"}`)))
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	for _, want := range []string{
+		`# HELP messages_total Messages, like C:\\logs\nor others.`,
+		`messages_total{msg="say \"hi\"\nbye"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %s in\n%s", want, buf.String())
+		}
+	}
+}
+
+// blockedWriter holds the first write until it's released.
+type blockedWriter struct {
+	writing, release chan struct{}
+}
+
+func (b *blockedWriter) Write(p []byte) (int, error) {
+	select {
+	case b.writing <- struct{}{}:
+		<-b.release
+	default:
+	}
+	return len(p), nil
+}
+
+func TestSlowWriter(t *testing.T) {
+	r, err := NewRegistry([]*Metric{{Name: "lines_total", Kind: Counter, Labels: mustLabels(t, "a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more series than the writer buffers, for it to write while the
+	// metrics are
+	for i := 0; i < 500; i++ {
+		r.Add(parser.ParseLine([]byte(fmt.Sprintf("a=%d", i))))
+	}
+	w := &blockedWriter{writing: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		r.WriteTo(w)
+		close(done)
+	}()
+	<-w.writing
+
+	added := make(chan struct{})
+	go func() {
+		r.Add(parser.ParseLine([]byte(`a=2`)))
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("adding waited for the writer")
+	}
+	close(w.release)
+	<-done
+}
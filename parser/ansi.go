@@ -0,0 +1,41 @@
+package parser
+
+import "bytes"
+
+const esc = 0x1b
+
+// stripANSI removes the CSI escape sequences (colors, cursor movements)
+// that programs write to terminals. It returns data untouched if there
+// are none.
+func stripANSI(data []byte) []byte {
+	if bytes.IndexByte(data, esc) == -1 {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		if n := csiLen(data[i:]); n > 0 {
+			i += n
+			continue
+		}
+		out = append(out, data[i])
+		i++
+	}
+	return out
+}
+
+// csiLen is the length of the CSI sequence at the start of data, which
+// looks like `ESC [ params final`, or 0 if there is none.
+func csiLen(data []byte) int {
+	if len(data) < 2 || data[0] != esc || data[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(data); i++ {
+		switch b := data[i]; {
+		case b >= 0x40 && b <= 0x7e: // final byte
+			return i + 1
+		case b < 0x20 || b > 0x3f: // not a parameter or intermediate
+			return 0
+		}
+	}
+	return 0
+}
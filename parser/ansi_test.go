@@ -0,0 +1,56 @@
+package parser
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  string
+	}{
+		{input: "no colors", want: "no colors"},
+		{input: "\x1b[31mred\x1b[0m", want: "red"},
+		{input: "\x1b[1;38;5;208mlevel\x1b[0m=info", want: "level=info"},
+		{input: "\x1b[2Kcleared", want: "cleared"},
+		{input: "lone \x1b escape", want: "lone \x1b escape"},
+		{input: "cut short \x1b[31", want: "cut short \x1b[31"},
+	}
+
+	for _, tt := range tests {
+		got := string(stripANSI([]byte(tt.input)))
+		if got != tt.want {
+			t.Errorf("input %q: want %q, got %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestCanParseColoredLines(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  map[string]Field
+	}{
+		{
+			input: "\x1b[36mlevel\x1b[0m=info \x1b[36mmsg\x1b[0m=hello",
+			want: map[string]Field{
+				"level": StringField("info"),
+				"msg":   StringField("hello"),
+			},
+		},
+		{
+			input: "\x1b[32m{\"level\":\"info\"}\x1b[0m",
+			want: map[string]Field{
+				"level": StringField("info"),
+			},
+		},
+		{
+			input: "\x1b[31mERROR\x1b[0m something broke",
+			want: map[string]Field{
+				DefaultRaw: RawField("\x1b[31mERROR\x1b[0m something broke"),
+			},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Logf("test %d", n)
+		canParseTestTable(t, tt.input, tt.want)
+	}
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// envelope is what container runtimes wrap around the lines that
+// a process writes to its stdout/stderr.
+type envelope struct {
+	stream string
+	time   time.Time
+}
+
+// decodeEnvelope unwraps Docker json-file and Kubernetes CRI records. It
+// returns the payload they carry and whether that payload is only part
+// of a longer line that continues in the next records.
+func decodeEnvelope(data []byte) (env envelope, payload []byte, partial, ok bool) {
+	if env, payload, partial, ok = decodeDockerJSON(data); ok {
+		return
+	}
+	return decodeCRI(data)
+}
+
+var dockerJSONPrefix = []byte(`{"log":`)
+
+type dockerJSON struct {
+	Log    *string `json:"log"`
+	Stream string  `json:"stream"`
+	Time   string  `json:"time"`
+}
+
+// decodeDockerJSON decodes lines written by Docker's json-file driver:
+//
+//	{"log":"hello\n","stream":"stdout","time":"2014-10-27T18:38:45.123Z"}
+//
+// Docker splits long lines in many records, only the last one of which
+// ends with a newline.
+func decodeDockerJSON(data []byte) (env envelope, payload []byte, partial, ok bool) {
+	// docker always writes the `log` key first, don't bother decoding
+	// JSON that doesn't start with it
+	if !bytes.HasPrefix(data, dockerJSONPrefix) {
+		return
+	}
+	var rec dockerJSON
+	if err := json.Unmarshal(data, &rec); err != nil || rec.Log == nil {
+		return
+	}
+	if !isStreamName(rec.Stream) {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, rec.Time)
+	if err != nil {
+		return
+	}
+	payload = []byte(*rec.Log)
+	if bytes.HasSuffix(payload, []byte("\n")) {
+		payload = bytes.TrimSuffix(payload[:len(payload)-1], []byte("\r"))
+	} else {
+		partial = true
+	}
+	return envelope{stream: rec.Stream, time: t}, payload, partial, true
+}
+
+// decodeCRI decodes lines written by CRI runtimes (containerd, CRI-O):
+//
+//	2014-10-27T18:38:45.123456789Z stdout F hello
+//
+// The tag is `P` for a partial record and `F` for the last record
+// of a line.
+func decodeCRI(data []byte) (env envelope, payload []byte, partial, ok bool) {
+	if len(data) == 0 || data[0] < '0' || data[0] > '9' {
+		return
+	}
+	ts, rest, found := cutByte(data, ' ')
+	if !found {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(ts))
+	if err != nil {
+		return
+	}
+	stream, rest, found := cutByte(rest, ' ')
+	if !found || !isStreamName(string(stream)) {
+		return
+	}
+	tag, payload, _ := cutByte(rest, ' ')
+	// tags can carry more flags after a `:`, only the first matters
+	if i := bytes.IndexByte(tag, ':'); i != -1 {
+		tag = tag[:i]
+	}
+	switch string(tag) {
+	case "P":
+		partial = true
+	case "F":
+	default:
+		return
+	}
+	return envelope{stream: string(stream), time: t}, payload, partial, true
+}
+
+func isStreamName(s string) bool {
+	return s == "stdout" || s == "stderr"
+}
+
+func cutByte(data []byte, sep byte) (before, after []byte, found bool) {
+	if i := bytes.IndexByte(data, sep); i != -1 {
+		return data[:i], data[i+1:], true
+	}
+	return data, nil, false
+}
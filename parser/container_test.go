@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanParseContainerLogs(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  []map[string]Field
+	}{
+		{
+			input: `{"log":"time=\"2014-10-27T18:38:45-04:00\" level=info msg=hello\n","stream":"stdout","time":"2014-10-27T22:38:46.123Z"}`,
+			want: []map[string]Field{{
+				"time":   TimeField{time.Date(2014, 10, 27, 18, 38, 45, 0, time.FixedZone("EDT", -4*60*60))},
+				"level":  StringField("info"),
+				"msg":    StringField("hello"),
+				"stream": StringField("stdout"),
+			}},
+		},
+		{
+			input: `{"log":"{\"level\":\"error\",","stream":"stderr","time":"2014-10-27T22:38:46.123Z"}
+{"log":"\"msg\":\"split\"}\n","stream":"stderr","time":"2014-10-27T22:38:46.124Z"}`,
+			want: []map[string]Field{{
+				"level":  StringField("error"),
+				"msg":    StringField("split"),
+				"stream": StringField("stderr"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 124000000, time.UTC)},
+			}},
+		},
+		{
+			input: `{"log":"just some text\n","stream":"stdout","time":"2014-10-27T22:38:46.123Z"}`,
+			want: []map[string]Field{{
+				DefaultRaw: RawField("just some text"),
+				"stream":   StringField("stdout"),
+				"time":     TimeField{time.Date(2014, 10, 27, 22, 38, 46, 123000000, time.UTC)},
+			}},
+		},
+		{
+			input: `2014-10-27T22:38:46.123456789Z stdout F level=info msg=hello`,
+			want: []map[string]Field{{
+				"level":  StringField("info"),
+				"msg":    StringField("hello"),
+				"stream": StringField("stdout"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 123456789, time.UTC)},
+			}},
+		},
+		{
+			input: `2014-10-27T22:38:46.1Z stderr P {"level":"warn",
+2014-10-27T22:38:46.2Z stderr P "msg":
+2014-10-27T22:38:46.3Z stderr F "reassembled"}
+2014-10-27T22:38:46.4Z stdout F`,
+			want: []map[string]Field{{
+				"level":  StringField("warn"),
+				"msg":    StringField("reassembled"),
+				"stream": StringField("stderr"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 300000000, time.UTC)},
+			}, {
+				DefaultRaw: RawField(nil),
+				"stream":   StringField("stdout"),
+				"time":     TimeField{time.Date(2014, 10, 27, 22, 38, 46, 400000000, time.UTC)},
+			}},
+		},
+		{
+			// records of stdout and stderr interleave, each stream is
+			// put back together on its own
+			input: `2014-10-27T22:38:46.1Z stdout P level=info
+2014-10-27T22:38:46.2Z stderr F level=error msg=between
+2014-10-27T22:38:46.3Z stdout F  msg=joined
+{"log":"level=warn ","stream":"stdout","time":"2014-10-27T22:38:46.4Z"}
+{"log":"level=debug\n","stream":"stderr","time":"2014-10-27T22:38:46.5Z"}
+{"log":"msg=docker\n","stream":"stdout","time":"2014-10-27T22:38:46.6Z"}`,
+			want: []map[string]Field{{
+				"level":  StringField("error"),
+				"msg":    StringField("between"),
+				"stream": StringField("stderr"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 200000000, time.UTC)},
+			}, {
+				"level":  StringField("info"),
+				"msg":    StringField("joined"),
+				"stream": StringField("stdout"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 300000000, time.UTC)},
+			}, {
+				"level":  StringField("debug"),
+				"stream": StringField("stderr"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 500000000, time.UTC)},
+			}, {
+				"level":  StringField("warn"),
+				"msg":    StringField("docker"),
+				"stream": StringField("stdout"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 600000000, time.UTC)},
+			}},
+		},
+		{
+			// a partial record cut short by the end of the input, or
+			// by a line that isn't from a container, is still emitted
+			input: `2014-10-27T22:38:46.1Z stdout P level=info msg=cut
+not=container
+2014-10-27T22:38:46.2Z stdout P level=info msg=eof`,
+			want: []map[string]Field{{
+				"level":  StringField("info"),
+				"msg":    StringField("cut"),
+				"stream": StringField("stdout"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 100000000, time.UTC)},
+			}, {
+				"not": StringField("container"),
+			}, {
+				"level":  StringField("info"),
+				"msg":    StringField("eof"),
+				"stream": StringField("stdout"),
+				"time":   TimeField{time.Date(2014, 10, 27, 22, 38, 46, 200000000, time.UTC)},
+			}},
+		},
+		{
+			// looks like docker, but isn't
+			input: `{"log":"hello","stream":"somewhere"}`,
+			want: []map[string]Field{{
+				"log":    StringField("hello"),
+				"stream": StringField("somewhere"),
+			}},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Logf("test %d", n)
+		canParseLines(t, tt.input, tt.want)
+	}
+}
+
+func canParseLines(t *testing.T, input string, want []map[string]Field) {
+	parser := NewParser(strings.NewReader(input))
+	i := 0
+	for ; parser.Next(); i++ {
+		gotE := parser.LogEntry()
+		if i >= len(want) {
+			t.Errorf("parsed too many lines: want %d, got %d so far", len(want), i)
+			continue
+		}
+		checkEntryMatch(t, want[i], gotE)
+	}
+	if i != len(want) {
+		t.Fatalf("parsed wrong number of lines: want %d, got %d", len(want), i)
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatalf("got parsing error: %v", err)
+	}
+}
@@ -29,6 +29,47 @@ func (e *Entry) FieldNames() []string {
 	return e.names
 }
 
+// Clone returns a copy of the entry that can be changed without changing
+// e.
+func (e *Entry) Clone() *Entry {
+	c := &Entry{
+		names:  append([]string(nil), e.names...),
+		fields: make(map[string]Field, len(e.fields)),
+	}
+	for name, f := range e.fields {
+		c.fields[name] = f
+	}
+	return c
+}
+
+// Set the field, replacing its value if the entry has it already.
+func (e *Entry) Set(name string, f Field) {
+	if _, ok := e.fields[name]; !ok {
+		e.names = append(e.names, name)
+	}
+	e.fields[name] = f
+}
+
+// Delete the field, if the entry has it.
+func (e *Entry) Delete(name string) {
+	if _, ok := e.fields[name]; !ok {
+		return
+	}
+	delete(e.fields, name)
+	for i, n := range e.names {
+		if n == name {
+			e.names = append(e.names[:i:i], e.names[i+1:]...)
+			break
+		}
+	}
+}
+
+// InferField types a value the way the parsers type the values of
+// key=value pairs: as a boolean, nil, number, duration, time or string.
+func InferField(val []byte) Field {
+	return inferValueField(val)
+}
+
 type Field interface{}
 
 type NilField struct{}
@@ -50,3 +91,40 @@ type TimeField struct {
 }
 
 type BooleanField bool
+
+// TimeFields are the names of the fields that commonly hold the timestamp
+// of an entry, in order of preference.
+var TimeFields = []string{DefaultTime, "ts", "timestamp", "@timestamp", "t", "date"}
+
+// Time is the canonical timestamp of the entry, taken from the first field
+// that is commonly used for that. Numbers are read as seconds or
+// milliseconds since the epoch.
+func (e *Entry) Time() (time.Time, bool) {
+	for _, name := range TimeFields {
+		switch f := e.fields[name].(type) {
+		case TimeField:
+			return f.Time, true
+		case NumberField:
+			if t, ok := epochTime(float64(f)); ok {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func epochTime(v float64) (time.Time, bool) {
+	const (
+		minSec = 1e9  // 2001-09-09
+		maxSec = 1e10 // 2286-11-20
+	)
+	switch {
+	case v >= minSec && v < maxSec:
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)).UTC(), true
+	case v >= minSec*1000 && v < maxSec*1000:
+		ms := int64(v)
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC(), true
+	}
+	return time.Time{}, false
+}
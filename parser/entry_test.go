@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEntryTime(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  time.Time
+		ok    bool
+	}{
+		{input: `{"time":"2014-10-27T18:38:45Z"}`, want: time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC), ok: true},
+		{input: `ts=2014-10-27T18:38:45Z msg=hello`, want: time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC), ok: true},
+		{input: `{"ts":1414435125.5}`, want: time.Date(2014, 10, 27, 18, 38, 45, 500000000, time.UTC), ok: true},
+		{input: `{"timestamp":1414435125000}`, want: time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC), ok: true},
+		{input: `{"@timestamp":"2014-10-27T18:38:45Z","time":"nope"}`, want: time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC), ok: true},
+		{input: `{"born":"2014-10-27T18:38:45Z"}`},
+		{input: `{"ts":42}`},
+		{input: `just some text`},
+	}
+	for _, tt := range tests {
+		got, ok := ParseLine([]byte(tt.input)).Time()
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("input %q: want %v (%v), got %v (%v)", tt.input, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestEntryCloneAndSet(t *testing.T) {
+	e := ParseLine([]byte(`level=info status=200`))
+	c := e.Clone()
+	c.Set("status", NumberField(500))
+	c.Set("slow", BooleanField(true))
+
+	if f, _ := e.Field("status"); f != NumberField(200) {
+		t.Errorf("the original changed: status=%#v", f)
+	}
+	if _, ok := e.Field("slow"); ok || len(e.FieldNames()) != 2 {
+		t.Errorf("the original has new fields: %v", e.FieldNames())
+	}
+	if f, _ := c.Field("status"); f != NumberField(500) {
+		t.Errorf("want status=500, got %#v", f)
+	}
+	want := []string{"level", "status", "slow"}
+	if got := c.FieldNames(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("want names %v, got %v", want, got)
+	}
+}
+
+func TestEntryDelete(t *testing.T) {
+	e := ParseLine([]byte(`level=info password=hunter2 status=200`))
+	c := e.Clone()
+	c.Delete("password")
+	c.Delete("nope")
+	if _, ok := c.Field("password"); ok {
+		t.Error("password is still there")
+	}
+	if got := c.FieldNames(); len(got) != 2 || got[0] != "level" || got[1] != "status" {
+		t.Errorf("want names [level status], got %v", got)
+	}
+	if len(e.FieldNames()) != 3 {
+		t.Errorf("the original changed: %v", e.FieldNames())
+	}
+}
@@ -5,11 +5,36 @@ import (
 	"io"
 )
 
-const DefaultRaw = "raw"
+const (
+	DefaultRaw     = "raw"
+	DefaultStream  = "stream"
+	DefaultTime    = "time"
+	DefaultService = "service"
+	DefaultTag     = "tag"
+)
 
 type Parser struct {
 	scan          *bufio.Scanner
 	allowEmptyKey bool
+
+	// current logical line, and the container envelope it was
+	// unwrapped from, if any
+	data []byte
+	env  *envelope
+
+	// reassembles container lines that were split in many partial
+	// records, one per stream since records of stdout and stderr
+	// interleave, in the order they started
+	partials []*partialRecord
+	held     []byte
+	hasHeld  bool
+}
+
+// partialRecord is the start of a container line whose end is still to
+// come.
+type partialRecord struct {
+	data []byte
+	env  envelope
 }
 
 func NewParser(r io.Reader) *Parser {
@@ -18,21 +43,101 @@ func NewParser(r io.Reader) *Parser {
 	return &Parser{scan: scan, allowEmptyKey: true}
 }
 
-func (p *Parser) Next() bool { return p.scan.Scan() }
+func (p *Parser) Next() bool {
+	p.env = nil
+	for {
+		var line []byte
+		if p.hasHeld {
+			line, p.hasHeld = p.held, false
+		} else if p.scan.Scan() {
+			line = p.scan.Bytes()
+		} else {
+			// flush a partial record that never saw its end
+			return p.flushPartial(nil)
+		}
+
+		env, payload, partial, ok := decodeEnvelope(line)
+		if !ok {
+			if p.flushPartial(line) {
+				return true
+			}
+			p.data = line
+			return true
+		}
+		rec := p.partialOf(env.stream)
+		rec.data = append(rec.data, payload...)
+		rec.env = env
+		if partial {
+			continue
+		}
+		p.data = rec.data
+		p.env = &rec.env
+		p.removePartial(rec)
+		return true
+	}
+}
+
+// partialOf is the pending record of the stream, started if there's none.
+func (p *Parser) partialOf(stream string) *partialRecord {
+	for _, rec := range p.partials {
+		if rec.env.stream == stream {
+			return rec
+		}
+	}
+	rec := &partialRecord{env: envelope{stream: stream}}
+	p.partials = append(p.partials, rec)
+	return rec
+}
+
+func (p *Parser) removePartial(rec *partialRecord) {
+	for i, r := range p.partials {
+		if r == rec {
+			p.partials = append(p.partials[:i], p.partials[i+1:]...)
+			return
+		}
+	}
+}
+
+// flushPartial makes the oldest pending partial record the current line,
+// if there is one. The line that interrupted it is kept for the next
+// call.
+func (p *Parser) flushPartial(interruptedBy []byte) bool {
+	if len(p.partials) == 0 {
+		return false
+	}
+	if interruptedBy != nil {
+		p.held = append(p.held[:0], interruptedBy...)
+		p.hasHeld = true
+	}
+	rec := p.partials[0]
+	p.partials = p.partials[1:]
+	p.data = rec.data
+	p.env = &rec.env
+	return true
+}
 
 func (p *Parser) Err() error { return p.scan.Err() }
 
 func (p *Parser) LogEntry() *Entry {
-	data := p.scan.Bytes()
-	switch data[0] {
-	case byte('{'):
-		e, ok := parseJSON(data)
-		if ok {
-			return e
-		}
+	e := parseLine(p.data, p.allowEmptyKey)
+	if p.env != nil {
+		// fields of the payload win over those of the envelope
+		e.setField(DefaultStream, StringField(p.env.stream))
+		e.setField(DefaultTime, TimeField{p.env.time})
+	}
+	return e
+}
+
+func parseLine(data []byte, allowEmptyKey bool) *Entry {
+	// colors get in the way of finding keys and values, but raw lines
+	// keep them so they can be drawn
+	clean := stripANSI(data)
+
+	if e, ok := parseStructured(clean, allowEmptyKey); ok {
+		return e
 	}
 
-	if e, ok := parseLogFmt(data, p.allowEmptyKey); ok {
+	if e, ok := parsePrefixed(clean, allowEmptyKey); ok {
 		return e
 	}
 
@@ -41,3 +146,20 @@ func (p *Parser) LogEntry() *Entry {
 
 	return e
 }
+
+func parseStructured(data []byte, allowEmptyKey bool) (*Entry, bool) {
+	if len(data) != 0 && data[0] == '{' {
+		e, ok := parseJSON(data)
+		if ok {
+			return e, true
+		}
+	}
+	return parseLogFmt(data, allowEmptyKey)
+}
+
+// ParseLine parses a single line, like LogEntry would. It doesn't know of
+// the lines around it, so container records that were split in parts are
+// not put back together.
+func ParseLine(data []byte) *Entry {
+	return parseLine(data, true)
+}
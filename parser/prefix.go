@@ -0,0 +1,118 @@
+package parser
+
+import "bytes"
+
+const (
+	// how many prefixes can be stacked before the structured data,
+	// like `web_1 | 2014-10-27T18:38:45Z [worker] {...}`
+	maxPrefixes = 3
+	// don't look further than this for the end of a prefix
+	maxPrefixLen = 100
+	// a timestamp spans at most this many words, as in
+	// `2006-01-02 15:04:05.999999999 -0700 MST`
+	maxTimeWords = 4
+)
+
+// parsePrefixed peels timestamps, docker-compose service names and
+// bracketed tags off the start of a line, until what's left parses as
+// JSON or logfmt. The prefixes become fields of the entry, unless the
+// structured data already has fields by that name.
+func parsePrefixed(data []byte, allowEmptyKey bool) (*Entry, bool) {
+	type prefix struct {
+		name string
+		f    Field
+	}
+	var prefixes []prefix
+
+	rest := data
+	for i := 0; i < maxPrefixes; i++ {
+		name, f, after, ok := peelPrefix(rest)
+		if !ok {
+			return nil, false
+		}
+		prefixes = append(prefixes, prefix{name, f})
+		rest = after
+
+		if e, ok := parseStructured(rest, allowEmptyKey); ok {
+			for _, p := range prefixes {
+				e.setField(p.name, p.f)
+			}
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func peelPrefix(data []byte) (name string, f Field, rest []byte, ok bool) {
+	if tag, rest, ok := peelBracketTag(data); ok {
+		return DefaultTag, StringField(tag), rest, true
+	}
+	if svc, rest, ok := peelComposeService(data); ok {
+		return DefaultService, StringField(svc), rest, true
+	}
+	if f, rest, ok := peelTimestamp(data); ok {
+		return DefaultTime, f, rest, true
+	}
+	return "", nil, nil, false
+}
+
+// peelBracketTag peels `[pod/foo] ` off a line.
+func peelBracketTag(data []byte) (tag, rest []byte, ok bool) {
+	if len(data) == 0 || data[0] != '[' {
+		return nil, nil, false
+	}
+	end := bytes.IndexByte(data, ']')
+	if end == -1 || end > maxPrefixLen {
+		return nil, nil, false
+	}
+	return data[1:end], bytes.TrimLeft(data[end+1:], " \t"), true
+}
+
+// peelComposeService peels `web_1  | ` off a line, as docker-compose
+// prints it in front of the output of each service.
+func peelComposeService(data []byte) (svc, rest []byte, ok bool) {
+	i := 0
+	for i < len(data) && i < maxPrefixLen && isServiceNameByte(data[i]) {
+		i++
+	}
+	if i == 0 {
+		return nil, nil, false
+	}
+	svc = data[:i]
+	for i < len(data) && data[i] == ' ' {
+		i++
+	}
+	if i+1 >= len(data) || data[i] != '|' || data[i+1] != ' ' {
+		return nil, nil, false
+	}
+	return svc, bytes.TrimLeft(data[i+1:], " \t"), true
+}
+
+func isServiceNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' ||
+		b >= 'A' && b <= 'Z' ||
+		b >= '0' && b <= '9' ||
+		b == '_' || b == '-' || b == '.'
+}
+
+// peelTimestamp peels a timestamp of a few words off a line, trying the
+// longest candidates first.
+func peelTimestamp(data []byte) (f Field, rest []byte, ok bool) {
+	if len(data) == 0 || !isServiceNameByte(data[0]) {
+		return nil, nil, false
+	}
+	var ends []int
+	for i := 0; i < len(data) && i < maxPrefixLen && len(ends) < maxTimeWords; i++ {
+		if data[i] == ' ' {
+			ends = append(ends, i)
+		}
+	}
+	for i := len(ends) - 1; i >= 0; i-- {
+		end := ends[i]
+		t, err := tryParseTime(string(data[:end]))
+		if err == nil {
+			return TimeField{t}, bytes.TrimLeft(data[end:], " \t"), true
+		}
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanParsePrefixedLines(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  map[string]Field
+	}{
+		{
+			input: `2014-10-27T18:38:45Z {"level":"info","msg":"hello"}`,
+			want: map[string]Field{
+				"level": StringField("info"),
+				"msg":   StringField("hello"),
+				"time":  TimeField{time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC)},
+			},
+		},
+		{
+			input: `2014-10-27 18:38:45.123 level=info msg=hello`,
+			want: map[string]Field{
+				"level": StringField("info"),
+				"msg":   StringField("hello"),
+				"time":  TimeField{time.Date(2014, 10, 27, 18, 38, 45, 123000000, time.UTC)},
+			},
+		},
+		{
+			// the entry's own time wins over the prefix
+			input: `2014-10-27T18:38:45Z time="2014-10-27T18:38:44Z" msg=hello`,
+			want: map[string]Field{
+				"msg":  StringField("hello"),
+				"time": TimeField{time.Date(2014, 10, 27, 18, 38, 44, 0, time.UTC)},
+			},
+		},
+		{
+			input: `web_1  | level=info msg=hello`,
+			want: map[string]Field{
+				"level":   StringField("info"),
+				"msg":     StringField("hello"),
+				"service": StringField("web_1"),
+			},
+		},
+		{
+			input: `[pod/foo] {"level":"info","msg":"hello"}`,
+			want: map[string]Field{
+				"level": StringField("info"),
+				"msg":   StringField("hello"),
+				"tag":   StringField("pod/foo"),
+			},
+		},
+		{
+			input: `api.1 | 2014-10-27T18:38:45Z [worker] {"msg":"stacked"}`,
+			want: map[string]Field{
+				"msg":     StringField("stacked"),
+				"service": StringField("api.1"),
+				"tag":     StringField("worker"),
+				"time":    TimeField{time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC)},
+			},
+		},
+		{
+			// nothing structured after the prefixes, keep it all raw
+			input: `[worker] 2014/10/27 18:38:45 something happened`,
+			want: map[string]Field{
+				DefaultRaw: RawField(`[worker] 2014/10/27 18:38:45 something happened`),
+			},
+		},
+		{
+			input: `web_1 |level=info`,
+			want: map[string]Field{
+				DefaultRaw: RawField(`web_1 |level=info`),
+			},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Logf("test %d", n)
+		canParseTestTable(t, tt.input, tt.want)
+	}
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+)
+
+const (
+	// below this many bytes, the binary search gives way to a scan
+	seekScanSize = 64 << 10
+	// how many lines without a timestamp to skip when probing for one
+	seekMaxUntimed = 1000
+)
+
+// SeekTime finds the offset of the first line of r whose entry is at or
+// after t, assuming that entries are sorted by time. It probes the lines
+// at the middle of shrinking ranges of r, so it reads O(log n) lines.
+// Lines without a timestamp, like stack traces, go with the entry before
+// them.
+func SeekTime(r io.ReaderAt, size int64, t time.Time) (int64, error) {
+	lo, hi := int64(0), size
+	for hi-lo > seekScanSize {
+		mid := lo + (hi-lo)/2
+		start, lineTime, found, err := nextTimedLine(r, size, mid)
+		if err != nil {
+			return 0, err
+		}
+		if found && lineTime.Before(t) {
+			// everything up to that line is before t
+			lo = start
+		} else {
+			hi = mid
+		}
+	}
+	return scanForTime(r, size, lo, t)
+}
+
+// nextTimedLine finds the first line that starts after `from` and has a
+// timestamp.
+func nextTimedLine(r io.ReaderAt, size, from int64) (start int64, t time.Time, found bool, err error) {
+	rd := bufio.NewReader(io.NewSectionReader(r, from, size-from))
+	off := from
+	if from > 0 {
+		// `from` is likely in the middle of a line, skip to the next
+		skipped, err := rd.ReadSlice('\n')
+		off += int64(len(skipped))
+		if err != nil && err != bufio.ErrBufferFull {
+			return off, t, false, ignoreEOF(err)
+		}
+		for err == bufio.ErrBufferFull {
+			skipped, err = rd.ReadSlice('\n')
+			off += int64(len(skipped))
+		}
+		if err != nil {
+			return off, t, false, ignoreEOF(err)
+		}
+	}
+	for i := 0; i < seekMaxUntimed; i++ {
+		line, err := rd.ReadBytes('\n')
+		if len(line) > 0 {
+			if lt, ok := ParseLine(trimEOL(line)).Time(); ok {
+				return off, lt, true, nil
+			}
+		}
+		off += int64(len(line))
+		if err != nil {
+			return off, t, false, ignoreEOF(err)
+		}
+	}
+	return off, t, false, nil
+}
+
+// scanForTime reads the lines from `from` until one is at or after t.
+func scanForTime(r io.ReaderAt, size, from int64, t time.Time) (int64, error) {
+	rd := bufio.NewReader(io.NewSectionReader(r, from, size-from))
+	off := from
+	for {
+		line, err := rd.ReadBytes('\n')
+		if len(line) > 0 {
+			if lt, ok := ParseLine(trimEOL(line)).Time(); ok && !lt.Before(t) {
+				return off, nil
+			}
+		}
+		off += int64(len(line))
+		if err == io.EOF {
+			return size, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func trimEOL(line []byte) []byte {
+	return bytes.TrimRight(line, "\r\n")
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
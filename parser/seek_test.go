@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type countingReaderAt struct {
+	r     *bytes.Reader
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.r.ReadAt(p, off)
+}
+
+func TestSeekTime(t *testing.T) {
+	start := time.Date(2014, 10, 27, 18, 0, 0, 0, time.UTC)
+	buf := bytes.NewBuffer(nil)
+	var offsets []int64
+	const lines = 200000
+	for i := 0; i < lines; i++ {
+		offsets = append(offsets, int64(buf.Len()))
+		if i%10 == 9 {
+			// lines without a timestamp go with the one before
+			fmt.Fprintf(buf, "\tat some.stack.Frame(%d)\n", i)
+			continue
+		}
+		ts := start.Add(time.Duration(i) * time.Second).Format(time.RFC3339)
+		switch i % 3 {
+		case 0:
+			fmt.Fprintf(buf, `{"time":%q,"msg":"line %d"}`+"\n", ts, i)
+		case 1:
+			fmt.Fprintf(buf, "time=%s msg=\"line %d\"\n", ts, i)
+		case 2:
+			fmt.Fprintf(buf, "%s level=info n=%d\n", ts, i)
+		}
+	}
+	data := buf.Bytes()
+
+	var tests = []struct {
+		at   time.Time
+		want int64
+	}{
+		{at: start.Add(-time.Hour), want: 0},
+		{at: start, want: offsets[0]},
+		{at: start.Add(12345 * time.Second), want: offsets[12345]},
+		{at: start.Add(12345*time.Second + time.Millisecond), want: offsets[12346]},
+		// line 19 has no time, it goes with line 18
+		{at: start.Add(19 * time.Second), want: offsets[20]},
+		{at: start.Add(lines * time.Second), want: int64(len(data))},
+	}
+
+	for _, tt := range tests {
+		r := &countingReaderAt{r: bytes.NewReader(data)}
+		got, err := SeekTime(r, int64(len(data)), tt.at)
+		if err != nil {
+			t.Fatalf("seeking %v: %v", tt.at, err)
+		}
+		if got != tt.want {
+			t.Errorf("seeking %v: want offset %d, got %d", tt.at, tt.want, got)
+		}
+		// each probe takes a couple of reads
+		if r.reads > 200 {
+			t.Errorf("seeking %v: took %d reads, want O(log n)", tt.at, r.reads)
+		}
+	}
+}
@@ -7,8 +7,12 @@ import (
 
 var formats = []string{
 	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999",
+	"2006/01/02 15:04:05.999999999",
 	time.RFC3339,
 	time.RFC3339Nano,
+	// common log format, of web servers
+	"02/Jan/2006:15:04:05 -0700",
 	time.RFC822,
 	time.RFC822Z,
 	time.RFC850,
@@ -24,6 +28,12 @@ var formats = []string{
 	time.StampNano,
 }
 
+// AddTimeLayouts makes times in these layouts parsed, before those that
+// are known. It's done before entries are parsed.
+func AddTimeLayouts(layouts ...string) {
+	formats = append(append([]string{}, layouts...), formats...)
+}
+
 // tries to parse time using a couple of formats before giving up
 func tryParseTime(value string) (time.Time, error) {
 	var t time.Time
@@ -36,3 +46,34 @@ func tryParseTime(value string) (time.Time, error) {
 	}
 	return t, fmt.Errorf("couldn't find a format to parse a time.Time from %q", value)
 }
+
+// shorter layouts that people type, read in the local time zone
+var argFormats = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"15:04:05",
+	"15:04",
+}
+
+// ParseTime reads a time as it would be found in an entry or as a user
+// would type it, like `2014-10-27T18:38`. Times without a date are for
+// today, and times without a zone are local.
+func ParseTime(value string) (time.Time, error) {
+	if t, err := tryParseTime(value); err == nil {
+		return t, nil
+	}
+	for _, layout := range argFormats {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			now := time.Now()
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("couldn't find a format to parse a time.Time from %q", value)
+}
@@ -0,0 +1,22 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddTimeLayouts(t *testing.T) {
+	defer func(saved []string) { formats = saved }(formats)
+	const value = "27.10.2014 18h38"
+	if _, err := tryParseTime(value); err == nil {
+		t.Fatalf("%q shouldn't parse before its layout is added", value)
+	}
+	AddTimeLayouts("02.01.2006 15h04")
+	got, err := tryParseTime(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2014, 10, 27, 18, 38, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,316 @@
+package query
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Aggregator summarizes the entries that reach the end of a query in a
+// table.
+type Aggregator interface {
+	Add(e *parser.Entry)
+	Table() *Table
+}
+
+// groups keeps a value per distinct combination of the `by` fields.
+type groups struct {
+	by    []string
+	keys  map[string]int
+	vals  [][]string
+	state []interface{}
+}
+
+func newGroups(by []string) *groups {
+	return &groups{by: by, keys: make(map[string]int)}
+}
+
+// get the index of the group of e, creating it with mk if it's new.
+func (g *groups) get(e *parser.Entry, mk func() interface{}) interface{} {
+	vals := make([]string, len(g.by))
+	for i, name := range g.by {
+		if f, ok := e.Field(name); ok {
+			vals[i] = formatField(f)
+		}
+	}
+	key := strings.Join(vals, "\x00")
+	i, ok := g.keys[key]
+	if !ok {
+		i = len(g.vals)
+		g.keys[key] = i
+		g.vals = append(g.vals, vals)
+		g.state = append(g.state, mk())
+	}
+	return g.state[i]
+}
+
+func groupValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// count: `count by level, service`
+
+type countAgg struct {
+	groups *groups
+}
+
+func newCount(by []string) *countAgg {
+	return &countAgg{groups: newGroups(by)}
+}
+
+func (c *countAgg) Add(e *parser.Entry) {
+	n := c.groups.get(e, func() interface{} { return new(int) }).(*int)
+	*n++
+}
+
+func (c *countAgg) Table() *Table {
+	t := &Table{Columns: append(append([]string{}, c.groups.by...), "count")}
+	counts := make([]float64, len(c.groups.vals))
+	for i, vals := range c.groups.vals {
+		n := *c.groups.state[i].(*int)
+		counts[i] = float64(n)
+		row := make([]string, 0, len(vals)+1)
+		for _, v := range vals {
+			row = append(row, groupValue(v))
+		}
+		t.Rows = append(t.Rows, append(row, strconv.Itoa(n)))
+	}
+	sortRowsBy(t.Rows, counts)
+	return t
+}
+
+// top: `top 10 path`
+
+type topAgg struct {
+	n     int
+	count *countAgg
+	total int
+}
+
+func newTop(n int, fields []string) *topAgg {
+	return &topAgg{n: n, count: newCount(fields)}
+}
+
+func (t *topAgg) Add(e *parser.Entry) {
+	t.total++
+	t.count.Add(e)
+}
+
+func (t *topAgg) Table() *Table {
+	tbl := t.count.Table()
+	if len(tbl.Rows) > t.n {
+		tbl.Rows = tbl.Rows[:t.n]
+	}
+	tbl.Columns = append(tbl.Columns, "percent")
+	for i, row := range tbl.Rows {
+		n, _ := strconv.Atoi(row[len(row)-1])
+		tbl.Rows[i] = append(row, fmt.Sprintf("%.1f%%", 100*float64(n)/float64(t.total)))
+	}
+	return tbl
+}
+
+// stats: `stats p50(latency) p99(latency) by route`
+
+type statFunc struct {
+	name  string
+	field string
+	// the percentile for pNN functions
+	pct float64
+}
+
+func (s statFunc) column() string {
+	if s.field == "" {
+		return s.name
+	}
+	return s.name + "(" + s.field + ")"
+}
+
+func parseStatFunc(name string) (statFunc, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "count", "sum", "avg", "min", "max":
+		return statFunc{name: name}, nil
+	case "median":
+		return statFunc{name: name, pct: 50}, nil
+	}
+	if strings.HasPrefix(name, "p") {
+		if pct, err := strconv.ParseFloat(name[1:], 64); err == nil && pct >= 0 && pct <= 100 {
+			return statFunc{name: name, pct: pct}, nil
+		}
+	}
+	return statFunc{}, fmt.Errorf("unknown function %q", name)
+}
+
+type statsAgg struct {
+	funcs  []statFunc
+	groups *groups
+}
+
+func newStats(funcs []statFunc, by []string) *statsAgg {
+	return &statsAgg{funcs: funcs, groups: newGroups(by)}
+}
+
+func (s *statsAgg) Add(e *parser.Entry) {
+	accs := s.groups.get(e, func() interface{} {
+		accs := make([]*accumulator, len(s.funcs))
+		for i := range accs {
+			accs[i] = &accumulator{}
+		}
+		return accs
+	}).([]*accumulator)
+	for i, fn := range s.funcs {
+		if fn.field == "" {
+			accs[i].values++
+			continue
+		}
+		if f, ok := e.Field(fn.field); ok {
+			accs[i].add(f)
+		}
+	}
+}
+
+func (s *statsAgg) Table() *Table {
+	t := &Table{Columns: append([]string{}, s.groups.by...)}
+	for _, fn := range s.funcs {
+		t.Columns = append(t.Columns, fn.column())
+	}
+	// the slowest, or largest, groups first
+	order := make([]float64, len(s.groups.vals))
+	for i, vals := range s.groups.vals {
+		accs := s.groups.state[i].([]*accumulator)
+		row := make([]string, 0, len(t.Columns))
+		for _, v := range vals {
+			row = append(row, groupValue(v))
+		}
+		for j, fn := range s.funcs {
+			v, ok := accs[j].result(fn)
+			if j == 0 {
+				order[i] = v
+			}
+			if !ok {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, formatNumeric(v, accs[j].resultKind(fn)))
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	sortRowsBy(t.Rows, order)
+	return t
+}
+
+// past that many values, percentiles are estimated from a uniform sample
+const maxSamples = 1 << 14
+
+// accumulator aggregates the numbers, or durations, of a field.
+type accumulator struct {
+	// values of any type, for count
+	values int
+	kind   valueKind
+	// numbers, or durations, for the other functions
+	count    int
+	sum      float64
+	min, max float64
+	samples  []float64
+	sorted   bool
+}
+
+func (a *accumulator) add(f parser.Field) {
+	switch f.(type) {
+	case nil, parser.NilField:
+		return
+	}
+	a.values++
+	v, kind, ok := numeric(f)
+	if !ok {
+		return
+	}
+	if a.kind == kindNone {
+		a.kind = kind
+	} else if a.kind != kind {
+		// can't aggregate durations with numbers
+		return
+	}
+	a.count++
+	a.sum += v
+	if a.count == 1 || v < a.min {
+		a.min = v
+	}
+	if a.count == 1 || v > a.max {
+		a.max = v
+	}
+	a.sorted = false
+	if len(a.samples) < maxSamples {
+		a.samples = append(a.samples, v)
+	} else if i := rand.Intn(a.count); i < maxSamples {
+		// reservoir sampling: every value seen so far has the same
+		// chance of being in the sample
+		a.samples[i] = v
+	}
+}
+
+func (a *accumulator) result(fn statFunc) (float64, bool) {
+	switch fn.name {
+	case "count":
+		return float64(a.values), true
+	}
+	if a.count == 0 {
+		return 0, false
+	}
+	switch fn.name {
+	case "sum":
+		return a.sum, true
+	case "avg":
+		return a.sum / float64(a.count), true
+	case "min":
+		return a.min, true
+	case "max":
+		return a.max, true
+	}
+	return a.percentile(fn.pct), true
+}
+
+func (a *accumulator) resultKind(fn statFunc) valueKind {
+	if fn.name == "count" {
+		return kindNumber
+	}
+	return a.kind
+}
+
+// percentile by the nearest rank.
+func (a *accumulator) percentile(pct float64) float64 {
+	if !a.sorted {
+		sort.Float64s(a.samples)
+		a.sorted = true
+	}
+	rank := int(math.Ceil(pct/100*float64(len(a.samples)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return a.samples[rank]
+}
+
+// sortRowsBy sorts the rows by decreasing weight, keeping the order in
+// which they were first seen for equal weights.
+func sortRowsBy(rows [][]string, weights []float64) {
+	sort.Stable(byWeight{rows: rows, weights: weights})
+}
+
+type byWeight struct {
+	rows    [][]string
+	weights []float64
+}
+
+func (b byWeight) Len() int           { return len(b.rows) }
+func (b byWeight) Less(i, j int) bool { return b.weights[i] > b.weights[j] }
+func (b byWeight) Swap(i, j int) {
+	b.rows[i], b.rows[j] = b.rows[j], b.rows[i]
+	b.weights[i], b.weights[j] = b.weights[j], b.weights[i]
+}
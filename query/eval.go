@@ -0,0 +1,455 @@
+package query
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// evalStage sets fields to the values of expressions, like
+// `eval latency_ms = latency / 1ms, slow = latency > 1s`. Each expression
+// sees the fields set before it.
+type evalStage struct {
+	names []string
+	exprs []expr
+}
+
+func parseEval(text string) (evalStage, error) {
+	var s evalStage
+	toks, err := lexExpr(text)
+	if err != nil {
+		return s, err
+	}
+	t := &tokens{toks: toks}
+	for {
+		name, err := t.value("a field to set")
+		if err != nil {
+			return s, err
+		}
+		if !t.accept("=") {
+			return s, fmt.Errorf("want `=` after %s, got %v", name, t.peek())
+		}
+		x, err := parseExpr(t)
+		if err != nil {
+			return s, fmt.Errorf("%s: %v", name, err)
+		}
+		s.names = append(s.names, name)
+		s.exprs = append(s.exprs, x)
+		if t.done() {
+			return s, nil
+		}
+		if !t.accept(",") {
+			return s, fmt.Errorf("unexpected %v", t.peek())
+		}
+	}
+}
+
+func (s evalStage) Apply(e *parser.Entry) (*parser.Entry, bool) {
+	e = e.Clone()
+	for i, x := range s.exprs {
+		e.Set(s.names[i], field(x.eval(e)))
+	}
+	return e, true
+}
+
+type arithExpr struct {
+	op   string
+	x, y expr
+}
+
+func (x arithExpr) eval(e *parser.Entry) value {
+	return arith(x.op, field(x.x.eval(e)), field(x.y.eval(e)))
+}
+
+// arith applies an operator to numbers, durations and times, the way
+// their units allow: times differ by durations, durations divide into
+// numbers, and so on. `+` joins strings. Anything else is null.
+func arith(op string, x, y parser.Field) parser.Field {
+	switch x := x.(type) {
+	case parser.NumberField:
+		switch y := y.(type) {
+		case parser.NumberField:
+			return arithNumbers(op, float64(x), float64(y))
+		case parser.DurationField:
+			if op == "*" {
+				return duration(float64(x) * float64(y.Duration))
+			}
+		}
+	case parser.DurationField:
+		switch y := y.(type) {
+		case parser.DurationField:
+			switch op {
+			case "+", "-", "%":
+				if n, ok := arithNumbers(op, float64(x.Duration), float64(y.Duration)).(parser.NumberField); ok {
+					return duration(float64(n))
+				}
+			case "/":
+				return arithNumbers(op, float64(x.Duration), float64(y.Duration))
+			}
+		case parser.NumberField:
+			if op == "*" || op == "/" {
+				if n, ok := arithNumbers(op, float64(x.Duration), float64(y)).(parser.NumberField); ok {
+					return duration(float64(n))
+				}
+			}
+		case parser.TimeField:
+			if op == "+" {
+				return parser.TimeField{Time: y.Add(x.Duration)}
+			}
+		}
+	case parser.TimeField:
+		switch y := y.(type) {
+		case parser.TimeField:
+			if op == "-" {
+				return parser.DurationField{Duration: x.Sub(y.Time)}
+			}
+		case parser.DurationField:
+			switch op {
+			case "+":
+				return parser.TimeField{Time: x.Add(y.Duration)}
+			case "-":
+				return parser.TimeField{Time: x.Add(-y.Duration)}
+			}
+		}
+	}
+	if op == "+" && (isText(x) || isText(y)) && !isNull(x) && !isNull(y) {
+		return parser.StringField(formatField(x) + formatField(y))
+	}
+	return parser.NilField{}
+}
+
+func arithNumbers(op string, x, y float64) parser.Field {
+	switch op {
+	case "+":
+		return parser.NumberField(x + y)
+	case "-":
+		return parser.NumberField(x - y)
+	case "*":
+		return parser.NumberField(x * y)
+	case "/":
+		if y != 0 {
+			return parser.NumberField(x / y)
+		}
+	case "%":
+		if y != 0 {
+			return parser.NumberField(math.Mod(x, y))
+		}
+	}
+	return parser.NilField{}
+}
+
+func duration(ns float64) parser.Field {
+	return parser.DurationField{Duration: time.Duration(math.Round(ns))}
+}
+
+func isText(f parser.Field) bool {
+	switch f.(type) {
+	case parser.StringField, parser.RawField:
+		return true
+	}
+	return false
+}
+
+func isNull(f parser.Field) bool {
+	_, ok := f.(parser.NilField)
+	return ok || f == nil
+}
+
+// function of expressions. Lazy ones get their arguments unevaluated, to
+// evaluate only those they need.
+type function struct {
+	usage    string
+	min, max int
+	call     func(args []value) value
+	lazy     func(e *parser.Entry, args []expr) value
+}
+
+var funcs = map[string]function{
+	"if":       {usage: "if(cond, then, else)", min: 2, max: 3, lazy: evalIf},
+	"case":     {usage: "case(cond, value, ..., default)", min: 2, max: -1, lazy: evalCase},
+	"coalesce": {usage: "coalesce(a, b, ...)", min: 1, max: -1, lazy: evalCoalesce},
+
+	"lower":      stringFunc("lower(s)", strings.ToLower),
+	"upper":      stringFunc("upper(s)", strings.ToUpper),
+	"trim":       stringFunc("trim(s)", strings.TrimSpace),
+	"len":        {usage: "len(s)", min: 1, max: 1, call: evalLen},
+	"substr":     {usage: "substr(s, start, length)", min: 2, max: 3, call: evalSubstr},
+	"replace":    {usage: "replace(s, old, new)", min: 3, max: 3, call: evalReplace},
+	"split":      {usage: "split(s, sep)", min: 2, max: 2, call: evalSplit},
+	"join":       {usage: "join(list, sep)", min: 2, max: 2, call: evalJoin},
+	"contains":   stringTest("contains(s, substr)", strings.Contains),
+	"startswith": stringTest("startswith(s, prefix)", strings.HasPrefix),
+	"endswith":   stringTest("endswith(s, suffix)", strings.HasSuffix),
+	"match":      {usage: "match(s, regexp)", min: 2, max: 2, call: evalMatch},
+
+	"tostring":   {usage: "tostring(x)", min: 1, max: 1, call: evalToString},
+	"tonumber":   {usage: "tonumber(x)", min: 1, max: 1, call: evalToNumber},
+	"toduration": {usage: "toduration(x)", min: 1, max: 1, call: evalToDuration},
+	"totime":     {usage: "totime(x)", min: 1, max: 1, call: evalToTime},
+
+	"abs":   mathFunc("abs(x)", math.Abs),
+	"floor": mathFunc("floor(x)", math.Floor),
+	"ceil":  mathFunc("ceil(x)", math.Ceil),
+	"round": {usage: "round(x, digits)", min: 1, max: 2, call: evalRound},
+	"now":   {usage: "now()", min: 0, max: 0, call: func([]value) value { return parser.TimeField{Time: time.Now()} }},
+
+	// read fields whose names aren't words, handled by parseCall
+	"field": {usage: `field("name")`, min: 1, max: 1},
+}
+
+func evalIf(e *parser.Entry, args []expr) value {
+	if truthy(args[0].eval(e)) {
+		return args[1].eval(e)
+	}
+	if len(args) == 3 {
+		return args[2].eval(e)
+	}
+	return parser.NilField{}
+}
+
+func evalCase(e *parser.Entry, args []expr) value {
+	for i := 0; i+1 < len(args); i += 2 {
+		if truthy(args[i].eval(e)) {
+			return args[i+1].eval(e)
+		}
+	}
+	if len(args)%2 == 1 {
+		return args[len(args)-1].eval(e)
+	}
+	return parser.NilField{}
+}
+
+func evalCoalesce(e *parser.Entry, args []expr) value {
+	for _, arg := range args {
+		if v := arg.eval(e); !isNull(field(v)) {
+			return v
+		}
+	}
+	return parser.NilField{}
+}
+
+// text of a value for string functions. Null has none.
+func text(v value) (string, bool) {
+	f := field(v)
+	if isNull(f) {
+		return "", false
+	}
+	return formatField(f), true
+}
+
+func stringFunc(usage string, fn func(string) string) function {
+	return function{usage: usage, min: 1, max: 1, call: func(args []value) value {
+		s, ok := text(args[0])
+		if !ok {
+			return parser.NilField{}
+		}
+		return parser.StringField(fn(s))
+	}}
+}
+
+func stringTest(usage string, fn func(s, sub string) bool) function {
+	return function{usage: usage, min: 2, max: 2, call: func(args []value) value {
+		s, ok := text(args[0])
+		sub, subOK := text(args[1])
+		return parser.BooleanField(ok && subOK && fn(s, sub))
+	}}
+}
+
+func mathFunc(usage string, fn func(float64) float64) function {
+	return function{usage: usage, min: 1, max: 1, call: func(args []value) value {
+		switch x := args[0].(type) {
+		case parser.NumberField:
+			return parser.NumberField(fn(float64(x)))
+		case parser.DurationField:
+			return duration(fn(float64(x.Duration)))
+		}
+		return parser.NilField{}
+	}}
+}
+
+func evalLen(args []value) value {
+	if l, ok := args[0].(list); ok {
+		return parser.NumberField(len(l))
+	}
+	s, ok := text(args[0])
+	if !ok {
+		return parser.NilField{}
+	}
+	return parser.NumberField(len([]rune(s)))
+}
+
+func evalSubstr(args []value) value {
+	s, ok := text(args[0])
+	start, startOK := args[1].(parser.NumberField)
+	if !ok || !startOK {
+		return parser.NilField{}
+	}
+	runes := []rune(s)
+	i := clamp(int(start), len(runes))
+	j := len(runes)
+	if len(args) == 3 {
+		n, ok := args[2].(parser.NumberField)
+		if !ok {
+			return parser.NilField{}
+		}
+		j = clamp(i+int(n), len(runes))
+	}
+	if j < i {
+		j = i
+	}
+	return parser.StringField(runes[i:j])
+}
+
+// clamp an index to [0, n], counting from the end when negative.
+func clamp(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	switch {
+	case i < 0:
+		return 0
+	case i > n:
+		return n
+	}
+	return i
+}
+
+func evalReplace(args []value) value {
+	s, ok := text(args[0])
+	old, oldOK := text(args[1])
+	repl, replOK := text(args[2])
+	if !ok || !oldOK || !replOK {
+		return parser.NilField{}
+	}
+	return parser.StringField(strings.Replace(s, old, repl, -1))
+}
+
+func evalSplit(args []value) value {
+	s, ok := text(args[0])
+	sep, sepOK := text(args[1])
+	if !ok || !sepOK {
+		return parser.NilField{}
+	}
+	parts := strings.Split(s, sep)
+	l := make(list, len(parts))
+	for i, p := range parts {
+		l[i] = parser.StringField(p)
+	}
+	return l
+}
+
+func evalJoin(args []value) value {
+	sep, ok := text(args[1])
+	l, isList := args[0].(list)
+	if !ok || !isList {
+		return parser.NilField{}
+	}
+	items := make([]string, len(l))
+	for i, f := range l {
+		items[i] = formatField(f)
+	}
+	return parser.StringField(strings.Join(items, sep))
+}
+
+// evalMatch compiles patterns that aren't literals for each entry, those
+// that are were compiled by parseCall.
+func evalMatch(args []value) value {
+	s, ok := text(args[0])
+	pattern, patternOK := text(args[1])
+	if !ok || !patternOK {
+		return parser.BooleanField(false)
+	}
+	re, err := regexp.Compile(pattern)
+	return parser.BooleanField(err == nil && re.MatchString(s))
+}
+
+// matchExpr is match of a literal pattern.
+type matchExpr struct {
+	s  expr
+	re *regexp.Regexp
+}
+
+func (m matchExpr) eval(e *parser.Entry) value {
+	s, ok := text(m.s.eval(e))
+	return parser.BooleanField(ok && m.re.MatchString(s))
+}
+
+func evalToString(args []value) value {
+	s, ok := text(args[0])
+	if !ok {
+		return parser.NilField{}
+	}
+	return parser.StringField(s)
+}
+
+func evalToNumber(args []value) value {
+	switch x := field(args[0]).(type) {
+	case parser.NumberField:
+		return x
+	case parser.BooleanField:
+		if x {
+			return parser.NumberField(1)
+		}
+		return parser.NumberField(0)
+	case parser.StringField, parser.RawField:
+		if v, err := strconv.ParseFloat(strings.TrimSpace(formatField(x)), 64); err == nil {
+			return parser.NumberField(v)
+		}
+	}
+	return parser.NilField{}
+}
+
+// evalToDuration reads strings like `1m30s`, and numbers as seconds.
+func evalToDuration(args []value) value {
+	switch x := field(args[0]).(type) {
+	case parser.DurationField:
+		return x
+	case parser.NumberField:
+		return duration(float64(x) * float64(time.Second))
+	case parser.StringField, parser.RawField:
+		if d, err := time.ParseDuration(strings.TrimSpace(formatField(x))); err == nil {
+			return parser.DurationField{Duration: d}
+		}
+	}
+	return parser.NilField{}
+}
+
+// evalToTime reads strings in the layouts of timestamps, and numbers as
+// seconds since the epoch.
+func evalToTime(args []value) value {
+	switch x := field(args[0]).(type) {
+	case parser.TimeField:
+		return x
+	case parser.NumberField:
+		sec, frac := math.Modf(float64(x))
+		return parser.TimeField{Time: time.Unix(int64(sec), int64(frac*1e9))}
+	case parser.StringField, parser.RawField:
+		if t, err := parser.ParseTime(strings.TrimSpace(formatField(x))); err == nil {
+			return parser.TimeField{Time: t}
+		}
+	}
+	return parser.NilField{}
+}
+
+func evalRound(args []value) value {
+	digits := 0.0
+	if len(args) == 2 {
+		d, ok := args[1].(parser.NumberField)
+		if !ok {
+			return parser.NilField{}
+		}
+		digits = float64(d)
+	}
+	scale := math.Pow(10, digits)
+	switch x := args[0].(type) {
+	case parser.NumberField:
+		return parser.NumberField(math.Round(float64(x)*scale) / scale)
+	case parser.DurationField:
+		return duration(math.Round(float64(x.Duration)*scale) / scale)
+	}
+	return parser.NilField{}
+}
@@ -0,0 +1,415 @@
+package query
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// expr is an expression of an eval stage, like `latency / 1ms` or
+// `if(status >= 500, "error", "ok")`. Its values are fields, and lists of
+// them for functions like split.
+type expr interface {
+	eval(e *parser.Entry) value
+}
+
+// value of an expression: a field, or a list.
+type value interface{}
+
+type list []parser.Field
+
+// the operators of expressions, longest first
+var exprOps = []string{"==", "!=", "<=", ">=", "=", "<", ">", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", "!"}
+
+// lexExpr splits an expression in words, quoted strings and operators.
+// Unlike in filters, `+ - * / %` are operators, so field names with a
+// dash must be quoted with field("a-b").
+func lexExpr(text string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(text); {
+		r, sz := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += sz
+		case r == '"':
+			end := i + 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(text) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s, err := strconv.Unquote(text[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", i, err)
+			}
+			toks = append(toks, token{kind: tokString, text: s, pos: i})
+			i = end + 1
+		case isWordRune(r):
+			end := i
+			for end < len(text) {
+				r, sz := utf8.DecodeRuneInString(text[end:])
+				if !isWordRune(r) {
+					break
+				}
+				end += sz
+			}
+			toks = append(toks, token{kind: tokWord, text: text[i:end], pos: i})
+			i = end
+		default:
+			op := ""
+			for _, o := range exprOps {
+				if strings.HasPrefix(text[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '@'
+}
+
+// parseExpr reads an expression, by precedence climbing:
+//
+//	or  and  not  comparisons  + -  * / %  unary -  [index]
+func parseExpr(t *tokens) (expr, error) { return parseOr(t) }
+
+func parseOr(t *tokens) (expr, error) {
+	x, err := parseAnd(t)
+	for err == nil && t.acceptWord("or") {
+		var y expr
+		if y, err = parseAnd(t); err == nil {
+			x = logicExpr{op: "or", x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func parseAnd(t *tokens) (expr, error) {
+	x, err := parseNot(t)
+	for err == nil && t.acceptWord("and") {
+		var y expr
+		if y, err = parseNot(t); err == nil {
+			x = logicExpr{op: "and", x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func parseNot(t *tokens) (expr, error) {
+	if t.acceptWord("not") || t.accept("!") {
+		x, err := parseNot(t)
+		return notExpr{x}, err
+	}
+	return parseComparison(t)
+}
+
+func parseComparison(t *tokens) (expr, error) {
+	x, err := parseSum(t)
+	if err != nil || !isComparison(t.peek()) {
+		return x, err
+	}
+	op := t.next().text
+	if op == "==" {
+		op = "="
+	}
+	y, err := parseSum(t)
+	return compareExpr{op: op, x: x, y: y}, err
+}
+
+func parseSum(t *tokens) (expr, error) {
+	x, err := parseProduct(t)
+	for err == nil && (t.peek().is(tokOp, "+") || t.peek().is(tokOp, "-")) {
+		op := t.next().text
+		var y expr
+		if y, err = parseProduct(t); err == nil {
+			x = arithExpr{op: op, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func parseProduct(t *tokens) (expr, error) {
+	x, err := parseUnary(t)
+	for err == nil && (t.peek().is(tokOp, "*") || t.peek().is(tokOp, "/") || t.peek().is(tokOp, "%")) {
+		op := t.next().text
+		var y expr
+		if y, err = parseUnary(t); err == nil {
+			x = arithExpr{op: op, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func parseUnary(t *tokens) (expr, error) {
+	if t.accept("-") {
+		x, err := parseUnary(t)
+		return negExpr{x: x}, err
+	}
+	x, err := parsePrimary(t)
+	for err == nil && t.accept("[") {
+		var i expr
+		if i, err = parseExpr(t); err != nil {
+			break
+		}
+		if !t.accept("]") {
+			return nil, fmt.Errorf("want `]`, got %v", t.peek())
+		}
+		x = indexExpr{x: x, i: i}
+	}
+	return x, err
+}
+
+func parsePrimary(t *tokens) (expr, error) {
+	tok := t.next()
+	switch {
+	case tok.kind == tokString:
+		return literal{parser.StringField(tok.text)}, nil
+	case tok.is(tokOp, "("):
+		x, err := parseExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		if !t.accept(")") {
+			return nil, fmt.Errorf("want `)`, got %v", t.peek())
+		}
+		return x, nil
+	case tok.kind == tokOp:
+		return nil, fmt.Errorf("want a value, got %v", tok)
+	}
+	if t.accept("(") {
+		return parseCall(t, tok.text)
+	}
+	switch strings.ToLower(tok.text) {
+	case "true":
+		return literal{parser.BooleanField(true)}, nil
+	case "false":
+		return literal{parser.BooleanField(false)}, nil
+	case "null", "nil":
+		return literal{parser.NilField{}}, nil
+	}
+	if r, _ := utf8.DecodeRuneInString(tok.text); unicode.IsDigit(r) || r == '.' {
+		if v, err := strconv.ParseFloat(tok.text, 64); err == nil {
+			return literal{parser.NumberField(v)}, nil
+		}
+		if v, err := time.ParseDuration(tok.text); err == nil {
+			return literal{parser.DurationField{Duration: v}}, nil
+		}
+		return nil, fmt.Errorf("invalid number %q", tok.text)
+	}
+	return fieldExpr(tok.text), nil
+}
+
+func parseCall(t *tokens, name string) (expr, error) {
+	name = strings.ToLower(name)
+	fn, ok := funcs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	var args []expr
+	for !t.accept(")") {
+		if len(args) > 0 && !t.accept(",") {
+			return nil, fmt.Errorf("want `,` or `)`, got %v", t.peek())
+		}
+		arg, err := parseExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, fmt.Errorf("%s: wrong number of arguments, want %s", name, fn.usage)
+	}
+	if name == "field" {
+		if lit, ok := args[0].(literal); ok {
+			if s, ok := lit.v.(parser.StringField); ok {
+				return fieldExpr(s), nil
+			}
+		}
+		return nil, fmt.Errorf("field needs a quoted name, like field(\"x-request-id\")")
+	}
+	if name == "match" {
+		if lit, ok := args[1].(literal); ok {
+			if pattern, ok := lit.v.(parser.StringField); ok {
+				re, err := regexp.Compile(string(pattern))
+				if err != nil {
+					return nil, fmt.Errorf("match: %v", err)
+				}
+				return matchExpr{s: args[0], re: re}, nil
+			}
+		}
+	}
+	return callExpr{fn: fn, args: args}, nil
+}
+
+type literal struct{ v value }
+
+func (l literal) eval(*parser.Entry) value { return l.v }
+
+type fieldExpr string
+
+func (f fieldExpr) eval(e *parser.Entry) value {
+	if v, ok := e.Field(string(f)); ok {
+		return v
+	}
+	return parser.NilField{}
+}
+
+type logicExpr struct {
+	op   string
+	x, y expr
+}
+
+func (l logicExpr) eval(e *parser.Entry) value {
+	x := truthy(l.x.eval(e))
+	if l.op == "and" && !x || l.op == "or" && x {
+		return parser.BooleanField(x)
+	}
+	return parser.BooleanField(truthy(l.y.eval(e)))
+}
+
+type notExpr struct{ x expr }
+
+func (n notExpr) eval(e *parser.Entry) value { return parser.BooleanField(!truthy(n.x.eval(e))) }
+
+// negExpr negates numbers and durations, anything else is null.
+type negExpr struct{ x expr }
+
+func (n negExpr) eval(e *parser.Entry) value {
+	switch x := field(n.x.eval(e)).(type) {
+	case parser.NumberField:
+		return -x
+	case parser.DurationField:
+		return parser.DurationField{Duration: -x.Duration}
+	}
+	return parser.NilField{}
+}
+
+type compareExpr struct {
+	op   string
+	x, y expr
+}
+
+func (c compareExpr) eval(e *parser.Entry) value {
+	x, y := field(c.x.eval(e)), field(c.y.eval(e))
+	_, xnil := x.(parser.NilField)
+	_, ynil := y.(parser.NilField)
+	if xnil || ynil {
+		// nothing is more or less than null
+		switch c.op {
+		case "=":
+			return parser.BooleanField(xnil && ynil)
+		case "!=":
+			return parser.BooleanField(xnil != ynil)
+		}
+		return parser.BooleanField(false)
+	}
+	// the right side is read as the type of the left, like the values
+	// of filters are
+	cmp := newOperand(formatField(y)).compare(x)
+	switch c.op {
+	case "=":
+		return parser.BooleanField(cmp == 0)
+	case "!=":
+		return parser.BooleanField(cmp != 0)
+	case "<":
+		return parser.BooleanField(cmp < 0)
+	case "<=":
+		return parser.BooleanField(cmp <= 0)
+	case ">":
+		return parser.BooleanField(cmp > 0)
+	case ">=":
+		return parser.BooleanField(cmp >= 0)
+	}
+	return parser.BooleanField(false)
+}
+
+type indexExpr struct{ x, i expr }
+
+func (ix indexExpr) eval(e *parser.Entry) value {
+	l, ok := ix.x.eval(e).(list)
+	n, isNum := ix.i.eval(e).(parser.NumberField)
+	if !ok || !isNum {
+		return parser.NilField{}
+	}
+	i := int(n)
+	if i < 0 {
+		i += len(l)
+	}
+	if i < 0 || i >= len(l) {
+		return parser.NilField{}
+	}
+	return l[i]
+}
+
+type callExpr struct {
+	fn   function
+	args []expr
+}
+
+func (c callExpr) eval(e *parser.Entry) value {
+	if c.fn.lazy != nil {
+		return c.fn.lazy(e, c.args)
+	}
+	args := make([]value, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.eval(e)
+	}
+	return c.fn.call(args)
+}
+
+// field turns a value into one that an entry can hold. Lists become
+// their items separated by commas.
+func field(v value) parser.Field {
+	l, ok := v.(list)
+	if !ok {
+		if v == nil {
+			return parser.NilField{}
+		}
+		return v
+	}
+	items := make([]string, len(l))
+	for i, f := range l {
+		items[i] = formatField(f)
+	}
+	return parser.StringField(strings.Join(items, ","))
+}
+
+// truthy tells if a value holds as a condition: true, a number other than
+// zero, or a string or list that isn't empty.
+func truthy(v value) bool {
+	switch v := v.(type) {
+	case parser.BooleanField:
+		return bool(v)
+	case parser.NumberField:
+		return v != 0
+	case parser.DurationField:
+		return v.Duration != 0
+	case parser.TimeField:
+		return !v.IsZero()
+	case parser.StringField:
+		return v != ""
+	case parser.RawField:
+		return len(v) > 0
+	case list:
+		return len(v) > 0
+	}
+	return false
+}
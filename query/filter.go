@@ -0,0 +1,203 @@
+package query
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fields searched by words that aren't compared to a field
+var textFields = []string{"msg", "message", parser.DefaultRaw}
+
+// Filter keeps the entries that match all of its conditions, like
+// `level=error latency>1s timeout`.
+type Filter struct {
+	conds []cond
+}
+
+// cond is a comparison of a field with a value, or a word to find in the
+// text of the entry when the field is empty.
+type cond struct {
+	field string
+	op    string
+	value operand
+}
+
+// ParseFilter reads the conditions of a filter.
+func ParseFilter(text string) (*Filter, error) {
+	toks, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+	return parseFilter(&tokens{toks: toks})
+}
+
+func parseFilter(t *tokens) (*Filter, error) {
+	f := &Filter{}
+	for !t.done() {
+		tok := t.next()
+		if tok.kind == tokOp {
+			return nil, fmt.Errorf("unexpected %v at %d", tok, tok.pos)
+		}
+		if tok.kind == tokString || !isComparison(t.peek()) {
+			f.conds = append(f.conds, cond{value: newOperand(tok.text)})
+			continue
+		}
+		op := t.next().text
+		if op == "==" {
+			op = "="
+		}
+		value, err := t.value("a value to compare " + tok.text + " with")
+		if err != nil {
+			return nil, err
+		}
+		f.conds = append(f.conds, cond{field: tok.text, op: op, value: newOperand(value)})
+	}
+	return f, nil
+}
+
+func isComparison(tok token) bool {
+	if tok.kind != tokOp {
+		return false
+	}
+	switch tok.text {
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// Match tells if the entry meets all the conditions.
+func (f *Filter) Match(e *parser.Entry) bool {
+	for _, c := range f.conds {
+		if !c.match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty tells if the filter matches everything.
+func (f *Filter) Empty() bool { return len(f.conds) == 0 }
+
+func (c cond) match(e *parser.Entry) bool {
+	if c.field == "" {
+		for _, name := range textFields {
+			if v, ok := e.Field(name); ok && containsFold(formatField(v), c.value.text) {
+				return true
+			}
+		}
+		return false
+	}
+	v, ok := e.Field(c.field)
+	if !ok {
+		return c.op == "!="
+	}
+	cmp := c.value.compare(v)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// operand is a value typed in a query, read as each of the types of field
+// it could be compared with.
+type operand struct {
+	text string
+
+	num     float64
+	isNum   bool
+	dur     time.Duration
+	isDur   bool
+	t       time.Time
+	isTime  bool
+	boolean bool
+	isBool  bool
+}
+
+func newOperand(text string) operand {
+	o := operand{text: text}
+	if v, err := strconv.ParseFloat(text, 64); err == nil {
+		o.num, o.isNum = v, true
+	}
+	if v, err := time.ParseDuration(text); err == nil {
+		o.dur, o.isDur = v, true
+	}
+	if v, err := parser.ParseTime(text); err == nil {
+		o.t, o.isTime = v, true
+	}
+	if v, err := strconv.ParseBool(text); err == nil {
+		o.boolean, o.isBool = v, true
+	}
+	return o
+}
+
+// compare the field to the operand, natively for its type. Strings are
+// compared with strings.Compare.
+func (o operand) compare(f parser.Field) int {
+	switch v := f.(type) {
+	case parser.NumberField:
+		if o.isNum {
+			return compareFloats(float64(v), o.num)
+		}
+	case parser.DurationField:
+		if o.isDur {
+			return compareFloats(float64(v.Duration), float64(o.dur))
+		}
+		if o.isNum && o.num == 0 {
+			return compareFloats(float64(v.Duration), 0)
+		}
+	case parser.TimeField:
+		if o.isTime {
+			switch {
+			case v.Before(o.t):
+				return -1
+			case v.After(o.t):
+				return 1
+			}
+			return 0
+		}
+	case parser.BooleanField:
+		if o.isBool {
+			switch {
+			case bool(v) == o.boolean:
+				return 0
+			case o.boolean:
+				return -1
+			}
+			return 1
+		}
+	case parser.NilField:
+		if o.text == "null" || o.text == "nil" {
+			return 0
+		}
+	}
+	return strings.Compare(formatField(f), o.text)
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokString {
+		return strconv.Quote(t.text)
+	}
+	return t.text
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// the runes that end a word
+const specials = `"|,()=!<>[]`
+
+// the operators, longest first
+var ops = []string{"!=", "<=", ">=", "==", "=", "<", ">", ",", "(", ")", "[", "]", "!"}
+
+// lex splits the text of a stage in words, quoted strings and operators.
+func lex(text string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(text); {
+		r, sz := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += sz
+		case r == '"':
+			end := i + 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(text) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s, err := strconv.Unquote(text[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", i, err)
+			}
+			toks = append(toks, token{kind: tokString, text: s, pos: i})
+			i = end + 1
+		case strings.ContainsRune(specials, r):
+			op := ""
+			for _, o := range ops {
+				if strings.HasPrefix(text[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		default:
+			end := i
+			for end < len(text) {
+				r, sz := utf8.DecodeRuneInString(text[end:])
+				if unicode.IsSpace(r) || strings.ContainsRune(specials, r) {
+					break
+				}
+				end += sz
+			}
+			toks = append(toks, token{kind: tokWord, text: text[i:end], pos: i})
+			i = end
+		}
+	}
+	return toks, nil
+}
+
+// splitStages splits a query on the `|` that aren't in quotes.
+func splitStages(text string) []string {
+	var (
+		stages  []string
+		start   int
+		inQuote bool
+	)
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			if inQuote {
+				i++
+			}
+		case '"':
+			inQuote = !inQuote
+		case '|':
+			if !inQuote {
+				stages = append(stages, text[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(stages, text[start:])
+}
+
+// tokens is a cursor over the tokens of a stage.
+type tokens struct {
+	toks []token
+	i    int
+}
+
+func (t *tokens) done() bool { return t.i >= len(t.toks) }
+
+func (t *tokens) peek() token {
+	if t.done() {
+		return token{kind: tokOp, text: "end of stage", pos: -1}
+	}
+	return t.toks[t.i]
+}
+
+func (t *tokens) next() token {
+	tok := t.peek()
+	t.i++
+	return tok
+}
+
+// accept consumes the next token if it is that operator.
+func (t *tokens) accept(op string) bool {
+	if t.peek().is(tokOp, op) {
+		t.i++
+		return true
+	}
+	return false
+}
+
+// acceptWord consumes the next token if it is that word.
+func (t *tokens) acceptWord(word string) bool {
+	if tok := t.peek(); tok.kind == tokWord && strings.EqualFold(tok.text, word) {
+		t.i++
+		return true
+	}
+	return false
+}
+
+func (t *tokens) word(what string) (string, error) {
+	tok := t.next()
+	if tok.kind != tokWord {
+		return "", fmt.Errorf("want %s, got %v", what, tok)
+	}
+	return tok.text, nil
+}
+
+func (t *tokens) value(what string) (string, error) {
+	tok := t.next()
+	if tok.kind == tokOp {
+		return "", fmt.Errorf("want %s, got %v", what, tok)
+	}
+	return tok.text, nil
+}
+
+// fieldList reads `a, b, c`.
+func (t *tokens) fieldList() ([]string, error) {
+	var fields []string
+	for {
+		f, err := t.word("a field name")
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+		if !t.accept(",") {
+			return fields, nil
+		}
+	}
+}
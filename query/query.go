@@ -0,0 +1,317 @@
+// Package query filters the entries of a log and summarizes them, with
+// queries like:
+//
+//	level=error service=api | count by route
+//	latency>100ms | stats p50(latency) p99(latency) by route
+//	| top 10 path
+//	| timechart span=10s count by level
+//	| extract "%{IP:client} took %{DURATION:took}" | where took>1s
+//	| eval latency_ms = latency / 1ms, slow = latency > 1s | where slow=true
+//
+// The first stage is a filter. The stages after it, separated by `|`,
+// keep reducing the entries, and the last one can aggregate them in a
+// table.
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/logterm/encode"
+	"github.com/aybabtme/logterm/extract"
+	"github.com/aybabtme/logterm/parser"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Stage passes on the entries it's given, or not.
+type Stage interface {
+	Apply(e *parser.Entry) (*parser.Entry, bool)
+}
+
+// Query is a parsed query. It's safe to add entries to it while its
+// table is read.
+type Query struct {
+	text   string
+	filter *Filter
+	stages []Stage
+
+	mu  sync.Mutex
+	agg Aggregator
+}
+
+// Parse a query.
+func Parse(text string) (*Query, error) {
+	q := &Query{text: strings.TrimSpace(text)}
+	for i, stage := range splitStages(text) {
+		if i > 0 && q.agg == nil {
+			// patterns are read as they're written, not lexed
+			if rest, ok := cutWord(stage, "extract"); ok {
+				rule, err := extract.ParseRule(rest, extract.Default)
+				if err != nil {
+					return nil, fmt.Errorf("stage %d: %v", i+1, err)
+				}
+				q.stages = append(q.stages, extractStage{rule})
+				continue
+			}
+			// expressions have operators that filters don't
+			if rest, ok := cutWord(stage, "eval"); ok {
+				s, err := parseEval(rest)
+				if err != nil {
+					return nil, fmt.Errorf("stage %d: eval: %v", i+1, err)
+				}
+				q.stages = append(q.stages, s)
+				continue
+			}
+		}
+		toks, err := lex(stage)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %v", i+1, err)
+		}
+		t := &tokens{toks: toks}
+		if i == 0 {
+			if q.filter, err = parseFilter(t); err != nil {
+				return nil, fmt.Errorf("filter: %v", err)
+			}
+			continue
+		}
+		if q.agg != nil {
+			return nil, fmt.Errorf("stage %d: nothing can follow an aggregation", i+1)
+		}
+		if err := q.parseStage(t); err != nil {
+			return nil, fmt.Errorf("stage %d: %v", i+1, err)
+		}
+	}
+	return q, nil
+}
+
+func (q *Query) parseStage(t *tokens) error {
+	name, err := t.word("a stage")
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(name) {
+	case "where":
+		f, err := parseFilter(t)
+		if err != nil {
+			return err
+		}
+		q.stages = append(q.stages, whereStage{f})
+		return nil
+	case "count":
+		by, err := parseBy(t)
+		if err != nil {
+			return err
+		}
+		q.agg = newCount(by)
+	case "stats":
+		var funcs []statFunc
+		for !t.done() && !t.peek().is(tokWord, "by") {
+			fn, err := parseStatCall(t)
+			if err != nil {
+				return err
+			}
+			funcs = append(funcs, fn)
+			t.accept(",")
+		}
+		if len(funcs) == 0 {
+			return fmt.Errorf("stats needs functions, like p99(latency)")
+		}
+		by, err := parseBy(t)
+		if err != nil {
+			return err
+		}
+		q.agg = newStats(funcs, by)
+	case "top":
+		n, err := t.word("how many values to show")
+		if err != nil {
+			return err
+		}
+		limit, err := strconv.Atoi(n)
+		if err != nil || limit < 1 {
+			return fmt.Errorf("top needs a number of values, got %q", n)
+		}
+		fields, err := t.fieldList()
+		if err != nil {
+			return err
+		}
+		q.agg = newTop(limit, fields)
+	case "timechart":
+		agg, err := parseTimechart(t)
+		if err != nil {
+			return err
+		}
+		q.agg = agg
+	default:
+		return fmt.Errorf("unknown stage %q", name)
+	}
+	if !t.done() {
+		return fmt.Errorf("unexpected %v", t.peek())
+	}
+	return nil
+}
+
+// parseBy reads the optional `by a, b` that ends aggregations.
+func parseBy(t *tokens) ([]string, error) {
+	if t.done() {
+		return nil, nil
+	}
+	if !t.acceptWord("by") {
+		return nil, fmt.Errorf("want `by`, got %v", t.peek())
+	}
+	return t.fieldList()
+}
+
+// parseStatCall reads `p99(latency)` or `count`.
+func parseStatCall(t *tokens) (statFunc, error) {
+	name, err := t.word("a function")
+	if err != nil {
+		return statFunc{}, err
+	}
+	fn, err := parseStatFunc(name)
+	if err != nil {
+		return fn, err
+	}
+	if !t.accept("(") {
+		if fn.name != "count" {
+			return fn, fmt.Errorf("%s needs a field, like %s(latency)", name, name)
+		}
+		return fn, nil
+	}
+	if fn.field, err = t.word("a field name"); err != nil {
+		return fn, err
+	}
+	if !t.accept(")") {
+		return fn, fmt.Errorf("want `)`, got %v", t.peek())
+	}
+	return fn, nil
+}
+
+type whereStage struct{ filter *Filter }
+
+func (w whereStage) Apply(e *parser.Entry) (*parser.Entry, bool) {
+	return e, w.filter.Match(e)
+}
+
+// extractStage sets the fields that a rule captures. Entries that it
+// doesn't match go through unchanged.
+type extractStage struct{ rule *extract.Rule }
+
+func (x extractStage) Apply(e *parser.Entry) (*parser.Entry, bool) {
+	e, _ = x.rule.Apply(e)
+	return e, true
+}
+
+// Computed are the fields that eval stages set, in order.
+func (q *Query) Computed() []string {
+	var names []string
+	for _, s := range q.stages {
+		if s, ok := s.(evalStage); ok {
+			names = append(names, s.names...)
+		}
+	}
+	return names
+}
+
+// AppendComputed appends the line to dst with the fields that eval stages
+// set on e, so that they show with those it was parsed with. JSON lines
+// are written again as the JSON of e, others get the fields as key=value
+// pairs after them.
+func (q *Query) AppendComputed(dst, line []byte, e *parser.Entry) []byte {
+	var computed []string
+	for _, name := range q.Computed() {
+		if _, ok := e.Field(name); ok {
+			computed = append(computed, name)
+		}
+	}
+	if len(computed) == 0 {
+		return append(dst, line...)
+	}
+	if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+		return encode.AppendJSON(dst, e)
+	}
+	dst = append(dst, line...)
+	for _, name := range computed {
+		f, _ := e.Field(name)
+		v := formatField(f)
+		if v == "" || strings.ContainsAny(v, " \t\"=") {
+			v = strconv.Quote(v)
+		}
+		dst = append(dst, ' ')
+		dst = append(dst, name...)
+		dst = append(dst, '=')
+		dst = append(dst, v...)
+	}
+	return dst
+}
+
+// cutWord returns what follows word, if text starts with it.
+func cutWord(text, word string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if len(text) < len(word) || !strings.EqualFold(text[:len(word)], word) {
+		return "", false
+	}
+	rest := text[len(word):]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false
+	}
+	return rest, true
+}
+
+func (q *Query) String() string { return q.text }
+
+// Aggregates tells if the query ends in a table, instead of a selection
+// of entries.
+func (q *Query) Aggregates() bool { return q.agg != nil }
+
+// Match runs the entry through the filter and the stages. It returns the
+// entry as the stages changed it.
+func (q *Query) Match(e *parser.Entry) (*parser.Entry, bool) {
+	if !q.filter.Match(e) {
+		return nil, false
+	}
+	for _, s := range q.stages {
+		var ok bool
+		if e, ok = s.Apply(e); !ok {
+			return nil, false
+		}
+	}
+	return e, true
+}
+
+// Add the entry to the aggregation, if it matches. It tells if it
+// matched.
+func (q *Query) Add(e *parser.Entry) bool {
+	e, ok := q.Match(e)
+	if !ok || q.agg == nil {
+		return ok
+	}
+	q.mu.Lock()
+	q.agg.Add(e)
+	q.mu.Unlock()
+	return true
+}
+
+// Chart is the aggregation of the entries added so far, or nil if the
+// query doesn't end in a timechart.
+func (q *Query) Chart() *Chart {
+	c, ok := q.agg.(Charter)
+	if !ok {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return c.Chart()
+}
+
+// Table is the aggregation of the entries added so far, or nil if the
+// query doesn't aggregate.
+func (q *Query) Table() *Table {
+	if q.agg == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.agg.Table()
+}
@@ -0,0 +1,327 @@
+package query
+
+import (
+	"bytes"
+	"github.com/aybabtme/logterm/parser"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var requests = []string{
+	`level=info route=/users latency=12ms status=200 msg="listed users"`,
+	`level=info route=/users latency=30ms status=200 msg="listed users"`,
+	`level=error route=/orders latency=1.2s status=500 msg="timeout talking to db"`,
+	`level=info route=/orders latency=80ms status=200 msg="created order"`,
+	`level=warn route=/users latency=250ms status=429 msg="rate limited"`,
+	`level=error route=/orders latency=900ms status=503 msg="db unavailable"`,
+	`not even logfmt`,
+}
+
+func runQuery(t *testing.T, text string) *Query {
+	q, err := Parse(text)
+	if err != nil {
+		t.Fatalf("can't parse %q: %v", text, err)
+	}
+	for _, line := range requests {
+		q.Add(parser.ParseLine([]byte(line)))
+	}
+	return q
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{``, []int{0, 1, 2, 3, 4, 5, 6}},
+		{`level=error`, []int{2, 5}},
+		{`level!=info`, []int{2, 4, 5, 6}},
+		{`latency>100ms`, []int{2, 4, 5}},
+		{`latency<=30ms route=/users`, []int{0, 1}},
+		{`status>=500`, []int{2, 5}},
+		{`DB`, []int{2, 5}},
+		{`"listed users"`, []int{0, 1}},
+		{`logfmt`, []int{6}},
+		{`| where status=429`, []int{4}},
+		{`| extract from=route "/(?P<resource>\w+)" | where resource=orders`, []int{2, 3, 5}},
+		{`| extract "not even %{WORD:format}" | where format=logfmt`, []int{6}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatalf("can't parse %q: %v", tt.query, err)
+		}
+		var got []int
+		for i, line := range requests {
+			if _, ok := q.Match(parser.ParseLine([]byte(line))); ok {
+				got = append(got, i)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: want lines %v, got %v", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *Table
+	}{
+		{
+			query: `| count by level`,
+			want: &Table{
+				Columns: []string{"level", "count"},
+				Rows:    [][]string{{"info", "3"}, {"error", "2"}, {"warn", "1"}, {"-", "1"}},
+			},
+		},
+		{
+			query: `level=info | count`,
+			want: &Table{
+				Columns: []string{"count"},
+				Rows:    [][]string{{"3"}},
+			},
+		},
+		{
+			query: `route=/orders | stats p50(latency) max(latency) avg(status) by level`,
+			want: &Table{
+				Columns: []string{"level", "p50(latency)", "max(latency)", "avg(status)"},
+				Rows:    [][]string{{"error", "900ms", "1.2s", "501.5"}, {"info", "80ms", "80ms", "200"}},
+			},
+		},
+		{
+			query: `| stats count, p99(latency) by route`,
+			want: &Table{
+				Columns: []string{"route", "count", "p99(latency)"},
+				Rows:    [][]string{{"/users", "3", "250ms"}, {"/orders", "3", "1.2s"}, {"-", "1", "-"}},
+			},
+		},
+		{
+			// strings are counted, but can't be averaged
+			query: `| stats count(msg), avg(msg) by level`,
+			want: &Table{
+				Columns: []string{"level", "count(msg)", "avg(msg)"},
+				Rows:    [][]string{{"info", "3", "-"}, {"error", "2", "-"}, {"warn", "1", "-"}, {"-", "0", "-"}},
+			},
+		},
+		{
+			query: `| top 2 route`,
+			want: &Table{
+				Columns: []string{"route", "count", "percent"},
+				Rows:    [][]string{{"/users", "3", "42.9%"}, {"/orders", "3", "42.9%"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := runQuery(t, tt.query).Table()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: want\n%#v\ngot\n%#v", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		`level=`,
+		`| count by`,
+		`| stats by route`,
+		`| stats p101(latency)`,
+		`| stats avg`,
+		`| top route`,
+		`| frobnicate`,
+		`| count | count`,
+		`| extract %{NOPE:x}`,
+		`| count | extract (?P<x>.)`,
+		`msg="unterminated`,
+	} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("%q: want an error", text)
+		}
+	}
+}
+
+func TestTableWriteTo(t *testing.T) {
+	var buf bytes.Buffer
+	runQuery(t, `| count by level`).Table().WriteTo(&buf)
+	want := strings.Join([]string{
+		"level  count",
+		"info   3",
+		"error  2",
+		"warn   1",
+		"-      1",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestTimechart(t *testing.T) {
+	lines := []string{
+		`time=2014-10-27T18:00:01Z level=info latency=10ms`,
+		`time=2014-10-27T18:00:04Z level=error latency=30ms`,
+		`time=2014-10-27T18:00:12Z level=info latency=20ms`,
+		`no time, not counted`,
+		`time=2014-10-27T18:00:31Z level=info latency=40ms`,
+	}
+	chart := func(text string) *Chart {
+		q, err := Parse(text)
+		if err != nil {
+			t.Fatalf("can't parse %q: %v", text, err)
+		}
+		for _, line := range lines {
+			q.Add(parser.ParseLine([]byte(line)))
+		}
+		return q.Chart()
+	}
+
+	got := chart(`| timechart span=10s count by level`)
+	if want := time.Date(2014, 10, 27, 18, 0, 0, 0, time.UTC); !got.Start.Equal(want) {
+		t.Errorf("want start %v, got %v", want, got.Start)
+	}
+	checkSeries(t, got.Series, []Series{
+		{Name: "info", Values: []float64{1, 1, 0, 1}},
+		{Name: "error", Values: []float64{1, 0, 0, 0}},
+	})
+
+	got = chart(`| timechart span=10s max(latency)`)
+	ms := float64(time.Millisecond)
+	checkSeries(t, got.Series, []Series{
+		{Name: "max(latency)", Values: []float64{30 * ms, 20 * ms, math.NaN(), 40 * ms}, Duration: true},
+	})
+	if got.Label != "max(latency)" || got.Span != 10*time.Second {
+		t.Errorf("want max(latency) every 10s, got %s every %v", got.Label, got.Span)
+	}
+
+	if c := runQuery(t, `| count`).Chart(); c != nil {
+		t.Errorf("count isn't a chart, got %#v", c)
+	}
+}
+
+func checkSeries(t *testing.T, got, want []Series) {
+	if len(got) != len(want) {
+		t.Fatalf("want %d series, got %d: %#v", len(want), len(got), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		same := g.Name == w.Name && g.Duration == w.Duration && len(g.Values) == len(w.Values)
+		for j := 0; same && j < len(w.Values); j++ {
+			same = g.Values[j] == w.Values[j] || math.IsNaN(g.Values[j]) && math.IsNaN(w.Values[j])
+		}
+		if !same {
+			t.Errorf("series %d: want %#v, got %#v", i, w, g)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	at := time.Date(2014, 10, 27, 18, 38, 45, 0, time.UTC)
+	e := parser.ParseLine([]byte(`time=2014-10-27T18:38:45Z start=2014-10-27T18:38:40Z latency=1.5s status=503 ` +
+		`addr=10.0.0.1:8080 user=Alice msg="db unavailable"`))
+	tests := []struct {
+		expr string
+		want parser.Field
+	}{
+		{`latency / 1ms`, parser.NumberField(1500)},
+		{`latency * 2 + 500ms`, parser.DurationField{Duration: 3500 * time.Millisecond}},
+		{`latency > 1s`, parser.BooleanField(true)},
+		{`time - start`, parser.DurationField{Duration: 5 * time.Second}},
+		{`time + 1h`, parser.TimeField{Time: at.Add(time.Hour)}},
+		{`status % 100`, parser.NumberField(3)},
+		{`-status + 3 * (2 + 1)`, parser.NumberField(-494)},
+		{`-latency`, parser.DurationField{Duration: -1500 * time.Millisecond}},
+		{`time + -latency`, parser.TimeField{Time: at.Add(-1500 * time.Millisecond)}},
+		{`--status`, parser.NumberField(503)},
+		{`-user`, parser.NilField{}},
+		{`status / 0`, parser.NilField{}},
+		{`split(addr, ":")[0]`, parser.StringField("10.0.0.1")},
+		{`split(addr, ":")[-1]`, parser.StringField("8080")},
+		{`tonumber(split(addr, ":")[1]) + 1`, parser.NumberField(8081)},
+		{`split(addr, ".")`, parser.StringField("10,0,0,1:8080")},
+		{`lower(user) + "@example.com"`, parser.StringField("alice@example.com")},
+		{`substr(msg, 0, 2)`, parser.StringField("db")},
+		{`len(msg)`, parser.NumberField(14)},
+		{`replace(msg, "db", "cache")`, parser.StringField("cache unavailable")},
+		{`contains(msg, "unavail") and not startswith(msg, "x")`, parser.BooleanField(true)},
+		{`match(addr, "^10\\.")`, parser.BooleanField(true)},
+		{`match(addr, lower("^10\\."))`, parser.BooleanField(true)},
+		{`match(addr, user)`, parser.BooleanField(false)},
+		{`if(status >= 500, "server", "ok")`, parser.StringField("server")},
+		{`case(status < 400, "ok", status < 500, "client", "server")`, parser.StringField("server")},
+		{`coalesce(missing, user)`, parser.StringField("Alice")},
+		{`missing = null`, parser.BooleanField(true)},
+		{`missing > 1`, parser.BooleanField(false)},
+		{`missing + 1`, parser.NilField{}},
+		{`round(latency / 1s * 1.234, 2)`, parser.NumberField(1.85)},
+		{`toduration("1m") / 1s`, parser.NumberField(60)},
+		{`totime("2014-10-27T18:38:45Z") = time`, parser.BooleanField(true)},
+		{`field("addr") = "10.0.0.1:8080"`, parser.BooleanField(true)},
+	}
+	for _, tt := range tests {
+		s, err := parseEval("x = " + tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		out, _ := s.Apply(e)
+		got, _ := out.Field("x")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: want %#v, got %#v", tt.expr, tt.want, got)
+		}
+	}
+	if _, ok := e.Field("x"); ok {
+		t.Error("eval changed the entry it was given")
+	}
+}
+
+func TestEvalStages(t *testing.T) {
+	got := runQuery(t, `| eval ms = latency / 1ms, slow = ms > 100 | where slow=true | stats max(ms) by route`).Table()
+	want := &Table{
+		Columns: []string{"route", "max(ms)"},
+		Rows:    [][]string{{"/orders", "1200"}, {"/users", "250"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want\n%#v\ngot\n%#v", want, got)
+	}
+
+	q, err := Parse(`| eval ms = latency / 1ms, tag = "a b"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(requests[0])
+	e, _ := q.Match(parser.ParseLine(line))
+	if got, want := string(q.AppendComputed(nil, line, e)), requests[0]+` ms=12 tag="a b"`; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	// JSON lines stay JSON
+	line = []byte(`{"latency":"250ms","a":1}`)
+	e, _ = q.Match(parser.ParseLine(line))
+	if got, want := string(q.AppendComputed([]byte("> "), line, e)), `> {"a":1,"latency":"250ms","ms":250,"tag":"a b"}`; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	// lines of queries without eval stages are left as they are
+	q, _ = Parse(`a=1`)
+	e, _ = q.Match(parser.ParseLine(line))
+	if got := string(q.AppendComputed(nil, line, e)); got != string(line) {
+		t.Errorf("want the line unchanged, got %q", got)
+	}
+
+	for _, text := range []string{
+		`| eval`,
+		`| eval x`,
+		`| eval x = `,
+		`| eval x = match(msg, "(")`,
+		`| eval x = nope(1)`,
+		`| eval x = lower(a, b)`,
+		`| eval x = (1 + 2`,
+		`| eval x = 1, `,
+		`| eval x = 1ms2`,
+	} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("%q: want an error", text)
+		}
+	}
+}
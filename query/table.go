@@ -0,0 +1,36 @@
+package query
+
+import (
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Table is the result of an aggregation.
+type Table struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// WriteTo writes the table as aligned text.
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 8, 2, ' ', 0)
+	io.WriteString(tw, strings.Join(t.Columns, "\t")+"\n")
+	for _, row := range t.Rows {
+		io.WriteString(tw, strings.Join(row, "\t")+"\n")
+	}
+	err := tw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
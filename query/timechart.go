@@ -0,0 +1,239 @@
+package query
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSpan = time.Minute
+	// the most recent buckets that a chart keeps
+	maxBuckets = 1000
+	// the series with the most entries that a chart keeps
+	maxSeries = 8
+)
+
+// Chart is the result of a timechart: series of values over buckets of
+// time.
+type Chart struct {
+	// Label says what the values are, like `count by level`
+	Label  string
+	Start  time.Time
+	Span   time.Duration
+	Series []Series
+}
+
+// Series are the values of a group in each bucket of a chart, NaN where
+// there is none. They are durations in nanoseconds when Duration is set.
+type Series struct {
+	Name     string
+	Values   []float64
+	Duration bool
+}
+
+// Charter is an aggregation that can also be shown as a chart.
+type Charter interface {
+	Chart() *Chart
+}
+
+// timechart: `timechart span=10s count by level`
+
+type timechartAgg struct {
+	span time.Duration
+	fn   statFunc
+	by   string
+
+	buckets map[int64][]*accumulator
+	series  []string
+	index   map[string]int
+	totals  []int
+	first   int64
+	last    int64
+}
+
+func newTimechart(span time.Duration, fn statFunc, by string) *timechartAgg {
+	return &timechartAgg{
+		span:    span,
+		fn:      fn,
+		by:      by,
+		buckets: make(map[int64][]*accumulator),
+		index:   make(map[string]int),
+	}
+}
+
+func parseTimechart(t *tokens) (*timechartAgg, error) {
+	span := defaultSpan
+	if t.acceptWord("span") {
+		if !t.accept("=") {
+			return nil, fmt.Errorf("want `=` after span, got %v", t.peek())
+		}
+		v, err := t.word("a span, like 10s")
+		if err != nil {
+			return nil, err
+		}
+		if span, err = time.ParseDuration(v); err != nil || span <= 0 {
+			return nil, fmt.Errorf("invalid span %q", v)
+		}
+	}
+	fn := statFunc{name: "count"}
+	if !t.done() && !t.peek().is(tokWord, "by") {
+		var err error
+		if fn, err = parseStatCall(t); err != nil {
+			return nil, err
+		}
+	}
+	by, err := parseBy(t)
+	if err != nil {
+		return nil, err
+	}
+	if len(by) > 1 {
+		return nil, fmt.Errorf("timechart splits by a single field, got %d", len(by))
+	}
+	if len(by) == 0 {
+		return newTimechart(span, fn, ""), nil
+	}
+	return newTimechart(span, fn, by[0]), nil
+}
+
+func (c *timechartAgg) Add(e *parser.Entry) {
+	t, ok := e.Time()
+	if !ok {
+		return
+	}
+	bucket := t.UnixNano() / int64(c.span)
+	if t.UnixNano() < 0 && t.UnixNano()%int64(c.span) != 0 {
+		bucket--
+	}
+	if len(c.buckets) == 0 || bucket < c.first {
+		c.first = bucket
+	}
+	if len(c.buckets) == 0 || bucket > c.last {
+		c.last = bucket
+	}
+
+	name := ""
+	if c.by != "" {
+		if f, ok := e.Field(c.by); ok {
+			name = formatField(f)
+		}
+	}
+	i, ok := c.index[name]
+	if !ok {
+		i = len(c.series)
+		c.index[name] = i
+		c.series = append(c.series, name)
+		c.totals = append(c.totals, 0)
+	}
+	c.totals[i]++
+
+	accs := c.buckets[bucket]
+	for len(accs) <= i {
+		accs = append(accs, nil)
+	}
+	if accs[i] == nil {
+		accs[i] = &accumulator{}
+	}
+	if c.fn.field == "" {
+		accs[i].values++
+	} else if f, ok := e.Field(c.fn.field); ok {
+		accs[i].add(f)
+	}
+	c.buckets[bucket] = accs
+	c.dropOld()
+}
+
+// dropOld forgets the buckets that a chart wouldn't show.
+func (c *timechartAgg) dropOld() {
+	if c.last-c.first < maxBuckets {
+		return
+	}
+	for b := range c.buckets {
+		if b <= c.last-maxBuckets {
+			delete(c.buckets, b)
+		}
+	}
+	c.first = c.last - maxBuckets + 1
+}
+
+func (c *timechartAgg) label() string {
+	label := c.fn.column()
+	if c.by != "" {
+		label += " by " + c.by
+	}
+	return label
+}
+
+func (c *timechartAgg) Chart() *Chart {
+	chart := &Chart{
+		Label: c.label(),
+		Span:  c.span,
+		Start: time.Unix(0, c.first*int64(c.span)),
+	}
+	if len(c.buckets) == 0 {
+		return chart
+	}
+
+	// the series with the most entries
+	order := make([]int, len(c.series))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return c.totals[order[i]] > c.totals[order[j]] })
+	if len(order) > maxSeries {
+		order = order[:maxSeries]
+	}
+
+	n := int(c.last-c.first) + 1
+	for _, i := range order {
+		s := Series{Name: groupValue(c.series[i]), Values: make([]float64, n)}
+		if c.by == "" {
+			s.Name = c.fn.column()
+		}
+		for b := range s.Values {
+			s.Values[b] = math.NaN()
+			accs := c.buckets[c.first+int64(b)]
+			if i < len(accs) && accs[i] != nil {
+				if v, ok := accs[i].result(c.fn); ok {
+					s.Values[b] = v
+				}
+				s.Duration = s.Duration || accs[i].resultKind(c.fn) == kindDuration
+			} else if c.fn.name == "count" {
+				s.Values[b] = 0
+			}
+		}
+		chart.Series = append(chart.Series, s)
+	}
+	return chart
+}
+
+// Table lists the value of each series in each bucket.
+func (c *timechartAgg) Table() *Table {
+	chart := c.Chart()
+	t := &Table{Columns: []string{"time"}}
+	for _, s := range chart.Series {
+		t.Columns = append(t.Columns, s.Name)
+	}
+	if len(chart.Series) == 0 {
+		return t
+	}
+	for b := range chart.Series[0].Values {
+		row := []string{chart.Start.Add(time.Duration(b) * chart.Span).Format(time.RFC3339)}
+		for _, s := range chart.Series {
+			v := s.Values[b]
+			switch {
+			case math.IsNaN(v):
+				row = append(row, "-")
+			case s.Duration:
+				row = append(row, time.Duration(v).String())
+			default:
+				row = append(row, strconv.FormatFloat(v, 'g', 6, 64))
+			}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
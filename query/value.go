@@ -0,0 +1,60 @@
+package query
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/parser"
+	"strconv"
+	"time"
+)
+
+// formatField writes the value of a field the way a user would type it
+// in a query.
+func formatField(f parser.Field) string {
+	switch v := f.(type) {
+	case nil, parser.NilField:
+		return "null"
+	case parser.StringField:
+		return string(v)
+	case parser.RawField:
+		return string(v)
+	case parser.NumberField:
+		return strconv.FormatFloat(float64(v), 'g', -1, 64)
+	case parser.DurationField:
+		return v.Duration.String()
+	case parser.TimeField:
+		return v.Time.Format(time.RFC3339Nano)
+	case parser.BooleanField:
+		return strconv.FormatBool(bool(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// valueKind is the type of the values an aggregation was computed over,
+// to show its results in that type.
+type valueKind int
+
+const (
+	kindNone valueKind = iota
+	kindNumber
+	kindDuration
+)
+
+// numeric reads the field as a number to aggregate. Durations are in
+// nanoseconds.
+func numeric(f parser.Field) (float64, valueKind, bool) {
+	switch v := f.(type) {
+	case parser.NumberField:
+		return float64(v), kindNumber, true
+	case parser.DurationField:
+		return float64(v.Duration), kindDuration, true
+	}
+	return 0, kindNone, false
+}
+
+func formatNumeric(v float64, kind valueKind) string {
+	if kind == kindDuration {
+		return time.Duration(v).String()
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
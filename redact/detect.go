@@ -0,0 +1,175 @@
+package redact
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Detector finds secrets in text, whatever field they're in.
+type Detector struct {
+	Name    string
+	pattern *regexp.Regexp
+	// valid tells if a match of the pattern is really a secret
+	valid func(s string) bool
+	// mask hides the part of a secret that identifies it
+	mask func(s string) string
+}
+
+// Detectors that are known, by the name that options use.
+var Detectors = []*Detector{
+	{
+		Name:    "jwt",
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,}`),
+		mask:    maskKeep(4, 0),
+	},
+	{
+		Name: "aws",
+		// access key ids, the secret keys that go with them are found
+		// by their field names
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[A-Z0-9]{16}\b`),
+		mask:    maskKeep(4, 4),
+	},
+	{
+		Name:    "card",
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid:   isCard,
+		mask:    maskDigits(4),
+	},
+	{
+		Name:    "email",
+		pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+		mask:    maskEmail,
+	},
+	{
+		Name:    "ip",
+		pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?i)(?:[0-9a-f]{0,4}:){2,7}[0-9a-f]{0,4}`),
+		valid:   isIP,
+		mask:    maskIP,
+	},
+}
+
+// detector of that name, or nil.
+func detector(name string) *Detector {
+	for _, d := range Detectors {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// isCard tells if the digits of s are those of a card number: of a
+// length and prefix that issuers use, and with a valid Luhn checksum.
+func isCard(s string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(digits) < 13 || len(digits) > 19 || !cardPrefix(digits) {
+		return false
+	}
+	return luhn(digits)
+}
+
+// cardPrefix tells if digits start like the numbers of Visa, Mastercard,
+// American Express, Discover, JCB or Diners cards.
+func cardPrefix(digits string) bool {
+	for _, p := range []string{"4", "51", "52", "53", "54", "55", "22", "23", "24", "25", "26", "27", "34", "37", "6011", "65", "644", "645", "646", "647", "648", "649", "35", "300", "301", "302", "303", "304", "305", "36", "38"} {
+		if strings.HasPrefix(digits, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// luhn tells if the last of digits is the checksum of the others.
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func isIP(s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.IsUnspecified() {
+		return false
+	}
+	if ip.To4() != nil {
+		return true
+	}
+	// names like std::vector can read as short addresses
+	groups := 0
+	for _, g := range strings.Split(s, ":") {
+		if g != "" {
+			groups++
+		}
+	}
+	return groups >= 3
+}
+
+// maskKeep masks s but its first and last runes.
+func maskKeep(first, last int) func(string) string {
+	return func(s string) string {
+		runes := []rune(s)
+		if len(runes) <= first+last {
+			return strings.Repeat("*", len(runes))
+		}
+		return string(runes[:first]) + strings.Repeat("*", len(runes)-first-last) + string(runes[len(runes)-last:])
+	}
+}
+
+// maskDigits masks the digits of s but the last ones, keeping separators.
+func maskDigits(keep int) func(string) string {
+	return func(s string) string {
+		b := []byte(s)
+		seen := 0
+		for i := len(b) - 1; i >= 0; i-- {
+			if b[i] < '0' || b[i] > '9' {
+				continue
+			}
+			if seen >= keep {
+				b[i] = '*'
+			}
+			seen++
+		}
+		return string(b)
+	}
+}
+
+// maskEmail keeps the first rune of the user and the domain.
+func maskEmail(s string) string {
+	at := strings.LastIndexByte(s, '@')
+	if at < 1 {
+		return maskKeep(0, 0)(s)
+	}
+	return maskKeep(1, 0)(s[:at]) + s[at:]
+}
+
+// maskIP keeps the first part of an address, like its network.
+func maskIP(s string) string {
+	sep := "."
+	if strings.Contains(s, ":") {
+		sep = ":"
+	}
+	parts := strings.Split(s, sep)
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = "*"
+		}
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,384 @@
+// Package redact hides the secrets of entries before they're shown or
+// written: the values of fields whose name tells they're secret, like
+// `password` or `authorization`, and values that look like secrets
+// wherever they are, like tokens, keys, card numbers, emails or
+// addresses.
+//
+// It works on lines, whether they're structured or not, so that what's
+// parsed out of them is redacted too, and on entries.
+package redact
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aybabtme/logterm/encode"
+	"github.com/aybabtme/logterm/parser"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// DefaultFields are the names of fields whose values are secret. A field
+// is secret when its name has one of them, in any case.
+var DefaultFields = []string{
+	"password", "passwd", "pwd", "secret", "token", "authorization",
+	"api_key", "apikey", "api-key", "cookie", "private_key", "credential",
+	"session_id", "sessionid",
+}
+
+// Dropped is what replaces the values that are dropped from lines.
+const Dropped = "[REDACTED]"
+
+// Action done to a secret.
+type Action int
+
+const (
+	// Mask hides most of the secret, keeping a part that helps tell
+	// them apart, like the last digits of a card
+	Mask Action = iota
+	// Hash replaces the secret with a hash of it, the same for the
+	// same secret
+	Hash
+	// Drop removes the secret
+	Drop
+)
+
+func parseAction(s string) (Action, error) {
+	switch s {
+	case "mask":
+		return Mask, nil
+	case "hash":
+		return Hash, nil
+	case "drop":
+		return Drop, nil
+	}
+	return 0, fmt.Errorf("unknown action %q, want mask, hash or drop", s)
+}
+
+func (a Action) String() string {
+	switch a {
+	case Hash:
+		return "hash"
+	case Drop:
+		return "drop"
+	}
+	return "mask"
+}
+
+// Options of a Redactor.
+type Options struct {
+	// Fields whose values are secret, DefaultFields when nil
+	Fields []string
+	// FieldAction done to the values of the fields
+	FieldAction Action
+	// Detect the secrets of these detectors, all of them when nil
+	Detect []string
+	// Actions done to what detectors find, by detector. Those that
+	// aren't there are masked.
+	Actions map[string]Action
+	// Salt of the hashes, so that they can't be guessed from the
+	// secrets
+	Salt string
+}
+
+// Parse options written like:
+//
+//	fields=password,token detect=jwt,card,email action=hash ip=mask salt=x
+//
+// Fields and detect replace the defaults, `none` for neither. Action is
+// done to the fields and what's detected, `<detector>=<action>` to what a
+// detector finds. The empty string, and `on`, are the defaults.
+func Parse(spec string) (Options, error) {
+	var opts Options
+	for _, arg := range strings.Fields(spec) {
+		if arg == "on" || arg == "default" {
+			continue
+		}
+		i := strings.IndexByte(arg, '=')
+		if i < 0 {
+			return opts, fmt.Errorf("want key=value, got %q", arg)
+		}
+		key, value := arg[:i], arg[i+1:]
+		switch key {
+		case "fields":
+			opts.Fields = list(value)
+		case "detect":
+			opts.Detect = list(value)
+			for _, name := range opts.Detect {
+				if detector(name) == nil {
+					return opts, fmt.Errorf("unknown detector %q", name)
+				}
+			}
+		case "action":
+			a, err := parseAction(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.FieldAction = a
+			opts.Actions = make(map[string]Action)
+			for _, d := range Detectors {
+				opts.Actions[d.Name] = a
+			}
+		case "salt":
+			opts.Salt = value
+		default:
+			if detector(key) == nil {
+				return opts, fmt.Errorf("unknown option %q", key)
+			}
+			a, err := parseAction(value)
+			if err != nil {
+				return opts, err
+			}
+			if opts.Actions == nil {
+				opts.Actions = make(map[string]Action)
+			}
+			opts.Actions[key] = a
+		}
+	}
+	return opts, nil
+}
+
+// list of comma separated names, `none` for an empty one.
+func list(s string) []string {
+	if s == "none" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// Redactor hides secrets. It's safe for concurrent use.
+type Redactor struct {
+	opts      Options
+	fields    []string
+	detectors []*Detector
+	// the values of secret fields in lines, like `password=x`,
+	// `"token":"x"` or `Authorization: Bearer x`
+	fieldPattern *regexp.Regexp
+}
+
+// New redactor with the options.
+func New(opts Options) (*Redactor, error) {
+	r := &Redactor{opts: opts, fields: opts.Fields}
+	if r.fields == nil {
+		r.fields = DefaultFields
+	}
+	if opts.Detect == nil {
+		r.detectors = Detectors
+	}
+	for _, name := range opts.Detect {
+		d := detector(name)
+		if d == nil {
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+		r.detectors = append(r.detectors, d)
+	}
+	if len(r.fields) > 0 {
+		names := make([]string, len(r.fields))
+		for i, name := range r.fields {
+			names[i] = regexp.QuoteMeta(strings.ToLower(name))
+		}
+		r.fieldPattern = regexp.MustCompile(`(?i)("?)([\w.@-]*(?:` + strings.Join(names, "|") + `)[\w.@-]*)("?)(\s*[=:]\s*)` +
+			`("(?:[^"\\]|\\.)*"|(?:Bearer|Basic|Token|Digest)\s+[^\s",;}&]+|[^\s",;}&]+)`)
+	}
+	return r, nil
+}
+
+// secretField tells if the values of the field are secret.
+func (r *Redactor) secretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, secret := range r.fields {
+		if strings.Contains(name, strings.ToLower(secret)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) hash(s string) string {
+	sum := sha256.Sum256([]byte(r.opts.Salt + s))
+	return "hash:" + hex.EncodeToString(sum[:6])
+}
+
+// act on a secret, with mask to mask it. Dropped secrets are replaced
+// with Dropped.
+func (r *Redactor) act(a Action, s string, mask func(string) string) string {
+	switch a {
+	case Hash:
+		return r.hash(s)
+	case Drop:
+		return Dropped
+	}
+	return mask(s)
+}
+
+// Line with its secrets hidden. Lines without secrets are returned as
+// they are.
+func (r *Redactor) Line(line []byte) []byte {
+	s := string(line)
+	out := s
+	if r.fieldPattern != nil {
+		out = r.fieldPattern.ReplaceAllStringFunc(out, r.fieldValue)
+	}
+	out = r.text(out, strings.HasPrefix(strings.TrimSpace(out), "{"))
+	if out == s {
+		return line
+	}
+	return []byte(out)
+}
+
+// fieldValue hides the value of a secret field, in a match of the field
+// pattern.
+func (r *Redactor) fieldValue(match string) string {
+	m := r.fieldPattern.FindStringSubmatch(match)
+	openQuote, key, closeQuote, sep, value := m[1], m[2], m[3], m[4], m[5]
+	if openQuote != closeQuote {
+		// `"password` isn't a key, but the end of a quoted value
+		return match
+	}
+	quoted := len(value) >= 2 && value[0] == '"'
+	secret := value
+	if quoted {
+		secret = value[1 : len(value)-1]
+	}
+	// keep the scheme of authorizations, it's not a secret
+	var scheme string
+	if i := strings.IndexAny(secret, " \t"); !quoted && i > 0 {
+		scheme, secret = secret[:i+1], strings.TrimLeft(secret[i:], " \t")
+	}
+	hidden := scheme + r.act(r.opts.FieldAction, secret, maskKeep(0, 0))
+	// a key in quotes is JSON, where values that aren't numbers need
+	// quotes too
+	if quoted || openQuote == `"` {
+		hidden = `"` + hidden + `"`
+	}
+	return openQuote + key + closeQuote + sep + hidden
+}
+
+// Text with the secrets that detectors find hidden.
+func (r *Redactor) Text(s string) string { return r.text(s, false) }
+
+// text with the secrets hidden. In JSON, secrets that were numbers are
+// quoted once hidden, since they aren't numbers anymore.
+func (r *Redactor) text(s string, json bool) string {
+	for _, d := range r.detectors {
+		a := r.opts.Actions[d.Name]
+		var out []byte
+		last := 0
+		for _, m := range d.pattern.FindAllStringIndex(s, -1) {
+			match := s[m[0]:m[1]]
+			if d.valid != nil && !d.valid(match) {
+				continue
+			}
+			hidden := r.act(a, match, d.mask)
+			if json && jsonValue(s[:m[0]]) {
+				hidden = `"` + hidden + `"`
+			}
+			out = append(append(out, s[last:m[0]]...), hidden...)
+			last = m[1]
+		}
+		if out != nil {
+			s = string(append(out, s[last:]...))
+		}
+	}
+	return s
+}
+
+// jsonValue tells if what comes after before, in JSON, is a value that
+// isn't in quotes, like a number.
+func jsonValue(before string) bool {
+	quoted := false
+	for i := 0; i < len(before); i++ {
+		switch before[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		}
+	}
+	if quoted {
+		return false
+	}
+	before = strings.TrimRight(before, " \t")
+	return strings.HasSuffix(before, ":") || strings.HasSuffix(before, "[") || strings.HasSuffix(before, ",")
+}
+
+// Entry with its secrets hidden: secret fields are dropped or replaced,
+// and the secrets found in other fields are replaced. The entry isn't
+// changed, a copy is returned if there are secrets.
+func (r *Redactor) Entry(e *parser.Entry) *parser.Entry {
+	c := e
+	set := func(name string, f parser.Field) {
+		if c == e {
+			c = e.Clone()
+		}
+		if f == nil {
+			c.Delete(name)
+			return
+		}
+		c.Set(name, f)
+	}
+	for _, name := range e.FieldNames() {
+		f, _ := e.Field(name)
+		if _, isNil := f.(parser.NilField); isNil {
+			continue
+		}
+		if r.secretField(name) {
+			if r.opts.FieldAction == Drop {
+				set(name, nil)
+			} else {
+				set(name, parser.StringField(r.act(r.opts.FieldAction, encode.Text(f), maskKeep(0, 0))))
+			}
+			continue
+		}
+		switch f := f.(type) {
+		case parser.StringField, parser.NumberField:
+			text := encode.Text(f)
+			if hidden := r.Text(text); hidden != text {
+				set(name, parser.StringField(hidden))
+			}
+		case parser.RawField:
+			if hidden := r.Line(f); string(hidden) != string(f) {
+				set(name, parser.RawField(hidden))
+			}
+		}
+	}
+	return c
+}
+
+// NewReader reads the lines of src with their secrets hidden.
+func NewReader(src io.Reader, r *Redactor) io.Reader {
+	return &reader{src: bufio.NewReader(src), r: r}
+}
+
+type reader struct {
+	src *bufio.Reader
+	r   *Redactor
+	buf []byte
+	err error
+}
+
+func (rd *reader) Read(p []byte) (int, error) {
+	for len(rd.buf) == 0 {
+		if rd.err != nil {
+			return 0, rd.err
+		}
+		line, err := rd.src.ReadBytes('\n')
+		rd.err = err
+		if len(line) == 0 {
+			continue
+		}
+		eol := line[len(line)-1] == '\n'
+		if eol {
+			line = line[:len(line)-1]
+		}
+		rd.buf = append(rd.buf[:0], rd.r.Line(line)...)
+		if eol {
+			rd.buf = append(rd.buf, '\n')
+		}
+	}
+	n := copy(p, rd.buf)
+	rd.buf = rd.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bytes of the line kept with a bookmark, to tell what it was
+const maxBookmarkText = 200
+
+// Bookmark on a line of a history.
+type Bookmark struct {
+	// Source the line was read from
+	Source string `json:"source"`
+	// Offset of the line, its number in the history
+	Offset uint64 `json:"offset"`
+	// Time of the entry, zero if it had none
+	Time time.Time `json:"time,omitempty"`
+	// Text of the line, cut short
+	Text string `json:"text"`
+	// Note the user wrote about it
+	Note string `json:"note,omitempty"`
+	// Added is when the bookmark was made
+	Added time.Time `json:"added"`
+}
+
+// Bookmarks of a history, by offset, in a file of one JSON object a line.
+// Keep it next to the history so that they go away together. It's safe
+// for concurrent use.
+type Bookmarks struct {
+	path string
+
+	mu    sync.Mutex
+	marks []Bookmark
+}
+
+// OpenBookmarks reads the bookmarks at path. A file that isn't there has
+// none.
+func OpenBookmarks(path string) (*Bookmarks, error) {
+	b := &Bookmarks{path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	for n := 1; scan.Scan(); n++ {
+		if len(scan.Bytes()) == 0 {
+			continue
+		}
+		var m Bookmark
+		if err := json.Unmarshal(scan.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %v", path, n, err)
+		}
+		b.marks = append(b.marks, m)
+	}
+	sort.Slice(b.marks, func(i, j int) bool { return b.marks[i].Offset < b.marks[j].Offset })
+	return b, scan.Err()
+}
+
+// List of the bookmarks, by offset.
+func (b *Bookmarks) List() []Bookmark {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Bookmark(nil), b.marks...)
+}
+
+// Has is true if the line at offset is bookmarked.
+func (b *Bookmarks) Has(offset uint64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.find(offset)
+	return ok
+}
+
+// Add a bookmark, in place of the one on the same line.
+func (b *Bookmarks) Add(m Bookmark) error {
+	if len(m.Text) > maxBookmarkText {
+		m.Text = m.Text[:maxBookmarkText]
+	}
+	if m.Added.IsZero() {
+		m.Added = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i, ok := b.find(m.Offset)
+	if ok {
+		b.marks[i] = m
+	} else {
+		b.marks = append(b.marks, Bookmark{})
+		copy(b.marks[i+1:], b.marks[i:])
+		b.marks[i] = m
+	}
+	return b.write()
+}
+
+// Remove the bookmark on the line at offset. It's false if there was none.
+func (b *Bookmarks) Remove(offset uint64) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i, ok := b.find(offset)
+	if !ok {
+		return false, nil
+	}
+	b.marks = append(b.marks[:i], b.marks[i+1:]...)
+	return true, b.write()
+}
+
+// Next is the first bookmark after offset, wrapping around to the first
+// one. It's false if there are none.
+func (b *Bookmarks) Next(offset uint64) (Bookmark, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.marks) == 0 {
+		return Bookmark{}, false
+	}
+	i := sort.Search(len(b.marks), func(i int) bool { return b.marks[i].Offset > offset })
+	return b.marks[i%len(b.marks)], true
+}
+
+// Previous is the last bookmark before offset, wrapping around to the
+// last one. It's false if there are none.
+func (b *Bookmarks) Previous(offset uint64) (Bookmark, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.marks) == 0 {
+		return Bookmark{}, false
+	}
+	i, _ := b.find(offset)
+	return b.marks[(i+len(b.marks)-1)%len(b.marks)], true
+}
+
+// find the index of the bookmark at offset, or where it would go.
+func (b *Bookmarks) find(offset uint64) (int, bool) {
+	i := sort.Search(len(b.marks), func(i int) bool { return b.marks[i].Offset >= offset })
+	return i, i < len(b.marks) && b.marks[i].Offset == offset
+}
+
+func (b *Bookmarks) write() error {
+	return writeFile(b.path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, m := range b.marks {
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func offsets(marks []Bookmark) []uint64 {
+	var ns []uint64
+	for _, m := range marks {
+		ns = append(ns, m.Offset)
+	}
+	return ns
+}
+
+func TestBookmarks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bookmarks")
+	b, err := OpenBookmarks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, n := range []uint64{30, 10, 20} {
+		if err := b.Add(Bookmark{Source: "app.log", Offset: n, Time: at, Text: "line"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Add(Bookmark{Offset: 20, Text: strings.Repeat("x", 1000), Note: "look"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.Remove(30); !ok || err != nil {
+		t.Errorf("want the bookmark removed, got %v, %v", ok, err)
+	}
+
+	b, err = OpenBookmarks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marks := b.List()
+	if got := offsets(marks); len(got) != 2 || got[0] != 10 || got[1] != 20 {
+		t.Fatalf("want bookmarks on 10 and 20, got %v", got)
+	}
+	if !marks[0].Time.Equal(at) || marks[0].Source != "app.log" {
+		t.Errorf("want the time and source read back, got %+v", marks[0])
+	}
+	if marks[1].Note != "look" || len(marks[1].Text) != maxBookmarkText {
+		t.Errorf("want the bookmark replaced and its text cut, got %+v", marks[1])
+	}
+	if !b.Has(10) || b.Has(30) {
+		t.Error("want 10 bookmarked and not 30")
+	}
+
+	for _, tt := range []struct {
+		from       uint64
+		next, prev uint64
+	}{
+		{0, 10, 20},
+		{10, 20, 20},
+		{15, 20, 10},
+		{20, 10, 10},
+		{25, 10, 20},
+	} {
+		if m, _ := b.Next(tt.from); m.Offset != tt.next {
+			t.Errorf("after %d: want %d, got %d", tt.from, tt.next, m.Offset)
+		}
+		if m, _ := b.Previous(tt.from); m.Offset != tt.prev {
+			t.Errorf("before %d: want %d, got %d", tt.from, tt.prev, m.Offset)
+		}
+	}
+}
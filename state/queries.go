@@ -0,0 +1,181 @@
+package state
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// QueryLog is the queries typed, oldest first, in a file of one query a
+// line. It's safe for concurrent use.
+type QueryLog struct {
+	path string
+	max  int
+
+	mu    sync.Mutex
+	lines []string
+	// lines in the file, that has more than lines once they're cut
+	written int
+}
+
+// OpenQueryLog reads the log at path, keeping its last max queries. A log
+// that isn't there is empty.
+func OpenQueryLog(path string, max int) (*QueryLog, error) {
+	l := &QueryLog{path: path, max: max}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		if line := scan.Text(); line != "" {
+			l.lines = append(l.lines, line)
+			l.written++
+		}
+	}
+	if len(l.lines) > max {
+		l.lines = l.lines[len(l.lines)-max:]
+	}
+	return l, scan.Err()
+}
+
+// Lines of the log, oldest first.
+func (l *QueryLog) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// Add a query to the log, unless it's empty or the same as the last one.
+// The file is rewritten with the last queries once it has twice as many
+// as are kept.
+func (l *QueryLog) Add(q string) error {
+	q = strings.TrimSpace(q)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if q == "" || strings.ContainsAny(q, "\r\n") || len(l.lines) > 0 && l.lines[len(l.lines)-1] == q {
+		return nil
+	}
+	l.lines = append(l.lines, q)
+	if len(l.lines) > l.max {
+		l.lines = l.lines[len(l.lines)-l.max:]
+	}
+	if l.written+1 >= 2*l.max {
+		l.written = len(l.lines)
+		return writeFile(l.path, func(w io.Writer) error {
+			for _, line := range l.lines {
+				if _, err := fmt.Fprintln(w, line); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.written++
+	if _, err := fmt.Fprintln(f, q); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Saved queries by name, in a file of `name<TAB>query` lines. It's safe
+// for concurrent use.
+type Saved struct {
+	path string
+
+	mu      sync.Mutex
+	queries map[string]string
+}
+
+// OpenSaved reads the saved queries at path. A file that isn't there has
+// none.
+func OpenSaved(path string) (*Saved, error) {
+	s := &Saved{path: path, queries: make(map[string]string)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	for n := 1; scan.Scan(); n++ {
+		line := scan.Text()
+		if line == "" {
+			continue
+		}
+		i := strings.IndexByte(line, '\t')
+		if i <= 0 {
+			return nil, fmt.Errorf("%s: line %d: want a name and a query separated by a tab", path, n)
+		}
+		s.queries[line[:i]] = line[i+1:]
+	}
+	return s, scan.Err()
+}
+
+// All the saved queries, by name.
+func (s *Saved) All() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make(map[string]string, len(s.queries))
+	for name, q := range s.queries {
+		all[name] = q
+	}
+	return all
+}
+
+// Save a query by a name, in place of the one that had it.
+func (s *Saved) Save(name, q string) error {
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid name %q, want one without spaces", name)
+	}
+	if strings.ContainsAny(q, "\r\n") {
+		return fmt.Errorf("a query can't have new lines")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries[name] = q
+	return s.write()
+}
+
+// Delete the query saved by a name. It's false if there was none.
+func (s *Saved) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.queries[name]; !ok {
+		return false, nil
+	}
+	delete(s.queries, name)
+	return true, s.write()
+}
+
+func (s *Saved) write() error {
+	names := make([]string, 0, len(s.queries))
+	for name := range s.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return writeFile(s.path, func(w io.Writer) error {
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "%s\t%s\n", name, s.queries[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+package state
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries")
+	l, err := OpenQueryLog(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{"a", "b", "b", " ", "c", "d"} {
+		if err := l.Add(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"b", "c", "d"}
+	if got := l.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want the last queries %q, got %q", want, got)
+	}
+
+	// the file is cut to the last queries once it has twice as many
+	for _, q := range []string{"e", "f"} {
+		if err := l.Add(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(data)); !reflect.DeepEqual(got, []string{"d", "e", "f"}) {
+		t.Errorf("want the file cut, got %q", got)
+	}
+
+	l, err = OpenQueryLog(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Lines(); !reflect.DeepEqual(got, []string{"e", "f"}) {
+		t.Errorf("want the queries read back, got %q", got)
+	}
+}
+
+func TestSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saved-queries")
+	s, err := OpenSaved(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("errors", "level=error"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("slow", "took>1s | count by service"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("two words", "x"); err == nil {
+		t.Error("want an error for a name with a space")
+	}
+	if ok, err := s.Delete("errors"); !ok || err != nil {
+		t.Errorf("want the query deleted, got %v, %v", ok, err)
+	}
+	if ok, _ := s.Delete("errors"); ok {
+		t.Error("want nothing deleted the second time")
+	}
+
+	s, err = OpenSaved(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"slow": "took>1s | count by service"}
+	if got := s.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v read back, got %v", want, got)
+	}
+}
@@ -0,0 +1,53 @@
+// Package state keeps what logterm remembers from one run to the next:
+// the queries typed, the queries saved by name, and bookmarks on the
+// lines of a history.
+package state
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Dir is where the state is kept, $XDG_STATE_HOME/logterm or
+// ~/.local/state/logterm. It may not be there.
+func Dir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "logterm"), nil
+}
+
+// DefaultDir is Dir, made if it's not there.
+func DefaultDir() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// writeFile replaces the file at path with what write writes, all at once
+// so that it's never half written.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
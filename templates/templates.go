@@ -0,0 +1,249 @@
+// Package templates groups log messages by the template they were printed
+// from, like `user <*> logged in` for `user 123 logged in` and `user 456
+// logged in`, as they stream in.
+//
+// It follows Drain (He et al., "Drain: An Online Log Parsing Approach with
+// Fixed Depth Tree", ICWS 2017): messages are routed through a tree by
+// their length and first words, and each leaf compares them with its few
+// templates, word by word.
+package templates
+
+import (
+	"github.com/aybabtme/logterm/parser"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Wildcard stands for the words that vary in a template.
+const Wildcard = "<*>"
+
+const (
+	DefaultDepth       = 4
+	DefaultSimilarity  = 0.4
+	DefaultMaxChildren = 100
+	DefaultMaxSamples  = 3
+)
+
+// fields that hold the message of an entry, in order of preference
+var messageFields = []string{"msg", "message"}
+
+// Options of a Miner. The zero value of a field takes its default.
+type Options struct {
+	// Depth of the tree: the length of the message and Depth-2 of its
+	// first words lead to a leaf
+	Depth int
+	// Similarity is the share of words that a message must have in
+	// common with a template to belong to it
+	Similarity float64
+	// MaxChildren of a node of the tree, past which words are routed
+	// to a wildcard
+	MaxChildren int
+	// MaxSamples of messages that each template keeps
+	MaxSamples int
+}
+
+// Template of messages.
+type Template struct {
+	ID      int
+	Words   []string
+	Count   int
+	First   time.Time
+	Last    time.Time
+	Samples []string
+}
+
+func (t *Template) String() string { return strings.Join(t.Words, " ") }
+
+// Miner finds the templates of the messages it's given. It's safe for
+// concurrent use.
+type Miner struct {
+	opts Options
+	now  func() time.Time
+
+	mu        sync.Mutex
+	root      map[int]*node
+	templates []*Template
+}
+
+type node struct {
+	children map[string]*node
+	leaf     []*Template
+}
+
+func NewMiner(opts Options) *Miner {
+	if opts.Depth < 3 {
+		opts.Depth = DefaultDepth
+	}
+	if opts.Similarity <= 0 {
+		opts.Similarity = DefaultSimilarity
+	}
+	if opts.MaxChildren <= 0 {
+		opts.MaxChildren = DefaultMaxChildren
+	}
+	if opts.MaxSamples <= 0 {
+		opts.MaxSamples = DefaultMaxSamples
+	}
+	return &Miner{
+		opts: opts,
+		now:  time.Now,
+		root: make(map[int]*node),
+	}
+}
+
+// Message is the text of an entry that templates are found for: its
+// message field, or the whole line.
+func Message(line []byte, e *parser.Entry) string {
+	for _, name := range messageFields {
+		if f, ok := e.Field(name); ok {
+			if s, ok := f.(parser.StringField); ok {
+				return string(s)
+			}
+		}
+	}
+	return string(line)
+}
+
+// AddEntry adds the message of an entry, seen at the time of the entry
+// or now if it has none.
+func (m *Miner) AddEntry(line []byte, e *parser.Entry) *Template {
+	at, ok := e.Time()
+	if !ok {
+		at = m.now()
+	}
+	return m.Add(Message(line, e), at)
+}
+
+// Add a message seen at that time, and return a copy of its template.
+func (m *Miner) Add(msg string, at time.Time) *Template {
+	words := strings.Fields(msg)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	leaf := m.route(words)
+	t := m.match(leaf.leaf, words)
+	if t == nil {
+		t = &Template{
+			ID:    len(m.templates) + 1,
+			Words: append([]string(nil), words...),
+			First: at,
+			Last:  at,
+		}
+		m.templates = append(m.templates, t)
+		leaf.leaf = append(leaf.leaf, t)
+	} else {
+		for i, w := range words {
+			if t.Words[i] != w {
+				t.Words[i] = Wildcard
+			}
+		}
+	}
+	t.Count++
+	if at.Before(t.First) {
+		t.First = at
+	}
+	if at.After(t.Last) {
+		t.Last = at
+	}
+	if len(t.Samples) < m.opts.MaxSamples {
+		t.Samples = append(t.Samples, msg)
+	}
+	return t.copy()
+}
+
+// route finds the leaf of the messages of that length that start with
+// the same words, creating the nodes that lead to it.
+func (m *Miner) route(words []string) *node {
+	n, ok := m.root[len(words)]
+	if !ok {
+		n = &node{children: make(map[string]*node)}
+		m.root[len(words)] = n
+	}
+	for i := 0; i < m.opts.Depth-2 && i < len(words); i++ {
+		w := words[i]
+		if hasDigit(w) {
+			// likely a value, route it with other values
+			w = Wildcard
+		}
+		child, ok := n.children[w]
+		if !ok && len(n.children) >= m.opts.MaxChildren {
+			w = Wildcard
+			child, ok = n.children[w]
+		}
+		if !ok {
+			child = &node{children: make(map[string]*node)}
+			n.children[w] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// match finds the template that is the most similar to the words, if
+// it's similar enough. Ties go to the template with the most wildcards.
+func (m *Miner) match(leaf []*Template, words []string) *Template {
+	var (
+		best      *Template
+		bestSim   = -1.0
+		bestWilds = -1
+	)
+	for _, t := range leaf {
+		sim, wilds := similarity(t.Words, words)
+		if sim > bestSim || sim == bestSim && wilds > bestWilds {
+			best, bestSim, bestWilds = t, sim, wilds
+		}
+	}
+	if best == nil || bestSim < m.opts.Similarity {
+		return nil
+	}
+	return best
+}
+
+// similarity is the share of the words that are the same in the template,
+// and how many of its words are wildcards.
+func similarity(template, words []string) (float64, int) {
+	if len(words) == 0 {
+		return 1, 0
+	}
+	same, wilds := 0, 0
+	for i, w := range template {
+		switch w {
+		case Wildcard:
+			wilds++
+		case words[i]:
+			same++
+		}
+	}
+	return float64(same) / float64(len(words)), wilds
+}
+
+func hasDigit(w string) bool {
+	return strings.IndexAny(w, "0123456789") != -1
+}
+
+// Templates returns copies of the templates found so far, the most common
+// first.
+func (m *Miner) Templates() []*Template {
+	m.mu.Lock()
+	all := make([]*Template, len(m.templates))
+	for i, t := range m.templates {
+		all[i] = t.copy()
+	}
+	m.mu.Unlock()
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Count > all[j].Count })
+	return all
+}
+
+// Newest sorts the templates by when they first appeared, the latest
+// first.
+func Newest(all []*Template) {
+	sort.SliceStable(all, func(i, j int) bool { return all[i].First.After(all[j].First) })
+}
+
+func (t *Template) copy() *Template {
+	c := *t
+	c.Words = append([]string(nil), t.Words...)
+	c.Samples = append([]string(nil), t.Samples...)
+	return &c
+}
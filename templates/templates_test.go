@@ -0,0 +1,98 @@
+package templates
+
+import (
+	"github.com/aybabtme/logterm/parser"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMine(t *testing.T) {
+	m := NewMiner(Options{})
+	start := time.Date(2014, 10, 27, 18, 0, 0, 0, time.UTC)
+	msgs := []string{
+		"user 123 logged in",
+		"connected to db-1 in 12ms",
+		"user 456 logged in",
+		"user alice logged out",
+		"connected to db-2 in 30ms",
+		"user 789 logged in",
+		"cache miss for key users:42",
+		"user bob logged out",
+		"",
+	}
+	for i, msg := range msgs {
+		m.Add(msg, start.Add(time.Duration(i)*time.Second))
+	}
+
+	type summary struct {
+		template    string
+		count       int
+		first, last int
+		sample      string
+	}
+	var got []summary
+	for _, tmpl := range m.Templates() {
+		got = append(got, summary{
+			template: tmpl.String(),
+			count:    tmpl.Count,
+			first:    int(tmpl.First.Sub(start) / time.Second),
+			last:     int(tmpl.Last.Sub(start) / time.Second),
+			sample:   tmpl.Samples[0],
+		})
+	}
+	want := []summary{
+		{"user <*> logged in", 3, 0, 5, "user 123 logged in"},
+		{"connected to <*> in <*>", 2, 1, 4, "connected to db-1 in 12ms"},
+		// routed by their first words, which differ
+		{"user alice logged out", 1, 3, 3, "user alice logged out"},
+		{"cache miss for key users:42", 1, 6, 6, "cache miss for key users:42"},
+		{"user bob logged out", 1, 7, 7, "user bob logged out"},
+		{"", 1, 8, 8, ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestSamplesAreBounded(t *testing.T) {
+	m := NewMiner(Options{MaxSamples: 2})
+	for _, msg := range []string{"job 1 done", "job 2 done", "job 3 done"} {
+		m.Add(msg, time.Now())
+	}
+	got := m.Templates()[0].Samples
+	if want := []string{"job 1 done", "job 2 done"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want samples %q, got %q", want, got)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	for _, tt := range []struct {
+		line, want string
+	}{
+		{`level=info msg="user 12 logged in" user=12`, "user 12 logged in"},
+		{`{"message":"hello","n":1}`, "hello"},
+		{`plain text line`, "plain text line"},
+		{`level=info took=12ms`, "level=info took=12ms"},
+	} {
+		line := []byte(tt.line)
+		if got := Message(line, parser.ParseLine(line)); got != tt.want {
+			t.Errorf("%q: want %q, got %q", tt.line, tt.want, got)
+		}
+	}
+}
+
+func TestAddEntryUsesEntryTime(t *testing.T) {
+	m := NewMiner(Options{})
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	line := []byte(`time=2014-10-27T18:00:00Z msg="started"`)
+	if got := m.AddEntry(line, parser.ParseLine(line)); !got.First.Equal(time.Date(2014, 10, 27, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("want the time of the entry, got %v", got.First)
+	}
+	line = []byte(`started again`)
+	if got := m.AddEntry(line, parser.ParseLine(line)); !got.First.Equal(now) {
+		t.Errorf("want now, got %v", got.First)
+	}
+}
@@ -0,0 +1,167 @@
+// Package timefmt shows the times of entries in a chosen zone, or
+// relative to now or to the entry before them.
+package timefmt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Layout of the times that are shown in a zone.
+const Layout = "2006-01-02T15:04:05.000Z07:00"
+
+const day = 24 * time.Hour
+
+// Mode of showing times.
+type Mode int
+
+const (
+	// Parsed shows times in the zone they were parsed in
+	Parsed Mode = iota
+	// Zone shows times in the zone of the display
+	Zone
+	// Ago shows how long ago times are, like `3.2s ago`
+	Ago
+	// Delta shows how long after the entry before an entry is, like
+	// `+120ms`
+	Delta
+)
+
+// Display of times. The zero value shows them as they were parsed.
+type Display struct {
+	Mode Mode
+	// Location of the Zone mode
+	Location *time.Location
+	// Now is the time that Ago counts from, the current time when nil
+	Now func() time.Time
+}
+
+// Parse a display: `utc`, `local`, an IANA zone like `America/Montreal`,
+// `ago`, `delta`, or `parsed` and the empty string for the zone times
+// were parsed in.
+func Parse(spec string) (Display, error) {
+	switch strings.ToLower(spec) {
+	case "", "parsed":
+		return Display{}, nil
+	case "utc":
+		return Display{Mode: Zone, Location: time.UTC}, nil
+	case "local":
+		return Display{Mode: Zone, Location: time.Local}, nil
+	case "ago", "relative":
+		return Display{Mode: Ago}, nil
+	case "delta":
+		return Display{Mode: Delta}, nil
+	}
+	loc, err := time.LoadLocation(spec)
+	if err != nil {
+		return Display{}, fmt.Errorf("want utc, local, ago, delta, parsed or a zone like America/Montreal: %v", err)
+	}
+	return Display{Mode: Zone, Location: loc}, nil
+}
+
+// Next display after d, in the cycle of those that the UI goes through:
+// as parsed, local, UTC, ago, delta, and as parsed again. A zone that
+// isn't local or UTC goes to ago.
+func (d Display) Next() Display {
+	switch {
+	case d.Mode == Parsed:
+		return Display{Mode: Zone, Location: time.Local, Now: d.Now}
+	case d.Mode == Zone && d.Location == time.Local:
+		return Display{Mode: Zone, Location: time.UTC, Now: d.Now}
+	case d.Mode == Zone:
+		return Display{Mode: Ago, Now: d.Now}
+	case d.Mode == Ago:
+		return Display{Mode: Delta, Now: d.Now}
+	}
+	return Display{Now: d.Now}
+}
+
+// Relative tells if times are shown relative to others, instead of as
+// they are.
+func (d Display) Relative() bool { return d.Mode == Ago || d.Mode == Delta }
+
+func (d Display) String() string {
+	switch d.Mode {
+	case Zone:
+		if d.Location == time.Local {
+			return "local"
+		}
+		return strings.ToLower(d.Location.String())
+	case Ago:
+		return "ago"
+	case Delta:
+		return "delta"
+	}
+	return "parsed"
+}
+
+// Format t, with the layout when it's shown in a zone. Prev is the time
+// of the entry before, for the Delta mode, zero if there's none.
+func (d Display) Format(t time.Time, prev time.Time, layout string) string {
+	switch d.Mode {
+	case Zone:
+		return t.In(d.Location).Format(layout)
+	case Ago:
+		now := time.Now
+		if d.Now != nil {
+			now = d.Now
+		}
+		since := now().Sub(t)
+		if since < 0 {
+			return "in " + Duration(-since)
+		}
+		return Duration(since) + " ago"
+	case Delta:
+		if prev.IsZero() {
+			return "+0s"
+		}
+		delta := t.Sub(prev)
+		if delta < 0 {
+			return "-" + Duration(-delta)
+		}
+		return "+" + Duration(delta)
+	}
+	return t.Format(layout)
+}
+
+// Duration rounded to a precision that reads well, like 3.2s, 1h5m or
+// 12d3h.
+func Duration(d time.Duration) string {
+	abs := d
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs >= 48*time.Hour {
+		abs = abs.Round(time.Hour)
+		s := fmt.Sprintf("%dd", abs/day)
+		if h := abs % day / time.Hour; h > 0 {
+			s += fmt.Sprintf("%dh", h)
+		}
+		if d < 0 {
+			s = "-" + s
+		}
+		return s
+	}
+	switch {
+	case abs >= time.Hour:
+		d = d.Round(time.Minute)
+	case abs >= time.Minute:
+		d = d.Round(time.Second)
+	case abs >= time.Second:
+		d = d.Round(100 * time.Millisecond)
+	case abs >= time.Millisecond:
+		d = d.Round(time.Millisecond)
+	case abs >= time.Microsecond:
+		d = d.Round(time.Microsecond)
+	}
+	s := d.String()
+	// 1h5m0s reads better as 1h5m
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package timefmt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	montreal, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Skipf("no zone database: %v", err)
+	}
+	at := time.Date(2014, 10, 27, 18, 38, 45, 123e6, time.UTC)
+	now := func() time.Time { return at.Add(3200 * time.Millisecond) }
+	for _, tt := range []struct {
+		spec string
+		prev time.Time
+		want string
+	}{
+		{"", time.Time{}, "2014-10-27T18:38:45.123Z"},
+		{"utc", time.Time{}, "2014-10-27T18:38:45.123Z"},
+		{"America/Montreal", time.Time{}, "2014-10-27T14:38:45.123-04:00"},
+		{"ago", time.Time{}, "3.2s ago"},
+		{"delta", time.Time{}, "+0s"},
+		{"delta", at.Add(-120 * time.Millisecond), "+120ms"},
+		{"delta", at.Add(90 * time.Minute), "-1h30m"},
+	} {
+		d, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Now = now
+		if got := d.Format(at, tt.prev, Layout); got != tt.want {
+			t.Errorf("%q: want %q, got %q", tt.spec, tt.want, got)
+		}
+	}
+	d, _ := Parse("America/Montreal")
+	if d.Location.String() != montreal.String() {
+		t.Errorf("want %v, got %v", montreal, d.Location)
+	}
+	if _, err := Parse("Mars/Olympus_Mons"); err == nil {
+		t.Error("want an error for an unknown zone")
+	}
+}
+
+func TestNext(t *testing.T) {
+	var d Display
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, d.String())
+		d = d.Next()
+	}
+	want := []string{"parsed", "local", "utc", "ago", "delta", "parsed"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for _, tt := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{3249 * time.Millisecond, "3.2s"},
+		{120*time.Millisecond + 400*time.Microsecond, "120ms"},
+		{65 * time.Minute, "1h5m"},
+		{2*time.Minute + 3400*time.Millisecond, "2m3s"},
+		{10 * time.Minute, "10m"},
+		{-1500 * time.Millisecond, "-1.5s"},
+		{300 * time.Nanosecond, "300ns"},
+		{50 * time.Hour, "2d2h"},
+		{-(72*time.Hour + 20*time.Minute), "-3d"},
+	} {
+		if got := Duration(tt.d); got != tt.want {
+			t.Errorf("%v: want %q, got %q", tt.d, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package ui
+
+import (
+	"sync"
+)
+
+var (
+	_ ResizeHandler = &BannerBox{}
+)
+
+// BannerBox shows a message across its line, in the colors of banners.
+type BannerBox struct {
+	win *Window
+
+	mu   sync.Mutex
+	text string
+}
+
+func NewBannerBox(win *Window) *BannerBox {
+	return &BannerBox{win: win}
+}
+
+// SetText replaces the message that is shown.
+func (b *BannerBox) SetText(text string) {
+	b.mu.Lock()
+	b.text = text
+	b.mu.Unlock()
+	b.Refresh()
+}
+
+func (b *BannerBox) Resize(x, y, width, height int) { b.Refresh() }
+
+func (b *BannerBox) Refresh() {
+	b.mu.Lock()
+	text := b.text
+	b.mu.Unlock()
+
+	width := b.win.Width()
+	style := Colors.Banner
+	x := 0
+	for _, c := range fitCells(nil, " "+text, width, style.Fg, style.Bg) {
+		b.win.Draw(x, 0, c.ch, c.fg, c.bg)
+		x += c.width
+	}
+	for ; x < width; x++ {
+		b.win.Draw(x, 0, ' ', style.Fg, style.Bg)
+	}
+}
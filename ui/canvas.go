@@ -75,17 +75,38 @@ func (c *Canvas) FullWithBar() (top, bot *Window) {
 		}
 }
 
+// Window is a part of the canvas, at x, y.
+func (c *Canvas) Window(x, y, width, height int) *Window {
+	return &Window{
+		canvas: c,
+		x:      x,
+		y:      y,
+		width:  width,
+		height: height,
+	}
+}
+
 func (c *Canvas) Size() (width, height int) {
 	return termbox.Size()
 }
 
+// Set the cell at x, y. Wide runes take two cells: the one that follows
+// them is hidden when the screen is flushed.
 func (c *Canvas) Set(x, y int, ch rune, fg, bg termbox.Attribute) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	w, h := termbox.Size()
+	if x < 0 || y < 0 || x >= w || y >= h {
+		return
+	}
 	i := c.computeIndex(x, y)
 	if i >= len(c.cells) {
 		return
 	}
+	if cellWidth(ch) == 2 && x+1 >= w {
+		// half of it would be off screen
+		ch = ' '
+	}
 	cell := c.cells[i]
 	newCell := termbox.Cell{Ch: ch, Fg: fg, Bg: bg}
 	if cell != newCell {
@@ -0,0 +1,411 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aybabtme/logterm/query"
+	"github.com/dustin/go-humanize"
+	"github.com/nsf/termbox-go"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ ResizeHandler = &ChartBox{}
+)
+
+// colors of the series of a chart, in order
+var (
+	seriesColors = []termbox.Attribute{
+		termbox.ColorGreen, termbox.ColorYellow, termbox.ColorBlue, termbox.ColorMagenta,
+		termbox.ColorCyan, termbox.ColorRed, termbox.ColorWhite, termbox.ColorDefault,
+	}
+	seriesSGR = []int{32, 33, 34, 35, 36, 31, 37, 39}
+)
+
+const sgrReset = "\x1b[0m"
+
+// eighths of a block, for the bars
+var blocks = []rune{' ', '▁', '▂', '▃', '▄', '▅', '▆', '▇', '█'}
+
+const (
+	brailleBase = 0x2800
+	// charts smaller than that only show their legend
+	minPlotWidth  = 8
+	minPlotHeight = 2
+)
+
+// ChartBox shows the result of a timechart.
+type ChartBox struct {
+	win *Window
+
+	mu    sync.Mutex
+	chart *query.Chart
+}
+
+func NewChartBox(win *Window) *ChartBox {
+	return &ChartBox{win: win}
+}
+
+// SetChart replaces the chart that is shown.
+func (c *ChartBox) SetChart(chart *query.Chart) {
+	c.mu.Lock()
+	c.chart = chart
+	c.mu.Unlock()
+	c.Refresh()
+}
+
+func (c *ChartBox) Resize(x, y, width, height int) { c.Refresh() }
+
+func (c *ChartBox) Refresh() {
+	c.mu.Lock()
+	chart := c.chart
+	c.mu.Unlock()
+
+	width, height := c.win.Width(), c.win.Height()
+	var rows [][]plotCell
+	if chart != nil {
+		rows = plotChart(chart, width, height)
+	}
+	for y := 0; y < height; y++ {
+		x := 0
+		if y < len(rows) {
+			for _, pc := range rows[y] {
+				fg := termbox.ColorDefault
+				if pc.series >= 0 {
+					fg = seriesColors[pc.series%len(seriesColors)]
+				}
+				c.win.Draw(x, y, pc.ch, fg, termbox.ColorDefault)
+				x++
+			}
+		}
+		for ; x < width; x++ {
+			c.win.Draw(x, y, ' ', termbox.ColorDefault, termbox.ColorDefault)
+		}
+	}
+}
+
+// WriteChart draws the chart as text in width × height characters. With
+// colors, the series are told apart with SGR escape sequences.
+func WriteChart(w io.Writer, chart *query.Chart, width, height int, colors bool) error {
+	bw := bufio.NewWriter(w)
+	for _, row := range plotChart(chart, width, height) {
+		last := -1
+		line := make([]rune, 0, len(row))
+		for _, pc := range row {
+			if colors && pc.series != last {
+				if pc.series < 0 {
+					line = append(line, []rune(sgrReset)...)
+				} else {
+					line = append(line, []rune(fmt.Sprintf("\x1b[%dm", seriesSGR[pc.series%len(seriesSGR)]))...)
+				}
+				last = pc.series
+			}
+			line = append(line, pc.ch)
+		}
+		if colors && last >= 0 {
+			line = append(line, []rune(sgrReset)...)
+		}
+		bw.WriteString(strings.TrimRight(string(line), " ") + "\n")
+	}
+	return bw.Flush()
+}
+
+// plotCell is a character of a chart. The axes and labels have no series.
+type plotCell struct {
+	ch     rune
+	series int
+}
+
+// plotChart lays out the chart in rows of `width` cells: a legend, the
+// plot with the values on its left, and the times under it. A chart of a
+// single series is drawn with bars, and many with lines of braille dots.
+func plotChart(chart *query.Chart, width, height int) [][]plotCell {
+	rows := make([][]plotCell, height)
+	for y := range rows {
+		rows[y] = make([]plotCell, width)
+		for x := range rows[y] {
+			rows[y][x] = plotCell{ch: ' ', series: -1}
+		}
+	}
+	if height == 0 {
+		return rows
+	}
+	drawLegend(rows[0], chart)
+
+	duration := false
+	for _, s := range chart.Series {
+		duration = duration || s.Duration
+	}
+
+	plotH := height - 3
+	bars := len(chart.Series) == 1
+	perCell := 2
+	if bars {
+		perCell = 1
+	}
+	// the labels of the y axis are as wide as the widest one, which
+	// depends on the values that fit next to them
+	labelW := 0
+	first, n, max := 0, 0, 0.0
+	for pass := 0; pass < 2; pass++ {
+		plotW := width - labelW - 1
+		if plotW < minPlotWidth || plotH < minPlotHeight || len(chart.Series) == 0 {
+			return rows
+		}
+		first, n, max = visibleRange(chart, plotW*perCell)
+		labelW = 0
+		for _, v := range []float64{0, max / 2, max} {
+			if l := len([]rune(axisValue(v, duration))); l > labelW {
+				labelW = l
+			}
+		}
+	}
+
+	// the y axis and its labels
+	for y := 1; y <= plotH; y++ {
+		rows[y][labelW] = plotCell{ch: '│', series: -1}
+	}
+	putRight(rows[1], labelW, axisValue(max, duration))
+	if plotH >= 4 {
+		putRight(rows[1+plotH/2], labelW, axisValue(max/2, duration))
+	}
+	putRight(rows[plotH], labelW, axisValue(0, duration))
+
+	plot := make([][]plotCell, plotH)
+	for y := range plot {
+		plot[y] = rows[1+y][labelW+1:]
+	}
+	var used int
+	if bars {
+		used = drawBars(plot, chart.Series[0].Values[first:first+n], max)
+	} else {
+		used = drawLines(plot, chart.Series, first, n, max)
+	}
+
+	// the x axis and its labels
+	axis := rows[height-2]
+	axis[labelW] = plotCell{ch: '└', series: -1}
+	for x := labelW + 1; x < width; x++ {
+		axis[x] = plotCell{ch: '─', series: -1}
+	}
+	start := chart.Start.Add(time.Duration(first) * chart.Span)
+	end := start.Add(time.Duration(n) * chart.Span)
+	labels := rows[height-1]
+	put(labels, labelW, axisTime(start, chart.Span))
+	endLabel := axisTime(end, chart.Span)
+	if x := labelW + 1 + used - len([]rune(endLabel)); x > labelW+len([]rune(axisTime(start, chart.Span))) {
+		put(labels, x, endLabel)
+	}
+	return rows
+}
+
+// visibleRange is the most recent buckets that fit, and the largest value
+// among them.
+func visibleRange(chart *query.Chart, buckets int) (first, n int, max float64) {
+	total := len(chart.Series[0].Values)
+	n = total
+	if n > buckets {
+		n = buckets
+	}
+	first = total - n
+	for _, s := range chart.Series {
+		for _, v := range s.Values[first:] {
+			if !math.IsNaN(v) && v > max {
+				max = v
+			}
+		}
+	}
+	return first, n, max
+}
+
+func drawLegend(row []plotCell, chart *query.Chart) {
+	x := put(row, 0, chart.Label+" every "+chart.Span.String())
+	if len(chart.Series) < 2 {
+		return
+	}
+	for i, s := range chart.Series {
+		x = put(row, x, "  ")
+		if x < len(row) {
+			row[x] = plotCell{ch: '■', series: i}
+			x++
+		}
+		x = put(row, x, " "+s.Name)
+	}
+}
+
+// drawBars draws a bar of blocks per value, to the eighth of a cell. The
+// bars widen to fill the plot when there are few of them. It returns how
+// many cells the bars take.
+func drawBars(plot [][]plotCell, values []float64, max float64) int {
+	height, width := len(plot), len(plot[0])
+	barW := 1
+	if len(values) > 0 && width/len(values) > 1 {
+		barW = width / len(values)
+	}
+	for i, v := range values {
+		if math.IsNaN(v) || max <= 0 {
+			continue
+		}
+		eighths := int(math.Floor(v/max*float64(height*8) + 0.5))
+		for y := 0; y < height; y++ {
+			fill := eighths - y*8
+			if fill <= 0 {
+				break
+			}
+			if fill > 8 {
+				fill = 8
+			}
+			for x := i * barW; x < (i+1)*barW && x < width; x++ {
+				if barW >= 3 && x == (i+1)*barW-1 {
+					// a gap between wide bars
+					continue
+				}
+				plot[height-1-y][x] = plotCell{ch: blocks[fill], series: 0}
+			}
+		}
+	}
+	return imin(len(values)*barW, width)
+}
+
+// drawLines draws each series with braille dots, each cell having 2×4 of
+// them. The values are spread over the width of the plot, and joined by
+// lines. It returns how many cells the lines take.
+func drawLines(plot [][]plotCell, series []query.Series, first, n int, max float64) int {
+	height, width := len(plot)*4, len(plot[0])*2
+	dots := make([][]uint8, len(plot))
+	for y := range dots {
+		dots[y] = make([]uint8, len(plot[0]))
+	}
+	dotX := func(i int) int {
+		if n <= 1 {
+			return 0
+		}
+		return int(math.Floor(float64(i)*float64(width-1)/float64(n-1) + 0.5))
+	}
+	dotY := func(v float64) int {
+		if max <= 0 || v < 0 {
+			return height - 1
+		}
+		return height - 1 - int(math.Floor(v/max*float64(height-1)+0.5))
+	}
+	set := func(x, y, si int) {
+		cx, cy := x/2, y/4
+		dots[cy][cx] |= brailleDot(x%2, y%4)
+		plot[cy][cx] = plotCell{ch: rune(brailleBase + int(dots[cy][cx])), series: si}
+	}
+	for si, s := range series {
+		prevX, prevY := -1, -1
+		for i, v := range s.Values[first : first+n] {
+			if math.IsNaN(v) {
+				prevX = -1
+				continue
+			}
+			x, y := dotX(i), dotY(v)
+			if prevX < 0 {
+				set(x, y, si)
+			} else {
+				line(prevX, prevY, x, y, func(x, y int) { set(x, y, si) })
+			}
+			prevX, prevY = x, y
+		}
+	}
+	return (dotX(n-1) + 2) / 2
+}
+
+// line calls plot with the points from x0, y0 to x1, y1, by Bresenham's
+// algorithm.
+func line(x0, y0, x1, y1 int, plot func(x, y int)) {
+	dx, dy := x1-x0, y1-y0
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy > 0 {
+		dy = -dy
+	}
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		plot(x0, y0)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * err; e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 := 2 * err; e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// brailleDot is the bit of the dot at column x and row y of a braille
+// character.
+func brailleDot(x, y int) uint8 {
+	if y == 3 {
+		return 0x40 << uint(x)
+	}
+	return 1 << uint(x*3+y)
+}
+
+func axisValue(v float64, duration bool) string {
+	if duration {
+		return shortDuration(time.Duration(v))
+	}
+	return strings.Replace(humanize.SIWithDigits(v, 1, ""), " ", "", -1)
+}
+
+// shortDuration keeps 3 significant digits, or so.
+func shortDuration(d time.Duration) string {
+	for _, unit := range []time.Duration{time.Hour, time.Minute, time.Second, time.Millisecond, time.Microsecond} {
+		if d >= unit {
+			return d.Round(unit / 100).String()
+		}
+	}
+	return d.String()
+}
+
+func axisTime(t time.Time, span time.Duration) string {
+	switch {
+	case span >= 24*time.Hour:
+		return t.Format("2006-01-02")
+	case span >= time.Minute:
+		return t.Format("Jan 2 15:04")
+	}
+	return t.Format("15:04:05")
+}
+
+// put writes s from x, and returns where it ended.
+func put(row []plotCell, x int, s string) int {
+	for _, r := range s {
+		if x >= len(row) {
+			break
+		}
+		row[x] = plotCell{ch: r, series: -1}
+		x++
+	}
+	return x
+}
+
+// putRight writes s so that it ends before x.
+func putRight(row []plotCell, x int, s string) {
+	put(row, x-len([]rune(s)), s)
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
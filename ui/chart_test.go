@@ -0,0 +1,75 @@
+package ui
+
+import (
+	"bytes"
+	"github.com/aybabtme/logterm/query"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteChartBars(t *testing.T) {
+	chart := &query.Chart{
+		Label: "count",
+		Start: time.Date(2014, 10, 27, 18, 0, 0, 0, time.UTC),
+		Span:  10 * time.Second,
+		Series: []query.Series{
+			{Name: "count", Values: []float64{4, 1, math.NaN(), 3, 2, 0, 1, 2, 4, 3}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteChart(&buf, chart, 32, 6, false); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"count every 10s",
+		"4│██       ▂▂             ██ ▂▂",
+		" │██       ██ ▄▄       ▄▄ ██ ██",
+		"0│██ ▆▆    ██ ██    ▆▆ ██ ██ ██",
+		" └──────────────────────────────",
+		" 18:00:00               18:01:40",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestWriteChartLines(t *testing.T) {
+	chart := &query.Chart{
+		Label: "count by level",
+		Start: time.Date(2014, 10, 27, 18, 0, 0, 0, time.UTC),
+		Span:  time.Minute,
+		Series: []query.Series{
+			{Name: "info", Values: []float64{0, 1, 2, 3}},
+			{Name: "error", Values: []float64{3, 3, 3, 3}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteChart(&buf, chart, 20, 5, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if want := "count by level every 1m0s  ■ info  ■ error"[:20]; lines[0] != want {
+		t.Errorf("want legend %q, got %q", want, lines[0])
+	}
+	// the flat series is the top row of dots, the other rises to it
+	if want := "  3│⠉⠉⠉⠉⠉⠉⠉⢉⣉⡩⠭⠝⠛⠛⠉⠉"; lines[1] != want {
+		t.Errorf("want top row %q, got %q", want, lines[1])
+	}
+}
+
+func TestBrailleDot(t *testing.T) {
+	// dots 1 to 8 of a braille cell, by column then row
+	want := []uint8{0x01, 0x02, 0x04, 0x40, 0x08, 0x10, 0x20, 0x80}
+	var got []uint8
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 4; y++ {
+			got = append(got, brailleDot(x, y))
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("want %x, got %x", want, got)
+	}
+}
@@ -0,0 +1,60 @@
+package ui
+
+import (
+	"bytes"
+	"io"
+)
+
+// ColumnWriter writes rows as aligned columns that fit in a width, like
+// for a terminal. Rows are written as they come: columns widen when a
+// wider value comes, as long as they all fit.
+type ColumnWriter struct {
+	w      io.Writer
+	names  []string
+	width  int
+	widths []int
+	header bool
+	buf    bytes.Buffer
+	cells  []cell
+}
+
+// NewColumnWriter writes rows of the named columns to w, under a header.
+func NewColumnWriter(w io.Writer, names []string, width int) *ColumnWriter {
+	return &ColumnWriter{w: w, names: names, width: width, widths: make([]int, len(names))}
+}
+
+// Write a row, with a value for each column.
+func (c *ColumnWriter) Write(row []string) error {
+	growWidths(c.widths, row)
+	if !c.header {
+		c.header = true
+		growWidths(c.widths, c.names)
+		if err := c.writeRow(c.names); err != nil {
+			return err
+		}
+	}
+	return c.writeRow(row)
+}
+
+func (c *ColumnWriter) writeRow(row []string) error {
+	// fitting narrows the columns, keep the widths seen intact
+	widths := fitWidths(append([]int(nil), c.widths...), c.width)
+	c.buf.Reset()
+	for i, w := range widths {
+		if i > 0 {
+			c.buf.WriteByte(' ')
+		}
+		v := ""
+		if i < len(row) {
+			v = row[i]
+		}
+		c.cells = fitCells(c.cells[:0], v, w, 0, 0)
+		for _, cell := range c.cells {
+			c.buf.WriteRune(cell.ch)
+		}
+	}
+	line := bytes.TrimRight(c.buf.Bytes(), " ")
+	line = append(line, '\n')
+	_, err := c.w.Write(line)
+	return err
+}
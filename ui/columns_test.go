@@ -0,0 +1,53 @@
+package ui
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestColumnWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewColumnWriter(&buf, []string{"level", "msg", "latency"}, 24)
+	for _, row := range [][]string{
+		{"info", "ok", "3ms"},
+		{"error", "db down, retrying soon", "1.5s"},
+		{"", "", "a line"},
+	} {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "level msg latency\n" +
+		"info  ok  3ms\n" +
+		"error db down, … 1.5s\n" +
+		"                 a line\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestFitWidths(t *testing.T) {
+	for _, tt := range []struct {
+		widths []int
+		width  int
+		want   []int
+	}{
+		{[]int{5, 10, 3}, 40, []int{5, 10, 3}},
+		{[]int{5, 10, 3}, 16, []int{5, 6, 3}},
+		// no narrower than the minimum
+		{[]int{5, 10, 3}, 4, []int{4, 4, 3}},
+		{nil, 10, nil},
+	} {
+		got := fitWidths(append([]int(nil), tt.widths...), tt.width)
+		if len(got) != len(tt.want) {
+			t.Errorf("%v in %d: want %v, got %v", tt.widths, tt.width, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v in %d: want %v, got %v", tt.widths, tt.width, tt.want, got)
+				break
+			}
+		}
+	}
+}
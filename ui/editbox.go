@@ -1,8 +1,10 @@
 package ui
 
 import (
+	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 	"log"
+	"strings"
 	"unicode"
 )
 
@@ -12,40 +14,59 @@ var (
 )
 
 type EditBox struct {
-	win    *Window
-	width  int
-	buffer []rune
-	lines  []rune
-	start  int
-	cursor int
+	win      *Window
+	buffer   []rune
+	cursor   int
+	onSubmit func(line string)
+
+	// lines submitted, oldest first, that Ctrl-R searches
+	history []string
+	// the search of the history, while there's one
+	search *historySearch
+}
+
+// historySearch is a reverse search of the history, like Ctrl-R does in
+// shells: the latest line that has the text is shown, and Ctrl-R again
+// goes to the one before it.
+type historySearch struct {
+	text  []rune
+	match int // index of the line in the history, -1 if none has text
+	// the line before the search started, for when it's cancelled
+	saved []rune
 }
 
 func NewEditBox(win *Window) *EditBox {
-	lines := make([]rune, win.Width())
-	for i := range lines {
-		lines[i] = ' '
-	}
 	e := &EditBox{
-		win:   win,
-		width: win.Width(),
-		lines: lines,
+		win: win,
 	}
 	e.drawLine()
 	return e
 }
 
+// OnSubmit calls fn with the content of the box when enter is pressed,
+// and clears the box.
+func (e *EditBox) OnSubmit(fn func(line string)) {
+	e.onSubmit = fn
+}
+
+// SetHistory sets the lines that Ctrl-R searches, oldest first. Lines
+// submitted afterwards are added to them.
+func (e *EditBox) SetHistory(lines []string) {
+	e.history = append([]string(nil), lines...)
+}
+
 func (e *EditBox) Resize(x, y, width, height int) {
-	if width > len(e.lines) {
-		e.lines = e.lines[:width]
-	}
-	if width < len(e.lines) {
-		e.lines = append(e.lines, make([]rune, len(e.lines)-width)...)
-	}
+	e.drawLine()
 }
 
 func (e *EditBox) KeyPress(ch rune, key termbox.Key, mod termbox.Modifier) {
 	log.Printf("ch=%#v\tkey=%#v\tmod=%#v", ch, key, mod)
 
+	if e.search != nil && e.searchKey(ch, key, mod) {
+		e.drawLine()
+		return
+	}
+
 	switch key {
 	case 0:
 		//continue
@@ -79,6 +100,23 @@ func (e *EditBox) KeyPress(ch rune, key termbox.Key, mod termbox.Modifier) {
 		e.cursor = len(e.buffer)
 		e.drawLine()
 		return
+	case termbox.KeyCtrlR:
+		e.search = &historySearch{match: len(e.history), saved: e.buffer}
+		e.findMatch(len(e.history) - 1)
+		e.drawLine()
+		return
+	case termbox.KeyEnter:
+		line := string(e.buffer)
+		e.buffer = nil
+		e.cursor = 0
+		e.drawLine()
+		if line != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+			e.history = append(e.history, line)
+		}
+		if e.onSubmit != nil {
+			e.onSubmit(line)
+		}
+		return
 	case 0x20: // space
 		ch = ' '
 	}
@@ -87,7 +125,7 @@ func (e *EditBox) KeyPress(ch rune, key termbox.Key, mod termbox.Modifier) {
 		return
 	}
 
-	if unicode.IsPrint(ch) {
+	if unicode.IsPrint(ch) || unicode.Is(unicode.Mn, ch) {
 		if e.cursor == len(e.buffer) {
 			e.buffer = append(e.buffer, ch)
 		} else {
@@ -104,30 +142,127 @@ func (e *EditBox) KeyPress(ch rune, key termbox.Key, mod termbox.Modifier) {
 	e.drawLine()
 }
 
+// searchKey handles a key while searching the history. It's false for the
+// keys that end the search and are then handled as usual, like enter
+// that submits the line found.
+func (e *EditBox) searchKey(ch rune, key termbox.Key, mod termbox.Modifier) bool {
+	s := e.search
+	switch {
+	case key == termbox.KeyCtrlR:
+		if s.match > 0 {
+			e.findMatch(s.match - 1)
+		}
+		return true
+	case key == termbox.KeyCtrlG:
+		e.buffer, e.cursor = s.saved, len(s.saved)
+		e.search = nil
+		return true
+	case key == 0x7f || key == termbox.KeyBackspace:
+		if len(s.text) > 0 {
+			s.text = s.text[:len(s.text)-1]
+			e.findMatch(len(e.history) - 1)
+		}
+		return true
+	case key == 0x20 || key == 0 && mod == 0 && unicode.IsPrint(ch):
+		if key == 0x20 {
+			ch = ' '
+		}
+		s.text = append(s.text, ch)
+		// the line shown can still have the longer text
+		from := s.match
+		if from >= len(e.history) {
+			from = len(e.history) - 1
+		}
+		e.findMatch(from)
+		return true
+	}
+	// any other key keeps the line found
+	if s.match >= 0 && s.match < len(e.history) {
+		e.buffer = []rune(e.history[s.match])
+	}
+	e.cursor = len(e.buffer)
+	e.search = nil
+	return false
+}
+
+// findMatch shows the latest line of the history, from that index, that
+// has the text searched.
+func (e *EditBox) findMatch(from int) {
+	s := e.search
+	for i := from; i >= 0; i-- {
+		if strings.Contains(e.history[i], string(s.text)) {
+			s.match = i
+			e.buffer = []rune(e.history[i])
+			e.cursor = len(e.buffer)
+			return
+		}
+	}
+	s.match = -1
+}
+
 func (e *EditBox) Mouse(termbox.Event) {}
 
 func (e *EditBox) drawLine() {
-
-	if len(e.buffer) > len(e.lines) {
-		buf := e.buffer[len(e.buffer)-len(e.lines) : len(e.buffer)]
-		copy(e.lines, buf)
-	} else {
-		copy(e.lines, e.buffer)
+	width := e.win.Width()
+	if e.search != nil {
+		e.drawSearch(width)
+		return
 	}
 
-	for i := len(e.buffer); i < len(e.lines); i++ {
-		e.lines[i] = 0x0
+	// scroll the line so that the cursor is always visible
+	start := 0
+	for start < e.cursor && runesWidth(e.buffer[start:e.cursor]) >= width {
+		start++
 	}
-	if e.cursor == len(e.lines) {
-		e.win.Draw(len(e.lines), 0, ' ', termbox.ColorWhite, termbox.ColorBlue)
-	}
-	for i, r := range e.lines {
+
+	x := 0
+	for i := start; i < len(e.buffer); i++ {
+		r := e.buffer[i]
+		if runewidth.RuneWidth(r) == 0 {
+			// a cell can't show a combining rune on top of
+			// the one it's combining with
+			continue
+		}
+		w := cellWidth(r)
+		if x+w > width {
+			break
+		}
 		if i == e.cursor {
-			e.win.Draw(i, 0, r, termbox.ColorWhite, termbox.ColorBlue)
-		} else if r == 0x0 {
-			e.win.Draw(i, 0, ' ', termbox.ColorBlack, termbox.ColorWhite)
+			e.win.Draw(x, 0, r, Colors.Cursor.Fg, Colors.Cursor.Bg)
 		} else {
-			e.win.Draw(i, 0, r, termbox.ColorBlack, termbox.ColorWhite)
+			e.win.Draw(x, 0, r, Colors.Input.Fg, Colors.Input.Bg)
+		}
+		x += w
+	}
+	if e.cursor == len(e.buffer) && x < width {
+		e.win.Draw(x, 0, ' ', Colors.Cursor.Fg, Colors.Cursor.Bg)
+		x++
+	}
+	for ; x < width; x++ {
+		e.win.Draw(x, 0, ' ', Colors.Input.Fg, Colors.Input.Bg)
+	}
+}
+
+// drawSearch shows the text searched and the line found, like shells do.
+func (e *EditBox) drawSearch(width int) {
+	prompt := "(reverse-i-search)`"
+	if e.search.match < 0 {
+		prompt = "(failed reverse-i-search)`"
+	}
+	x := 0
+	draw := func(text string, style Style) {
+		for _, r := range text {
+			w := cellWidth(r)
+			if runewidth.RuneWidth(r) == 0 || x+w > width {
+				continue
+			}
+			e.win.Draw(x, 0, r, style.Fg, style.Bg)
+			x += w
 		}
 	}
+	draw(prompt+string(e.search.text)+"': ", Colors.Cursor)
+	draw(string(e.buffer), Colors.Input)
+	for ; x < width; x++ {
+		e.win.Draw(x, 0, ' ', Colors.Input.Fg, Colors.Input.Bg)
+	}
 }
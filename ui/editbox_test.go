@@ -0,0 +1,59 @@
+package ui
+
+import (
+	"github.com/nsf/termbox-go"
+	"testing"
+)
+
+func typeText(e *EditBox, text string) {
+	for _, r := range text {
+		e.KeyPress(r, 0, 0)
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	e := NewEditBox(&Window{})
+	var submitted []string
+	e.OnSubmit(func(line string) { submitted = append(submitted, line) })
+	e.SetHistory([]string{"level=error", "| count by level", "service=api"})
+
+	// the latest line with the text, then the one before it
+	e.KeyPress(0, termbox.KeyCtrlR, 0)
+	typeText(e, "level")
+	if got := string(e.buffer); got != "| count by level" {
+		t.Fatalf("want the latest match, got %q", got)
+	}
+	e.KeyPress(0, termbox.KeyCtrlR, 0)
+	if got := string(e.buffer); got != "level=error" {
+		t.Fatalf("want the match before, got %q", got)
+	}
+	e.KeyPress(0, termbox.KeyEnter, 0)
+	if len(submitted) != 1 || submitted[0] != "level=error" {
+		t.Fatalf("want the match submitted, got %q", submitted)
+	}
+	if got := e.history[len(e.history)-1]; got != "level=error" {
+		t.Errorf("want the submitted line last in the history, got %q", got)
+	}
+
+	// cancelling brings back the line typed
+	typeText(e, "host")
+	e.KeyPress(0, termbox.KeyCtrlR, 0)
+	typeText(e, "nothing like it")
+	if e.search.match != -1 {
+		t.Errorf("want no match, got %d", e.search.match)
+	}
+	e.KeyPress(0, termbox.KeyCtrlG, 0)
+	if got := string(e.buffer); got != "host" || e.search != nil {
+		t.Errorf("want the search cancelled and the line back, got %q", got)
+	}
+
+	// other keys keep the match to edit it
+	e.buffer, e.cursor = nil, 0
+	e.KeyPress(0, termbox.KeyCtrlR, 0)
+	typeText(e, "api")
+	e.KeyPress(0, termbox.KeyCtrlA, 0)
+	typeText(e, "!")
+	if got := string(e.buffer); got != "!service=api" {
+		t.Errorf("want the match edited, got %q", got)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/aybabtme/logterm/alert"
+	"github.com/aybabtme/logterm/ui"
+	"log"
+	"sync"
+	"time"
+)
+
+// how long the banner of an alert stays up
+const bannerTime = 15 * time.Second
+
+// alertBanner shows the last alert at the top of the canvas, for a while.
+type alertBanner struct {
+	layout *ui.Layout
+	banner *ui.BannerBox
+	win    *ui.Window
+
+	mu    sync.Mutex
+	shown time.Time
+}
+
+func (b *alertBanner) show(a *alert.Alert) {
+	log.Print(a)
+	b.mu.Lock()
+	b.shown = time.Now()
+	b.mu.Unlock()
+	b.banner.SetText(a.String() + ": " + string(a.Line))
+	b.layout.SetVisible(b.win, true)
+	time.AfterFunc(bannerTime, b.hide)
+}
+
+// hide the banner, unless another alert showed since.
+func (b *alertBanner) hide() {
+	b.mu.Lock()
+	old := time.Since(b.shown) >= bannerTime
+	b.mu.Unlock()
+	if old {
+		b.layout.SetVisible(b.win, false)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/aybabtme/logterm/anomaly"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/ui"
+	"github.com/nsf/termbox-go"
+	"time"
+)
+
+// how the lines of each kind of anomaly are highlighted in the pager
+var anomalyColors = map[anomaly.Kind]termbox.Attribute{
+	anomaly.NewTemplate: termbox.ColorBlue,
+	anomaly.ErrorRate:   termbox.ColorRed,
+}
+
+func markAnomalies(det *anomaly.Detector) func(n uint64) (termbox.Attribute, bool) {
+	return func(n uint64) (termbox.Attribute, bool) {
+		kind, ok := det.Flagged(n)
+		return anomalyColors[kind], ok
+	}
+}
+
+// listAnomalies keeps the side pane up to date with the anomalies, the
+// latest first. The pane shows up with the first one.
+func listAnomalies(det *anomaly.Detector, layout *ui.Layout, win *ui.Window, table *ui.TableBox) {
+	for range time.Tick(tableRefresh) {
+		all := det.Anomalies()
+		if len(all) == 0 {
+			continue
+		}
+		t := &query.Table{Columns: []string{"at", "anomaly"}}
+		for i := len(all) - 1; i >= 0; i-- {
+			a := all[i]
+			t.Rows = append(t.Rows, []string{a.Start.Format("15:04:05"), a.String()})
+		}
+		table.SetTable(t)
+		layout.SetVisible(win, true)
+	}
+}
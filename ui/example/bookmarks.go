@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/state"
+	"github.com/aybabtme/logterm/ui"
+	"github.com/nsf/termbox-go"
+	"log"
+	"strings"
+	"sync"
+)
+
+// bookmarkView keeps bookmarks on the lines of the history, lists them in
+// a side pane, and brings the pager back to them.
+type bookmarkView struct {
+	hist   *history.Store
+	pager  *ui.PagerBox
+	marks  *state.Bookmarks
+	source string
+	layout *ui.Layout
+	table  *ui.TableBox
+	win    *ui.Window
+
+	mu     sync.Mutex
+	listed bool
+}
+
+// toggle the bookmark on line n.
+func (v *bookmarkView) toggle(n uint64) {
+	removed, err := v.marks.Remove(n)
+	if err != nil {
+		log.Printf("can't remove the bookmark on line %d: %v", n, err)
+		return
+	}
+	if !removed {
+		v.add(n, "")
+		return
+	}
+	v.refresh()
+}
+
+// add a bookmark on line n, with a note about it.
+func (v *bookmarkView) add(n uint64, note string) {
+	line, meta, err := v.hist.Line(n)
+	if err != nil {
+		log.Printf("can't bookmark line %d: %v", n, err)
+		return
+	}
+	m := state.Bookmark{Source: v.source, Offset: n, Time: meta.Time, Text: string(line), Note: note}
+	if err := v.marks.Add(m); err != nil {
+		log.Printf("can't bookmark line %d: %v", n, err)
+		return
+	}
+	v.refresh()
+}
+
+// remove the k-th bookmark of the list, from 1.
+func (v *bookmarkView) remove(k int) {
+	m, ok := v.nth(k)
+	if !ok {
+		return
+	}
+	if _, err := v.marks.Remove(m.Offset); err != nil {
+		log.Printf("can't remove bookmark %d: %v", k, err)
+	}
+	v.refresh()
+}
+
+// toggleList shows or hides the list of bookmarks.
+func (v *bookmarkView) toggleList(uint64) {
+	v.mu.Lock()
+	v.listed = !v.listed
+	listed := v.listed
+	v.mu.Unlock()
+	v.layout.SetVisible(v.win, listed)
+}
+
+// jump to the k-th bookmark of the list, from 1.
+func (v *bookmarkView) jump(k int) {
+	if m, ok := v.nth(k); ok {
+		v.goTo(m)
+	}
+}
+
+// next goes to the bookmark after line n.
+func (v *bookmarkView) next(n uint64) {
+	if m, ok := v.marks.Next(n); ok {
+		v.goTo(m)
+	}
+}
+
+// previous goes to the bookmark before line n.
+func (v *bookmarkView) previous(n uint64) {
+	if m, ok := v.marks.Previous(n); ok {
+		v.goTo(m)
+	}
+}
+
+func (v *bookmarkView) nth(k int) (state.Bookmark, bool) {
+	marks := v.marks.List()
+	if k < 1 || k > len(marks) {
+		log.Printf("no bookmark %d, there are %d", k, len(marks))
+		return state.Bookmark{}, false
+	}
+	return marks[k-1], true
+}
+
+// goTo shows the line of a bookmark at the top of the pager, and selects
+// it in the list. Retention may have dropped the line since.
+func (v *bookmarkView) goTo(m state.Bookmark) {
+	if _, _, err := v.hist.Line(m.Offset); err != nil {
+		if err == history.ErrEvicted {
+			log.Printf("line %d of the bookmark was dropped from the history", m.Offset)
+		} else {
+			log.Printf("can't go to line %d of the bookmark: %v", m.Offset, err)
+		}
+		return
+	}
+	if m.Source != v.source {
+		log.Printf("line %d was bookmarked on %s, not %s", m.Offset, m.Source, v.source)
+	}
+	v.pager.GoTo(m.Offset)
+	for i, other := range v.marks.List() {
+		if other.Offset == m.Offset {
+			v.table.SetSelected(i)
+		}
+	}
+}
+
+// refresh the list and the lines marked in the pager.
+func (v *bookmarkView) refresh() {
+	v.table.SetTable(bookmarkTable(v.marks.List()))
+	v.pager.Refresh()
+}
+
+func bookmarkTable(marks []state.Bookmark) *query.Table {
+	t := &query.Table{Columns: []string{"#", "line", "at", "bookmark"}}
+	for i, m := range marks {
+		at := ""
+		if !m.Time.IsZero() {
+			at = m.Time.Format("15:04:05")
+		}
+		text := strings.TrimSpace(m.Text)
+		if m.Note != "" {
+			text = m.Note + ": " + text
+		}
+		t.Rows = append(t.Rows, []string{fmt.Sprint(i + 1), fmt.Sprint(m.Offset), at, text})
+	}
+	return t
+}
+
+// markBookmarks marks the bookmarked lines, and the others as mark does.
+func markBookmarks(marks *state.Bookmarks, mark func(n uint64) (termbox.Attribute, bool)) func(n uint64) (termbox.Attribute, bool) {
+	return func(n uint64) (termbox.Attribute, bool) {
+		if marks.Has(n) {
+			return ui.Colors.Bookmark.Bg, true
+		}
+		return mark(n)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/aybabtme/logterm/columns"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/timefmt"
+	"github.com/aybabtme/logterm/ui"
+	"log"
+	"sync"
+	"time"
+)
+
+// rows that a sort reads at most, the last ones of the history
+const maxSorted = 100000
+
+// columnView shows the lines of the pager as columns of their fields,
+// that the user picks from the list of fields, and sorts them.
+type columnView struct {
+	hist      *history.Store
+	pager     *ui.PagerBox
+	queries   *queryRunner
+	layout    *ui.Layout
+	fields    *ui.FieldListBox
+	fieldsWin *ui.Window
+
+	mu      sync.Mutex
+	listed  bool
+	names   []string
+	sortBy  string
+	desc    bool
+	sorting bool
+}
+
+// toggleList shows or hides the list of fields.
+func (v *columnView) toggleList(uint64) {
+	v.mu.Lock()
+	v.listed = !v.listed
+	listed := v.listed
+	v.mu.Unlock()
+	v.layout.SetVisible(v.fieldsWin, listed)
+}
+
+// set the columns, none shows the lines again.
+func (v *columnView) set(names []string) {
+	v.mu.Lock()
+	v.names = names
+	v.mu.Unlock()
+	v.fields.SetColumns(names)
+	if len(names) == 0 {
+		v.pager.SetColumns(nil)
+		return
+	}
+	v.pager.SetColumns(names)
+}
+
+func (v *columnView) columns() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.names
+}
+
+// toggleSelected adds the selected field as the last column, or removes
+// it if it's one.
+func (v *columnView) toggleSelected(uint64) {
+	if name, ok := v.fields.Selected(); ok {
+		v.set(columns.Toggle(v.columns(), name))
+	}
+}
+
+// moveSelected moves the column of the selected field by delta places.
+func (v *columnView) moveSelected(delta int) {
+	if name, ok := v.fields.Selected(); ok {
+		v.set(columns.Move(v.columns(), name, delta))
+	}
+}
+
+// sortSelected sorts by the selected field, then in reverse when it's
+// sorted by it already, then stops sorting.
+func (v *columnView) sortSelected(uint64) {
+	name, ok := v.fields.Selected()
+	if !ok {
+		return
+	}
+	v.mu.Lock()
+	desc := false
+	if v.sorting && v.sortBy == name {
+		if v.desc {
+			v.mu.Unlock()
+			v.stopSort()
+			return
+		}
+		desc = true
+	}
+	v.mu.Unlock()
+	v.sort(name, desc)
+}
+
+// sort the entries of the history by field, in a table of the columns.
+// The table is read once, it doesn't grow with the entries appended.
+func (v *columnView) sort(field string, desc bool) {
+	names := v.columns()
+	if columns.Index(names, field) < 0 {
+		names = append(names[:len(names):len(names)], field)
+		v.set(names)
+	}
+	v.mu.Lock()
+	v.sortBy, v.desc, v.sorting = field, desc, true
+	v.mu.Unlock()
+
+	table := sortedTable(v.hist, names, field, desc, v.pager.Times())
+	v.queries.showTable(func() *query.Table { return table })
+}
+
+func (v *columnView) stopSort() {
+	v.mu.Lock()
+	v.sorting = false
+	v.mu.Unlock()
+	v.queries.run(nil)
+}
+
+// sortedTable reads the entries of the history, up to maxSorted of the
+// last, in rows of the columns sorted by field, with times shown as they
+// are in the pager.
+func sortedTable(hist *history.Store, names []string, field string, desc bool, times timefmt.Display) *query.Table {
+	from := hist.First()
+	if next := hist.Next(); next-from > maxSorted {
+		from = next - maxSorted
+	}
+	var rows []columns.Row
+	// a few lines at a time, to not hold the history for too long
+	for n, until := from, hist.Next(); n < until; {
+		start := n
+		err := hist.Walk(n, func(i uint64, line []byte, _ history.Meta) bool {
+			rows = append(rows, columns.NewRow(i, line, parseLine(line), names))
+			n = i + 1
+			return n < until && n-start < backfillChunk
+		})
+		if err == history.ErrEvicted {
+			n = hist.First()
+			continue
+		}
+		if err != nil {
+			log.Printf("can't read history to sort it: %v", err)
+		}
+		if err != nil || n == start {
+			break
+		}
+	}
+	col := columns.Index(names, field)
+	columns.Sort(rows, col, desc)
+
+	t := &query.Table{Columns: append([]string(nil), names...)}
+	mark := " ▲"
+	if desc {
+		mark = " ▼"
+	}
+	t.Columns[col] += mark
+	var prev time.Time
+	for _, r := range rows {
+		if times.Mode != timefmt.Parsed {
+			r.FormatTimes(times, prev)
+		}
+		for _, f := range r.Fields {
+			if at, ok := f.(parser.TimeField); ok {
+				prev = at.Time
+				break
+			}
+		}
+		t.Rows = append(t.Rows, r.Cells)
+	}
+	return t
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/aybabtme/logterm/correlate"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/query"
+	"log"
+	"sync"
+)
+
+// correlations shows the groups of entries that share a request or trace
+// identifier, found in the history.
+type correlations struct {
+	hist    *history.Store
+	queries *queryRunner
+
+	mu     sync.Mutex
+	fields []string
+}
+
+func newCorrelations(hist *history.Store, queries *queryRunner) *correlations {
+	return &correlations{hist: hist, queries: queries, fields: correlate.DefaultFields}
+}
+
+// setFields that entries are grouped by, the first that they have.
+func (c *correlations) setFields(fields []string) {
+	c.mu.Lock()
+	c.fields = fields
+	c.mu.Unlock()
+}
+
+// showLine shows the group of the entry on line n.
+func (c *correlations) showLine(n uint64) {
+	line, _, err := c.hist.Line(n)
+	if err != nil {
+		log.Printf("can't read line %d: %v", n, err)
+		return
+	}
+	c.mu.Lock()
+	fields := c.fields
+	c.mu.Unlock()
+	field, value, ok := correlate.Key(parseLine(line), fields)
+	if !ok {
+		log.Printf("line %d has none of the fields %v", n, fields)
+		return
+	}
+	c.show(field, value)
+}
+
+// show the group of the entries whose field has the value. It grows with
+// the entries that are appended.
+func (c *correlations) show(field, value string) {
+	c.queries.showTable(func() *query.Table {
+		g, err := findGroup(c.hist, field, value)
+		if err != nil {
+			log.Printf("can't find the entries of %s=%s: %v", field, value, err)
+		}
+		return g.Table(false)
+	})
+}
+
+// findGroup reads the entries of a group out of the history. The index
+// finds those that have the tokens of the value, only those that have
+// the whole value are kept.
+func findGroup(hist *history.Store, field, value string) (*correlate.Group, error) {
+	g := &correlate.Group{Field: field, Value: value}
+	lines, err := hist.Search(history.Match{Field: field, Value: value})
+	if err != nil {
+		return g, err
+	}
+	for _, n := range lines {
+		line, _, err := hist.Line(n)
+		if err == history.ErrEvicted {
+			continue
+		}
+		if err != nil {
+			return g, err
+		}
+		e := parseLine(line)
+		if _, v, ok := correlate.Key(e, []string{field}); ok && v == value {
+			g.Add(n, line, e)
+		}
+	}
+	return g, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aybabtme/logterm/dedup"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/ui"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lines of a run shown when it's expanded
+const maxExpanded = 1000
+
+// repeats collapses the repeated lines of the pager, when it's on.
+type repeats struct {
+	hist    *history.Store
+	pager   *ui.PagerBox
+	queries *queryRunner
+
+	mu   sync.Mutex
+	c    *dedup.Collapser
+	stop chan struct{}
+}
+
+// parseDedup reads the arguments of the dedup command: `raw` or `fields`
+// and a `window=1m`.
+func parseDedup(args []string) (dedup.Options, error) {
+	var opts dedup.Options
+	for _, arg := range args {
+		switch {
+		case arg == "raw":
+			opts.By = dedup.Raw
+		case arg == "fields":
+			opts.By = dedup.Fields
+		case strings.HasPrefix(arg, "window="):
+			d, err := time.ParseDuration(strings.TrimPrefix(arg, "window="))
+			if err != nil {
+				return opts, fmt.Errorf("invalid window: %v", err)
+			}
+			opts.Window = d
+		default:
+			return opts, fmt.Errorf("unexpected %q, want raw, fields or window=<duration>", arg)
+		}
+	}
+	return opts, nil
+}
+
+// start collapsing the lines of the history, in place of how they were.
+func (r *repeats) start(opts dedup.Options) {
+	c := dedup.NewCollapser(opts)
+	r.mu.Lock()
+	r.stopLocked()
+	r.c = c
+	r.stop = make(chan struct{})
+	stop := r.stop
+	r.mu.Unlock()
+
+	r.pager.SetFolder(c)
+	go r.follow(c, stop)
+}
+
+// off shows all the lines again.
+func (r *repeats) off() {
+	r.mu.Lock()
+	r.stopLocked()
+	r.c = nil
+	r.mu.Unlock()
+	r.pager.SetFolder(nil)
+}
+
+func (r *repeats) stopLocked() {
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// follow adds the lines of the history to c, in order, as they're
+// appended.
+func (r *repeats) follow(c *dedup.Collapser, stop chan struct{}) {
+	n := r.hist.First()
+	tick := time.NewTicker(tableRefresh)
+	defer tick.Stop()
+	for {
+		start := n
+		err := r.hist.Walk(n, func(i uint64, line []byte, meta history.Meta) bool {
+			e := parseLine(line)
+			at, ok := e.Time()
+			if !ok {
+				at = meta.Added
+			}
+			c.Add(i, line, e, at)
+			n = i + 1
+			return n-start < backfillChunk
+		})
+		switch {
+		case err == history.ErrEvicted:
+			n = r.hist.First()
+			continue
+		case err != nil:
+			log.Printf("can't read history to collapse repeats: %v", err)
+			return
+		}
+		// the runs keep only the lines that the history does
+		c.Evict(r.hist.First())
+		if n-start >= backfillChunk {
+			// more to read
+			continue
+		}
+		r.pager.Refresh()
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// expand shows the entries of the run of line n.
+func (r *repeats) expand(n uint64) {
+	r.mu.Lock()
+	c := r.c
+	r.mu.Unlock()
+	if c == nil {
+		return
+	}
+	run, ok := c.Run(n)
+	if !ok {
+		return
+	}
+	t := &query.Table{Columns: []string{"line", run.String()}}
+	for i, n := range run.Lines {
+		if i == maxExpanded {
+			t.Rows = append(t.Rows, []string{"", fmt.Sprintf("… %d more", len(run.Lines)-i)})
+			break
+		}
+		line, _, err := r.hist.Line(n)
+		if err == history.ErrEvicted {
+			continue
+		}
+		if err != nil {
+			log.Printf("can't read line %d: %v", n, err)
+			return
+		}
+		t.Rows = append(t.Rows, []string{strconv.FormatUint(n, 10), string(line)})
+	}
+	r.queries.showTable(func() *query.Table { return t })
+}
@@ -2,13 +2,55 @@ package main
 
 import (
 	"flag"
+	"github.com/aybabtme/logterm/alert"
+	"github.com/aybabtme/logterm/anomaly"
+	"github.com/aybabtme/logterm/columns"
+	"github.com/aybabtme/logterm/config"
+	"github.com/aybabtme/logterm/correlate"
+	"github.com/aybabtme/logterm/extract"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/metrics"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/redact"
+	"github.com/aybabtme/logterm/state"
+	"github.com/aybabtme/logterm/templates"
+	"github.com/aybabtme/logterm/timefmt"
 	"github.com/aybabtme/logterm/ui"
 	"github.com/aybabtme/tailf"
 	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// parseLine parses the lines of the log, with the extraction rules if
+// there are some.
+var parseLine = parser.ParseLine
+
+// conf is the config, whose saved queries commands can use.
+var conf = config.New()
+
+// saved is where the save command keeps queries by name, on top of those
+// of the config.
+var saved *state.Saved
+
+// configured are the names of the queries of the config, that save
+// can't replace.
+var configured = make(map[string]bool)
+
+// lastQuery is the last query run, that save keeps when it's given none.
+var lastQuery string
+
+// queries typed that are kept, for Ctrl-R to search
+const maxQueryLog = 1000
+
 func main() {
 	log.SetFlags(0)
 	f, err := os.OpenFile("canvas.log1", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -20,41 +62,458 @@ func main() {
 	log.Print("starting")
 
 	follow := flag.String("f", "", "file to follow")
+	colors := flag.Bool("colors", false, "draw the colors of lines that have escape sequences")
+	histDir := flag.String("history", "", "directory where to keep the history, default to a temporary one")
+	histSize := flag.Int64("history-size", 1<<30, "bytes of history to keep")
+	histAge := flag.Duration("history-age", 24*time.Hour, "how long to keep history for")
+	rulesFile := flag.String("rules", "", "file of grok patterns and rules that extract fields from lines")
+	timeFlag := flag.String("time", "", "show times in utc, local, an IANA zone, or relative: ago, or delta since the entry before")
+	redactFlag := flag.String("redact", "", "hide secrets from lines before they're kept, `on` for the defaults, or options like `action=hash`")
+	columnsFlag := flag.String("columns", "", "show the lines as columns of these fields")
+	queryFlag := flag.String("q", "", "only keep the lines that match this query, or show the table of its aggregation")
+	configFlag := flag.String("config", "", "config file, by default ~/.config/logterm/config.toml or config.yaml if there's one")
+	profileFlag := flag.String("p", "", "profile of the config, whose source is followed and whose options are the defaults of the flags")
+	alertsFlag := flag.Bool("alerts", true, "run the alerts of the config on the lines, with a banner at the top")
+	metricsFlag := flag.String("metrics", "", "serve the metrics of the config on /metrics at this address, like `:9100`")
 	flag.Parse()
 
+	if conf, err = config.Load(*configFlag); err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+	ui.Colors = conf.Theme
+	parser.AddTimeLayouts(conf.TimeLayouts...)
+	stateDir, err := state.DefaultDir()
+	if err != nil {
+		log.Fatalf("can't make the state directory: %v", err)
+	}
+	queryLog, err := state.OpenQueryLog(filepath.Join(stateDir, "queries"), maxQueryLog)
+	if err != nil {
+		log.Fatalf("can't read the queries typed before: %v", err)
+	}
+	if saved, err = state.OpenSaved(filepath.Join(stateDir, "saved-queries")); err != nil {
+		log.Fatalf("can't read the saved queries: %v", err)
+	}
+	for name := range conf.Queries {
+		configured[name] = true
+	}
+	for name, q := range saved.All() {
+		if configured[name] {
+			log.Printf("query %q is saved in the config, the one saved with save is ignored", name)
+			continue
+		}
+		conf.Queries[name] = q
+	}
+	if *profileFlag != "" {
+		profile, err := conf.Profile(*profileFlag)
+		if err != nil {
+			log.Fatalf("invalid -p: %v", err)
+		}
+		profile.SetFlags(flag.CommandLine)
+		if *follow == "" {
+			if len(profile.Sources) != 1 || len(profile.Command) > 0 {
+				log.Fatalf("profile %q needs a single file in its sources to be followed", profile.Name)
+			}
+			*follow = profile.Sources[0]
+		}
+	}
+
 	if *follow == "" {
 		log.Print("need a file to follow")
 		flag.PrintDefaults()
 		return
 	}
 
+	times := conf.Times
+	if *timeFlag != "" {
+		if times, err = timefmt.Parse(*timeFlag); err != nil {
+			log.Fatalf("invalid -time: %v", err)
+		}
+	}
+	parseLine = conf.Parse
+	if *rulesFile != "" {
+		rules, err := extract.LoadFile(*rulesFile, conf.Patterns)
+		if err != nil {
+			log.Fatalf("can't load rules: %v", err)
+		}
+		parseLine = func(line []byte) *parser.Entry { return rules.Apply(conf.Parse(line)) }
+	}
+	var q *query.Query
+	if *queryFlag != "" {
+		if q, err = conf.ParseQuery(*queryFlag); err != nil {
+			log.Fatalf("invalid -q: %v", err)
+		}
+	}
+
 	src, err := tailf.Follow(*follow, true)
 	if err != nil {
 		log.Fatalf("couldn't follow %q: %v", *follow, err)
 	}
 	defer src.Close()
+	var in io.Reader = src
+	if *redactFlag != "" {
+		opts, err := redact.Parse(*redactFlag)
+		if err != nil {
+			log.Fatalf("invalid -redact: %v", err)
+		}
+		r, err := redact.New(opts)
+		if err != nil {
+			log.Fatalf("invalid -redact: %v", err)
+		}
+		in = redact.NewReader(src, r)
+	}
+	if q != nil && !q.Aggregates() {
+		in = matching(in, q)
+	}
+
+	dir := *histDir
+	if dir == "" {
+		dir, err = ioutil.TempDir("", "logterm-history")
+		if err != nil {
+			log.Fatalf("can't create history directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+	}
+	hist, err := history.Open(dir, history.Options{
+		MaxSize: *histSize,
+		MaxAge:  *histAge,
+	})
+	if err != nil {
+		log.Fatalf("can't open history in %q: %v", dir, err)
+	}
+	defer hist.Close()
 
 	c, err := ui.NewCanvas(60)
 	if err != nil {
 		log.Fatalf("couldn't create canvas: %v", err)
 	}
 	defer c.Close()
-	top, bot := c.FullWithBar()
+	layout := ui.NewLayout(c)
+	pagerWin := layout.Pane(2)
+	tableWin := layout.Pane(1)
+	layout.SetVisible(tableWin, false)
+	chartWin := layout.Pane(1)
+	layout.SetVisible(chartWin, false)
+	bookmarksWin := layout.Side(1)
+	layout.SetVisible(bookmarksWin, false)
+	anomalyWin := layout.Side(1)
+	layout.SetVisible(anomalyWin, false)
+	fieldsWin := layout.Side(1)
+	layout.SetVisible(fieldsWin, false)
+
+	pager := ui.NewPagerBox(pagerWin, hist)
+	pager.SetInterpretColors(*colors)
+	pager.SetParser(parseLine)
+	pager.SetTimes(times)
+	layout.Attach(pagerWin, pager)
+	table := ui.NewTableBox(tableWin)
+	layout.Attach(tableWin, table)
+	chart := ui.NewChartBox(chartWin)
+	layout.Attach(chartWin, chart)
+	banner := &alertBanner{layout: layout, banner: ui.NewBannerBox(layout.Top()), win: layout.Top()}
+	layout.Attach(banner.win, banner.banner)
+	edit := ui.NewEditBox(layout.Bar())
+	edit.SetHistory(queryLog.Lines())
+	layout.Attach(layout.Bar(), edit)
+
+	queries := &queryRunner{
+		hist:     hist,
+		layout:   layout,
+		table:    table,
+		tableWin: tableWin,
+		chart:    chart,
+		chartWin: chartWin,
+	}
+	anomalies := ui.NewTableBox(anomalyWin)
+	layout.Attach(anomalyWin, anomalies)
+	det := anomaly.NewDetector(anomaly.Options{})
+	go listAnomalies(det, layout, anomalyWin, anomalies)
 
-	pager := ui.NewPagerBox(top)
-	edit := ui.NewEditBox(bot)
+	bookmarks, err := state.OpenBookmarks(filepath.Join(hist.Dir(), "bookmarks"))
+	if err != nil {
+		log.Fatalf("can't read the bookmarks: %v", err)
+	}
+	source, err := filepath.Abs(*follow)
+	if err != nil {
+		log.Fatalf("can't find %q: %v", *follow, err)
+	}
+	marksTable := ui.NewTableBox(bookmarksWin)
+	layout.Attach(bookmarksWin, marksTable)
+	marks := &bookmarkView{
+		hist:   hist,
+		pager:  pager,
+		marks:  bookmarks,
+		source: source,
+		layout: layout,
+		table:  marksTable,
+		win:    bookmarksWin,
+	}
+	marks.refresh()
+	pager.SetMarker(markBookmarks(bookmarks, markAnomalies(det)))
+
+	fields := ui.NewFieldListBox(fieldsWin)
+	layout.Attach(fieldsWin, fields)
+	cols := &columnView{
+		hist:      hist,
+		pager:     pager,
+		queries:   queries,
+		layout:    layout,
+		fields:    fields,
+		fieldsWin: fieldsWin,
+	}
+	// keys are bound to actions as the config says
+	bind := func(action string, fn func(uint64)) {
+		if key, ok := conf.Keys[action]; ok {
+			pager.Bind(key, fn)
+		}
+	}
+	bind("fields", cols.toggleList)
+	bind("next-field", func(uint64) { fields.Move(1) })
+	bind("previous-field", func(uint64) { fields.Move(-1) })
+	bind("toggle-column", cols.toggleSelected)
+	bind("column-left", func(uint64) { cols.moveSelected(-1) })
+	bind("column-right", func(uint64) { cols.moveSelected(1) })
+	bind("sort", cols.sortSelected)
+	bind("times", func(uint64) { pager.SetTimes(pager.Times().Next()) })
+	bind("bookmark", marks.toggle)
+	bind("bookmarks", marks.toggleList)
+	bind("next-bookmark", marks.next)
+	bind("previous-bookmark", marks.previous)
+
+	var rules []*alert.Rule
+	if *alertsFlag {
+		rules = conf.Alerts
+	}
+	alerts, err := alert.NewEngine(rules, alert.Options{Bell: os.Stdout, Banner: banner.show})
+	if err != nil {
+		log.Fatalf("invalid alerts: %v", err)
+	}
+	defer alerts.Close()
+	// no metrics unless they're served
+	reg, _ := metrics.NewRegistry(nil)
+	if *metricsFlag != "" {
+		if reg, err = serveMetrics(*metricsFlag); err != nil {
+			log.Fatalf("can't serve metrics: %v", err)
+		}
+	}
+
+	miner, mined := mineTemplates(hist)
+	pager.OnAppend(func(n uint64, line []byte, e *parser.Entry) {
+		queries.appended(n, line, e)
+		mined(n, line, e)
+		det.Add(n, line, e)
+		fields.Add(e)
+		alerts.Add(line, e)
+		reg.Add(e)
+	})
+	groups := newCorrelations(hist, queries)
+	bind("correlate", groups.showLine)
+	dups := &repeats{hist: hist, pager: pager, queries: queries}
+	bind("repeats", dups.expand)
+	edit.OnSubmit(func(line string) {
+		if err := queryLog.Add(line); err != nil {
+			log.Printf("can't keep the query typed: %v", err)
+		}
+		runCommand(pager, queries, miner, groups, dups, cols, marks, line)
+	})
+
+	if *columnsFlag != "" {
+		cols.set(columns.Parse(*columnsFlag))
+	}
+	if q != nil && q.Aggregates() {
+		queries.run(q)
+	}
 
 	go func() {
-		n, err := io.Copy(pager, src)
+		n, err := io.Copy(pager, in)
 		if err != nil {
 			log.Fatalf("writing to pager: %v", err)
 		}
 		log.Printf("%d bytes written", n)
 	}()
 
-	err = c.Run([]ui.ResizeHandler{top, bot}, []ui.InputHandler{edit})
+	err = c.Run([]ui.ResizeHandler{layout}, []ui.InputHandler{edit, pager})
 	if err != nil {
 		log.Printf("error running canvas: %v", err)
 	}
 
 }
+
+// runCommand typed in the edit box:
+//
+//	goto <time>    show the first line at or after that time
+//	templates      show the templates of the messages, the most common first
+//	templates new  show the templates that appeared last first
+//	correlate <field>=<value>
+//	               show the entries that share that value, in time order
+//	correlate <field>...
+//	               group by those fields when Ctrl-T is pressed on a line,
+//	               instead of the usual request and trace identifiers, or
+//	               by those again when no field is given
+//	dedup [raw|fields] [window=<duration>]
+//	               collapse the repeats of a line, or of its fields minus
+//	               times and ids, that follow it or come within the window.
+//	               Ctrl-O on a line lists its repeats
+//	dedup off      show all the lines again
+//	columns <field>...
+//	               show the lines as columns of those fields. Ctrl-F shows
+//	               the list of fields, Ctrl-N and Ctrl-P select one of
+//	               them, Ctrl-X adds or removes its column, Ctrl-B and
+//	               Ctrl-L move its column left or right
+//	columns off    show the lines again
+//	sort <field> [desc]
+//	               show the entries of the history in a table of the
+//	               columns, sorted by the field. Ctrl-S sorts by the
+//	               selected field, then in reverse, then stops
+//	sort off       hide the sorted table
+//	time <display> show times in utc, local, an IANA zone, or relative:
+//	               ago, or delta since the entry before. parsed shows them
+//	               as they were parsed again. Ctrl-W goes through the
+//	               displays
+//	<query>        show the table of an aggregation, like `| count by level`,
+//	               or its chart, like `| timechart span=10s count by level`.
+//	               `| extract <pattern>` stages capture more fields first
+//	@<name>        run the query saved by that name in the config, or with
+//	               save
+//	save <name> [query]
+//	               save the query, or the last one run, by that name. It's
+//	               kept from one run to the next
+//	unsave <name>  forget the query saved by that name
+//	queries        show the table of the saved queries
+//	bookmark [note]
+//	               bookmark the current line, with a note. Ctrl-K bookmarks
+//	               it or removes its bookmark, Ctrl-U and Ctrl-Y go to the
+//	               next and previous bookmarks. They're kept with the
+//	               history, for as long as it has their lines
+//	unbookmark <k> remove the k-th bookmark of the list
+//	bookmarks      show or hide the list of bookmarks, as Ctrl-V does
+//	jump <k>       go to the line of the k-th bookmark of the list
+//
+// An empty line stops the query. Ctrl-R searches the lines typed before,
+// again for older ones, and Ctrl-G stops searching.
+func runCommand(pager *ui.PagerBox, queries *queryRunner, miner *templates.Miner, groups *correlations, dups *repeats, cols *columnView, marks *bookmarkView, line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		queries.run(nil)
+		return
+	}
+	switch fields[0] {
+	case "goto":
+		t, err := parser.ParseTime(strings.Join(fields[1:], " "))
+		if err != nil {
+			log.Printf("can't go to time: %v", err)
+			return
+		}
+		if err := pager.GoToTime(t); err != nil {
+			log.Printf("can't go to time %v: %v", t, err)
+		}
+	case "templates":
+		newest := len(fields) > 1 && fields[1] == "new"
+		queries.showTable(func() *query.Table { return templateTable(miner, newest) })
+	case "correlate":
+		if len(fields) == 1 {
+			groups.setFields(correlate.DefaultFields)
+			return
+		}
+		if i := strings.IndexByte(fields[1], '='); i > 0 {
+			groups.show(fields[1][:i], fields[1][i+1:])
+			return
+		}
+		groups.setFields(fields[1:])
+	case "dedup":
+		if len(fields) > 1 && fields[1] == "off" {
+			dups.off()
+			return
+		}
+		opts, err := parseDedup(fields[1:])
+		if err != nil {
+			log.Printf("can't collapse repeats: %v", err)
+			return
+		}
+		dups.start(opts)
+	case "columns":
+		if len(fields) > 1 && fields[1] == "off" {
+			cols.set(nil)
+			return
+		}
+		cols.set(columns.Parse(strings.Join(fields[1:], " ")))
+	case "time":
+		d, err := timefmt.Parse(strings.Join(fields[1:], " "))
+		if err != nil {
+			log.Printf("can't show times: %v", err)
+			return
+		}
+		pager.SetTimes(d)
+	case "sort":
+		switch {
+		case len(fields) < 2:
+			log.Printf("sort needs a field")
+		case fields[1] == "off":
+			cols.stopSort()
+		default:
+			cols.sort(fields[1], len(fields) > 2 && fields[2] == "desc")
+		}
+	case "save":
+		if len(fields) < 2 {
+			log.Printf("save needs a name")
+			return
+		}
+		saveQuery(fields[1], wordsAfter(line, 2))
+	case "unsave":
+		if len(fields) < 2 {
+			log.Printf("unsave needs a name")
+			return
+		}
+		unsaveQuery(fields[1])
+	case "queries":
+		t := savedTable()
+		queries.showTable(func() *query.Table { return t })
+	case "bookmark":
+		if n, ok := pager.Current(); ok {
+			marks.add(n, strings.Join(fields[1:], " "))
+		}
+	case "unbookmark", "jump":
+		if len(fields) < 2 {
+			log.Printf("%s needs the number of a bookmark", fields[0])
+			return
+		}
+		k, err := strconv.Atoi(fields[1])
+		if err != nil {
+			log.Printf("invalid bookmark %q: %v", fields[1], err)
+			return
+		}
+		if fields[0] == "jump" {
+			marks.jump(k)
+		} else {
+			marks.remove(k)
+		}
+	case "bookmarks":
+		marks.toggleList(0)
+	default:
+		q, err := conf.ParseQuery(line)
+		if err != nil {
+			log.Printf("invalid query %q: %v", line, err)
+			return
+		}
+		lastQuery = strings.TrimSpace(line)
+		queries.run(q)
+	}
+}
+
+// serveMetrics of the config on /metrics at addr, in the background.
+func serveMetrics(addr string) (*metrics.Registry, error) {
+	reg, err := metrics.NewRegistry(conf.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg)
+	go func() {
+		log.Printf("stopped serving metrics: %v", http.Serve(l, mux))
+	}()
+	log.Printf("serving metrics on http://%s/metrics", l.Addr())
+	return reg, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/ui"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// how often the table of a query is redrawn
+	tableRefresh = 250 * time.Millisecond
+	// lines read from the history at once, to not hold it for too long
+	backfillChunk = 1024
+)
+
+// queryRunner aggregates a query over the history, then over the lines
+// that are appended to it, and keeps a table or chart pane up to date.
+type queryRunner struct {
+	hist     *history.Store
+	layout   *ui.Layout
+	table    *ui.TableBox
+	tableWin *ui.Window
+	chart    *ui.ChartBox
+	chartWin *ui.Window
+
+	mu   sync.Mutex
+	q    *query.Query
+	from uint64
+	stop chan struct{}
+}
+
+// run q in place of the query that was running. A nil q hides the panes.
+func (r *queryRunner) run(q *query.Query) {
+	r.mu.Lock()
+	r.stopLocked()
+	if q == nil || !q.Aggregates() {
+		r.mu.Unlock()
+		r.hide()
+		return
+	}
+	// lines from there are added as they are appended, the ones
+	// before are read from the history
+	r.q = q
+	r.from = r.hist.Next()
+	r.stop = make(chan struct{})
+	stop, from := r.stop, r.from
+	r.mu.Unlock()
+
+	show := func() {
+		if chart := q.Chart(); chart != nil {
+			r.chart.SetChart(chart)
+		} else {
+			r.table.SetTable(q.Table())
+		}
+	}
+	show()
+	charts := q.Chart() != nil
+	r.layout.SetVisible(r.tableWin, !charts)
+	r.layout.SetVisible(r.chartWin, charts)
+	go func() {
+		err := backfill(r.hist, from, stop, func(line []byte, e *parser.Entry) { q.Add(e) })
+		if err != nil {
+			log.Printf("can't read history for query %q: %v", q, err)
+		}
+	}()
+	go refresh(stop, show)
+}
+
+// showTable shows the tables that fn returns, until something else is
+// shown.
+func (r *queryRunner) showTable(fn func() *query.Table) {
+	r.mu.Lock()
+	r.stopLocked()
+	r.stop = make(chan struct{})
+	stop := r.stop
+	r.mu.Unlock()
+
+	show := func() { r.table.SetTable(fn()) }
+	show()
+	r.layout.SetVisible(r.chartWin, false)
+	r.layout.SetVisible(r.tableWin, true)
+	go refresh(stop, show)
+}
+
+func (r *queryRunner) stopLocked() {
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	r.q = nil
+}
+
+func (r *queryRunner) hide() {
+	r.layout.SetVisible(r.tableWin, false)
+	r.layout.SetVisible(r.chartWin, false)
+}
+
+// appended is called by the pager with each new line.
+func (r *queryRunner) appended(n uint64, line []byte, e *parser.Entry) {
+	r.mu.Lock()
+	q, from := r.q, r.from
+	r.mu.Unlock()
+	if q != nil && n >= from {
+		q.Add(e)
+	}
+}
+
+// backfill calls fn with the lines of the history that are before
+// `until`, a few at a time, until it's done or stopped.
+func backfill(hist *history.Store, until uint64, stop chan struct{}, fn func(line []byte, e *parser.Entry)) error {
+	n := hist.First()
+	for n < until {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		start := n
+		err := hist.Walk(n, func(i uint64, line []byte, _ history.Meta) bool {
+			fn(line, parseLine(line))
+			n = i + 1
+			return n < until && n-start < backfillChunk
+		})
+		if err == history.ErrEvicted {
+			// the start of the history went away while reading it
+			n = hist.First()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == start {
+			return nil
+		}
+	}
+	return nil
+}
+
+// refresh calls show regularly, until stopped.
+func refresh(stop chan struct{}, show func()) {
+	tick := time.NewTicker(tableRefresh)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			show()
+		}
+	}
+}
+
+// matching reads the lines of src that match a query that doesn't
+// aggregate.
+func matching(src io.Reader, q *query.Query) io.Reader {
+	rd, wr := io.Pipe()
+	go func() {
+		scan := bufio.NewScanner(src)
+		scan.Buffer(nil, 1<<20)
+		var line []byte
+		for scan.Scan() {
+			if _, ok := q.Match(parseLine(scan.Bytes())); !ok {
+				continue
+			}
+			line = append(append(line[:0], scan.Bytes()...), '\n')
+			if _, err := wr.Write(line); err != nil {
+				return
+			}
+		}
+		wr.CloseWithError(scan.Err())
+	}()
+	return rd
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/aybabtme/logterm/query"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// saveQuery saves q by name, or the last query run if q is empty. Names
+// of the config can't be saved over.
+func saveQuery(name, q string) {
+	if q == "" {
+		q = lastQuery
+	}
+	if q == "" {
+		log.Printf("no query to save as %q", name)
+		return
+	}
+	if _, err := conf.ParseQuery(q); err != nil {
+		log.Printf("can't save invalid query %q: %v", q, err)
+		return
+	}
+	if configured[name] {
+		log.Printf("can't save %q, the config has a query by that name", name)
+		return
+	}
+	if err := saved.Save(name, q); err != nil {
+		log.Printf("can't save query %q: %v", name, err)
+		return
+	}
+	conf.Queries[name] = q
+}
+
+// unsaveQuery forgets the query saved by name.
+func unsaveQuery(name string) {
+	if !isSaved(name) {
+		if configured[name] {
+			log.Printf("can't unsave %q, it's saved in the config", name)
+		} else {
+			log.Printf("no query is saved as %q", name)
+		}
+		return
+	}
+	if _, err := saved.Delete(name); err != nil {
+		log.Printf("can't unsave query %q: %v", name, err)
+		return
+	}
+	delete(conf.Queries, name)
+}
+
+// isSaved is true if the query by name was saved with save, and not in
+// the config.
+func isSaved(name string) bool {
+	_, ok := saved.All()[name]
+	return ok && !configured[name]
+}
+
+// savedTable of the queries of the config and those saved, by name.
+func savedTable() *query.Table {
+	names := make([]string, 0, len(conf.Queries))
+	for name := range conf.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	t := &query.Table{Columns: []string{"name", "query", "saved in"}}
+	for _, name := range names {
+		in := "config"
+		if isSaved(name) {
+			in = "state"
+		}
+		t.Rows = append(t.Rows, []string{"@" + name, conf.Queries[name], in})
+	}
+	return t
+}
+
+// wordsAfter is what follows the first k words of line, as it was typed.
+func wordsAfter(line string, k int) string {
+	s := strings.TrimSpace(line)
+	for ; k > 0 && s != ""; k-- {
+		i := strings.IndexFunc(s, unicode.IsSpace)
+		if i < 0 {
+			return ""
+		}
+		s = strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+	}
+	return s
+}
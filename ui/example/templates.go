@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"github.com/aybabtme/logterm/templates"
+	"github.com/dustin/go-humanize"
+	"log"
+	"strconv"
+)
+
+// mineTemplates finds the templates of the lines of the history, and then
+// of those that are appended to it, which the caller passes to the
+// returned func.
+func mineTemplates(hist *history.Store) (*templates.Miner, func(n uint64, line []byte, e *parser.Entry)) {
+	miner := templates.NewMiner(templates.Options{})
+	from := hist.Next()
+	go func() {
+		err := backfill(hist, from, nil, func(line []byte, e *parser.Entry) { miner.AddEntry(line, e) })
+		if err != nil {
+			log.Printf("can't read history for templates: %v", err)
+		}
+	}()
+	return miner, func(n uint64, line []byte, e *parser.Entry) {
+		if n >= from {
+			miner.AddEntry(line, e)
+		}
+	}
+}
+
+// templateTable lists the templates, those that appeared last first when
+// newest is set.
+func templateTable(miner *templates.Miner, newest bool) *query.Table {
+	all := miner.Templates()
+	if newest {
+		templates.Newest(all)
+	}
+	t := &query.Table{Columns: []string{"count", "first", "last", "template"}}
+	for _, tmpl := range all {
+		t.Rows = append(t.Rows, []string{
+			strconv.Itoa(tmpl.Count),
+			humanize.Time(tmpl.First),
+			humanize.Time(tmpl.Last),
+			tmpl.String(),
+		})
+	}
+	return t
+}
@@ -0,0 +1,129 @@
+package ui
+
+import (
+	"github.com/aybabtme/logterm/columns"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/nsf/termbox-go"
+	"strconv"
+	"sync"
+)
+
+var (
+	_ ResizeHandler = &FieldListBox{}
+)
+
+// FieldListBox lists the fields of the entries it's given, with how many
+// entries have them, and the place of those shown as columns. One of them
+// is selected, to act on.
+type FieldListBox struct {
+	win *Window
+
+	mu      sync.Mutex
+	names   []string
+	counts  map[string]int
+	cursor  int
+	columns []string
+}
+
+func NewFieldListBox(win *Window) *FieldListBox {
+	return &FieldListBox{win: win, counts: make(map[string]int)}
+}
+
+// Add the fields of the entry to the list.
+func (f *FieldListBox) Add(e *parser.Entry) {
+	f.mu.Lock()
+	for _, name := range e.FieldNames() {
+		if _, ok := f.counts[name]; !ok {
+			f.names = append(f.names, name)
+		}
+		f.counts[name]++
+	}
+	f.mu.Unlock()
+	f.Refresh()
+}
+
+// Move the selection by delta fields, down the list when it's positive.
+func (f *FieldListBox) Move(delta int) {
+	f.mu.Lock()
+	f.cursor += delta
+	if f.cursor >= len(f.names) {
+		f.cursor = len(f.names) - 1
+	}
+	if f.cursor < 0 {
+		f.cursor = 0
+	}
+	f.mu.Unlock()
+	f.Refresh()
+}
+
+// Selected is the name of the selected field, false when there are none.
+func (f *FieldListBox) Selected() (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cursor >= len(f.names) {
+		return "", false
+	}
+	return f.names[f.cursor], true
+}
+
+// SetColumns marks the fields that are shown as columns with their place.
+func (f *FieldListBox) SetColumns(names []string) {
+	f.mu.Lock()
+	f.columns = names
+	f.mu.Unlock()
+	f.Refresh()
+}
+
+func (f *FieldListBox) Resize(x, y, width, height int) { f.Refresh() }
+
+func (f *FieldListBox) Refresh() {
+	f.mu.Lock()
+	rows := [][]string{{"#", "field", "count"}}
+	for _, name := range f.names {
+		place := ""
+		if i := columns.Index(f.columns, name); i >= 0 {
+			place = strconv.Itoa(i + 1)
+		}
+		rows = append(rows, []string{place, name, strconv.Itoa(f.counts[name])})
+	}
+	cursor := f.cursor
+	f.mu.Unlock()
+
+	width, height := f.win.Width(), f.win.Height()
+	// keep the selection in view, under the header
+	first := 1
+	if cursor+1 >= height && height > 1 {
+		first = cursor + 2 - (height - 1)
+	}
+	widths := columnWidths(rows, width)
+	for y := 0; y < height; y++ {
+		i := y
+		if y > 0 {
+			i = first + y - 1
+		}
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		switch {
+		case y == 0:
+			fg, bg = Colors.Header.Fg, Colors.Header.Bg
+		case i == cursor+1:
+			fg, bg = Colors.Selected.Fg, Colors.Selected.Bg
+		}
+		var cells []cell
+		if i < len(rows) {
+			for j, w := range widths {
+				if j > 0 {
+					cells = append(cells, cell{ch: ' ', width: 1, fg: fg, bg: bg})
+				}
+				cells = fitCells(cells, rows[i][j], w, fg, bg)
+			}
+		}
+		x := 0
+		for _, c := range cells {
+			f.win.Draw(x, y, c.ch, c.fg, c.bg)
+			x += c.width
+		}
+		for ; x < width; x++ {
+			f.win.Draw(x, y, ' ', fg, bg)
+		}
+	}
+}
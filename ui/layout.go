@@ -0,0 +1,184 @@
+package ui
+
+import (
+	"sync"
+)
+
+var (
+	_ ResizeHandler = &Layout{}
+)
+
+// the side column takes a share of the width, within bounds
+const (
+	sideShare    = 3
+	minSideWidth = 20
+	maxSideWidth = 60
+)
+
+// Layout stacks panes above a bar at the bottom of the canvas, and side
+// panes in a column on their right, under a line at the top that's only
+// there when it's visible. The panes share the height by weight, and
+// hidden panes leave their room to the others.
+type Layout struct {
+	canvas *Canvas
+
+	mu    sync.Mutex
+	panes []*pane
+	sides []*pane
+	top   *pane
+	bar   *pane
+}
+
+type pane struct {
+	win    *Window
+	box    ResizeHandler
+	weight int
+	hidden bool
+}
+
+func NewLayout(canvas *Canvas) *Layout {
+	return &Layout{
+		canvas: canvas,
+		top:    &pane{win: canvas.Window(0, 0, 0, 0), weight: 1, hidden: true},
+		bar:    &pane{win: canvas.Window(0, 0, 0, 0), weight: 1},
+	}
+}
+
+// Bar is the window of the line at the bottom.
+func (l *Layout) Bar() *Window { return l.bar.win }
+
+// Top is the window of the line at the top, hidden until it's made
+// visible.
+func (l *Layout) Top() *Window { return l.top.win }
+
+// Pane adds a pane under the others, taking `weight` shares of the height.
+func (l *Layout) Pane(weight int) *Window {
+	p := &pane{win: l.canvas.Window(0, 0, 0, 0), weight: weight}
+	l.mu.Lock()
+	l.panes = append(l.panes, p)
+	l.mu.Unlock()
+	return p.win
+}
+
+// Side adds a pane under the others of the side column, taking `weight`
+// shares of its height.
+func (l *Layout) Side(weight int) *Window {
+	p := &pane{win: l.canvas.Window(0, 0, 0, 0), weight: weight}
+	l.mu.Lock()
+	l.sides = append(l.sides, p)
+	l.mu.Unlock()
+	return p.win
+}
+
+// Attach the box that draws in the window, to tell it when it's resized.
+func (l *Layout) Attach(win *Window, box ResizeHandler) {
+	l.mu.Lock()
+	if p := l.find(win); p != nil {
+		p.box = box
+	}
+	l.mu.Unlock()
+	l.Arrange()
+}
+
+// SetVisible shows or hides the pane of the window.
+func (l *Layout) SetVisible(win *Window, visible bool) {
+	l.mu.Lock()
+	if p := l.find(win); p != nil {
+		p.hidden = !visible
+	}
+	l.mu.Unlock()
+	l.Arrange()
+}
+
+func (l *Layout) find(win *Window) *pane {
+	if l.bar.win == win {
+		return l.bar
+	}
+	if l.top.win == win {
+		return l.top
+	}
+	for _, p := range append(l.panes, l.sides...) {
+		if p.win == win {
+			return p
+		}
+	}
+	return nil
+}
+
+func (l *Layout) Resize(x, y, width, height int) { l.Arrange() }
+
+// Arrange the panes to fit the canvas.
+func (l *Layout) Arrange() {
+	width, height := l.canvas.Size()
+
+	l.mu.Lock()
+	sideW := 0
+	if anyVisible(l.sides) {
+		sideW = width / sideShare
+		if sideW < minSideWidth {
+			sideW = minSideWidth
+		}
+		if sideW > maxSideWidth {
+			sideW = maxSideWidth
+		}
+		if sideW > width/2 {
+			sideW = width / 2
+		}
+	}
+	top := 0
+	if !l.top.hidden {
+		top = 1
+	}
+	visible := stack(l.panes, 0, top, width-sideW, height-1-top)
+	visible = append(visible, stack(l.sides, width-sideW, top, sideW, height-1-top)...)
+	l.bar.win.Resize(0, height-1, width, 1)
+	visible = append(visible, l.bar)
+	l.top.win.Resize(0, 0, width*top, top)
+	if top > 0 {
+		visible = append(visible, l.top)
+	}
+	l.mu.Unlock()
+
+	for _, p := range visible {
+		if p.box != nil {
+			p.box.Resize(0, 0, p.win.Width(), p.win.Height())
+		}
+	}
+}
+
+func anyVisible(panes []*pane) bool {
+	for _, p := range panes {
+		if !p.hidden {
+			return true
+		}
+	}
+	return false
+}
+
+// stack the visible panes in a column at x, from y down, and return them.
+func stack(panes []*pane, x, y, width, height int) []*pane {
+	var (
+		visible []*pane
+		weights int
+	)
+	for _, p := range panes {
+		if p.hidden {
+			// nothing it draws shows
+			p.win.Resize(0, 0, 0, 0)
+			continue
+		}
+		visible = append(visible, p)
+		weights += p.weight
+	}
+	bottom := y + height
+	for i, p := range visible {
+		h := height * p.weight / weights
+		if i == len(visible)-1 {
+			// the last one gets what's left from rounding
+			h = bottom - y
+		}
+		p.win.Resize(x, y, width, h)
+		y += h
+	}
+	return visible
+}
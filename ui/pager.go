@@ -2,89 +2,548 @@ package ui
 
 import (
 	"bytes"
-	"github.com/aybabtme/linehistory"
-	"io"
-	"unicode/utf8"
+	"github.com/aybabtme/logterm/columns"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/timefmt"
+	"github.com/nsf/termbox-go"
+	"log"
+	"sync"
+	"time"
 )
 
-const maxRuneLen = 4 // assume the max length of a utf8 rune is 4
+var (
+	_ ResizeHandler = &PagerBox{}
+	_ InputHandler  = &PagerBox{}
+)
+
+// Folder hides lines of the pager behind others, like the repeats of a
+// line behind it.
+type Folder interface {
+	// Fold tells if line n is hidden, and if it isn't, what to show
+	// after it
+	Fold(n uint64) (suffix string, hidden bool)
+	// Back is the line shown k shown lines before line n
+	Back(n uint64, k int) uint64
+	// Forward is the line shown k shown lines after line n
+	Forward(n uint64, k int) uint64
+}
 
-// PagerBox shows text from an io.ReadSeeker.
+// PagerBox shows the lines written to it, and lets the user scroll back
+// through those that the history retains.
 type PagerBox struct {
 	win    *Window
 	width  int
 	height int
-	lines  linehistory.History
+	colors bool
+
+	mu      sync.Mutex
+	lines   *history.Store
+	partial []byte
+	// when not following the end of the history, the line shown at
+	// the top of the pager
+	follow bool
+	top    uint64
+
+	parse    func(line []byte) *parser.Entry
+	onAppend func(n uint64, line []byte, e *parser.Entry)
+	marker   func(n uint64) (termbox.Attribute, bool)
+	keys     map[termbox.Key]func(n uint64)
+	folder   Folder
+	// when not nil, lines are shown as the columns of their fields
+	columns []string
+	times   timefmt.Display
 }
 
-func NewPagerBox(win *Window) *PagerBox {
-	maxSize := win.Width() * win.Height() * maxRuneLen
+func NewPagerBox(win *Window, lines *history.Store) *PagerBox {
 	return &PagerBox{
-		win:   win,
-		lines: linehistory.NewNaive(maxSize, '\n'),
+		win:    win,
+		lines:  lines,
+		follow: true,
+		parse:  parser.ParseLine,
+		keys:   make(map[termbox.Key]func(n uint64)),
 	}
 }
 
+// SetParser makes the pager parse the lines written to it with fn, like
+// to extract more fields than parser.ParseLine does.
+func (p *PagerBox) SetParser(fn func(line []byte) *parser.Entry) {
+	p.mu.Lock()
+	p.parse = fn
+	p.mu.Unlock()
+}
+
+// SetInterpretColors makes the pager draw the colors that SGR escape
+// sequences ask for, instead of dropping them.
+func (p *PagerBox) SetInterpretColors(colors bool) {
+	p.mu.Lock()
+	p.colors = colors
+	p.mu.Unlock()
+	p.Refresh()
+}
+
+// OnAppend calls fn with each line that is written to the pager, once
+// it's in the history. fn can keep the line and its entry, but can't use
+// the pager.
+func (p *PagerBox) OnAppend(fn func(n uint64, line []byte, e *parser.Entry)) {
+	p.mu.Lock()
+	p.onAppend = fn
+	p.mu.Unlock()
+}
+
+// SetMarker highlights the lines for which fn returns true, with the
+// background color it returns.
+func (p *PagerBox) SetMarker(fn func(n uint64) (bg termbox.Attribute, ok bool)) {
+	p.mu.Lock()
+	p.marker = fn
+	p.mu.Unlock()
+	p.Refresh()
+}
+
+// Bind calls fn with the number of the current line when key is
+// pressed. fn can use the pager.
+func (p *PagerBox) Bind(key termbox.Key, fn func(n uint64)) {
+	p.mu.Lock()
+	p.keys[key] = fn
+	p.mu.Unlock()
+}
+
+// Current is the number of the line the user is looking at: the one at
+// the top of the pager, or the last one when following the end of the
+// history. It's false when the history is empty.
+func (p *PagerBox) Current() (uint64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := p.lines.Next()
+	if next == p.lines.First() {
+		return 0, false
+	}
+	if p.follow {
+		return next - 1, true
+	}
+	return p.topLine(), true
+}
+
+// SetFolder hides the lines that f folds, nil shows them all again.
+func (p *PagerBox) SetFolder(f Folder) {
+	p.mu.Lock()
+	p.folder = f
+	p.mu.Unlock()
+	p.Refresh()
+}
+
+// SetColumns shows the fields of the lines in columns, under a header,
+// instead of the lines. Nil shows the lines again.
+func (p *PagerBox) SetColumns(names []string) {
+	p.mu.Lock()
+	p.columns = names
+	p.mu.Unlock()
+	p.Refresh()
+}
+
+// SetTimes shows the times of entries with the display. Unless it shows
+// them as they were parsed, lines are prefixed with their time.
+func (p *PagerBox) SetTimes(d timefmt.Display) {
+	p.mu.Lock()
+	p.times = d
+	p.mu.Unlock()
+	p.Refresh()
+}
+
+// Times is how times are displayed.
+func (p *PagerBox) Times() timefmt.Display {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.times
+}
+
+// Columns that are shown, nil when lines are.
+func (p *PagerBox) Columns() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.columns
+}
+
+// back is the line shown k lines before n.
+func (p *PagerBox) back(n, k uint64) uint64 {
+	if p.folder != nil {
+		return p.folder.Back(n, int(k))
+	}
+	if n < k {
+		return 0
+	}
+	return n - k
+}
+
+// forward is the line shown k lines after n.
+func (p *PagerBox) forward(n, k uint64) uint64 {
+	if p.folder != nil {
+		return p.folder.Forward(n, int(k))
+	}
+	return n + k
+}
+
+// rows of lines the pager shows, those of the window but the header of
+// the columns. The lock must be held.
+func (p *PagerBox) rows() uint64 {
+	height := p.win.Height()
+	if p.columns != nil {
+		height--
+	}
+	if height < 0 {
+		return 0
+	}
+	return uint64(height)
+}
+
 func (p *PagerBox) canShowRunes() int {
 	return p.win.Height() * p.win.Width()
 }
 
+// Write appends the complete lines of b to the history. The last line is
+// kept until its end is written.
 func (p *PagerBox) Write(b []byte) (int, error) {
-	p.lines.Add(b)
+	p.mu.Lock()
+	data := append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			break
+		}
+		// the line is copied out of the buffer that's reused by the
+		// next write, for its entry and onAppend to keep
+		line := append([]byte(nil), bytes.TrimSuffix(data[:i], []byte("\r"))...)
+		e := p.parse(line)
+		n, err := p.lines.Append(line, e)
+		if err != nil {
+			p.mu.Unlock()
+			return 0, err
+		}
+		if p.onAppend != nil {
+			p.onAppend(n, line, e)
+		}
+		data = data[i+1:]
+	}
+	p.partial = append(p.partial[:0], data...)
+	p.mu.Unlock()
+
 	p.Refresh()
 	return len(b), nil
 }
 
+func (p *PagerBox) Resize(x, y, width, height int) { p.Refresh() }
+
+func (p *PagerBox) KeyPress(ch rune, key termbox.Key, mod termbox.Modifier) {
+	p.mu.Lock()
+	height := p.rows()
+	p.mu.Unlock()
+	switch key {
+	case termbox.KeyArrowUp:
+		p.scrollUp(1)
+	case termbox.KeyArrowDown:
+		p.scrollDown(1)
+	case termbox.KeyPgup:
+		p.scrollUp(height)
+	case termbox.KeyPgdn:
+		p.scrollDown(height)
+	case termbox.KeyEnd:
+		p.mu.Lock()
+		p.follow = true
+		p.mu.Unlock()
+	default:
+		p.mu.Lock()
+		fn := p.keys[key]
+		p.mu.Unlock()
+		if n, ok := p.Current(); fn != nil && ok {
+			fn(n)
+		}
+		return
+	}
+	p.Refresh()
+}
+
+func (p *PagerBox) Mouse(termbox.Event) {}
+
+func (p *PagerBox) scrollUp(n uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	top := p.back(p.topLine(), n)
+	if first := p.lines.First(); top < first {
+		top = first
+	}
+	p.follow = false
+	p.top = top
+}
+
+func (p *PagerBox) scrollDown(n uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.follow {
+		return
+	}
+	p.top = p.forward(p.top, n)
+	if p.forward(p.top, p.rows()) >= p.lines.Next() {
+		p.follow = true
+	}
+}
+
+// GoTo shows the line numbered n at the top of the pager, or follows the
+// end of the history if n is on its last page.
+func (p *PagerBox) GoTo(n uint64) {
+	p.mu.Lock()
+	p.follow = p.forward(n, p.rows()) >= p.lines.Next()
+	p.top = n
+	p.mu.Unlock()
+	p.Refresh()
+}
+
+// GoToTime shows the first line at or after t at the top of the pager.
+func (p *PagerBox) GoToTime(t time.Time) error {
+	n, err := p.lines.SeekTime(t)
+	if err != nil {
+		return err
+	}
+	p.GoTo(n)
+	return nil
+}
+
+// topLine is the number of the line shown at the top. When following the
+// end of the history, it's a guess that ignores wrapped lines.
+func (p *PagerBox) topLine() uint64 {
+	first := p.lines.First()
+	if !p.follow {
+		if p.top < first {
+			return first
+		}
+		return p.top
+	}
+	if top := p.back(p.lines.Next(), p.rows()); top > first {
+		return top
+	}
+	return first
+}
+
+// view is what the pager shows, read at once to draw it.
+type view struct {
+	top     uint64
+	follow  bool
+	colors  bool
+	marker  func(n uint64) (termbox.Attribute, bool)
+	folder  Folder
+	columns []string
+	parse   func(line []byte) *parser.Entry
+	times   timefmt.Display
+}
+
 func (p *PagerBox) Refresh() {
-	var lines []*bytes.Buffer
-	var totalSpan int
-	p.lines.Walk(func(line []byte) {
-		span := (utf8.RuneCount(line)-1)/p.win.Width() + 1
-		totalSpan += span
+	p.mu.Lock()
+	v := view{
+		top:     p.topLine(),
+		follow:  p.follow,
+		colors:  p.colors,
+		marker:  p.marker,
+		folder:  p.folder,
+		columns: p.columns,
+		parse:   p.parse,
+		times:   p.times,
+	}
+	p.mu.Unlock()
+
+	if v.columns != nil {
+		p.refreshColumns(v)
+		return
+	}
+	width := p.win.Width()
+	height := p.win.Height()
+
+	// each line takes at least a row, so no more than `height` lines
+	// can be seen
+	var lines []row
+	shown := 0
+	prev := p.timeBefore(v)
+	err := p.lines.Walk(v.top, func(n uint64, line []byte, _ history.Meta) bool {
+		var suffix string
+		if v.folder != nil {
+			var hidden bool
+			if suffix, hidden = v.folder.Fold(n); hidden {
+				return true
+			}
+		}
+		var cells []cell
+		if v.times.Mode != timefmt.Parsed {
+			// the time as it's displayed goes before the line
+			if at, ok := v.parse(line).Time(); ok {
+				cells = appendClusters(cells, []byte(v.times.Format(at, prev, timefmt.Layout)), Colors.Time.Fg, Colors.Time.Bg)
+				cells = append(cells, cell{ch: ' ', width: 1})
+				prev = at
+			}
+		}
+		cells = append(cells, lineCells(line, v.colors)...)
+		if suffix != "" {
+			cells = appendClusters(append(cells, cell{ch: ' ', width: 1}), []byte(suffix), Colors.Count.Fg, Colors.Count.Bg)
+		}
+		var bg termbox.Attribute
+		if v.marker != nil {
+			if mark, ok := v.marker(n); ok {
+				bg = mark
+				for i := range cells {
+					cells[i].bg = bg
+				}
+			}
+		}
 		// when a line is wider than the pager, break it in many lines
-		for i := 0; i < span; i++ {
-			from := i * p.win.Width()
-			to := imin(from+p.win.Width(), len(line))
-			lines = append(lines, bytes.NewBuffer(line[from:to]))
+		for _, cells := range wrapCells(cells, width) {
+			lines = append(lines, row{cells: cells, bg: bg})
 		}
+		shown++
+		return shown < height
 	})
+	if err != nil {
+		log.Printf("can't read history: %v", err)
+	}
 
-	// discard lines that are higher than what the pager can show
-	start := totalSpan - p.win.Height()
-	if start >= 0 {
-		lines = lines[start:]
-	} else {
+	switch {
+	case len(lines) > height && v.follow:
+		// discard lines that are higher than what the pager can show
+		lines = lines[len(lines)-height:]
+	case len(lines) > height:
+		// discard lines that are lower than what the pager can show
+		lines = lines[:height]
+	case v.follow:
 		// need to pad with empty lines
-		missing := -1 * start
-		empties := make([]*bytes.Buffer, 0, missing)
-		for ; missing > 0; missing-- {
-			empties = append(empties, bytes.NewBuffer([]byte("\n")))
+		lines = append(make([]row, height-len(lines)), lines...)
+	default:
+		lines = append(lines, make([]row, height-len(lines))...)
+	}
+
+	p.drawLines(lines)
+}
+
+// timeBefore is the time of the line before the top one, that the times
+// shown relative to the entry before them start from.
+func (p *PagerBox) timeBefore(v view) time.Time {
+	if v.times.Mode != timefmt.Delta || v.top <= p.lines.First() {
+		return time.Time{}
+	}
+	line, _, err := p.lines.Line(v.top - 1)
+	if err != nil {
+		return time.Time{}
+	}
+	at, _ := v.parse(line).Time()
+	return at
+}
+
+// refreshColumns draws a line per row, with the values of its fields in
+// columns that fit the widest values shown.
+func (p *PagerBox) refreshColumns(v view) {
+	width := p.win.Width()
+	// the header takes a row
+	height := p.win.Height() - 1
+	if height < 0 {
+		height = 0
+	}
+
+	type shownRow struct {
+		cells  []string
+		suffix string
+		bg     termbox.Attribute
+	}
+	var shown []shownRow
+	// columns of times, that tell how they're displayed
+	timeCols := make([]bool, len(v.columns))
+	prev := p.timeBefore(v)
+	err := p.lines.Walk(v.top, func(n uint64, line []byte, _ history.Meta) bool {
+		var suffix string
+		if v.folder != nil {
+			var hidden bool
+			if suffix, hidden = v.folder.Fold(n); hidden {
+				return true
+			}
+		}
+		e := v.parse(line)
+		cols := columns.NewRow(n, line, e, v.columns)
+		if v.times.Mode != timefmt.Parsed {
+			cols.FormatTimes(v.times, prev)
+			if at, ok := e.Time(); ok {
+				prev = at
+			}
+		}
+		for i, f := range cols.Fields {
+			if _, ok := f.(parser.TimeField); ok {
+				timeCols[i] = true
+			}
 		}
-		lines = append(empties, lines...)
+		r := shownRow{cells: cols.Cells, suffix: suffix}
+		if v.marker != nil {
+			if mark, ok := v.marker(n); ok {
+				r.bg = mark
+			}
+		}
+		shown = append(shown, r)
+		return len(shown) < height
+	})
+	if err != nil {
+		log.Printf("can't read history: %v", err)
 	}
 
+	header := append([]string(nil), v.columns...)
+	if v.times.Mode != timefmt.Parsed {
+		for i := range header {
+			if timeCols[i] {
+				header[i] += " (" + v.times.String() + ")"
+			}
+		}
+	}
+	widths := make([]int, len(header))
+	growWidths(widths, header)
+	for _, r := range shown {
+		growWidths(widths, r.cells)
+	}
+	widths = fitWidths(widths, width)
+
+	rowCells := func(values []string, fg, bg termbox.Attribute) []cell {
+		var cells []cell
+		for i, w := range widths {
+			if i > 0 {
+				cells = append(cells, cell{ch: ' ', width: 1, fg: fg, bg: bg})
+			}
+			cells = fitCells(cells, values[i], w, fg, bg)
+		}
+		return cells
+	}
+	lines := []row{{cells: rowCells(header, Colors.Header.Fg, Colors.Header.Bg), bg: Colors.Header.Bg}}
+	if v.follow && len(shown) < height {
+		lines = append(lines, make([]row, height-len(shown))...)
+	}
+	for _, r := range shown {
+		cells := rowCells(r.cells, 0, r.bg)
+		if r.suffix != "" {
+			cells = appendClusters(append(cells, cell{ch: ' ', width: 1, bg: r.bg}), []byte(r.suffix), Colors.Count.Fg, r.bg)
+		}
+		lines = append(lines, row{cells: cells, bg: r.bg})
+	}
+	for len(lines) < height+1 {
+		lines = append(lines, row{})
+	}
 	p.drawLines(lines)
 }
 
-func (p *PagerBox) drawLines(lines []*bytes.Buffer) {
+// row of the pager, a part of a line
+type row struct {
+	cells []cell
+	bg    termbox.Attribute
+}
 
-	var (
-		x   int
-		r   rune
-		err error
-	)
+func (p *PagerBox) drawLines(lines []row) {
 	for y, line := range lines {
-
-		for err != io.EOF {
-			x++
-			r, _, err = line.ReadRune()
-			p.win.Draw(x, y, r, 0, 0)
+		x := 0
+		for _, c := range line.cells {
+			p.win.Draw(x, y, c.ch, c.fg, c.bg)
+			x += c.width
 		}
 		for ; x < p.win.Width(); x++ {
-			p.win.Draw(x, y, ' ', 0, 0)
+			p.win.Draw(x, y, ' ', 0, line.bg)
 		}
-		err = nil
-		x = 0
 	}
 }
 
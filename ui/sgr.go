@@ -0,0 +1,156 @@
+package ui
+
+import (
+	"bytes"
+	"github.com/nsf/termbox-go"
+	"strconv"
+)
+
+const esc = 0x1b
+
+// cell is a rune, how many cells of the screen it takes and the colors
+// to draw it with.
+type cell struct {
+	ch     rune
+	width  int
+	fg, bg termbox.Attribute
+}
+
+// sgr is the graphic rendition that escape sequences have set so far on
+// a line.
+type sgr struct {
+	fg, bg termbox.Attribute
+}
+
+// apply the parameters of a `ESC [ params m` sequence.
+func (s *sgr) apply(params []byte) {
+	if len(params) == 0 {
+		*s = sgr{}
+		return
+	}
+	codes := bytes.Split(params, []byte(";"))
+	for i := 0; i < len(codes); i++ {
+		code, err := strconv.Atoi(string(codes[i]))
+		if err != nil {
+			// `ESC[;1m` is the same as `ESC[0;1m`
+			code = 0
+		}
+		switch {
+		case code == 0:
+			*s = sgr{}
+		case code == 1:
+			s.fg |= termbox.AttrBold
+		case code == 4:
+			s.fg |= termbox.AttrUnderline
+		case code == 7:
+			s.fg |= termbox.AttrReverse
+		case code == 22:
+			s.fg &^= termbox.AttrBold
+		case code == 24:
+			s.fg &^= termbox.AttrUnderline
+		case code == 27:
+			s.fg &^= termbox.AttrReverse
+		case code >= 30 && code <= 37:
+			s.fg = s.fg&attrMask | basicColor(code-30)
+		case code == 39:
+			s.fg &= attrMask
+		case code >= 40 && code <= 47:
+			s.bg = basicColor(code - 40)
+		case code == 49:
+			s.bg = termbox.ColorDefault
+		case code >= 90 && code <= 97:
+			s.fg = s.fg&attrMask | basicColor(code-90) | termbox.AttrBold
+		case code >= 100 && code <= 107:
+			s.bg = basicColor(code - 100)
+		case code == 38 || code == 48:
+			// extended colors: `38;5;n` or `38;2;r;g;b`
+			color, used := extendedColor(codes[i+1:])
+			i += used
+			if code == 38 {
+				s.fg = s.fg&attrMask | color
+			} else {
+				s.bg = color
+			}
+		}
+	}
+}
+
+const attrMask = termbox.AttrBold | termbox.AttrUnderline | termbox.AttrReverse
+
+func basicColor(n int) termbox.Attribute {
+	return termbox.ColorBlack + termbox.Attribute(n)
+}
+
+// extendedColor approximates 256 colors and true colors with the 8
+// basic colors, since that's what termbox shows in its default mode.
+func extendedColor(codes [][]byte) (color termbox.Attribute, used int) {
+	if len(codes) == 0 {
+		return termbox.ColorDefault, 0
+	}
+	switch string(codes[0]) {
+	case "5":
+		if len(codes) < 2 {
+			return termbox.ColorDefault, len(codes)
+		}
+		n, _ := strconv.Atoi(string(codes[1]))
+		if n < 16 {
+			return basicColor(n % 8), 2
+		}
+		return termbox.ColorDefault, 2
+	case "2":
+		if len(codes) < 4 {
+			return termbox.ColorDefault, len(codes)
+		}
+		var bits int
+		for i, c := range codes[1:4] {
+			if v, _ := strconv.Atoi(string(c)); v >= 128 {
+				bits |= 1 << uint(i)
+			}
+		}
+		return basicColor(bits), 4
+	}
+	return termbox.ColorDefault, 1
+}
+
+// lineCells turns a line into the cells to draw. Escape sequences are
+// dropped, and if `colors`, the SGR ones set the colors of the cells.
+func lineCells(line []byte, colors bool) []cell {
+	cells := make([]cell, 0, len(line))
+	var state sgr
+	for len(line) > 0 {
+		i := bytes.IndexByte(line, esc)
+		if i == -1 {
+			return appendClusters(cells, line, state.fg, state.bg)
+		}
+		cells = appendClusters(cells, line[:i], state.fg, state.bg)
+		line = line[i:]
+
+		params, final, n := csi(line)
+		if n == 0 {
+			// lone escape, drop it
+			line = line[1:]
+			continue
+		}
+		if colors && final == 'm' {
+			state.apply(params)
+		}
+		line = line[n:]
+	}
+	return cells
+}
+
+// csi decodes a `ESC [ params final` sequence at the start of data.
+func csi(data []byte) (params []byte, final byte, n int) {
+	if len(data) < 2 || data[0] != esc || data[1] != '[' {
+		return nil, 0, 0
+	}
+	for i := 2; i < len(data); i++ {
+		switch b := data[i]; {
+		case b >= 0x40 && b <= 0x7e:
+			return data[2:i], b, i + 1
+		case b < 0x20 || b > 0x3f:
+			return nil, 0, 0
+		}
+	}
+	return nil, 0, 0
+}
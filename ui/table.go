@@ -0,0 +1,171 @@
+package ui
+
+import (
+	"github.com/aybabtme/logterm/query"
+	"github.com/nsf/termbox-go"
+	"sync"
+)
+
+var (
+	_ ResizeHandler = &TableBox{}
+)
+
+// columns are never narrower than that, unless the table is
+const minColumnWidth = 4
+
+// TableBox shows the result of an aggregation.
+type TableBox struct {
+	win *Window
+
+	mu       sync.Mutex
+	table    *query.Table
+	selected int // row shown as selected, -1 for none
+}
+
+func NewTableBox(win *Window) *TableBox {
+	return &TableBox{win: win, selected: -1}
+}
+
+// SetTable replaces the table that is shown.
+func (t *TableBox) SetTable(table *query.Table) {
+	t.mu.Lock()
+	t.table = table
+	t.mu.Unlock()
+	t.Refresh()
+}
+
+// SetSelected shows a row, from 0 for the first under the header, as
+// selected. -1 selects none.
+func (t *TableBox) SetSelected(row int) {
+	t.mu.Lock()
+	t.selected = row
+	t.mu.Unlock()
+	t.Refresh()
+}
+
+func (t *TableBox) Resize(x, y, width, height int) { t.Refresh() }
+
+func (t *TableBox) Refresh() {
+	t.mu.Lock()
+	table, selected := t.table, t.selected
+	t.mu.Unlock()
+
+	width, height := t.win.Width(), t.win.Height()
+	var rows [][]string
+	if table != nil {
+		rows = append([][]string{table.Columns}, table.Rows...)
+	}
+	widths := columnWidths(rows, width)
+	for y := 0; y < height; y++ {
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		switch {
+		case y == 0:
+			fg, bg = Colors.Header.Fg, Colors.Header.Bg
+		case y == selected+1:
+			fg, bg = Colors.Selected.Fg, Colors.Selected.Bg
+		}
+		x := 0
+		if y < len(rows) {
+			for i, v := range rows[y] {
+				if i >= len(widths) {
+					break
+				}
+				if i > 0 {
+					t.win.Draw(x, y, ' ', fg, bg)
+					x++
+				}
+				x = t.drawCell(x, y, v, widths[i], fg, bg)
+			}
+		}
+		for ; x < width; x++ {
+			t.win.Draw(x, y, ' ', fg, bg)
+		}
+	}
+}
+
+// drawCell draws v in a column of that width, marking it with an ellipsis
+// if it's cut.
+func (t *TableBox) drawCell(x, y int, v string, width int, fg, bg termbox.Attribute) int {
+	for _, c := range fitCells(nil, v, width, fg, bg) {
+		t.win.Draw(x, y, c.ch, c.fg, c.bg)
+		x += c.width
+	}
+	return x
+}
+
+// fitCells appends the cells of v to cells, cut to width with an
+// ellipsis, or padded to it.
+func fitCells(cells []cell, v string, width int, fg, bg termbox.Attribute) []cell {
+	text := appendClusters(nil, []byte(v), fg, bg)
+	total := 0
+	for _, c := range text {
+		total += c.width
+	}
+	room := width
+	if total > width {
+		// leave room for the mark
+		room--
+	}
+	used := 0
+	for _, c := range text {
+		if used+c.width > room {
+			break
+		}
+		cells = append(cells, c)
+		used += c.width
+	}
+	if total > width && width > 0 {
+		cells = append(cells, cell{ch: '…', width: 1, fg: fg, bg: bg})
+		used++
+	}
+	for ; used < width; used++ {
+		cells = append(cells, cell{ch: ' ', width: 1, fg: fg, bg: bg})
+	}
+	return cells
+}
+
+// columnWidths fits the columns in the width, taking from the widest ones
+// until they fit.
+func columnWidths(rows [][]string, width int) []int {
+	if len(rows) == 0 {
+		return nil
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		growWidths(widths, row)
+	}
+	return fitWidths(widths, width)
+}
+
+// growWidths widens the columns that are narrower than the values of row.
+func growWidths(widths []int, row []string) {
+	for i, v := range row {
+		if i < len(widths) {
+			if w := runesWidth([]rune(v)); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+}
+
+// fitWidths narrows the widest of widths until they fit in width, with a
+// space between them. It changes widths.
+func fitWidths(widths []int, width int) []int {
+	if len(widths) == 0 {
+		return widths
+	}
+	avail := width - (len(widths) - 1)
+	for {
+		total, widest := 0, 0
+		for i, w := range widths {
+			total += w
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if total <= avail || widths[widest] <= minColumnWidth {
+			return widths
+		}
+		widths[widest]--
+	}
+}
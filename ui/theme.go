@@ -0,0 +1,156 @@
+package ui
+
+import (
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"sort"
+	"strings"
+)
+
+// Style of cells, their colors and attributes.
+type Style struct {
+	Fg, Bg termbox.Attribute
+}
+
+// Theme is the styles that boxes draw with.
+type Theme struct {
+	// Time shown before lines, when it's not shown as parsed
+	Time Style
+	// Count of the repeats of a line
+	Count Style
+	// Header of tables and columns
+	Header Style
+	// Selected row of lists
+	Selected Style
+	// Input of the edit box
+	Input Style
+	// Cursor of the edit box
+	Cursor Style
+	// Bookmarked lines of the pager, by their background
+	Bookmark Style
+	// Banner of alerts
+	Banner Style
+}
+
+// DefaultTheme is the theme that boxes draw with unless another is set.
+var DefaultTheme = Theme{
+	Time:     Style{Fg: termbox.ColorCyan},
+	Count:    Style{Fg: termbox.ColorYellow | termbox.AttrBold},
+	Header:   Style{Fg: termbox.ColorBlack, Bg: termbox.ColorWhite},
+	Selected: Style{Fg: termbox.ColorBlack, Bg: termbox.ColorCyan},
+	Input:    Style{Fg: termbox.ColorBlack, Bg: termbox.ColorWhite},
+	Cursor:   Style{Fg: termbox.ColorWhite, Bg: termbox.ColorBlue},
+	Bookmark: Style{Bg: termbox.ColorMagenta},
+	Banner:   Style{Fg: termbox.ColorWhite | termbox.AttrBold, Bg: termbox.ColorRed},
+}
+
+// Colors is the theme that boxes draw with. It's set before the canvas
+// runs.
+var Colors = DefaultTheme
+
+// Set the style of a part of the theme, by its name like `header`, to
+// one written like ParseStyle reads them.
+func (t *Theme) Set(name, spec string) error {
+	s, err := ParseStyle(spec)
+	if err != nil {
+		return err
+	}
+	p, ok := t.parts()[name]
+	if !ok {
+		return fmt.Errorf("unknown part of the theme %q, want one of %s", name, strings.Join(ThemeParts(), ", "))
+	}
+	*p = s
+	return nil
+}
+
+func (t *Theme) parts() map[string]*Style {
+	return map[string]*Style{
+		"time":     &t.Time,
+		"count":    &t.Count,
+		"header":   &t.Header,
+		"selected": &t.Selected,
+		"input":    &t.Input,
+		"cursor":   &t.Cursor,
+		"bookmark": &t.Bookmark,
+		"banner":   &t.Banner,
+	}
+}
+
+// ThemeParts are the names of the parts of themes.
+func ThemeParts() []string {
+	var names []string
+	for name := range new(Theme).parts() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var colorNames = map[string]termbox.Attribute{
+	"default": termbox.ColorDefault,
+	"black":   termbox.ColorBlack,
+	"red":     termbox.ColorRed,
+	"green":   termbox.ColorGreen,
+	"yellow":  termbox.ColorYellow,
+	"blue":    termbox.ColorBlue,
+	"magenta": termbox.ColorMagenta,
+	"cyan":    termbox.ColorCyan,
+	"white":   termbox.ColorWhite,
+}
+
+var attrNames = map[string]termbox.Attribute{
+	"bold":      termbox.AttrBold,
+	"underline": termbox.AttrUnderline,
+	"reverse":   termbox.AttrReverse,
+}
+
+// ParseStyle reads a style like `yellow`, `bold red` or `black on white`.
+func ParseStyle(spec string) (Style, error) {
+	var s Style
+	words := strings.Fields(strings.ToLower(spec))
+	if len(words) == 0 {
+		return s, fmt.Errorf("empty style")
+	}
+	fg := &s.Fg
+	for i, w := range words {
+		if w == "on" {
+			if fg == &s.Bg || i == len(words)-1 {
+				return s, fmt.Errorf("invalid style %q, want like `black on white`", spec)
+			}
+			fg = &s.Bg
+			continue
+		}
+		if a, ok := attrNames[w]; ok && fg == &s.Fg {
+			s.Fg |= a
+			continue
+		}
+		c, ok := colorNames[w]
+		if !ok {
+			return s, fmt.Errorf("unknown color %q in style %q", w, spec)
+		}
+		*fg |= c
+	}
+	return s, nil
+}
+
+// ParseKey reads the name of a key that can be bound, like `ctrl-t` or
+// `f5`.
+func ParseKey(name string) (termbox.Key, error) {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "ctrl-") && len(lower) == len("ctrl-")+1 {
+		c := lower[len(lower)-1]
+		switch {
+		case c == 'h' || c == 'i' || c == 'm':
+			return 0, fmt.Errorf("%s can't be bound, terminals send it for backspace, tab or enter", name)
+		case c >= 'a' && c <= 'z':
+			return termbox.KeyCtrlA + termbox.Key(c-'a'), nil
+		}
+	}
+	if strings.HasPrefix(lower, "f") {
+		var n int
+		if _, err := fmt.Sscanf(lower, "f%d", &n); err == nil && n >= 1 && n <= 12 && lower == fmt.Sprintf("f%d", n) {
+			return termbox.KeyF1 - termbox.Key(n-1), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key %q, want one like ctrl-t or f5", name)
+}
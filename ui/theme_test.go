@@ -0,0 +1,62 @@
+package ui
+
+import (
+	"github.com/nsf/termbox-go"
+	"testing"
+)
+
+func TestParseStyle(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want Style
+	}{
+		{"yellow", Style{Fg: termbox.ColorYellow}},
+		{"bold red", Style{Fg: termbox.ColorRed | termbox.AttrBold}},
+		{"Black on White", Style{Fg: termbox.ColorBlack, Bg: termbox.ColorWhite}},
+		{"underline default on blue", Style{Fg: termbox.AttrUnderline, Bg: termbox.ColorBlue}},
+	} {
+		got, err := ParseStyle(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: want %+v, got %+v", tt.spec, tt.want, got)
+		}
+	}
+	for _, spec := range []string{"", "plaid", "red on", "red on blue on green", "red on bold"} {
+		if _, err := ParseStyle(spec); err == nil {
+			t.Errorf("%q: want an error", spec)
+		}
+	}
+	var theme Theme
+	if err := theme.Set("header", "red on black"); err != nil || theme.Header.Bg != termbox.ColorBlack {
+		t.Errorf("want the header set, got %+v, %v", theme.Header, err)
+	}
+	if err := theme.Set("footer", "red"); err == nil {
+		t.Error("want an error for an unknown part")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want termbox.Key
+	}{
+		{"ctrl-t", termbox.KeyCtrlT},
+		{"Ctrl-A", termbox.KeyCtrlA},
+		{"ctrl-z", termbox.KeyCtrlZ},
+		{"f1", termbox.KeyF1},
+		{"F12", termbox.KeyF12},
+	} {
+		got, err := ParseKey(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("%q: want %v, got %v, %v", tt.name, tt.want, got, err)
+		}
+	}
+	for _, name := range []string{"ctrl-m", "ctrl-1", "f13", "f01", "t", "alt-t"} {
+		if _, err := ParseKey(name); err == nil {
+			t.Errorf("%q: want an error", name)
+		}
+	}
+}
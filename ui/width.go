@@ -0,0 +1,72 @@
+package ui
+
+import (
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+	"github.com/rivo/uniseg"
+	"unicode/utf8"
+)
+
+// cellWidth is how many cells termbox gives to a rune when it flushes
+// the screen. Wide runes take two cells, and termbox skips the cell that
+// follows them.
+func cellWidth(r rune) int {
+	w := runewidth.RuneWidth(r)
+	if w == 0 || w == 2 && runewidth.IsAmbiguousWidth(r) {
+		return 1
+	}
+	return w
+}
+
+// runesWidth is how many cells a line of runes takes. Runes that
+// combine with the one before them take none.
+func runesWidth(runes []rune) int {
+	var w int
+	for _, r := range runes {
+		if runewidth.RuneWidth(r) != 0 {
+			w += cellWidth(r)
+		}
+	}
+	return w
+}
+
+// appendClusters appends a cell for each grapheme cluster of text. A
+// cell can show a single rune, so clusters are drawn with their first
+// one: combining marks, variation selectors and the like are dropped.
+func appendClusters(cells []cell, text []byte, fg, bg termbox.Attribute) []cell {
+	state := -1
+	var cluster []byte
+	for len(text) > 0 {
+		cluster, text, _, state = uniseg.FirstGraphemeCluster(text, state)
+		r, _ := utf8.DecodeRune(cluster)
+		switch {
+		case r == '\t':
+			r = ' '
+		case r < 0x20 || r == 0x7f:
+			// control runes move the cursor around, don't draw them
+			continue
+		}
+		cells = append(cells, cell{ch: r, width: cellWidth(r), fg: fg, bg: bg})
+	}
+	return cells
+}
+
+// wrapCells breaks a line of cells in many lines that are at most width
+// wide. A wide cell that doesn't fit at the end of a line moves to the
+// next one.
+func wrapCells(cells []cell, width int) [][]cell {
+	if width < 2 {
+		// can't fit a wide cell, give up on wrapping
+		return [][]cell{cells}
+	}
+	var lines [][]cell
+	var lineW, start int
+	for i, c := range cells {
+		if lineW+c.width > width {
+			lines = append(lines, cells[start:i])
+			start, lineW = i, 0
+		}
+		lineW += c.width
+	}
+	return append(lines, cells[start:])
+}
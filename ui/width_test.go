@@ -0,0 +1,133 @@
+package ui
+
+import (
+	"testing"
+)
+
+func TestLineCells(t *testing.T) {
+	var tests = []struct {
+		input     string
+		wantRunes string
+		wantWidth []int
+	}{
+		{
+			input:     "msg=hello",
+			wantRunes: "msg=hello",
+			wantWidth: []int{1, 1, 1, 1, 1, 1, 1, 1, 1},
+		},
+		{
+			input:     "user=日本 ok",
+			wantRunes: "user=日本 ok",
+			wantWidth: []int{1, 1, 1, 1, 1, 2, 2, 1, 1, 1},
+		},
+		{
+			// e + combining acute accent is one cluster
+			input:     "café!",
+			wantRunes: "cafe!",
+			wantWidth: []int{1, 1, 1, 1, 1},
+		},
+		{
+			// woman + zero width joiner + laptop is one cluster
+			input:     "dev=\U0001F469‍\U0001F4BB.",
+			wantRunes: "dev=\U0001F469.",
+			wantWidth: []int{1, 1, 1, 1, 2, 1},
+		},
+		{
+			input:     "\x1b[31mошибка\x1b[0m\tلا",
+			wantRunes: "ошибка لا",
+			wantWidth: []int{1, 1, 1, 1, 1, 1, 1, 1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		cells := lineCells([]byte(tt.input), false)
+		var gotRunes []rune
+		var gotWidth []int
+		for _, c := range cells {
+			gotRunes = append(gotRunes, c.ch)
+			gotWidth = append(gotWidth, c.width)
+		}
+		if string(gotRunes) != tt.wantRunes {
+			t.Errorf("input %q: want runes %q, got %q", tt.input, tt.wantRunes, string(gotRunes))
+		}
+		if !equalInts(gotWidth, tt.wantWidth) {
+			t.Errorf("input %q: want widths %v, got %v", tt.input, tt.wantWidth, gotWidth)
+		}
+	}
+}
+
+func TestWrapCells(t *testing.T) {
+	var tests = []struct {
+		input string
+		width int
+		want  []string
+	}{
+		{input: "abcdef", width: 3, want: []string{"abc", "def"}},
+		{input: "abcdefg", width: 3, want: []string{"abc", "def", "g"}},
+		// a wide rune doesn't get split across lines
+		{input: "ab日本c", width: 3, want: []string{"ab", "日", "本c"}},
+		{input: "日本語のログ", width: 5, want: []string{"日本", "語の", "ログ"}},
+		{input: "", width: 3, want: []string{""}},
+	}
+
+	for _, tt := range tests {
+		lines := wrapCells(lineCells([]byte(tt.input), false), tt.width)
+		var got []string
+		for _, line := range lines {
+			var runes []rune
+			var w int
+			for _, c := range line {
+				runes = append(runes, c.ch)
+				w += c.width
+			}
+			if w > tt.width {
+				t.Errorf("input %q: line %q is %d wide, more than %d", tt.input, string(runes), w, tt.width)
+			}
+			got = append(got, string(runes))
+		}
+		if !equalStrings(got, tt.want) {
+			t.Errorf("input %q: want lines %q, got %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestRunesWidth(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  int
+	}{
+		{input: "hello", want: 5},
+		{input: "日本", want: 4},
+		{input: "café", want: 4},
+		{input: "한국어 log", want: 10},
+	}
+	for _, tt := range tests {
+		if got := runesWidth([]rune(tt.input)); got != tt.want {
+			t.Errorf("input %q: want width %d, got %d", tt.input, tt.want, got)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
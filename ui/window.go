@@ -30,6 +30,14 @@ func (w *Window) Resize(x, y, width, height int) {
 func (w *Window) Draw(x, y int, ch rune, fg, bg termbox.Attribute) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	if x < 0 || y < 0 || x >= w.width || y >= w.height {
+		// belongs to another window
+		return
+	}
+	if cellWidth(ch) == 2 && x+1 >= w.width {
+		// half of it would be in the next window
+		ch = ' '
+	}
 	w.canvas.Set(w.x+x, w.y+y, ch, fg, bg)
 }
 
@@ -0,0 +1,187 @@
+// The page follows the entries that match the query over Server-Sent
+// Events, counts their fields in the sidebar, and shows one in the
+// inspector when it's clicked.
+"use strict";
+
+// rows kept in the page, the oldest go first
+const maxRows = 5000;
+
+const $ = (id) => document.getElementById(id);
+const queryInput = $("query");
+const entries = $("entries");
+const table = $("table");
+const view = $("view");
+
+let source = null;
+let fieldCounts = new Map();
+let columns = [];
+let rows = [];
+let selected = null;
+let lines = 0;
+
+function status(text, error) {
+  $("status").textContent = text;
+  $("status").className = error ? "error" : "";
+}
+
+function el(tag, text, className) {
+  const e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  if (className) e.className = className;
+  return e;
+}
+
+function field(entry, name) {
+  const f = entry.fields.find((f) => f.name === name);
+  return f ? f.value : "";
+}
+
+// run the query: follow its entries, or the table of its aggregation
+function run() {
+  if (source) source.close();
+  const q = queryInput.value.trim();
+  history.replaceState(null, "", q ? "?q=" + encodeURIComponent(q) : location.pathname);
+  entries.querySelector("tbody").replaceChildren();
+  fieldCounts = new Map();
+  rows = [];
+  lines = 0;
+  inspect(null);
+  renderFields();
+  renderHeader();
+  status("connecting…");
+
+  source = new EventSource("events?q=" + encodeURIComponent(q));
+  source.onopen = () => status("following");
+  source.onerror = () => status("disconnected, retrying…", true);
+  source.addEventListener("error", (ev) => {
+    if (!ev.data) return;
+    source.close();
+    status(JSON.parse(ev.data), true);
+  });
+  source.addEventListener("dropped", (ev) => status("following, " + JSON.parse(ev.data) + " lines dropped", true));
+  source.addEventListener("entry", (ev) => {
+    if ($("pause").checked) return;
+    add(JSON.parse(ev.data));
+  });
+  source.addEventListener("table", (ev) => showTable(JSON.parse(ev.data)));
+}
+
+function add(entry) {
+  entries.hidden = false;
+  table.hidden = true;
+  const follow = view.scrollTop + view.clientHeight >= view.scrollHeight - 4;
+  rows.push(entry);
+  const tr = renderRow(entry);
+  entries.querySelector("tbody").append(tr);
+  if (rows.length > maxRows) {
+    rows.shift();
+    entries.querySelector("tbody").firstChild.remove();
+  }
+  let added = false;
+  for (const f of entry.fields) {
+    if (!fieldCounts.has(f.name)) added = true;
+    fieldCounts.set(f.name, (fieldCounts.get(f.name) || 0) + 1);
+  }
+  lines++;
+  if (added || lines % 50 === 0) renderFields();
+  status("following, " + lines + " entries");
+  if (follow) view.scrollTop = view.scrollHeight;
+}
+
+function renderHeader() {
+  const tr = el("tr");
+  tr.append(el("th", "time"));
+  if (columns.length === 0) tr.append(el("th", "line"));
+  for (const name of columns) tr.append(el("th", name));
+  entries.querySelector("thead").replaceChildren(tr);
+}
+
+function renderRow(entry) {
+  const tr = el("tr");
+  tr.append(el("td", entry.time ? entry.time.replace("T", " ").replace(/\.\d+/, "") : "", "time"));
+  if (columns.length === 0) {
+    tr.append(el("td", entry.line));
+  }
+  for (const name of columns) {
+    const v = field(entry, name);
+    tr.append(el("td", v, name === "level" ? "level-" + v.toLowerCase() : ""));
+  }
+  tr.onclick = () => inspect(entry, tr);
+  return tr;
+}
+
+// the rows again, when the columns change
+function renderRows() {
+  renderHeader();
+  const body = entries.querySelector("tbody");
+  body.replaceChildren(...rows.map(renderRow));
+}
+
+function renderFields() {
+  const names = [...fieldCounts.keys()].sort();
+  $("field-list").replaceChildren(...names.map((name) => {
+    const li = el("li", undefined, columns.includes(name) ? "column" : "");
+    li.append(el("span", name), el("span", String(fieldCounts.get(name)), "count"));
+    li.title = "show or hide the column";
+    li.onclick = () => {
+      columns = columns.includes(name) ? columns.filter((c) => c !== name) : columns.concat(name);
+      renderFields();
+      renderRows();
+    };
+    return li;
+  }));
+}
+
+function inspect(entry, tr) {
+  if (selected) selected.classList.remove("selected");
+  selected = tr || null;
+  $("inspector").hidden = !entry;
+  if (!entry) return;
+  tr.classList.add("selected");
+  $("inspected").textContent = "#" + entry.n;
+  $("inspector-fields").replaceChildren(...entry.fields.map((f) => {
+    const row = el("tr");
+    const value = el("td", f.value, "value");
+    value.title = "add to the query";
+    value.onclick = () => {
+      const v = /^[\w.:\/-]+$/.test(f.value) ? f.value : JSON.stringify(f.value);
+      queryInput.value = (queryInput.value.trim() + " " + f.name + "=" + v).trim();
+      run();
+    };
+    row.append(el("td", f.name, "name"), el("td", f.type, "type"), value);
+    return row;
+  }));
+  $("inspector-line").textContent = entry.line;
+}
+
+function showTable(t) {
+  entries.hidden = true;
+  table.hidden = false;
+  const head = el("tr");
+  for (const c of t.Columns || []) head.append(el("th", c));
+  table.querySelector("thead").replaceChildren(head);
+  table.querySelector("tbody").replaceChildren(...(t.Rows || []).map((r) => {
+    const tr = el("tr");
+    for (const v of r) tr.append(el("td", v));
+    return tr;
+  }));
+  status("aggregating, " + (t.Rows || []).length + " rows");
+}
+
+$("bar").onsubmit = (ev) => {
+  ev.preventDefault();
+  run();
+};
+$("close").onclick = () => inspect(null);
+
+fetch("queries").then((r) => r.json()).then((saved) => {
+  $("saved").replaceChildren(...Object.keys(saved).sort().map((name) => {
+    const o = el("option");
+    o.value = "@" + name;
+    o.label = saved[name];
+    return o;
+  }));
+});
+
+queryInput.value = new URLSearchParams(location.search).get("q") || "";
+run();
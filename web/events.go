@@ -0,0 +1,259 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/logterm/encode"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// lines of the history that the backlog is looked for in, the last
+	// ones
+	maxScanned = 100000
+	// lines waiting to be sent to a browser, those past it are dropped
+	clientQueue = 1024
+	// how often the table of an aggregation is sent
+	tableRefresh = time.Second
+	// how often idle streams get a comment, for proxies to keep them
+	keepAlive = 15 * time.Second
+)
+
+// item is a line appended, and its entry.
+type item struct {
+	n     uint64
+	line  []byte
+	entry *parser.Entry
+}
+
+// client is a browser that follows the lines.
+type client struct {
+	items chan item
+	// lines dropped because the browser was too slow, the server holds
+	// its lock to change it
+	dropped uint64
+}
+
+func (c *client) send(it item) {
+	select {
+	case c.items <- it:
+	default:
+		c.dropped++
+	}
+}
+
+// Entry as browsers get it.
+type Entry struct {
+	// N is the number of the line in the history
+	N      uint64  `json:"n"`
+	Time   string  `json:"time,omitempty"`
+	Line   string  `json:"line"`
+	Fields []Field `json:"fields"`
+}
+
+// Field of an entry, with the name of its type.
+type Field struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// NewEntry of line n, with the fields of e sorted by name.
+func NewEntry(n uint64, line []byte, e *parser.Entry) *Entry {
+	out := &Entry{N: n, Line: string(line), Fields: []Field{}}
+	if t, ok := e.Time(); ok {
+		out.Time = t.Format(time.RFC3339Nano)
+	}
+	names := append([]string(nil), e.FieldNames()...)
+	sort.Strings(names)
+	for _, name := range names {
+		f, _ := e.Field(name)
+		out.Fields = append(out.Fields, Field{Name: name, Type: typeName(f), Value: encode.Text(f)})
+	}
+	return out
+}
+
+func typeName(f parser.Field) string {
+	switch f.(type) {
+	case parser.StringField:
+		return "string"
+	case parser.NumberField:
+		return "number"
+	case parser.DurationField:
+		return "duration"
+	case parser.TimeField:
+		return "time"
+	case parser.BooleanField:
+		return "bool"
+	case nil, parser.NilField:
+		return "null"
+	}
+	return "raw"
+}
+
+// serveEvents streams the entries that match the query `q`, the last
+// ones of the history first, or the table of its aggregation every
+// second. A browser that reconnects with the Last-Event-ID it got gets
+// the entries after it, in place of the backlog.
+//
+// Events are `entry` and `table`, with their JSON, `dropped` with the
+// count of lines the browser missed for being too slow, and `error` with
+// the message of a query that can't be parsed.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	q, err := s.opts.Query(r.URL.Query().Get("q"))
+	if err != nil {
+		writeEvent(w, "error", "", err.Error())
+		flusher.Flush()
+		return
+	}
+
+	c := &client{items: make(chan item, clientQueue)}
+	next := s.follow(c)
+	defer s.unfollow(c)
+
+	var sent uint64
+	if q.Aggregates() {
+		s.walk(next, maxScanned, func(it item) { q.Add(it.entry) })
+		tick := time.NewTicker(tableRefresh)
+		defer tick.Stop()
+		writeEvent(w, "table", "", q.Table())
+		flusher.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case it := <-c.items:
+				q.Add(it.entry)
+			case <-tick.C:
+				writeEvent(w, "table", "", q.Table())
+				s.writeDropped(w, c, &sent)
+				flusher.Flush()
+			}
+		}
+	}
+
+	if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && id < next {
+		s.walk(next, next-id-1, func(it item) { writeMatch(w, q, it) })
+	} else {
+		for _, it := range s.backlog(next, q) {
+			writeEntry(w, it)
+		}
+	}
+	flusher.Flush()
+	tick := time.NewTicker(keepAlive)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case it := <-c.items:
+			writeMatch(w, q, it)
+			// send what's waiting at once
+			for len(c.items) > 0 {
+				writeMatch(w, q, <-c.items)
+			}
+			s.writeDropped(w, c, &sent)
+			flusher.Flush()
+		case <-tick.C:
+			fmt.Fprint(w, ": keep alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// walk the last `last` lines before next, or fewer if the history doesn't
+// have that many, in order.
+func (s *Server) walk(next, last uint64, fn func(it item)) {
+	from := s.hist.First()
+	if next-from > last {
+		from = next - last
+	}
+	for from < next {
+		err := s.hist.Walk(from, func(n uint64, line []byte, _ history.Meta) bool {
+			if n >= next {
+				return false
+			}
+			// entries can hold on to their line
+			line = append([]byte(nil), line...)
+			fn(item{n: n, line: line, entry: s.opts.Parse(line)})
+			from = n + 1
+			return true
+		})
+		if err != history.ErrEvicted {
+			return
+		}
+		// retention dropped the lines while they were read
+		if first := s.hist.First(); first > from {
+			from = first
+		}
+	}
+}
+
+// backlog is the last entries before next that match the query, as it
+// changed them, up to the backlog of the options.
+func (s *Server) backlog(next uint64, q *query.Query) []item {
+	var matches []item
+	s.walk(next, maxScanned, func(it item) {
+		var ok bool
+		if it.entry, ok = q.Match(it.entry); !ok {
+			return
+		}
+		if len(matches) == 2*s.opts.Backlog {
+			matches = append(matches[:0], matches[s.opts.Backlog:]...)
+		}
+		matches = append(matches, it)
+	})
+	if len(matches) > s.opts.Backlog {
+		matches = matches[len(matches)-s.opts.Backlog:]
+	}
+	return matches
+}
+
+func (s *Server) writeDropped(w http.ResponseWriter, c *client, sent *uint64) {
+	s.mu.Lock()
+	dropped := c.dropped
+	s.mu.Unlock()
+	if dropped > *sent {
+		writeEvent(w, "dropped", "", dropped)
+		*sent = dropped
+	}
+}
+
+func writeMatch(w http.ResponseWriter, q *query.Query, it item) {
+	var ok bool
+	if it.entry, ok = q.Match(it.entry); ok {
+		writeEntry(w, it)
+	}
+}
+
+func writeEntry(w http.ResponseWriter, it item) {
+	writeEvent(w, "entry", strconv.FormatUint(it.n, 10), NewEntry(it.n, it.line, it.entry))
+}
+
+// writeEvent of Server-Sent Events, with v as JSON, and its id if it has
+// one.
+func writeEvent(w http.ResponseWriter, event, id string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(err.Error())
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,135 @@
+// Package web serves a stream of entries to browsers. Each one follows the
+// entries that match its query over Server-Sent Events, or the table of
+// its aggregation, lists their fields and inspects them. The page and its
+// assets are embedded.
+package web
+
+import (
+	"bufio"
+	"embed"
+	"encoding/json"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/parser"
+	"github.com/aybabtme/logterm/query"
+	"io"
+	"io/fs"
+	"net/http"
+	"sync"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// DefaultBacklog is how many entries that match are sent to a browser
+// before those that come next.
+const DefaultBacklog = 500
+
+// Options of a Server. The zero value of a field takes its default.
+type Options struct {
+	// Parse lines into entries, parser.ParseLine by default
+	Parse func(line []byte) *parser.Entry
+	// Query parses the queries of browsers, query.Parse by default
+	Query func(text string) (*query.Query, error)
+	// Queries saved by name, that browsers list
+	Queries map[string]string
+	// Backlog of the entries that match sent first
+	Backlog int
+}
+
+// Server appends lines to a history, and streams them to the browsers
+// that follow them. It's safe for concurrent use.
+type Server struct {
+	hist *history.Store
+	opts Options
+	mux  *http.ServeMux
+
+	// held while appending, for browsers to start following between
+	// two lines
+	mu      sync.Mutex
+	clients map[*client]bool
+}
+
+// New server of the lines of the history, and those appended to it.
+func New(hist *history.Store, opts Options) *Server {
+	if opts.Parse == nil {
+		opts.Parse = parser.ParseLine
+	}
+	if opts.Query == nil {
+		opts.Query = query.Parse
+	}
+	if opts.Backlog <= 0 {
+		opts.Backlog = DefaultBacklog
+	}
+	s := &Server{
+		hist:    hist,
+		opts:    opts,
+		mux:     http.NewServeMux(),
+		clients: make(map[*client]bool),
+	}
+	static, _ := fs.Sub(assets, "assets")
+	s.mux.Handle("/", http.FileServer(http.FS(static)))
+	s.mux.HandleFunc("/events", s.serveEvents)
+	s.mux.HandleFunc("/queries", s.serveQueries)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Append a line to the history, and send it to the browsers. The line can
+// be reused once it returns.
+func (s *Server) Append(line []byte) error {
+	// entries can hold on to their line
+	line = append([]byte(nil), line...)
+	e := s.opts.Parse(line)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.hist.Append(line, e)
+	if err != nil {
+		return err
+	}
+	it := item{n: n, line: line, entry: e}
+	for c := range s.clients {
+		c.send(it)
+	}
+	return nil
+}
+
+// ReadFrom appends the lines of r until it ends.
+func (s *Server) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1<<20)
+	for scan.Scan() {
+		n += int64(len(scan.Bytes())) + 1
+		if err := s.Append(scan.Bytes()); err != nil {
+			return n, err
+		}
+	}
+	return n, scan.Err()
+}
+
+// follow the lines appended from now on. It returns the number of the
+// next line, those before it are in the history.
+func (s *Server) follow(c *client) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[c] = true
+	return s.hist.Next()
+}
+
+func (s *Server) unfollow(c *client) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+}
+
+func (s *Server) serveQueries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	queries := s.opts.Queries
+	if queries == nil {
+		queries = map[string]string{}
+	}
+	json.NewEncoder(w).Encode(queries)
+}
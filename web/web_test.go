@@ -0,0 +1,239 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/aybabtme/logterm/history"
+	"github.com/aybabtme/logterm/query"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newServer of a history in a temporary directory, removed by done.
+func newServer(t *testing.T, opts Options) (s *Server, ts *httptest.Server, done func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist, err := history.Open(dir, history.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = New(hist, opts)
+	ts = httptest.NewServer(s)
+	return s, ts, func() {
+		ts.CloseClientConnections()
+		ts.Close()
+		hist.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+type event struct {
+	name, id, data string
+}
+
+// stream of the events of a query, until the server is closed.
+func stream(t *testing.T, ts *httptest.Server, q, lastID string) <-chan event {
+	t.Helper()
+	req, _ := http.NewRequest("GET", ts.URL+"/events?q="+url.QueryEscape(q), nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("want an event stream, got %q", ct)
+	}
+	events := make(chan event, 100)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		var ev event
+		scan := bufio.NewScanner(resp.Body)
+		for scan.Scan() {
+			line := scan.Text()
+			switch {
+			case line == "":
+				if ev.name != "" {
+					events <- ev
+				}
+				ev = event{}
+			case strings.HasPrefix(line, "event: "):
+				ev.name = line[len("event: "):]
+			case strings.HasPrefix(line, "id: "):
+				ev.id = line[len("id: "):]
+			case strings.HasPrefix(line, "data: "):
+				ev.data = line[len("data: "):]
+			}
+		}
+	}()
+	return events
+}
+
+func next(t *testing.T, events <-chan event) event {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("the stream ended")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event came")
+	}
+	return event{}
+}
+
+func nextEntry(t *testing.T, events <-chan event) *Entry {
+	t.Helper()
+	ev := next(t, events)
+	if ev.name != "entry" {
+		t.Fatalf("want an entry, got %s %s", ev.name, ev.data)
+	}
+	var e Entry
+	if err := json.Unmarshal([]byte(ev.data), &e); err != nil {
+		t.Fatal(err)
+	}
+	if ev.id != fmt.Sprint(e.N) {
+		t.Fatalf("want id %d, got %q", e.N, ev.id)
+	}
+	return &e
+}
+
+func appendLines(t *testing.T, s *Server, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if err := s.Append([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAssets(t *testing.T) {
+	_, ts, done := newServer(t, Options{Queries: map[string]string{"errors": "level=error"}})
+	defer done()
+	for path, want := range map[string]string{
+		"/":          "<title>logterm</title>",
+		"/app.js":    "EventSource",
+		"/style.css": "#fields",
+		"/queries":   `{"errors":"level=error"}`,
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+			t.Errorf("%s: want %q, got %d %q", path, want, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, ts, done := newServer(t, Options{Backlog: 2})
+	defer done()
+	appendLines(t, s,
+		`{"level":"error","msg":"one"}`,
+		`{"level":"info","msg":"two"}`,
+		`{"level":"error","msg":"three","took":"2s"}`,
+		`{"level":"error","msg":"four","time":"2014-10-27T18:38:00Z"}`,
+	)
+	events := stream(t, ts, "level=error", "")
+
+	// the last two that match
+	if e := nextEntry(t, events); e.N != 2 || e.Line != `{"level":"error","msg":"three","took":"2s"}` {
+		t.Fatalf("want line 2, got %+v", e)
+	}
+	e := nextEntry(t, events)
+	if e.N != 3 || e.Time != "2014-10-27T18:38:00Z" {
+		t.Fatalf("want line 3 and its time, got %+v", e)
+	}
+
+	appendLines(t, s, `{"level":"info","msg":"five"}`, `{"level":"error","msg":"six","took":"2s","ok":true}`)
+	e = nextEntry(t, events)
+	want := []Field{
+		{Name: "level", Type: "string", Value: "error"},
+		{Name: "msg", Type: "string", Value: "six"},
+		{Name: "ok", Type: "bool", Value: "true"},
+		{Name: "took", Type: "duration", Value: "2s"},
+	}
+	if e.N != 5 || fmt.Sprint(e.Fields) != fmt.Sprint(want) {
+		t.Fatalf("want line 5 with fields %v, got %+v", want, e)
+	}
+
+	// a browser that reconnects gets what it missed
+	resumed := stream(t, ts, "level=error", "0")
+	for _, n := range []uint64{2, 3, 5} {
+		if e := nextEntry(t, resumed); e.N != n {
+			t.Fatalf("want line %d after reconnecting, got %+v", n, e)
+		}
+	}
+}
+
+func TestReusedLine(t *testing.T) {
+	s, ts, done := newServer(t, Options{})
+	defer done()
+	events := stream(t, ts, "", "")
+	// like the buffer of a scanner, the line is overwritten by the next
+	buf := []byte("disk full")
+	if err := s.Append(buf); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf, "XXXXXXXXX")
+	e := nextEntry(t, events)
+	if want := []Field{{Name: "raw", Type: "raw", Value: "disk full"}}; fmt.Sprint(e.Fields) != fmt.Sprint(want) {
+		t.Errorf("want fields %v, got %v", want, e.Fields)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	s, ts, done := newServer(t, Options{})
+	defer done()
+	appendLines(t, s, `{"service":"api"}`, `{"service":"db"}`, `{"service":"api"}`)
+	events := stream(t, ts, "| count by service", "")
+
+	var table query.Table
+	ev := next(t, events)
+	if ev.name != "table" {
+		t.Fatalf("want a table, got %s %s", ev.name, ev.data)
+	}
+	if err := json.Unmarshal([]byte(ev.data), &table); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(table.Rows); !strings.Contains(got, "api 2") || !strings.Contains(got, "db 1") {
+		t.Fatalf("want the counts of the backlog, got %v", got)
+	}
+
+	appendLines(t, s, `{"service":"db"}`)
+	ev = next(t, events)
+	if err := json.Unmarshal([]byte(ev.data), &table); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(table.Rows); !strings.Contains(got, "db 2") {
+		t.Fatalf("want the counts with the new line, got %v", got)
+	}
+}
+
+func TestBadQuery(t *testing.T) {
+	_, ts, done := newServer(t, Options{})
+	defer done()
+	events := stream(t, ts, "level=", "")
+	if ev := next(t, events); ev.name != "error" || ev.data == "" {
+		t.Fatalf("want an error, got %s %s", ev.name, ev.data)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("want the stream to end")
+	}
+}